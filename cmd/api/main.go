@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"gostockly/config"
+	"gostockly/internal/repositories"
+	"gostockly/internal/services"
 	"gostockly/pkg/api"
 	"gostockly/pkg/database"
 
@@ -17,8 +25,44 @@ func main() {
 	cfg := config.LoadConfig()
 	db := database.Connect()
 
-	router := api.NewRouter(cfg, db)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("Server starting on :8080...")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	jobService := services.NewJobService(
+		repositories.NewJobRepository(db),
+		cfg.WorkerConcurrency,
+		cfg.WorkerPollInterval,
+		cfg.JobLockTimeout,
+		cfg.JobMaxAttempts,
+	)
+
+	router := api.NewRouter(cfg, db, jobService)
+
+	// Start the job workers once all handlers have been registered by the router
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		jobService.Run(ctx)
+	}()
+
+	server := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		log.Println("Server starting on :8080...")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+
+	// Wait for running jobs to finish, anything left unfinished is picked up again after restart
+	workers.Wait()
 }
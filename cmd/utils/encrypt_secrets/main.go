@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"gostockly/config"
+	"gostockly/pkg/database"
+	"gostockly/pkg/secrets"
+	"log"
+
+	"github.com/joho/godotenv"
+)
+
+// storeSecrets are the stored credential columns of a store, read without decrypting them.
+type storeSecrets struct {
+	ID               string
+	ShopifyStoreStub string
+	AccessToken      string
+	WebhookSignature string
+}
+
+// encrypt_secrets encrypts the credentials of stores saved before encryption at rest was
+// introduced, and encrypts credentials sealed with an older key again with the primary key of
+// ENCRYPTION_KEYS. It is safe to run repeatedly.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report the stores whose credentials would be encrypted")
+	flag.Parse()
+
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, proceeding with system environment variables")
+	}
+
+	db := database.Connect()
+	keyring := config.EncryptionKeyring()
+
+	// Read the raw columns, loading models.Store would decrypt them
+	var stores []storeSecrets
+	if err := db.Table("stores").Select("id", "shopify_store_stub", "access_token", "webhook_signature").Find(&stores).Error; err != nil {
+		log.Fatalf("Failed to read stores: %v", err)
+	}
+
+	updated, failed := 0, 0
+	for _, store := range stores {
+		columns := map[string]interface{}{}
+		for column, value := range map[string]string{
+			"access_token":      store.AccessToken,
+			"webhook_signature": store.WebhookSignature,
+		} {
+			if !keyring.NeedsRotation(value) {
+				continue
+			}
+			encrypted, err := reencrypt(keyring, value)
+			if err != nil {
+				log.Printf("Failed to encrypt %s of store %s (%s): %v", column, store.ShopifyStoreStub, store.ID, err)
+				failed++
+				continue
+			}
+			columns[column] = encrypted
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		if *dryRun {
+			log.Printf("Would encrypt %d credentials of store %s (%s)", len(columns), store.ShopifyStoreStub, store.ID)
+			updated++
+			continue
+		}
+		if err := db.Table("stores").Where("id = ?", store.ID).UpdateColumns(columns).Error; err != nil {
+			log.Printf("Failed to update store %s (%s): %v", store.ShopifyStoreStub, store.ID, err)
+			failed++
+			continue
+		}
+		log.Printf("Encrypted %d credentials of store %s (%s)", len(columns), store.ShopifyStoreStub, store.ID)
+		updated++
+	}
+
+	log.Printf("Checked %d stores, encrypted the credentials of %d with key %s, %d failures", len(stores), updated, keyring.Primary(), failed)
+	if failed > 0 {
+		log.Fatal("Some credentials could not be encrypted, see above")
+	}
+}
+
+// reencrypt encrypts a plaintext value, or a value encrypted with an older key, with the primary key.
+func reencrypt(keyring *secrets.Keyring, value string) (string, error) {
+	if secrets.IsEncrypted(value) {
+		plaintext, err := keyring.Decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		value = plaintext
+	}
+	return keyring.Encrypt(value)
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"gostockly/internal/models"
+	"gostockly/config"
 	"gostockly/internal/repositories"
+	"gostockly/internal/services"
 	"gostockly/pkg/database"
+	"gostockly/pkg/secrets"
 	"gostockly/pkg/shopify"
 	"log"
 	"os"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	paginated := flag.Bool("paginated", false, "page through variants directly instead of exporting them with a bulk operation")
+	flag.Parse()
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, proceeding with system environment variables")
@@ -21,6 +28,8 @@ func main() {
 
 	// Connect to the database
 	db := database.Connect()
+	shopify.SetDefaultAPIVersion(config.ShopifyAPIVersion())
+	secrets.SetKeyring(config.EncryptionKeyring())
 
 	// Initialize repositories
 	storeRepo := repositories.NewStoreRepository(db)
@@ -38,47 +47,51 @@ func main() {
 		log.Fatalf("Failed to fetch stores: %v", err)
 	}
 
+	// Large catalogs are exported in one bulk operation rather than page by page
+	if !*paginated {
+		catalogSyncService := services.NewCatalogSyncService(repositories.NewCatalogSyncRepository(db), storeRepo, inventoryRepo,
+			services.NewJobService(repositories.NewJobRepository(db), 1, time.Second, 10*time.Minute, 10))
+
+		for _, store := range stores {
+			log.Printf("Exporting products for store: %s", store.ShopifyStoreStub)
+			sync, err := catalogSyncService.SyncNow(context.Background(), &store, 5*time.Second)
+			if err != nil {
+				log.Printf("Failed to sync products for store %s: %v", store.ShopifyStoreStub, err)
+				continue
+			}
+			log.Printf("Synced %d variants for store %s", sync.VariantsSynced, store.ShopifyStoreStub)
+		}
+
+		log.Println("Finished syncing products for all stores")
+		return
+	}
+
 	// Process each store
 	for _, store := range stores {
 		log.Printf("Fetching products for store: %s", store.ShopifyStoreStub)
 
-		shopifyClient := shopify.NewShopifyClient(store.AccessToken, store.ShopifyStoreStub)
-		products, err := shopifyClient.FetchProducts()
-		if err != nil {
-			log.Printf("Failed to fetch products for store %s: %v", store.ShopifyStoreStub, err)
-			continue
-		}
+		// Sync inventory for the store one variant at a time as the pages come in
+		shopifyClient := shopify.NewShopifyClientWithVersion(store.AccessToken, store.ShopifyStoreStub, store.ShopifyAPIVersion)
+		synced := 0
+		err := shopifyClient.ListVariants(context.Background(), func(variant shopify.Variant) error {
+			if variant.SKU == "" {
+				log.Printf("Skipping variant %d of %s without a SKU in store %s", variant.ID, variant.ProductTitle, store.ShopifyStoreStub)
+				return nil
+			}
 
-		// Sync inventory for the store
-		for _, product := range products {
-			for _, variant := range product.Variants {
-				err := syncInventory(inventoryRepo, store, variant)
-				if err != nil {
-					log.Printf("Failed to sync inventory for SKU %s in store %s: %v", variant.SKU, store.ShopifyStoreStub, err)
-				}
+			err := inventoryRepo.SaveInventoryItem(variant.SKU, store.ID, fmt.Sprintf("%d", variant.InventoryItemID))
+			if err != nil {
+				return fmt.Errorf("failed to save inventory for SKU %s: %w", variant.SKU, err)
 			}
+			synced++
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to sync products for store %s after syncing %d variants: %v", store.ShopifyStoreStub, synced, err)
+			continue
 		}
+		log.Printf("Synced %d variants for store %s", synced, store.ShopifyStoreStub)
 	}
 
 	log.Println("Finished syncing products for all stores")
 }
-
-func syncInventory(repo *repositories.InventoryRepository, store models.Store, variant shopify.Variant) error {
-	// Check if inventory already exists
-	_, err := repo.GetInventoryBySKUAndStore(variant.SKU, store.ID)
-	if err != nil {
-		// Create new inventory if it doesn't exist
-		log.Printf("Creating new inventory for SKU %s in store %s", variant.SKU, store.ShopifyStoreStub)
-		newInventory := &models.Inventory{
-			ID:              uuid.New(),
-			SKU:             variant.SKU,
-			InventoryItemID: fmt.Sprintf("%d", variant.InventoryItemID),
-			StoreID:         store.ID,
-		}
-		return repo.CreateInventory(newInventory)
-	}
-
-	// Update existing inventory if necessary
-	log.Printf("Updating inventory for SKU %s in store %s", variant.SKU, store.ShopifyStoreStub)
-	return repo.UpdateInventoryItemID(variant.SKU, store.ID, fmt.Sprintf("%d", variant.InventoryItemID))
-}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"gostockly/config"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/internal/services"
+	"gostockly/pkg/database"
+	"gostockly/pkg/secrets"
+	"gostockly/pkg/shopify"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	stockGroupID := flag.String("stock-group", "", "ID of the stock group to reconcile, defaults to every stock group of COMPANY_ID")
+	autoCorrect := flag.Bool("auto-correct", false, "set drifted stores to the agreed quantity instead of only reporting them")
+	flag.Parse()
+
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, proceeding with system environment variables")
+	}
+
+	// Connect to the database
+	db := database.Connect()
+	shopify.SetDefaultAPIVersion(config.ShopifyAPIVersion())
+	secrets.SetKeyring(config.EncryptionKeyring())
+
+	// Initialize repositories and services
+	storeRepo := repositories.NewStoreRepository(db)
+	stockGroupRepo := repositories.NewStockGroupRepository(db)
+	ledgerRepo := repositories.NewStockLedgerRepository(db)
+	jobService := services.NewJobService(repositories.NewJobRepository(db), 1, time.Second, 10*time.Minute, 10)
+	locationService := services.NewStoreLocationService(storeRepo, repositories.NewStoreLocationRepository(db))
+	adjustmentService := services.NewAdjustmentService(storeRepo, repositories.NewDeadLetterAdjustmentRepository(db),
+		repositories.NewInventoryEchoRepository(db), ledgerRepo, locationService, jobService)
+	reconcileService := services.NewReconcileService(
+		stockGroupRepo,
+		repositories.NewStockGroupStoreRepository(db),
+		repositories.NewInventoryRepository(db),
+		ledgerRepo,
+		repositories.NewReconciliationReportRepository(db),
+		adjustmentService,
+		locationService,
+		jobService,
+		0,
+		false,
+	)
+
+	// Work out which stock groups to reconcile
+	var stockGroups []models.StockGroup
+	if *stockGroupID != "" {
+		stockGroup, err := stockGroupRepo.GetStockGroupByID(*stockGroupID)
+		if err != nil {
+			log.Fatalf("Failed to fetch stock group %s: %v", *stockGroupID, err)
+		}
+		stockGroups = append(stockGroups, *stockGroup)
+	} else {
+		companyID := os.Getenv("COMPANY_ID")
+		if companyID == "" {
+			log.Fatal("Pass -stock-group or set COMPANY_ID to reconcile every stock group of a company.")
+		}
+
+		var err error
+		stockGroups, err = stockGroupRepo.GetStockGroupsByCompany(companyID)
+		if err != nil {
+			log.Fatalf("Failed to fetch stock groups: %v", err)
+		}
+	}
+
+	// Reconcile each stock group and print its report
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	failed := false
+	for i := range stockGroups {
+		stockGroup := &stockGroups[i]
+		log.Printf("Reconciling stock group %s (%s)", stockGroup.Name, stockGroup.ID)
+
+		report, err := reconcileService.Reconcile(context.Background(), stockGroup, *autoCorrect)
+		if err != nil {
+			log.Printf("Failed to reconcile stock group %s: %v", stockGroup.ID, err)
+			failed = true
+		}
+		if report != nil {
+			encoder.Encode(report)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	log.Println("Finished reconciling stock groups")
+}
@@ -1,13 +1,53 @@
 package config
 
 import (
+	"gostockly/pkg/mailer"
+	"gostockly/pkg/secrets"
+	"gostockly/pkg/shopify"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	DatabaseURL string
 	JWTSecret   string
+
+	// AccessTokenTTL is how long an access token is valid, RefreshTokenTTL how long a session
+	// can go without being refreshed
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	WorkerConcurrency  int
+	WorkerPollInterval time.Duration
+	JobLockTimeout     time.Duration
+	JobMaxAttempts     int
+
+	InventoryLevelSettleDelay time.Duration
+
+	ReconcileInterval    time.Duration
+	ReconcileAutoCorrect bool
+
+	ShopifyAPIVersion string
+
+	// EncryptionKeyring encrypts the credentials of stores at rest
+	EncryptionKeyring *secrets.Keyring
+
+	// Credentials of the Shopify app that stores install through OAuth
+	ShopifyAPIKey    string
+	ShopifyAPISecret string
+	ShopifyScopes    []string
+	// PublicURL is where Shopify reaches the API, for OAuth redirects and webhooks
+	PublicURL string
+	// FrontendURL is where merchants are sent back to after installing the app
+	FrontendURL string
+	// WebhookRepairInterval is how often every store's webhook subscriptions are checked and repaired
+	WebhookRepairInterval time.Duration
+
+	// Mailer delivers invitations, verification and password reset emails
+	Mailer mailer.Mailer
 }
 
 func LoadConfig() *Config {
@@ -24,5 +64,156 @@ func LoadConfig() *Config {
 	return &Config{
 		DatabaseURL: dbURL,
 		JWTSecret:   jwtSecret,
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		WorkerConcurrency:  getEnvInt("WORKER_CONCURRENCY", 4),
+		WorkerPollInterval: getEnvDuration("WORKER_POLL_INTERVAL", time.Second),
+		JobLockTimeout:     getEnvDuration("JOB_LOCK_TIMEOUT", 10*time.Minute),
+		JobMaxAttempts:     getEnvInt("JOB_MAX_ATTEMPTS", 10),
+
+		InventoryLevelSettleDelay: getEnvDuration("INVENTORY_LEVEL_SETTLE_DELAY", 10*time.Second),
+
+		ReconcileInterval:    getEnvDuration("RECONCILE_INTERVAL", time.Hour),
+		ReconcileAutoCorrect: getEnvBool("RECONCILE_AUTO_CORRECT", false),
+
+		ShopifyAPIVersion: ShopifyAPIVersion(),
+
+		EncryptionKeyring: EncryptionKeyring(),
+
+		ShopifyAPIKey:    os.Getenv("SHOPIFY_API_KEY"),
+		ShopifyAPISecret: os.Getenv("SHOPIFY_API_SECRET"),
+		ShopifyScopes:    getEnvList("SHOPIFY_SCOPES", defaultShopifyScopes),
+		PublicURL:        os.Getenv("PUBLIC_URL"),
+		FrontendURL:      os.Getenv("FRONTEND_URL"),
+
+		WebhookRepairInterval: getEnvDuration("WEBHOOK_REPAIR_INTERVAL", 6*time.Hour),
+
+		Mailer: Mailer(),
+	}
+}
+
+// defaultShopifyScopes are the access scopes the stock sync needs on every store.
+var defaultShopifyScopes = []string{"read_products", "read_orders", "write_inventory", "read_locations"}
+
+// ShopifyAPIVersion reads the Shopify Admin API version used by stores without an override.
+func ShopifyAPIVersion() string {
+	version := os.Getenv("SHOPIFY_API_VERSION")
+	if version == "" {
+		return shopify.DefaultAPIVersion
+	}
+	if !shopify.ValidAPIVersion(version) {
+		log.Fatalf("SHOPIFY_API_VERSION must be a version such as %s, got %q", shopify.DefaultAPIVersion, version)
+	}
+	return version
+}
+
+// EncryptionKeyring reads the keys stored secrets are encrypted with from ENCRYPTION_KEYS, given
+// as comma separated id:base64 pairs of 32 byte keys. New secrets are encrypted with the key
+// named by ENCRYPTION_KEY_ID, which may be left out when there is only one key. Keys are
+// rotated by adding a new key, making it the primary one and running the encrypt_secrets command.
+func EncryptionKeyring() *secrets.Keyring {
+	keys := os.Getenv("ENCRYPTION_KEYS")
+	if keys == "" {
+		log.Fatal("ENCRYPTION_KEYS not set")
+	}
+	keyring, err := secrets.ParseKeyring(keys, os.Getenv("ENCRYPTION_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Invalid ENCRYPTION_KEYS: %v", err)
+	}
+	return keyring
+}
+
+// Mailer reads how emails are delivered from MAILER: "log" writes them to the log, "file"
+// writes them into MAIL_DIR and "smtp" sends them through SMTP_HOST and SMTP_PORT, signed in
+// with SMTP_USERNAME and SMTP_PASSWORD, from MAIL_FROM.
+func Mailer() mailer.Mailer {
+	switch driver := getEnvString("MAILER", "log"); driver {
+	case "log":
+		return mailer.NewLogMailer()
+	case "file":
+		m, err := mailer.NewFileMailer(getEnvString("MAIL_DIR", "mail"))
+		if err != nil {
+			log.Fatalf("Invalid MAIL_DIR: %v", err)
+		}
+		return m
+	case "smtp":
+		smtpConfig := mailer.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     getEnvInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if smtpConfig.Host == "" || smtpConfig.From == "" {
+			log.Fatal("SMTP_HOST and MAIL_FROM must be set when MAILER is smtp")
+		}
+		return mailer.NewSMTPMailer(smtpConfig)
+	default:
+		log.Fatalf("MAILER must be log, file or smtp, got %q", driver)
+		return nil
+	}
+}
+
+// getEnvString reads a string environment variable, falling back to def when it is unset.
+func getEnvString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvInt reads an integer environment variable, falling back to def when it is unset.
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", key, err)
+	}
+	return parsed
+}
+
+// getEnvBool reads a boolean such as "true" or "1" from the environment, falling back to def when it is unset.
+func getEnvBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be a boolean: %v", key, err)
+	}
+	return parsed
+}
+
+// getEnvList reads a comma separated list from the environment, falling back to def when it is unset.
+func getEnvList(key string, def []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvDuration reads a duration such as "30s" from the environment, falling back to def when it is unset.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration: %v", key, err)
 	}
+	return parsed
 }
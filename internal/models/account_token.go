@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountTokenPurpose is what an account token can be used for.
+type AccountTokenPurpose string
+
+const (
+	// AccountTokenVerifyEmail confirms that a user owns their email address
+	AccountTokenVerifyEmail AccountTokenPurpose = "verify_email"
+	// AccountTokenResetPassword lets a user who forgot their password choose a new one
+	AccountTokenResetPassword AccountTokenPurpose = "reset_password"
+)
+
+// AccountToken is a single use token emailed to a user to verify their address or reset their
+// password. Only the hash of the token is stored.
+type AccountToken struct {
+	ID        uuid.UUID           `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID           `gorm:"type:uuid;not null;index:idx_account_token_user" json:"user_id"`
+	Purpose   AccountTokenPurpose `gorm:"not null;index:idx_account_token_user" json:"purpose"`
+	TokenHash string              `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time           `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time          `json:"used_at"`
+	CreatedAt time.Time           `gorm:"index:idx_account_token_user" json:"created_at"`
+}
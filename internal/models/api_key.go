@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey lets scripts and integrations call the API on behalf of a company. The key itself is
+// only shown when it is created; the hash is stored and Prefix is kept to tell keys apart.
+type APIKey struct {
+	ID        uuid.UUID    `gorm:"type:uuid;primaryKey" json:"id"`
+	CompanyID uuid.UUID    `gorm:"type:uuid;not null;index" json:"company_id"`
+	Name      string       `gorm:"not null" json:"name"`
+	Prefix    string       `gorm:"not null" json:"prefix"`
+	KeyHash   string       `gorm:"not null;uniqueIndex" json:"-"`
+	Scopes    []Permission `gorm:"type:jsonb;serializer:json" json:"scopes"`
+	CreatedBy uuid.UUID    `gorm:"type:uuid;not null" json:"created_by"`
+	// ExpiresAt is nil for keys that do not expire
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// apiKeyScopes are the permissions an API key can be given. Managing users and API keys is
+// left to people, so a leaked key cannot create more keys.
+var apiKeyScopes = []Permission{PermissionView, PermissionOperate, PermissionManageStores}
+
+// ValidAPIKeyScope reports whether an API key can be given a permission.
+func ValidAPIKeyScope(permission Permission) bool {
+	return Grants(apiKeyScopes, permission)
+}
+
+// Can reports whether the key was given a permission.
+func (k *APIKey) Can(permission Permission) bool {
+	return Grants(k.Scopes, permission)
+}
+
+// Grants reports whether permission is one of granted.
+func Grants(granted []Permission, permission Permission) bool {
+	for _, p := range granted {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
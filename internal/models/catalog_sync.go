@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Catalog sync statuses.
+const (
+	CatalogSyncPending   = "pending"
+	CatalogSyncRunning   = "running"
+	CatalogSyncImporting = "importing"
+	CatalogSyncCompleted = "completed"
+	CatalogSyncFailed    = "failed"
+)
+
+// CatalogSync is a full import of a store's variants into its inventory mappings,
+// exported by a Shopify bulk operation.
+type CatalogSync struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	CompanyID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"company_id"`
+	StoreID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"store_id"`
+	BulkOperationID string     `gorm:"not null;default:'';index" json:"bulk_operation_id"`
+	Status          string     `gorm:"not null" json:"status"`
+	ObjectCount     int64      `gorm:"not null;default:0" json:"object_count"`
+	VariantsSynced  int        `gorm:"not null;default:0" json:"variants_synced"`
+	LastError       string     `gorm:"not null;default:''" json:"last_error"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetterAdjustment is an inventory adjustment that kept failing after all retries.
+type DeadLetterAdjustment struct {
+	ID              uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	CompanyID       uuid.UUID `gorm:"type:uuid;not null;index" json:"company_id"`
+	StockGroupID    uuid.UUID `gorm:"type:uuid;not null" json:"stock_group_id"`
+	StoreID         uuid.UUID `gorm:"type:uuid;not null" json:"store_id"`
+	SKU             string    `gorm:"not null" json:"sku"`
+	InventoryItemID string    `gorm:"not null" json:"inventory_item_id"`
+	LocationID      string    `gorm:"not null" json:"location_id"`
+	Delta           int       `gorm:"not null" json:"delta"`
+	WebhookID       string    `gorm:"not null;default:''" json:"webhook_id"`
+	MovementID      uuid.UUID `gorm:"type:uuid" json:"movement_id"`
+	JobID           uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"job_id"`
+	Attempts        int       `gorm:"not null" json:"attempts"`
+	LastError       string    `gorm:"not null;default:''" json:"last_error"`
+	CreatedAt       time.Time `json:"created_at"`
+}
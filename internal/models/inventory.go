@@ -8,8 +8,8 @@ import (
 
 type Inventory struct {
 	ID              uuid.UUID `gorm:"type:uuid;primaryKey;" json:"id"`
-	SKU             string    `gorm:"not null;" json:"sku"`
+	SKU             string    `gorm:"not null;uniqueIndex:idx_inventory_store_sku,priority:2" json:"sku"`
 	InventoryItemID string    `gorm:"not null;" json:"inventory_item_id"`
-	StoreID         uuid.UUID `gorm:"not null;" json:"store_id"`
+	StoreID         uuid.UUID `gorm:"not null;uniqueIndex:idx_inventory_store_sku,priority:1" json:"store_id"`
 	UpdatedAt       time.Time `json:"updated_at"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InventoryEcho is an inventory level Gostockly has just written to a store. Shopify reports
+// our own writes back through inventory_levels/update, and the matching echo lets us ignore them.
+type InventoryEcho struct {
+	ID              uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	StoreID         uuid.UUID `gorm:"type:uuid;not null;index:idx_inventory_echo_level" json:"store_id"`
+	InventoryItemID string    `gorm:"not null;index:idx_inventory_echo_level" json:"inventory_item_id"`
+	LocationID      string    `gorm:"not null;index:idx_inventory_echo_level" json:"location_id"`
+	Available       int       `gorm:"not null" json:"available"`
+	ExpiresAt       time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+}
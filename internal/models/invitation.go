@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invitation lets someone join a company with a role. Only the hash of its token is stored,
+// the token itself is handed to the invitee.
+type Invitation struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	CompanyID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"company_id"`
+	Email      string     `gorm:"not null" json:"email"`
+	Role       Role       `gorm:"not null" json:"role"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	InvitedBy  uuid.UUID  `gorm:"type:uuid;not null" json:"invited_by"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
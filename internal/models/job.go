@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job is a unit of background work in the Postgres backed job queue.
+type Job struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	Type        string     `gorm:"not null;index" json:"type"`
+	Payload     []byte     `gorm:"not null" json:"payload"`
+	Status      string     `gorm:"not null;default:'queued';index:idx_jobs_status_run_at" json:"status"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"not null;default:10" json:"max_attempts"`
+	RunAt       time.Time  `gorm:"not null;index:idx_jobs_status_run_at" json:"run_at"`
+	LockedAt    *time.Time `json:"locked_at"`
+	LockedBy    string     `gorm:"not null;default:''" json:"locked_by"`
+	LastError   string     `gorm:"not null;default:''" json:"last_error"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
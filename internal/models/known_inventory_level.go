@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KnownInventoryLevel is the available quantity of an inventory item at a store location that the
+// ledger already accounts for. An inventory_levels/update webhook changes the ledger by the
+// difference between the level it reports and this one.
+type KnownInventoryLevel struct {
+	ID              uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	StoreID         uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_known_inventory_level" json:"store_id"`
+	InventoryItemID string    `gorm:"not null;uniqueIndex:idx_known_inventory_level" json:"inventory_item_id"`
+	LocationID      string    `gorm:"not null;uniqueIndex:idx_known_inventory_level" json:"location_id"`
+	Available       int       `gorm:"not null" json:"available"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReconciliationReport is the outcome of comparing the available levels of every store in a
+// stock group with the quantity they should agree on.
+type ReconciliationReport struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	CompanyID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"company_id"`
+	StockGroupID uuid.UUID  `gorm:"type:uuid;not null;index" json:"stock_group_id"`
+	AutoCorrect  bool       `gorm:"not null" json:"auto_correct"`
+	SKUsChecked  int        `gorm:"not null" json:"skus_checked"`
+	DriftCount   int        `gorm:"not null" json:"drift_count"`
+	Corrected    int        `gorm:"not null" json:"corrected"`
+	Drifts       []SKUDrift `gorm:"type:jsonb;serializer:json" json:"drifts"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// SKUDrift describes a SKU whose stores do not all hold the agreed quantity.
+type SKUDrift struct {
+	SKU string `json:"sku"`
+	// Expected is the agreed quantity, or nil if the stores disagree and there is no canonical level
+	Expected *int `json:"expected"`
+	// Source is where the agreed quantity came from, either the stock ledger or the majority of stores
+	Source string `json:"source"`
+	// Skipped explains why the drift was not corrected in an auto-correct run
+	Skipped string       `json:"skipped,omitempty"`
+	Stores  []StoreDrift `json:"stores"`
+}
+
+// StoreDrift is the level of a drifted SKU in one store.
+type StoreDrift struct {
+	StoreID   uuid.UUID `json:"store_id"`
+	Store     string    `json:"store"`
+	Available *int      `json:"available"`
+	Corrected bool      `json:"corrected"`
+	Error     string    `json:"error,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is a single use token exchanged for a new access token and a new refresh token.
+// The tokens rotated from one login form a family, which is revoked as a whole when a token
+// that was already used is presented again. Only the hash of the token is stored.
+type RefreshToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	FamilyID  uuid.UUID `gorm:"type:uuid;not null;index" json:"family_id"`
+	TokenHash string    `gorm:"not null;uniqueIndex" json:"-"`
+	// TokenVersion is the user's token version when the token was issued
+	TokenVersion int        `gorm:"not null" json:"token_version"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt       *time.Time `json:"used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+// Role is what a user may do within their company.
+type Role string
+
+const (
+	// RoleOwner may do everything, including managing users and their roles
+	RoleOwner Role = "owner"
+	// RoleAdmin manages stores, their credentials and stock groups
+	RoleAdmin Role = "admin"
+	// RoleOperator works with stock, but cannot change stores or stock groups
+	RoleOperator Role = "operator"
+	// RoleViewer can only read
+	RoleViewer Role = "viewer"
+)
+
+// Permission is an action a route requires.
+type Permission string
+
+const (
+	// PermissionView reads stores, stock groups, stock and reports
+	PermissionView Permission = "view"
+	// PermissionOperate changes stock, reconciles, syncs catalogs and handles dead letters
+	PermissionOperate Permission = "operate"
+	// PermissionManageStores manages stores and their credentials, webhooks, stock groups
+	// and stock group membership
+	PermissionManageStores Permission = "manage_stores"
+	// PermissionManageUsers invites users, changes their roles and manages API keys
+	PermissionManageUsers Permission = "manage_users"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:    {PermissionView, PermissionOperate, PermissionManageStores, PermissionManageUsers},
+	RoleAdmin:    {PermissionView, PermissionOperate, PermissionManageStores},
+	RoleOperator: {PermissionView, PermissionOperate},
+	RoleViewer:   {PermissionView},
+}
+
+// Valid reports whether r is one of the defined roles.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants a permission.
+func (r Role) Can(permission Permission) bool {
+	return Grants(rolePermissions[r], permission)
+}
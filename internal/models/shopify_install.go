@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShopifyInstall is an OAuth install of the Shopify app started by a company. Its State is
+// sent to Shopify and must come back on the callback, which can complete the install once.
+type ShopifyInstall struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	CompanyID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"company_id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null" json:"user_id"`
+	ShopDomain  string     `gorm:"not null" json:"shop_domain"`
+	State       string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	StoreID     *uuid.UUID `gorm:"type:uuid" json:"store_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stock movement reasons.
+const (
+	StockMovementSeed           = "seed"
+	StockMovementOrder          = "order"
+	StockMovementRefund         = "refund"
+	StockMovementOrderCancelled = "order_cancelled"
+	StockMovementOrderEdited    = "order_edited"
+	StockMovementInventoryLevel = "inventory_level"
+	StockMovementManual         = "manual"
+	StockMovementReconcile      = "reconcile"
+)
+
+// StockLevel is the canonical quantity of a SKU in a stock group. Every store in the
+// group is kept in line with it.
+type StockLevel struct {
+	ID           uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	StockGroupID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_stock_level" json:"stock_group_id"`
+	SKU          string    `gorm:"not null;uniqueIndex:idx_stock_level" json:"sku"`
+	Quantity     int       `gorm:"not null" json:"quantity"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// StockMovement is an append-only ledger entry for a change to a StockLevel.
+type StockMovement struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	StockGroupID  uuid.UUID  `gorm:"type:uuid;not null;index:idx_stock_movement_level;uniqueIndex:idx_stock_movement_webhook" json:"stock_group_id"`
+	SKU           string     `gorm:"not null;index:idx_stock_movement_level;uniqueIndex:idx_stock_movement_webhook" json:"sku"`
+	Delta         int        `gorm:"not null" json:"delta"`
+	QuantityAfter int        `gorm:"not null" json:"quantity_after"`
+	Reason        string     `gorm:"not null" json:"reason"`
+	Note          string     `gorm:"not null;default:''" json:"note"`
+	StoreID       *uuid.UUID `gorm:"type:uuid" json:"store_id"`
+	UserID        *uuid.UUID `gorm:"type:uuid" json:"user_id"`
+	WebhookID     string     `gorm:"not null;default:'';uniqueIndex:idx_stock_movement_webhook,where:webhook_id <> ''" json:"webhook_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
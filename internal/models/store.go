@@ -9,11 +9,62 @@ import (
 type Store struct {
 	ID               uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
 	CompanyID        uuid.UUID `gorm:"type:uuid;not null" json:"company_id"`
-	ShopifyStoreStub string    `gorm:"not null" json:"shopify_store_stub"`
-	AccessToken      string    `gorm:"not null" json:"access_token"`
-	WebhookSignature string    `gorm:"not null;default:''" json:"webhook_signature"` // Default empty string
-	LocationID       string    `gorm:"not null;default:''" json:"location_id"`       // Default empty string
-	CreatedAt        time.Time `json:"created_at"`
+	ShopifyStoreStub string    `gorm:"not null;uniqueIndex:idx_stores_shopify_store_stub" json:"shopify_store_stub"`
+	// Credentials are encrypted at rest and never serialized, see StoreResponse
+	AccessToken      string `gorm:"not null;serializer:encrypted" json:"-"`
+	WebhookSignature string `gorm:"not null;default:'';serializer:encrypted" json:"-"` // Default empty string
+	// LocationID is the primary location, where stock levels are read and set. Orders fulfilled
+	// from other synced locations are followed through StoreLocation.
+	LocationID string `gorm:"not null;default:''" json:"location_id"` // Default empty string
+	// ShopifyAPIVersion overrides the deployment's Admin API version for this store when set
+	ShopifyAPIVersion string `gorm:"not null;default:''" json:"shopify_api_version"`
+	// The last deprecation Shopify reported for requests of this store
+	APIDeprecationReason  string     `gorm:"not null;default:''" json:"api_deprecation_reason"`
+	APIDeprecationVersion string     `gorm:"not null;default:''" json:"api_deprecation_version"`
+	APIDeprecatedAt       *time.Time `json:"api_deprecated_at"`
+	CreatedAt             time.Time  `json:"created_at"`
 
 	Company *Company `gorm:"foreignKey:CompanyID" json:"company"`
 }
+
+// StoreResponse is a store as returned by the API. Its credentials are redacted to whether
+// they are set.
+type StoreResponse struct {
+	ID                    uuid.UUID  `json:"id"`
+	CompanyID             uuid.UUID  `json:"company_id"`
+	ShopifyStoreStub      string     `json:"shopify_store_stub"`
+	HasAccessToken        bool       `json:"has_access_token"`
+	HasWebhookSignature   bool       `json:"has_webhook_signature"`
+	LocationID            string     `json:"location_id"`
+	ShopifyAPIVersion     string     `json:"shopify_api_version"`
+	APIDeprecationReason  string     `json:"api_deprecation_reason"`
+	APIDeprecationVersion string     `json:"api_deprecation_version"`
+	APIDeprecatedAt       *time.Time `json:"api_deprecated_at"`
+	CreatedAt             time.Time  `json:"created_at"`
+}
+
+// NewStoreResponse redacts the credentials of a store.
+func NewStoreResponse(store *Store) StoreResponse {
+	return StoreResponse{
+		ID:                    store.ID,
+		CompanyID:             store.CompanyID,
+		ShopifyStoreStub:      store.ShopifyStoreStub,
+		HasAccessToken:        store.AccessToken != "",
+		HasWebhookSignature:   store.WebhookSignature != "",
+		LocationID:            store.LocationID,
+		ShopifyAPIVersion:     store.ShopifyAPIVersion,
+		APIDeprecationReason:  store.APIDeprecationReason,
+		APIDeprecationVersion: store.APIDeprecationVersion,
+		APIDeprecatedAt:       store.APIDeprecatedAt,
+		CreatedAt:             store.CreatedAt,
+	}
+}
+
+// NewStoreResponses redacts the credentials of a list of stores.
+func NewStoreResponses(stores []Store) []StoreResponse {
+	responses := make([]StoreResponse, 0, len(stores))
+	for i := range stores {
+		responses = append(responses, NewStoreResponse(&stores[i]))
+	}
+	return responses
+}
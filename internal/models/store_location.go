@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StoreLocation is a Shopify location of a store, discovered with the locations query.
+//
+// Synced locations take part in the stock group: a store's level of a SKU is the total available
+// across them, changes at them change the group's stock, and the other stores make up the
+// difference at their synced location of the same name, which is taken to be the same warehouse.
+// Stock at ignored locations is the store's own. The store's primary location, Store.LocationID,
+// is always synced.
+type StoreLocation struct {
+	ID                   uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	StoreID              uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_store_location" json:"store_id"`
+	ShopifyLocationID    string    `gorm:"not null;uniqueIndex:idx_store_location" json:"shopify_location_id"`
+	Name                 string    `gorm:"not null" json:"name"`
+	Active               bool      `gorm:"not null" json:"active"`
+	FulfillsOnlineOrders bool      `gorm:"not null" json:"fulfills_online_orders"`
+	Synced               bool      `gorm:"not null;default:false" json:"synced"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
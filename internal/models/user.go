@@ -11,7 +11,12 @@ type User struct {
 	Email     string    `gorm:"unique;not null" json:"email"`
 	Password  string    `gorm:"not null" json:"-"`
 	CompanyID uuid.UUID `gorm:"type:uuid;not null" json:"company_id"`
-	CreatedAt time.Time `json:"created_at"`
+	Role      Role      `gorm:"not null;default:'viewer'" json:"role"`
+	// TokenVersion is raised to invalidate every access and refresh token issued to the user
+	TokenVersion int `gorm:"not null;default:0" json:"-"`
+	// EmailVerifiedAt is when the user proved they own their email address
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
 
 	Company *Company `gorm:"foreignKey:CompanyID" json:"company"`
 }
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryReceived   = "received"
+	WebhookDeliveryQueued     = "queued"
+	WebhookDeliveryProcessing = "processing"
+	WebhookDeliveryProcessed  = "processed"
+	WebhookDeliveryFailed     = "failed"
+)
+
+// WebhookDelivery is the ledger entry for a single Shopify webhook, keyed on X-Shopify-Webhook-Id.
+type WebhookDelivery struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	WebhookID   string     `gorm:"uniqueIndex;not null" json:"webhook_id"`
+	Topic       string     `gorm:"not null;default:''" json:"topic"`
+	ShopDomain  string     `gorm:"not null;default:''" json:"shop_domain"`
+	PayloadHash string     `gorm:"not null;default:''" json:"payload_hash"`
+	Status      string     `gorm:"not null;default:'received'" json:"status"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	LastError   string     `gorm:"not null;default:''" json:"last_error"`
+	ProcessedAt *time.Time `json:"processed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// WebhookDeliveryItem records a side effect of a delivery that has already been applied,
+// so a retried delivery can skip it.
+type WebhookDeliveryItem struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	DeliveryID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_webhook_delivery_item" json:"delivery_id"`
+	StoreID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_webhook_delivery_item" json:"store_id"`
+	SKU        string    `gorm:"not null;uniqueIndex:idx_webhook_delivery_item" json:"sku"`
+	Delta      int       `gorm:"not null" json:"delta"`
+	CreatedAt  time.Time `json:"created_at"`
+}
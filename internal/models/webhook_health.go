@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookHealth is the outcome of the last check of a store's webhook subscriptions against
+// the topics the stock sync requires.
+type WebhookHealth struct {
+	StoreID   uuid.UUID `gorm:"type:uuid;primaryKey" json:"store_id"`
+	CompanyID uuid.UUID `gorm:"type:uuid;not null;index" json:"company_id"`
+	Healthy   bool      `gorm:"not null" json:"healthy"`
+	// Missing are the required topics without a subscription to the expected URL
+	Missing []string `gorm:"type:jsonb;serializer:json" json:"missing"`
+	// Misconfigured are subscriptions that are not required, such as ones to an old URL or duplicates
+	Misconfigured []WebhookIssue `gorm:"type:jsonb;serializer:json" json:"misconfigured"`
+	// Repaired is how many subscriptions were created or deleted by the check
+	Repaired  int       `gorm:"not null;default:0" json:"repaired"`
+	LastError string    `gorm:"not null;default:''" json:"last_error"`
+	CheckedAt time.Time `gorm:"not null" json:"checked_at"`
+}
+
+// WebhookIssue is a webhook subscription of a store that should not exist.
+type WebhookIssue struct {
+	SubscriptionID string `json:"subscription_id"`
+	Topic          string `json:"topic"`
+	CallbackURL    string `json:"callback_url"`
+	Problem        string `json:"problem"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookRejection counts the webhook deliveries of a shop that failed signature verification for
+// the same reason within one minute. The other fields describe the latest of them.
+type WebhookRejection struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	ShopDomain  string    `gorm:"not null;default:'';index;uniqueIndex:idx_webhook_rejection_window" json:"shop_domain"`
+	Reason      string    `gorm:"not null;uniqueIndex:idx_webhook_rejection_window" json:"reason"`
+	WindowStart time.Time `gorm:"not null;uniqueIndex:idx_webhook_rejection_window" json:"window_start"`
+	Count       int       `gorm:"not null;default:1" json:"count"`
+	Topic       string    `gorm:"not null;default:''" json:"topic"`
+	WebhookID   string    `gorm:"not null;default:''" json:"webhook_id"`
+	Path        string    `gorm:"not null;default:''" json:"path"`
+	RemoteAddr  string    `gorm:"not null;default:''" json:"remote_addr"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
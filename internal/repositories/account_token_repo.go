@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrAccountTokenNotFound is returned when an account token is unknown, used, expired or meant
+// for another purpose.
+var ErrAccountTokenNotFound = errors.New("account token not found")
+
+type AccountTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountTokenRepository(db *gorm.DB) *AccountTokenRepository {
+	return &AccountTokenRepository{db: db}
+}
+
+func (r *AccountTokenRepository) CreateAccountToken(token *models.AccountToken) error {
+	return r.db.Create(token).Error
+}
+
+// CountAccountTokensSince counts the tokens created for a user and purpose since the given time.
+func (r *AccountTokenRepository) CountAccountTokensSince(userID uuid.UUID, purpose models.AccountTokenPurpose, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&count).Error
+	return count, err
+}
+
+// ClaimAccountToken marks the unused, unexpired token with the given hash and purpose as used
+// and returns it. Each token can be claimed once.
+func (r *AccountTokenRepository) ClaimAccountToken(tokenHash string, purpose models.AccountTokenPurpose, now time.Time) (*models.AccountToken, error) {
+	result := r.db.Model(&models.AccountToken{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAccountTokenNotFound
+	}
+
+	var token models.AccountToken
+	if err := r.db.First(&token, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ExpireAccountTokens marks the unused tokens of a user and purpose as used, so only a token
+// issued afterwards works.
+func (r *AccountTokenRepository) ExpireAccountTokens(userID uuid.UUID, purpose models.AccountTokenPurpose, now time.Time) error {
+	return r.db.Model(&models.AccountToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error
+}
@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrAPIKeyNotFound is returned when an API key is unknown, revoked or belongs to another company.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) CreateAPIKey(key *models.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+// GetAPIKeysByCompany retrieves the API keys of a company that were not revoked, newest first.
+func (r *APIKeyRepository) GetAPIKeysByCompany(companyID string) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.Where("company_id = ? AND revoked_at IS NULL", companyID).
+		Order("created_at DESC").
+		Find(&keys).Error
+	return keys, err
+}
+
+// GetActiveAPIKey retrieves the API key with the given hash if it is neither revoked nor expired.
+func (r *APIKeyRepository) GetActiveAPIKey(keyHash string, now time.Time) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", keyHash, now).
+		First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeAPIKey revokes an API key of a company. Revoked keys are kept for their history.
+func (r *APIKeyRepository) RevokeAPIKey(companyID, keyID string, now time.Time) error {
+	if _, err := uuid.Parse(keyID); err != nil {
+		return ErrAPIKeyNotFound
+	}
+	result := r.db.Model(&models.APIKey{}).
+		Where("company_id = ? AND id = ? AND revoked_at IS NULL", companyID, keyID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used, at most once per interval so busy keys do not
+// write on every request.
+func (r *APIKeyRepository) TouchAPIKey(id uuid.UUID, now time.Time, interval time.Duration) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-interval)).
+		Update("last_used_at", now).Error
+}
@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CatalogSyncRepository struct {
+	db *gorm.DB
+}
+
+func NewCatalogSyncRepository(db *gorm.DB) *CatalogSyncRepository {
+	return &CatalogSyncRepository{db: db}
+}
+
+func (r *CatalogSyncRepository) CreateCatalogSync(sync *models.CatalogSync) error {
+	return r.db.Create(sync).Error
+}
+
+func (r *CatalogSyncRepository) GetCatalogSyncByID(syncID uuid.UUID) (*models.CatalogSync, error) {
+	var sync models.CatalogSync
+	if err := r.db.First(&sync, "id = ?", syncID).Error; err != nil {
+		return nil, err
+	}
+	return &sync, nil
+}
+
+func (r *CatalogSyncRepository) GetCatalogSyncByBulkOperationID(bulkOperationID string) (*models.CatalogSync, error) {
+	var sync models.CatalogSync
+	if err := r.db.First(&sync, "bulk_operation_id = ?", bulkOperationID).Error; err != nil {
+		return nil, err
+	}
+	return &sync, nil
+}
+
+// GetCatalogSyncsByStore returns the most recent syncs of a store owned by the company.
+func (r *CatalogSyncRepository) GetCatalogSyncsByStore(companyID, storeID string, limit int) ([]models.CatalogSync, error) {
+	var syncs []models.CatalogSync
+	err := r.db.Where("company_id = ? AND store_id = ?", companyID, storeID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&syncs).Error
+	return syncs, err
+}
+
+// MarkCatalogSyncRunning records the bulk operation that exports the store's catalog.
+func (r *CatalogSyncRepository) MarkCatalogSyncRunning(syncID uuid.UUID, bulkOperationID string) error {
+	return r.db.Model(&models.CatalogSync{}).Where("id = ?", syncID).
+		Updates(map[string]interface{}{
+			"status":            models.CatalogSyncRunning,
+			"bulk_operation_id": bulkOperationID,
+		}).Error
+}
+
+// ClaimCatalogSyncImport moves a running sync to importing so only one worker imports its result.
+// An import left unfinished since staleBefore is assumed abandoned and may be claimed again.
+// It returns false if the sync is not ready to import or is already being imported.
+func (r *CatalogSyncRepository) ClaimCatalogSyncImport(syncID uuid.UUID, objectCount int64, staleBefore time.Time) (bool, error) {
+	result := r.db.Model(&models.CatalogSync{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))",
+			syncID, models.CatalogSyncRunning, models.CatalogSyncImporting, staleBefore).
+		Updates(map[string]interface{}{
+			"status":       models.CatalogSyncImporting,
+			"object_count": objectCount,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseCatalogSyncImport hands a failed import back so a retry can claim it.
+func (r *CatalogSyncRepository) ReleaseCatalogSyncImport(syncID uuid.UUID, lastError string) error {
+	return r.db.Model(&models.CatalogSync{}).Where("id = ? AND status = ?", syncID, models.CatalogSyncImporting).
+		Updates(map[string]interface{}{
+			"status":     models.CatalogSyncRunning,
+			"last_error": lastError,
+		}).Error
+}
+
+func (r *CatalogSyncRepository) CompleteCatalogSync(syncID uuid.UUID, variantsSynced int) error {
+	now := time.Now()
+	return r.db.Model(&models.CatalogSync{}).Where("id = ?", syncID).
+		Updates(map[string]interface{}{
+			"status":          models.CatalogSyncCompleted,
+			"variants_synced": variantsSynced,
+			"last_error":      "",
+			"completed_at":    &now,
+		}).Error
+}
+
+func (r *CatalogSyncRepository) FailCatalogSync(syncID uuid.UUID, lastError string) error {
+	now := time.Now()
+	return r.db.Model(&models.CatalogSync{}).Where("id = ?", syncID).
+		Updates(map[string]interface{}{
+			"status":       models.CatalogSyncFailed,
+			"last_error":   lastError,
+			"completed_at": &now,
+		}).Error
+}
@@ -7,6 +7,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// ErrCompanyNotFound is returned when no company matches.
+var ErrCompanyNotFound = errors.New("company not found")
+
 type CompanyRepository struct {
 	db *gorm.DB
 }
@@ -25,7 +28,17 @@ func (r *CompanyRepository) GetCompanyBySubdomain(subdomain string) (*models.Com
 	var company models.Company
 	err := r.db.Where("subdomain = ?", subdomain).First(&company).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, errors.New("company not found")
+		return nil, ErrCompanyNotFound
+	}
+	return &company, err
+}
+
+// GetCompanyByID retrieves a company by its ID.
+func (r *CompanyRepository) GetCompanyByID(companyID string) (*models.Company, error) {
+	var company models.Company
+	err := r.db.First(&company, "id = ?", companyID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCompanyNotFound
 	}
 	return &company, err
 }
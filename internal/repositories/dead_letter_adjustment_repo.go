@@ -0,0 +1,70 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrDeadLetterNotFound is returned when a dead lettered adjustment does not exist, belongs to
+// another company or has already been retried or discarded.
+var ErrDeadLetterNotFound = errors.New("dead letter adjustment not found")
+
+type DeadLetterAdjustmentRepository struct {
+	db *gorm.DB
+}
+
+func NewDeadLetterAdjustmentRepository(db *gorm.DB) *DeadLetterAdjustmentRepository {
+	return &DeadLetterAdjustmentRepository{db: db}
+}
+
+// CreateDeadLetterAdjustment adds an adjustment to the dead letter table. A job is dead lettered
+// at most once, so adding the adjustment of the same job again does nothing.
+func (r *DeadLetterAdjustmentRepository) CreateDeadLetterAdjustment(adjustment *models.DeadLetterAdjustment) error {
+	return r.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "job_id"}}, DoNothing: true}).Create(adjustment).Error
+}
+
+// GetDeadLetterAdjustmentsByCompany retrieves all dead lettered adjustments of a company, newest first.
+func (r *DeadLetterAdjustmentRepository) GetDeadLetterAdjustmentsByCompany(companyID string) ([]models.DeadLetterAdjustment, error) {
+	var adjustments []models.DeadLetterAdjustment
+	err := r.db.Where("company_id = ?", companyID).Order("created_at DESC").Find(&adjustments).Error
+	return adjustments, err
+}
+
+// GetDeadLetterAdjustmentByID retrieves a dead lettered adjustment belonging to a company.
+func (r *DeadLetterAdjustmentRepository) GetDeadLetterAdjustmentByID(companyID, adjustmentID string) (*models.DeadLetterAdjustment, error) {
+	if _, err := uuid.Parse(adjustmentID); err != nil {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	var adjustment models.DeadLetterAdjustment
+	err := r.db.Where("company_id = ? AND id = ?", companyID, adjustmentID).First(&adjustment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeadLetterNotFound
+	}
+	return &adjustment, err
+}
+
+// TakeDeadLetterAdjustment removes a dead lettered adjustment belonging to a company and returns
+// it. Of several concurrent calls for the same adjustment only one gets it, the others get
+// ErrDeadLetterNotFound.
+func (r *DeadLetterAdjustmentRepository) TakeDeadLetterAdjustment(companyID, adjustmentID string) (*models.DeadLetterAdjustment, error) {
+	if _, err := uuid.Parse(adjustmentID); err != nil {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	var adjustment models.DeadLetterAdjustment
+	result := r.db.Clauses(clause.Returning{}).
+		Where("company_id = ? AND id = ?", companyID, adjustmentID).
+		Delete(&adjustment)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrDeadLetterNotFound
+	}
+	return &adjustment, nil
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type InventoryEchoRepository struct {
+	db *gorm.DB
+}
+
+func NewInventoryEchoRepository(db *gorm.DB) *InventoryEchoRepository {
+	return &InventoryEchoRepository{db: db}
+}
+
+// CreateInventoryEcho records an inventory level we have just written.
+func (r *InventoryEchoRepository) CreateInventoryEcho(echo *models.InventoryEcho) error {
+	return r.db.Create(echo).Error
+}
+
+// consumeInventoryEchoSQL deletes, in one statement, the oldest unexpired echo matching a
+// reported level together with the echoes of the same inventory level that no webhook can match
+// anymore: expired ones and ones written before the matched echo, whose levels Shopify has
+// already reported past. An echo another caller is consuming is skipped, not waited for.
+const consumeInventoryEchoSQL = `
+	WITH matched AS (
+		SELECT id, created_at FROM inventory_echos
+		WHERE store_id = @store AND inventory_item_id = @item AND location_id = @location
+			AND available = @available AND expires_at > @now
+		ORDER BY created_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	DELETE FROM inventory_echos
+	WHERE store_id = @store AND inventory_item_id = @item AND location_id = @location
+		AND (expires_at <= @now OR id IN (SELECT id FROM matched) OR created_at < (SELECT created_at FROM matched))
+	RETURNING id IN (SELECT id FROM matched) AS consumed
+`
+
+// ConsumeInventoryEcho deletes the oldest unexpired echo matching the reported level and
+// returns whether there was one. Echoes of the same inventory level that have expired or were
+// written before the matched one are deleted with it, so a stale echo cannot hide a genuine
+// change later on. Of several concurrent calls for one echo only one consumes it.
+func (r *InventoryEchoRepository) ConsumeInventoryEcho(storeID uuid.UUID, inventoryItemID, locationID string, available int) (bool, error) {
+	var deleted []struct {
+		Consumed bool
+	}
+	err := r.db.Raw(consumeInventoryEchoSQL, map[string]interface{}{
+		"store":     storeID,
+		"item":      inventoryItemID,
+		"location":  locationID,
+		"available": available,
+		"now":       time.Now(),
+	}).Scan(&deleted).Error
+	if err != nil {
+		return false, err
+	}
+
+	for _, echo := range deleted {
+		if echo.Consumed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
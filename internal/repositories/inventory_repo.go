@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type InventoryRepository struct {
@@ -16,10 +17,6 @@ func NewInventoryRepository(db *gorm.DB) *InventoryRepository {
 	return &InventoryRepository{db: db}
 }
 
-func (r *InventoryRepository) CreateInventory(inventory *models.Inventory) error {
-	return r.db.Create(inventory).Error
-}
-
 func (r *InventoryRepository) GetInventoryBySKUAndStore(sku string, storeID uuid.UUID) (*models.Inventory, error) {
 	var inventory models.Inventory
 	err := r.db.Where("sku = ? AND store_id = ?", sku, storeID).First(&inventory).Error
@@ -29,13 +26,33 @@ func (r *InventoryRepository) GetInventoryBySKUAndStore(sku string, storeID uuid
 	return &inventory, err
 }
 
-func (r *InventoryRepository) UpdateInventoryItemID(sku string, storeID uuid.UUID, inventoryItemID string) error {
-	result := r.db.Model(&models.Inventory{}).Where("sku = ? AND store_id = ?", sku, storeID).
-		Update("inventory_item_id", inventoryItemID)
-	if result.RowsAffected == 0 {
-		return errors.New("no inventory found to update for the specified store")
+func (r *InventoryRepository) GetInventoryByInventoryItemIDAndStore(inventoryItemID string, storeID uuid.UUID) (*models.Inventory, error) {
+	var inventory models.Inventory
+	err := r.db.Where("inventory_item_id = ? AND store_id = ?", inventoryItemID, storeID).First(&inventory).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("inventory not found for the specified store")
 	}
-	return result.Error
+	return &inventory, err
+}
+
+// SaveInventoryItem maps a SKU to an inventory item in a store, creating the mapping if needed.
+// Concurrent saves of the same SKU end up with one mapping instead of racing to create it.
+func (r *InventoryRepository) SaveInventoryItem(sku string, storeID uuid.UUID, inventoryItemID string) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "store_id"}, {Name: "sku"}},
+		DoUpdates: clause.AssignmentColumns([]string{"inventory_item_id", "updated_at"}),
+	}).Create(&models.Inventory{
+		ID:              uuid.New(),
+		SKU:             sku,
+		InventoryItemID: inventoryItemID,
+		StoreID:         storeID,
+	}).Error
+}
+
+func (r *InventoryRepository) GetInventoryByStore(storeID uuid.UUID) ([]models.Inventory, error) {
+	var inventories []models.Inventory
+	err := r.db.Where("store_id = ?", storeID).Find(&inventories).Error
+	return inventories, err
 }
 
 func (r *InventoryRepository) GetInventoryByStockGroupAndStore(stockGroup string, storeID uuid.UUID) ([]models.Inventory, error) {
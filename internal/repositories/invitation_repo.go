@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInvitationNotFound is returned when an invitation is unknown, expired, already accepted
+// or belongs to another company.
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+type InvitationRepository struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) *InvitationRepository {
+	return &InvitationRepository{db: db}
+}
+
+func (r *InvitationRepository) CreateInvitation(invitation *models.Invitation) error {
+	return r.db.Create(invitation).Error
+}
+
+// GetPendingInvitationsByCompany retrieves the invitations of a company that can still be accepted.
+func (r *InvitationRepository) GetPendingInvitationsByCompany(companyID string, now time.Time) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	err := r.db.Where("company_id = ? AND accepted_at IS NULL AND expires_at > ?", companyID, now).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return invitations, err
+}
+
+// DeleteInvitation removes an invitation of a company, so it can no longer be accepted.
+func (r *InvitationRepository) DeleteInvitation(companyID, invitationID string) error {
+	if _, err := uuid.Parse(invitationID); err != nil {
+		return ErrInvitationNotFound
+	}
+	result := r.db.Delete(&models.Invitation{}, "company_id = ? AND id = ?", companyID, invitationID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// GetPendingInvitation retrieves the invitation with the given token hash if it can still be accepted.
+func (r *InvitationRepository) GetPendingInvitation(tokenHash string, now time.Time) (*models.Invitation, error) {
+	var invitation models.Invitation
+	err := r.db.Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, now).First(&invitation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// ClaimInvitation marks the invitation with the given token hash as accepted and returns it.
+// Each invitation can be claimed once, before it expires.
+func (r *InvitationRepository) ClaimInvitation(tokenHash string, now time.Time) (*models.Invitation, error) {
+	result := r.db.Model(&models.Invitation{}).
+		Where("token_hash = ? AND accepted_at IS NULL AND expires_at > ?", tokenHash, now).
+		Update("accepted_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvitationNotFound
+	}
+
+	var invitation models.Invitation
+	if err := r.db.First(&invitation, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
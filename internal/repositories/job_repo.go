@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrJobLockLost is returned when recording the outcome of a job whose lock has expired and
+// which another worker may have claimed since.
+var ErrJobLockLost = errors.New("job lock lost")
+
+type JobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+// CreateJob adds a job to the queue.
+func (r *JobRepository) CreateJob(job *models.Job) error {
+	return r.db.Create(job).Error
+}
+
+// ClaimNextJob locks the next due job and marks it as running by workerID.
+// Jobs left running longer than lockTimeout are assumed abandoned and claimed again.
+// It returns nil if no job is due.
+func (r *JobRepository) ClaimNextJob(workerID string, lockTimeout time.Duration) (*models.Job, error) {
+	var claimed *models.Job
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var job models.Job
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)",
+				models.JobQueued, now, models.JobRunning, now.Add(-lockTimeout)).
+			Order("run_at").
+			First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		job.Status = models.JobRunning
+		job.Attempts++
+		job.LockedAt = &now
+		job.LockedBy = workerID
+		err = tx.Model(&job).Updates(map[string]interface{}{
+			"status":    job.Status,
+			"attempts":  job.Attempts,
+			"locked_at": job.LockedAt,
+			"locked_by": job.LockedBy,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		claimed = &job
+		return nil
+	})
+	return claimed, err
+}
+
+// CompleteJob marks a job as successfully completed. It returns ErrJobLockLost if the job is no
+// longer held by the attempt that claimed it.
+func (r *JobRepository) CompleteJob(job *models.Job) error {
+	now := time.Now()
+	return r.releaseJob(job, map[string]interface{}{
+		"status":       models.JobCompleted,
+		"last_error":   "",
+		"completed_at": &now,
+	})
+}
+
+// RescheduleJob puts a failed job back in the queue to run again at runAt. It returns
+// ErrJobLockLost if the job is no longer held by the attempt that claimed it.
+func (r *JobRepository) RescheduleJob(job *models.Job, runAt time.Time, lastError string) error {
+	return r.releaseJob(job, map[string]interface{}{
+		"status":     models.JobQueued,
+		"run_at":     runAt,
+		"last_error": lastError,
+	})
+}
+
+// FailJob marks a job as permanently failed. It returns ErrJobLockLost if the job is no longer
+// held by the attempt that claimed it.
+func (r *JobRepository) FailJob(job *models.Job, lastError string) error {
+	return r.releaseJob(job, map[string]interface{}{
+		"status":     models.JobFailed,
+		"last_error": lastError,
+	})
+}
+
+// releaseJob records the outcome of the attempt that claimed a job and unlocks it, unless the
+// job has been claimed again since.
+func (r *JobRepository) releaseJob(job *models.Job, updates map[string]interface{}) error {
+	updates["locked_at"] = nil
+	updates["locked_by"] = ""
+	result := r.db.Model(&models.Job{}).
+		Where("id = ? AND locked_by = ? AND attempts = ?", job.ID, job.LockedBy, job.Attempts).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrJobLockLost
+	}
+	return nil
+}
+
+// HasPendingJob reports whether a job of the given type is queued or running.
+func (r *JobRepository) HasPendingJob(jobType string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Job{}).
+		Where("type = ? AND status IN ?", jobType, []string{models.JobQueued, models.JobRunning}).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,29 @@
+package repositories
+
+import (
+	"gostockly/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type ReconciliationReportRepository struct {
+	db *gorm.DB
+}
+
+func NewReconciliationReportRepository(db *gorm.DB) *ReconciliationReportRepository {
+	return &ReconciliationReportRepository{db: db}
+}
+
+func (r *ReconciliationReportRepository) CreateReconciliationReport(report *models.ReconciliationReport) error {
+	return r.db.Create(report).Error
+}
+
+// GetReconciliationReports returns the most recent reports of a stock group owned by the company.
+func (r *ReconciliationReportRepository) GetReconciliationReports(companyID, stockGroupID string, limit int) ([]models.ReconciliationReport, error) {
+	var reports []models.ReconciliationReport
+	err := r.db.Where("company_id = ? AND stock_group_id = ?", companyID, stockGroupID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&reports).Error
+	return reports, err
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrRefreshTokenNotFound is returned when no refresh token has the given hash.
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+type RefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+func (r *RefreshTokenRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by its hash, whether it is still usable or not.
+func (r *RefreshTokenRepository) GetRefreshTokenByHash(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed marks a refresh token as used when it was neither used nor revoked yet.
+// It reports false when another request used or revoked the token first.
+func (r *RefreshTokenRepository) MarkRefreshTokenUsed(id uuid.UUID, now time.Time) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token rotated from the same login.
+func (r *RefreshTokenRepository) RevokeRefreshTokenFamily(familyID uuid.UUID, now time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user.
+func (r *RefreshTokenRepository) RevokeUserRefreshTokens(userID uuid.UUID, now time.Time) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// DeleteExpiredRefreshTokens removes refresh tokens that expired before the given time.
+func (r *RefreshTokenRepository) DeleteExpiredRefreshTokens(before time.Time) error {
+	return r.db.Where("expires_at < ?", before).Delete(&models.RefreshToken{}).Error
+}
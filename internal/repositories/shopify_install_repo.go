@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInstallNotFound is returned when an install state is unknown, expired or already used.
+var ErrInstallNotFound = errors.New("shopify install not found")
+
+type ShopifyInstallRepository struct {
+	db *gorm.DB
+}
+
+func NewShopifyInstallRepository(db *gorm.DB) *ShopifyInstallRepository {
+	return &ShopifyInstallRepository{db: db}
+}
+
+func (r *ShopifyInstallRepository) CreateInstall(install *models.ShopifyInstall) error {
+	return r.db.Create(install).Error
+}
+
+// ClaimInstall marks the pending install of shopDomain with the given state as completed and
+// returns it. Each state can be claimed once, before it expires.
+func (r *ShopifyInstallRepository) ClaimInstall(state, shopDomain string, now time.Time) (*models.ShopifyInstall, error) {
+	result := r.db.Model(&models.ShopifyInstall{}).
+		Where("state = ? AND shop_domain = ? AND completed_at IS NULL AND expires_at > ?", state, shopDomain, now).
+		Update("completed_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInstallNotFound
+	}
+
+	var install models.ShopifyInstall
+	if err := r.db.First(&install, "state = ?", state).Error; err != nil {
+		return nil, err
+	}
+	return &install, nil
+}
+
+// SetInstallStore records the store created or updated by an install.
+func (r *ShopifyInstallRepository) SetInstallStore(installID, storeID uuid.UUID) error {
+	return r.db.Model(&models.ShopifyInstall{}).Where("id = ?", installID).Update("store_id", storeID).Error
+}
+
+// DeleteExpiredInstalls removes installs that were never completed and expired before the given time.
+func (r *ShopifyInstallRepository) DeleteExpiredInstalls(before time.Time) error {
+	return r.db.Where("completed_at IS NULL AND expires_at < ?", before).Delete(&models.ShopifyInstall{}).Error
+}
@@ -1,11 +1,16 @@
 package repositories
 
 import (
+	"errors"
 	"gostockly/internal/models"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrStockGroupNotFound is returned when a stock group does not exist or belongs to another company.
+var ErrStockGroupNotFound = errors.New("stock group not found")
+
 type StockGroupRepository struct {
 	db *gorm.DB
 }
@@ -24,6 +29,30 @@ func (r *StockGroupRepository) GetStockGroupsByCompany(companyID string) ([]mode
 	return stockGroups, err
 }
 
+func (r *StockGroupRepository) GetAllStockGroups() ([]models.StockGroup, error) {
+	var stockGroups []models.StockGroup
+	err := r.db.Find(&stockGroups).Error
+	return stockGroups, err
+}
+
+// GetCompanyStockGroup retrieves a stock group belonging to a company.
+func (r *StockGroupRepository) GetCompanyStockGroup(companyID, stockGroupID string) (*models.StockGroup, error) {
+	if _, err := uuid.Parse(stockGroupID); err != nil {
+		return nil, ErrStockGroupNotFound
+	}
+	var stockGroup models.StockGroup
+	err := r.db.Where("company_id = ? AND id = ?", companyID, stockGroupID).First(&stockGroup).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStockGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stockGroup, nil
+}
+
+// GetStockGroupByID retrieves a stock group by its ID, whichever company it belongs to.
+// Requests made on behalf of a company use GetCompanyStockGroup.
 func (r *StockGroupRepository) GetStockGroupByID(stockGroupID string) (*models.StockGroup, error) {
 	var stockGroup models.StockGroup
 	err := r.db.First(&stockGroup, "id = ?", stockGroupID).Error
@@ -37,6 +66,17 @@ func (r *StockGroupRepository) UpdateStockGroup(stockGroup *models.StockGroup) e
 	return r.db.Save(stockGroup).Error
 }
 
-func (r *StockGroupRepository) DeleteStockGroup(stockGroupID string) error {
-	return r.db.Delete(&models.StockGroup{}, "id = ?", stockGroupID).Error
+// DeleteStockGroup removes a stock group belonging to a company.
+func (r *StockGroupRepository) DeleteStockGroup(companyID, stockGroupID string) error {
+	if _, err := uuid.Parse(stockGroupID); err != nil {
+		return ErrStockGroupNotFound
+	}
+	result := r.db.Delete(&models.StockGroup{}, "company_id = ? AND id = ?", companyID, stockGroupID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStockGroupNotFound
+	}
+	return nil
 }
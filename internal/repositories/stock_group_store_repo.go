@@ -8,6 +8,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// ErrStoreAlreadyGrouped is returned when adding a store that is already part of a stock group.
+var ErrStoreAlreadyGrouped = errors.New("store is already part of a stock group")
+
 type StockGroupStoreRepository struct {
 	db *gorm.DB
 }
@@ -45,7 +48,7 @@ func (r *StockGroupStoreRepository) AddStoreToStockGroup(stockGroupID, storeID u
 		return err
 	}
 	if existingStockGroup != nil {
-		return errors.New("store is already part of a stock group")
+		return ErrStoreAlreadyGrouped
 	}
 
 	stockGroupStore := &models.StockGroupStore{
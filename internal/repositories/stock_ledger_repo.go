@@ -0,0 +1,232 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrStockLevelNotFound = errors.New("stock level not found")
+
+type StockLedgerRepository struct {
+	db *gorm.DB
+}
+
+func NewStockLedgerRepository(db *gorm.DB) *StockLedgerRepository {
+	return &StockLedgerRepository{db: db}
+}
+
+// GetStockLevel retrieves the canonical level of a SKU in a stock group.
+func (r *StockLedgerRepository) GetStockLevel(stockGroupID uuid.UUID, sku string) (*models.StockLevel, error) {
+	var level models.StockLevel
+	err := r.db.Where("stock_group_id = ? AND sku = ?", stockGroupID, sku).First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStockLevelNotFound
+	}
+	return &level, err
+}
+
+// GetStockLevelsByStockGroup retrieves all canonical levels of a stock group.
+func (r *StockLedgerRepository) GetStockLevelsByStockGroup(stockGroupID uuid.UUID) ([]models.StockLevel, error) {
+	var levels []models.StockLevel
+	err := r.db.Where("stock_group_id = ?", stockGroupID).Order("sku").Find(&levels).Error
+	return levels, err
+}
+
+// GetMovements retrieves the most recent movements of a SKU in a stock group, newest first.
+func (r *StockLedgerRepository) GetMovements(stockGroupID uuid.UUID, sku string, limit int) ([]models.StockMovement, error) {
+	var movements []models.StockMovement
+	err := r.db.Where("stock_group_id = ? AND sku = ?", stockGroupID, sku).
+		Order("created_at DESC").Limit(limit).Find(&movements).Error
+	return movements, err
+}
+
+// GetMovementByWebhook retrieves the movement a webhook made to a SKU, or nil if it made none.
+func (r *StockLedgerRepository) GetMovementByWebhook(stockGroupID uuid.UUID, sku, webhookID string) (*models.StockMovement, error) {
+	var movement models.StockMovement
+	err := r.db.Where("stock_group_id = ? AND sku = ? AND webhook_id = ?", stockGroupID, sku, webhookID).First(&movement).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &movement, err
+}
+
+// SeedStockLevel creates the first level of a SKU from movement.QuantityAfter and records
+// the seed movement. It returns false if the level already exists.
+func (r *StockLedgerRepository) SeedStockLevel(movement *models.StockMovement) (bool, error) {
+	created := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.StockLevel{
+			ID:           uuid.New(),
+			StockGroupID: movement.StockGroupID,
+			SKU:          movement.SKU,
+			Quantity:     movement.QuantityAfter,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		movement.Delta = movement.QuantityAfter
+		created = true
+		return tx.Create(movement).Error
+	})
+	return created, err
+}
+
+// ApplyDelta changes the level of movement.SKU by movement.Delta and records the movement.
+// It returns ErrStockLevelNotFound if the SKU has no level yet.
+func (r *StockLedgerRepository) ApplyDelta(movement *models.StockMovement) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		level, err := lockStockLevel(tx, movement.StockGroupID, movement.SKU)
+		if err != nil {
+			return err
+		}
+		return applyDelta(tx, level, movement)
+	})
+}
+
+// ApplyStoreDelta applies a change a store made at one of its locations like ApplyDelta, and moves
+// the known level of the location by the same delta so the location's own report of the change is
+// not counted again. It returns whether the location had a known level, in which case
+// at.Available is set to the new one.
+func (r *StockLedgerRepository) ApplyStoreDelta(movement *models.StockMovement, at *models.KnownInventoryLevel) (bool, error) {
+	known := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		level, err := lockStockLevel(tx, movement.StockGroupID, movement.SKU)
+		if err != nil {
+			return err
+		}
+		if err := applyDelta(tx, level, movement); err != nil {
+			return err
+		}
+
+		current, err := lockKnownLevel(tx, at)
+		if err != nil || current == nil {
+			return err
+		}
+		at.Available = current.Available + movement.Delta
+		known = true
+		return tx.Model(current).Update("available", at.Available).Error
+	})
+	return known, err
+}
+
+// ApplyReportedLevel records the level a store reported at one of its locations. The level of
+// movement.SKU changes by the difference from the location's known level, which becomes the
+// reported one, and the movement is only recorded if there is a difference. A location without a
+// known level just has it set, as there is nothing to tell a change from. It returns
+// ErrStockLevelNotFound, and changes nothing, if the SKU has no level yet.
+func (r *StockLedgerRepository) ApplyReportedLevel(movement *models.StockMovement, reported *models.KnownInventoryLevel) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		level, err := lockStockLevel(tx, movement.StockGroupID, movement.SKU)
+		if err != nil {
+			return err
+		}
+
+		known, err := lockKnownLevel(tx, reported)
+		if err != nil {
+			return err
+		}
+		movement.QuantityAfter = level.Quantity
+		if known == nil {
+			reported.ID = uuid.New()
+			return tx.Create(reported).Error
+		}
+
+		movement.Delta = reported.Available - known.Available
+		if movement.Delta == 0 {
+			return nil
+		}
+		if err := tx.Model(known).Update("available", reported.Available).Error; err != nil {
+			return err
+		}
+		return applyDelta(tx, level, movement)
+	})
+}
+
+// SetKnownLevel records the level of an inventory item at a store location that the ledger
+// accounts for, replacing the one known before.
+func (r *StockLedgerRepository) SetKnownLevel(level *models.KnownInventoryLevel) error {
+	level.ID = uuid.New()
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "store_id"}, {Name: "inventory_item_id"}, {Name: "location_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"available", "updated_at"}),
+	}).Create(level).Error
+}
+
+// GetKnownLevel retrieves the known level of an inventory item at a store location, or nil if
+// there is none.
+func (r *StockLedgerRepository) GetKnownLevel(storeID uuid.UUID, inventoryItemID, locationID string) (*models.KnownInventoryLevel, error) {
+	var level models.KnownInventoryLevel
+	err := r.db.Where("store_id = ? AND inventory_item_id = ? AND location_id = ?", storeID, inventoryItemID, locationID).
+		First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &level, err
+}
+
+// SetQuantity sets the level of movement.SKU to movement.QuantityAfter, creating it if needed,
+// and records the movement with the resulting delta.
+func (r *StockLedgerRepository) SetQuantity(movement *models.StockMovement) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.StockLevel{
+			ID:           uuid.New(),
+			StockGroupID: movement.StockGroupID,
+			SKU:          movement.SKU,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		level, err := lockStockLevel(tx, movement.StockGroupID, movement.SKU)
+		if err != nil {
+			return err
+		}
+
+		movement.Delta = movement.QuantityAfter - level.Quantity
+		level.Quantity = movement.QuantityAfter
+		if err := tx.Model(level).Update("quantity", level.Quantity).Error; err != nil {
+			return err
+		}
+		return tx.Create(movement).Error
+	})
+}
+
+// applyDelta changes a locked level by movement.Delta and records the movement.
+func applyDelta(tx *gorm.DB, level *models.StockLevel, movement *models.StockMovement) error {
+	level.Quantity += movement.Delta
+	movement.QuantityAfter = level.Quantity
+	if err := tx.Model(level).Update("quantity", level.Quantity).Error; err != nil {
+		return err
+	}
+	return tx.Create(movement).Error
+}
+
+// lockKnownLevel loads the known level of the inventory item at the location of at and locks it
+// until the end of the transaction. It returns nil if there is none.
+func lockKnownLevel(tx *gorm.DB, at *models.KnownInventoryLevel) (*models.KnownInventoryLevel, error) {
+	var level models.KnownInventoryLevel
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("store_id = ? AND inventory_item_id = ? AND location_id = ?", at.StoreID, at.InventoryItemID, at.LocationID).
+		First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &level, err
+}
+
+// lockStockLevel loads a level and locks it until the end of the transaction.
+func lockStockLevel(tx *gorm.DB, stockGroupID uuid.UUID, sku string) (*models.StockLevel, error) {
+	var level models.StockLevel
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("stock_group_id = ? AND sku = ?", stockGroupID, sku).
+		First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStockLevelNotFound
+	}
+	return &level, err
+}
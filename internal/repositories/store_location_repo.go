@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrStoreLocationNotFound is returned when a store has no location with the given ID.
+var ErrStoreLocationNotFound = errors.New("store location not found")
+
+type StoreLocationRepository struct {
+	db *gorm.DB
+}
+
+func NewStoreLocationRepository(db *gorm.DB) *StoreLocationRepository {
+	return &StoreLocationRepository{db: db}
+}
+
+// GetLocationsByStore retrieves the locations of a store by name.
+func (r *StoreLocationRepository) GetLocationsByStore(storeID uuid.UUID) ([]models.StoreLocation, error) {
+	var locations []models.StoreLocation
+	err := r.db.Where("store_id = ?", storeID).Order("name").Find(&locations).Error
+	return locations, err
+}
+
+// SaveDiscoveredLocations creates the locations a store reported and updates the ones already
+// known, keeping whether they are synced.
+func (r *StoreLocationRepository) SaveDiscoveredLocations(locations []models.StoreLocation) error {
+	if len(locations) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "store_id"}, {Name: "shopify_location_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "active", "fulfills_online_orders", "updated_at"}),
+	}).Create(&locations).Error
+}
+
+// SetLocationSynced marks a location of a store as synced or ignored.
+func (r *StoreLocationRepository) SetLocationSynced(storeID uuid.UUID, shopifyLocationID string, synced bool) error {
+	result := r.db.Model(&models.StoreLocation{}).
+		Where("store_id = ? AND shopify_location_id = ?", storeID, shopifyLocationID).
+		Updates(map[string]interface{}{"synced": synced, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStoreLocationNotFound
+	}
+	return nil
+}
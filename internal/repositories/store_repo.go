@@ -4,10 +4,15 @@ import (
 	"errors"
 	"gostockly/internal/models"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrStoreNotFound is returned when a store does not exist or belongs to another company.
+var ErrStoreNotFound = errors.New("store not found")
+
 type StoreRepository struct {
 	db *gorm.DB
 }
@@ -28,7 +33,31 @@ func (r *StoreRepository) GetStoresByCompany(companyID string) ([]models.Store,
 	return stores, err
 }
 
-// GetStoreByID retrieves a store by its ID.
+// GetAllStores retrieves the stores of every company.
+func (r *StoreRepository) GetAllStores() ([]models.Store, error) {
+	var stores []models.Store
+	err := r.db.Find(&stores).Error
+	return stores, err
+}
+
+// GetCompanyStore retrieves a store belonging to a company.
+func (r *StoreRepository) GetCompanyStore(companyID, storeID string) (*models.Store, error) {
+	if _, err := uuid.Parse(storeID); err != nil {
+		return nil, ErrStoreNotFound
+	}
+	var store models.Store
+	err := r.db.Where("company_id = ? AND id = ?", companyID, storeID).First(&store).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStoreNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// GetStoreByID retrieves a store by its ID, whichever company it belongs to. Requests made on
+// behalf of a company use GetCompanyStore.
 func (r *StoreRepository) GetStoreByID(storeID string) (*models.Store, error) {
 	var store models.Store
 	err := r.db.First(&store, "id = ?", storeID).Error
@@ -60,10 +89,41 @@ func (r *StoreRepository) GetStoreByShopifyDomain(domain string) (*models.Store,
 	return r.GetStoreByShopifyStub(shopifyStub)
 }
 
+// RecordAPIDeprecation stores the last deprecation Shopify reported for requests of a store.
+func (r *StoreRepository) RecordAPIDeprecation(shopifyStub, apiVersion, reason string, at time.Time) error {
+	return r.db.Model(&models.Store{}).
+		Where("shopify_store_stub = ?", shopifyStub).
+		Updates(map[string]interface{}{
+			"api_deprecation_reason":  reason,
+			"api_deprecation_version": apiVersion,
+			"api_deprecated_at":       at,
+		}).Error
+}
+
+// GetDeprecatedStoresByCompany retrieves the stores of a company whose requests Shopify has flagged as deprecated.
+func (r *StoreRepository) GetDeprecatedStoresByCompany(companyID string) ([]models.Store, error) {
+	var stores []models.Store
+	err := r.db.Where("company_id = ? AND api_deprecated_at IS NOT NULL", companyID).
+		Order("api_deprecated_at DESC").
+		Find(&stores).Error
+	return stores, err
+}
+
 func (r *StoreRepository) UpdateStore(store *models.Store) error {
 	return r.db.Save(store).Error
 }
 
-func (r *StoreRepository) DeleteStore(storeID string) error {
-	return r.db.Delete(&models.Store{}, "id = ?", storeID).Error
+// DeleteStore removes a store belonging to a company.
+func (r *StoreRepository) DeleteStore(companyID, storeID string) error {
+	if _, err := uuid.Parse(storeID); err != nil {
+		return ErrStoreNotFound
+	}
+	result := r.db.Delete(&models.Store{}, "company_id = ? AND id = ?", companyID, storeID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStoreNotFound
+	}
+	return nil
 }
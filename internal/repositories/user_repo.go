@@ -3,10 +3,15 @@ package repositories
 import (
 	"errors"
 	"gostockly/internal/models"
+	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrUserNotFound is returned when a user does not exist or belongs to another company.
+var ErrUserNotFound = errors.New("user not found")
+
 type UserRepository struct {
 	db *gorm.DB
 }
@@ -20,12 +25,24 @@ func (r *UserRepository) CreateUser(user *models.User) error {
 	return r.db.Create(user).Error
 }
 
-// GetUserByEmail retrieves a user by their email address.
+// CreateUserWithCompany adds a new company together with its first user, so neither is kept
+// if the other cannot be created.
+func (r *UserRepository) CreateUserWithCompany(company *models.Company, user *models.User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(company).Error; err != nil {
+			return err
+		}
+		return tx.Create(user).Error
+	})
+}
+
+// GetUserByEmail retrieves a user by their email address, ignoring case. Addresses are stored
+// lowercased, except by accounts created before they were.
 func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("email = ?", email).First(&user).Error
+	err := r.db.Where("lower(email) = lower(?)", email).First(&user).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 	return &user, err
 }
@@ -35,7 +52,56 @@ func (r *UserRepository) GetUserByID(userID string) (*models.User, error) {
 	var user models.User
 	err := r.db.First(&user, "id = ?", userID).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
+	}
+	return &user, err
+}
+
+// GetUsersByCompany retrieves the users of a company, oldest first.
+func (r *UserRepository) GetUsersByCompany(companyID string) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("company_id = ?", companyID).Order("created_at").Find(&users).Error
+	return users, err
+}
+
+// GetCompanyUser retrieves a user belonging to a company.
+func (r *UserRepository) GetCompanyUser(companyID, userID string) (*models.User, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrUserNotFound
+	}
+	var user models.User
+	err := r.db.Where("company_id = ? AND id = ?", companyID, userID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
 	}
 	return &user, err
 }
+
+// CountUsersWithRole counts the users of a company that have a role.
+func (r *UserRepository) CountUsersWithRole(companyID string, role models.Role) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("company_id = ? AND role = ?", companyID, role).Count(&count).Error
+	return count, err
+}
+
+// UpdateUserRole changes the role of a user.
+func (r *UserRepository) UpdateUserRole(userID uuid.UUID, role models.Role) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("role", role).Error
+}
+
+// IncrementTokenVersion raises the token version of a user, invalidating all their tokens.
+func (r *UserRepository) IncrementTokenVersion(userID uuid.UUID) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// UpdatePassword sets the password hash of a user.
+func (r *UserRepository) UpdatePassword(userID uuid.UUID, passwordHash string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash).Error
+}
+
+// MarkEmailVerified records that a user proved they own their email address.
+func (r *UserRepository) MarkEmailVerified(userID uuid.UUID, now time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", now).Error
+}
@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookDeliveryRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookDeliveryRepository(db *gorm.DB) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{db: db}
+}
+
+// CreateOrGetDelivery inserts the delivery unless one with the same webhook ID already exists.
+// It returns the stored delivery and whether it was newly created.
+func (r *WebhookDeliveryRepository) CreateOrGetDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, bool, error) {
+	result := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "webhook_id"}},
+		DoNothing: true,
+	}).Create(delivery)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return delivery, true, nil
+	}
+
+	existing, err := r.GetDeliveryByWebhookID(delivery.WebhookID)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+// GetDeliveryByWebhookID retrieves a delivery by its Shopify webhook ID.
+func (r *WebhookDeliveryRepository) GetDeliveryByWebhookID(webhookID string) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.db.Where("webhook_id = ?", webhookID).First(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("webhook delivery not found")
+	}
+	return &delivery, err
+}
+
+// GetDeliveryByID retrieves a delivery by its ID.
+func (r *WebhookDeliveryRepository) GetDeliveryByID(deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := r.db.First(&delivery, "id = ?", deliveryID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("webhook delivery not found")
+	}
+	return &delivery, err
+}
+
+// MarkDeliveryQueued records that a job has been queued to process the delivery.
+func (r *WebhookDeliveryRepository) MarkDeliveryQueued(deliveryID uuid.UUID) error {
+	return r.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ?", deliveryID, models.WebhookDeliveryReceived).
+		Updates(map[string]interface{}{
+			"status":     models.WebhookDeliveryQueued,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// ClaimDelivery marks a delivery as processing if it is not processed and not being
+// processed by someone else. Deliveries stuck in processing since before staleBefore
+// are claimed again. It returns false if the delivery could not be claimed.
+func (r *WebhookDeliveryRepository) ClaimDelivery(deliveryID uuid.UUID, staleBefore time.Time) (bool, error) {
+	result := r.db.Model(&models.WebhookDelivery{}).
+		Where("id = ?", deliveryID).
+		Where("status IN ? OR (status = ? AND updated_at < ?)",
+			[]string{models.WebhookDeliveryReceived, models.WebhookDeliveryQueued, models.WebhookDeliveryFailed},
+			models.WebhookDeliveryProcessing, staleBefore).
+		Updates(map[string]interface{}{
+			"status":     models.WebhookDeliveryProcessing,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": time.Now(),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// MarkDeliveryProcessed records that a delivery finished without errors.
+func (r *WebhookDeliveryRepository) MarkDeliveryProcessed(deliveryID uuid.UUID) error {
+	now := time.Now()
+	return r.db.Model(&models.WebhookDelivery{}).Where("id = ?", deliveryID).
+		Updates(map[string]interface{}{
+			"status":       models.WebhookDeliveryProcessed,
+			"last_error":   "",
+			"processed_at": &now,
+			"updated_at":   now,
+		}).Error
+}
+
+// MarkDeliveryFailed records that a delivery failed so it can be resumed on retry.
+func (r *WebhookDeliveryRepository) MarkDeliveryFailed(deliveryID uuid.UUID, lastError string) error {
+	return r.db.Model(&models.WebhookDelivery{}).Where("id = ?", deliveryID).
+		Updates(map[string]interface{}{
+			"status":     models.WebhookDeliveryFailed,
+			"last_error": lastError,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// GetAppliedItems returns the side effects of a delivery that have already been applied.
+func (r *WebhookDeliveryRepository) GetAppliedItems(deliveryID uuid.UUID) ([]models.WebhookDeliveryItem, error) {
+	var items []models.WebhookDeliveryItem
+	err := r.db.Where("delivery_id = ?", deliveryID).Find(&items).Error
+	return items, err
+}
+
+// CreateAppliedItem records that a side effect of a delivery has been applied.
+func (r *WebhookDeliveryRepository) CreateAppliedItem(item *models.WebhookDeliveryItem) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(item).Error
+}
@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"gostockly/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type WebhookHealthRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookHealthRepository(db *gorm.DB) *WebhookHealthRepository {
+	return &WebhookHealthRepository{db: db}
+}
+
+// SaveWebhookHealth stores the result of a check, replacing the previous result of the store.
+func (r *WebhookHealthRepository) SaveWebhookHealth(health *models.WebhookHealth) error {
+	return r.db.Save(health).Error
+}
+
+// GetWebhookHealthByCompany returns the last check of every checked store of the company.
+func (r *WebhookHealthRepository) GetWebhookHealthByCompany(companyID string) ([]models.WebhookHealth, error) {
+	var health []models.WebhookHealth
+	err := r.db.Where("company_id = ?", companyID).Find(&health).Error
+	return health, err
+}
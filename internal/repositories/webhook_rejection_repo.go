@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"time"
+
+	"gostockly/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// webhookRejectionWindow is how long the rejections of a shop for one reason share a row.
+const webhookRejectionWindow = time.Minute
+
+type WebhookRejectionRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRejectionRepository(db *gorm.DB) *WebhookRejectionRepository {
+	return &WebhookRejectionRepository{db: db}
+}
+
+// RecordWebhookRejection counts a webhook delivery that failed verification in the row of its
+// shop and reason for the current minute, so a flood of forged requests adds one row a minute.
+func (r *WebhookRejectionRepository) RecordWebhookRejection(rejection *models.WebhookRejection) error {
+	rejection.ID = uuid.New()
+	rejection.WindowStart = time.Now().UTC().Truncate(webhookRejectionWindow)
+	rejection.Count = 1
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "shop_domain"}, {Name: "reason"}, {Name: "window_start"}},
+		DoUpdates: append(
+			clause.AssignmentColumns([]string{"topic", "webhook_id", "path", "remote_addr", "updated_at"}),
+			clause.Assignment{Column: clause.Column{Name: "count"}, Value: gorm.Expr("webhook_rejections.count + 1")},
+		),
+	}).Create(rejection).Error
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/mailer"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// verifyEmailTTL is how long an email verification link can be used
+	verifyEmailTTL = 48 * time.Hour
+	// resetPasswordTTL is how long a password reset link can be used
+	resetPasswordTTL = time.Hour
+	// accountTokenLimit is how many emails of each kind a user can be sent per accountTokenWindow
+	accountTokenLimit  = 3
+	accountTokenWindow = time.Hour
+)
+
+var (
+	ErrInvalidAccountToken = errors.New("invalid or expired link")
+	ErrEmailNotVerified    = errors.New("email address is not verified")
+	// ErrTooManyEmails is returned when a user was sent too many emails of one kind recently
+	ErrTooManyEmails = errors.New("too many emails were sent to this address, try again later")
+)
+
+// AccountService verifies the email addresses of users and lets them reset a forgotten
+// password, through single use links emailed to them.
+type AccountService struct {
+	UserRepo         *repositories.UserRepository
+	AccountTokenRepo *repositories.AccountTokenRepository
+	Sessions         *SessionService
+	Mailer           mailer.Mailer
+	// FrontendURL is where the pages the links lead to are
+	FrontendURL string
+}
+
+func NewAccountService(userRepo *repositories.UserRepository, accountTokenRepo *repositories.AccountTokenRepository, sessions *SessionService, m mailer.Mailer, frontendURL string) *AccountService {
+	return &AccountService{
+		UserRepo:         userRepo,
+		AccountTokenRepo: accountTokenRepo,
+		Sessions:         sessions,
+		Mailer:           m,
+		FrontendURL:      strings.TrimRight(frontendURL, "/"),
+	}
+}
+
+// SendVerification emails a user the link that verifies their address.
+func (s *AccountService) SendVerification(user *models.User) error {
+	token, err := s.issue(user, models.AccountTokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm that this is your email address to start using your account:\n%s\n\n"+
+			"The link expires in %s. If you did not create an account, you can ignore this email.\n",
+			s.link("/verify-email", token), humanDuration(verifyEmailTTL)),
+	})
+}
+
+// ResendVerification emails a new verification link to the user with the given address. It
+// does nothing for unknown or verified addresses, so it cannot be used to find accounts.
+func (s *AccountService) ResendVerification(email string) error {
+	user, err := s.UserRepo.GetUserByEmail(normalizeEmail(email))
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+	return s.SendVerification(user)
+}
+
+// VerifyEmail marks the address of the user a verification token was sent to as verified.
+func (s *AccountService) VerifyEmail(token string) (*models.User, error) {
+	claimed, err := s.AccountTokenRepo.ClaimAccountToken(hashToken(token), models.AccountTokenVerifyEmail, time.Now())
+	if errors.Is(err, repositories.ErrAccountTokenNotFound) {
+		return nil, ErrInvalidAccountToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.UserRepo.MarkEmailVerified(claimed.UserID, time.Now()); err != nil {
+		return nil, err
+	}
+	logger.GetLogger().Info("User %s verified their email address", claimed.UserID)
+	return s.UserRepo.GetUserByID(claimed.UserID.String())
+}
+
+// RequestPasswordReset emails a password reset link to the user with the given address. It
+// does nothing for unknown addresses, so it cannot be used to find accounts.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	user, err := s.UserRepo.GetUserByEmail(normalizeEmail(email))
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issue(user, models.AccountTokenResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Choose a new password for your account:\n%s\n\n"+
+			"The link expires in %s. If you did not ask to reset your password, you can ignore this email.\n",
+			s.link("/reset-password", token), humanDuration(resetPasswordTTL)),
+	})
+}
+
+// ResetPassword sets a new password for the user a reset token was sent to and ends all their
+// sessions. As the token was emailed to them, their address counts as verified too.
+func (s *AccountService) ResetPassword(token, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+
+	claimed, err := s.AccountTokenRepo.ClaimAccountToken(hashToken(token), models.AccountTokenResetPassword, time.Now())
+	if errors.Is(err, repositories.ErrAccountTokenNotFound) {
+		return ErrInvalidAccountToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.UserRepo.UpdatePassword(claimed.UserID, string(hashedPassword)); err != nil {
+		return err
+	}
+	if err := s.UserRepo.MarkEmailVerified(claimed.UserID, time.Now()); err != nil {
+		return err
+	}
+	if err := s.Sessions.RevokeSessions(claimed.UserID); err != nil {
+		return err
+	}
+	logger.GetLogger().Info("User %s reset their password", claimed.UserID)
+	return nil
+}
+
+// issue creates a token for a user, replacing the earlier ones of the same purpose, unless the
+// user was sent too many recently.
+func (s *AccountService) issue(user *models.User, purpose models.AccountTokenPurpose, ttl time.Duration) (string, error) {
+	now := time.Now()
+	sent, err := s.AccountTokenRepo.CountAccountTokensSince(user.ID, purpose, now.Add(-accountTokenWindow))
+	if err != nil {
+		return "", err
+	}
+	if sent >= accountTokenLimit {
+		return "", ErrTooManyEmails
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	if err := s.AccountTokenRepo.ExpireAccountTokens(user.ID, purpose, now); err != nil {
+		return "", err
+	}
+	if err := s.AccountTokenRepo.CreateAccountToken(&models.AccountToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl),
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// link is the frontend page at path with a token.
+func (s *AccountService) link(path, token string) string {
+	return s.FrontendURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// humanDuration writes a whole number of hours the way an email would.
+func humanDuration(d time.Duration) string {
+	if hours := int(d / time.Hour); hours != 1 {
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return "1 hour"
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+var accountLink = regexp.MustCompile(`https://app\.test/(verify-email|reset-password)\?token=(\S+)`)
+
+// lastLinkToken returns the token of the link in the last email sent.
+func lastLinkToken(t *testing.T, mail *recordingMailer) string {
+	t.Helper()
+	if len(mail.sent) == 0 {
+		t.Fatal("expected an email to be sent")
+	}
+	match := accountLink.FindStringSubmatch(mail.sent[len(mail.sent)-1].Body)
+	if match == nil {
+		t.Fatalf("email has no link: %s", mail.sent[len(mail.sent)-1].Body)
+	}
+	token, _ := url.QueryUnescape(match[2])
+	return token
+}
+
+func TestAccountVerificationAndPasswordReset(t *testing.T) {
+	db := openTestDB(t)
+	userRepo := repositories.NewUserRepository(db)
+	sessions := NewSessionService(userRepo, repositories.NewRefreshTokenRepository(db), "test-secret", time.Minute, time.Hour)
+	mail := &recordingMailer{}
+	accounts := NewAccountService(userRepo, repositories.NewAccountTokenRepository(db), sessions, mail, "https://app.test/")
+	userService := NewUserService(userRepo, repositories.NewCompanyRepository(db), sessions, accounts)
+
+	suffix := uuid.New().String()[:8]
+	email := "account-" + suffix + "@accounts.test"
+	if _, err := userService.RegisterUser(email, "password", "Accounts "+suffix, "accounts-"+suffix); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if _, err := userService.AuthenticateUser(email, "password"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected sign in before verification to be refused, got %v", err)
+	}
+
+	verifyToken := lastLinkToken(t, mail)
+	if _, err := accounts.VerifyEmail(verifyToken); err != nil {
+		t.Fatalf("failed to verify email: %v", err)
+	}
+	if _, err := accounts.VerifyEmail(verifyToken); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Fatalf("expected verification token to be single use, got %v", err)
+	}
+	session, err := userService.AuthenticateUser(email, "password")
+	if err != nil {
+		t.Fatalf("expected sign in after verification: %v", err)
+	}
+
+	// Unknown addresses are not told apart from known ones
+	sent := len(mail.sent)
+	if err := accounts.RequestPasswordReset("nobody-" + suffix + "@accounts.test"); err != nil {
+		t.Fatalf("expected reset of unknown address to succeed silently, got %v", err)
+	}
+	if len(mail.sent) != sent {
+		t.Fatal("expected no email for an unknown address")
+	}
+
+	if err := accounts.RequestPasswordReset(email); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+	first := lastLinkToken(t, mail)
+	if err := accounts.RequestPasswordReset(email); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+	resetToken := lastLinkToken(t, mail)
+	if err := accounts.ResetPassword(first, "new-password"); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Fatalf("expected an earlier reset link to stop working, got %v", err)
+	}
+	if err := accounts.ResetPassword(resetToken, "short"); !errors.Is(err, ErrPasswordTooShort) {
+		t.Fatalf("expected short password to be refused, got %v", err)
+	}
+	if err := accounts.ResetPassword(resetToken, "new-password"); err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+	if err := accounts.ResetPassword(resetToken, "other-password"); !errors.Is(err, ErrInvalidAccountToken) {
+		t.Fatalf("expected reset token to be single use, got %v", err)
+	}
+
+	if _, err := sessions.Authenticate(session.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Fatalf("expected sessions to end with the password reset, got %v", err)
+	}
+	if _, err := userService.AuthenticateUser(email, "new-password"); err != nil {
+		t.Fatalf("expected sign in with the new password: %v", err)
+	}
+
+	// Two reset emails were sent this hour, the third is the last one allowed
+	if err := accounts.RequestPasswordReset(email); err != nil {
+		t.Fatalf("failed to request password reset: %v", err)
+	}
+	if err := accounts.RequestPasswordReset(email); !errors.Is(err, ErrTooManyEmails) {
+		t.Fatalf("expected password resets to be rate limited, got %v", err)
+	}
+}
+
+func TestRegisterUserNormalizesEmailAndCreatesCompanyWithOwner(t *testing.T) {
+	db := openTestDB(t)
+	userRepo := repositories.NewUserRepository(db)
+	companyRepo := repositories.NewCompanyRepository(db)
+	sessions := NewSessionService(userRepo, repositories.NewRefreshTokenRepository(db), "test-secret", time.Minute, time.Hour)
+	accounts := NewAccountService(userRepo, repositories.NewAccountTokenRepository(db), sessions, &recordingMailer{}, "https://app.test/")
+	userService := NewUserService(userRepo, companyRepo, sessions, accounts)
+
+	suffix := uuid.New().String()[:8]
+	user, err := userService.RegisterUser("  Owner-"+suffix+"@Accounts.TEST ", "password", "Cased "+suffix, "cased-"+suffix)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	email := "owner-" + suffix + "@accounts.test"
+	if user.Email != email {
+		t.Fatalf("expected the address to be stored as %s, got %s", email, user.Email)
+	}
+	if _, err := userService.RegisterUser("OWNER-"+suffix+"@accounts.test", "password", "Other "+suffix, "other-"+suffix); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("expected the address in another case to be taken, got %v", err)
+	}
+	if _, err := userService.AuthenticateUser("Owner-"+suffix+"@ACCOUNTS.test", "password"); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("expected the account to be found in another case, got %v", err)
+	}
+
+	// A company is not kept without its owner
+	duplicate := &models.User{ID: uuid.New(), Email: email, Password: "hash", Role: models.RoleOwner}
+	company := &models.Company{ID: uuid.New(), Name: "Orphan " + suffix, Subdomain: "orphan-" + suffix}
+	duplicate.CompanyID = company.ID
+	if err := userRepo.CreateUserWithCompany(company, duplicate); err == nil {
+		t.Fatal("expected creating a second user with the address to fail")
+	}
+	if _, err := companyRepo.GetCompanyBySubdomain("orphan-" + suffix); !errors.Is(err, repositories.ErrCompanyNotFound) {
+		t.Fatalf("expected the company to be rolled back, got %v", err)
+	}
+}
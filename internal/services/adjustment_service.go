@@ -0,0 +1,496 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDeadLetterNotFound is returned for dead letters that do not exist, belong to another
+// company or have already been retried or discarded.
+var ErrDeadLetterNotFound = repositories.ErrDeadLetterNotFound
+
+// JobTypeInventoryAdjustment retries a single failed per-store, per-SKU adjustment.
+const JobTypeInventoryAdjustment = "inventory.adjust"
+
+// inventoryEchoTTL is how long we wait for Shopify to report one of our own writes back.
+const inventoryEchoTTL = 2 * time.Minute
+
+// InventoryAdjustment is a stock change for one SKU in one store, used as the retry job payload.
+type InventoryAdjustment struct {
+	CompanyID       uuid.UUID `json:"company_id"`
+	StockGroupID    uuid.UUID `json:"stock_group_id"`
+	StoreID         uuid.UUID `json:"store_id"`
+	SKU             string    `json:"sku"`
+	InventoryItemID string    `json:"inventory_item_id"`
+	LocationID      string    `json:"location_id"`
+	Delta           int       `json:"delta"`
+	WebhookID       string    `json:"webhook_id"`
+	MovementID      uuid.UUID `json:"movement_id"`
+}
+
+// reference is the referenceDocumentUri for the adjustment, pointing at the ledger
+// movement it was derived from, or at the webhook for adjustments queued before the ledger.
+func (a InventoryAdjustment) reference() string {
+	if a.MovementID != uuid.Nil {
+		return movementReference(a.MovementID)
+	}
+	return webhookReference(a.WebhookID)
+}
+
+type AdjustmentService struct {
+	StoreRepo       *repositories.StoreRepository
+	DeadLetterRepo  *repositories.DeadLetterAdjustmentRepository
+	EchoRepo        *repositories.InventoryEchoRepository
+	LedgerRepo      *repositories.StockLedgerRepository
+	LocationService *StoreLocationService
+	JobService      *JobService
+}
+
+func NewAdjustmentService(
+	storeRepo *repositories.StoreRepository,
+	deadLetterRepo *repositories.DeadLetterAdjustmentRepository,
+	echoRepo *repositories.InventoryEchoRepository,
+	ledgerRepo *repositories.StockLedgerRepository,
+	locationService *StoreLocationService,
+	jobService *JobService,
+) *AdjustmentService {
+	s := &AdjustmentService{
+		StoreRepo:       storeRepo,
+		DeadLetterRepo:  deadLetterRepo,
+		EchoRepo:        echoRepo,
+		LedgerRepo:      ledgerRepo,
+		LocationService: locationService,
+		JobService:      jobService,
+	}
+
+	jobService.RegisterHandler(JobTypeInventoryAdjustment, s.ProcessAdjustmentJob)
+	jobService.RegisterDeadLetterHandler(JobTypeInventoryAdjustment, s.deadLetterAdjustment)
+
+	return s
+}
+
+// ScheduleRetry queues a failed adjustment to be retried with backoff.
+func (s *AdjustmentService) ScheduleRetry(adjustment InventoryAdjustment, cause error) error {
+	log := logger.GetLogger()
+
+	job, err := s.JobService.EnqueueRetry(JobTypeInventoryAdjustment, adjustment, cause)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Scheduled retry job %s for SKU %s in store %s", job.ID, adjustment.SKU, adjustment.StoreID)
+	return nil
+}
+
+// ProcessAdjustmentJob is the job handler that retries a failed adjustment.
+//
+// The delta is not replayed, as an earlier attempt that timed out may have reached Shopify
+// after all and the job may have been claimed again after its lock expired. Instead the store
+// is brought to the SKU's canonical level with SyncLevels, so a retry of an adjustment that did
+// land changes nothing.
+func (s *AdjustmentService) ProcessAdjustmentJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	var adjustment InventoryAdjustment
+	if err := DecodeJobPayload(job, &adjustment); err != nil {
+		return err
+	}
+
+	store, err := s.StoreRepo.GetStoreByID(adjustment.StoreID.String())
+	if err != nil {
+		// The store has been removed since, so there is nothing left to adjust
+		log.Error("Dropping adjustment job %s, store %s not found: %v", job.ID, adjustment.StoreID, err)
+		return nil
+	}
+
+	level, err := s.LedgerRepo.GetStockLevel(adjustment.StockGroupID, adjustment.SKU)
+	if err != nil {
+		return fmt.Errorf("failed to read the canonical level of SKU %s: %w", adjustment.SKU, err)
+	}
+
+	routing, err := s.LocationService.routing([]models.Store{*store})
+	if err != nil {
+		return fmt.Errorf("failed to load locations of store %s: %w", store.ShopifyStoreStub, err)
+	}
+
+	log.Info("Retrying inventory adjustment for SKU %s in store %s (attempt %d)", adjustment.SKU, store.ShopifyStoreStub, job.Attempts)
+	errs, err := s.SyncLevels(ctx, newStoreClient(store), store, routing.synced(store), []LevelChange{{
+		SKU:             adjustment.SKU,
+		InventoryItemID: adjustment.InventoryItemID,
+		LocationID:      adjustment.LocationID,
+		Quantity:        level.Quantity,
+	}}, adjustment.reference())
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+// deadLetterAdjustment moves an adjustment that ran out of attempts to the dead letter table.
+func (s *AdjustmentService) deadLetterAdjustment(job *models.Job, cause error) error {
+	var adjustment InventoryAdjustment
+	if err := DecodeJobPayload(job, &adjustment); err != nil {
+		return err
+	}
+
+	return s.DeadLetterRepo.CreateDeadLetterAdjustment(&models.DeadLetterAdjustment{
+		ID:              uuid.New(),
+		CompanyID:       adjustment.CompanyID,
+		StockGroupID:    adjustment.StockGroupID,
+		StoreID:         adjustment.StoreID,
+		SKU:             adjustment.SKU,
+		InventoryItemID: adjustment.InventoryItemID,
+		LocationID:      adjustment.LocationID,
+		Delta:           adjustment.Delta,
+		WebhookID:       adjustment.WebhookID,
+		MovementID:      adjustment.MovementID,
+		JobID:           job.ID,
+		Attempts:        job.Attempts,
+		LastError:       cause.Error(),
+	})
+}
+
+func (s *AdjustmentService) GetDeadLettersByCompany(companyID string) ([]models.DeadLetterAdjustment, error) {
+	return s.DeadLetterRepo.GetDeadLetterAdjustmentsByCompany(companyID)
+}
+
+func (s *AdjustmentService) GetDeadLetterByID(companyID, deadLetterID string) (*models.DeadLetterAdjustment, error) {
+	return s.DeadLetterRepo.GetDeadLetterAdjustmentByID(companyID, deadLetterID)
+}
+
+// RetryDeadLetter queues a dead lettered adjustment again with a fresh set of attempts
+// and removes it from the dead letter table. The adjustment is taken out of the table before
+// it is queued, so retrying it twice at the same time queues it once.
+func (s *AdjustmentService) RetryDeadLetter(companyID, deadLetterID string) (*models.Job, error) {
+	log := logger.GetLogger()
+
+	deadLetter, err := s.DeadLetterRepo.TakeDeadLetterAdjustment(companyID, deadLetterID)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := s.JobService.Enqueue(JobTypeInventoryAdjustment, InventoryAdjustment{
+		CompanyID:       deadLetter.CompanyID,
+		StockGroupID:    deadLetter.StockGroupID,
+		StoreID:         deadLetter.StoreID,
+		SKU:             deadLetter.SKU,
+		InventoryItemID: deadLetter.InventoryItemID,
+		LocationID:      deadLetter.LocationID,
+		Delta:           deadLetter.Delta,
+		WebhookID:       deadLetter.WebhookID,
+		MovementID:      deadLetter.MovementID,
+	})
+	if err != nil {
+		// Put the adjustment back so it can be retried again
+		if restoreErr := s.DeadLetterRepo.CreateDeadLetterAdjustment(deadLetter); restoreErr != nil {
+			log.Error("Failed to restore dead letter %s after failing to queue it: %v", deadLetter.ID, restoreErr)
+		}
+		return nil, errors.New("failed to queue adjustment")
+	}
+	return job, nil
+}
+
+// DiscardDeadLetter drops a dead lettered adjustment without applying it.
+func (s *AdjustmentService) DiscardDeadLetter(companyID, deadLetterID string) error {
+	_, err := s.DeadLetterRepo.TakeDeadLetterAdjustment(companyID, deadLetterID)
+	return err
+}
+
+// webhookReference is the referenceDocumentUri for writes caused by a webhook. Every write
+// Gostockly makes carries a gostockly:// reference so it can be told apart in Shopify's
+// inventory history.
+func webhookReference(webhookID string) string {
+	return "gostockly://webhook/" + webhookID
+}
+
+// movementReference is the referenceDocumentUri for writes derived from a stock ledger movement.
+func movementReference(movementID uuid.UUID) string {
+	return "gostockly://movement/" + movementID.String()
+}
+
+// reconcileReference is the referenceDocumentUri for corrections made by a reconciliation run.
+func reconcileReference(reportID uuid.UUID) string {
+	return "gostockly://reconcile/" + reportID.String()
+}
+
+// LevelChange asks for an inventory item of a store to be brought to the canonical level of its SKU.
+type LevelChange struct {
+	SKU             string
+	InventoryItemID string
+	// LocationID is the location of the store any difference is made up at
+	LocationID string
+	// Quantity is the canonical level
+	Quantity int
+}
+
+// SyncLevels brings inventory items of a store to the canonical levels of their SKUs. The level
+// of an item in a store is its available quantity summed over the store's synced locations, and
+// any difference is made up at the location the change names. Items already at their level are
+// left alone, so syncing the same levels again changes nothing.
+//
+// The differences are sent as deltas in batched inventoryAdjustQuantities mutations, so a sale
+// that races the write is kept rather than overwritten, and a retry reads the levels again so a
+// delta that landed is not applied twice. The written levels are remembered so their
+// inventory_levels/update echoes are ignored.
+//
+// The returned slice holds an error for every change that was not applied, at the change's
+// index. If a request fails outright, the changes it and the later requests carried are all
+// given its error, which is also returned. Changes sent by earlier mutations keep their outcome,
+// so only the failed ones need to be retried.
+func (s *AdjustmentService) SyncLevels(ctx context.Context, client *shopify.ShopifyClient, store *models.Store, syncedLocations []string, changes []LevelChange, reference string) ([]error, error) {
+	log := logger.GetLogger()
+	errs := make([]error, len(changes))
+
+	// Read the current levels at the synced locations and at the locations the changes are made at
+	locations := append([]string(nil), syncedLocations...)
+	var items []string
+	seen := make(map[string]bool)
+	for _, change := range changes {
+		if !slices.Contains(locations, change.LocationID) {
+			locations = append(locations, change.LocationID)
+		}
+		if !seen[change.InventoryItemID] {
+			seen[change.InventoryItemID] = true
+			items = append(items, change.InventoryItemID)
+		}
+	}
+	current, err := readLevels(ctx, client, store, locations, items)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs, err
+	}
+
+	var adjustments []shopify.QuantityChange
+	var levels []int
+	var indexes []int
+	for i, change := range changes {
+		total := 0
+		for _, locationID := range syncedLocations {
+			total += current[locationID][change.InventoryItemID]
+		}
+		difference := change.Quantity - total
+		if difference == 0 {
+			continue
+		}
+
+		at, ok := current[change.LocationID][change.InventoryItemID]
+		if !ok {
+			errs[i] = fmt.Errorf("inventory item %s is not stocked at location %s: %w", change.InventoryItemID, change.LocationID, shopify.ErrNotStocked)
+			continue
+		}
+		adjustments = append(adjustments, shopify.QuantityChange{
+			InventoryItemID: change.InventoryItemID,
+			LocationID:      change.LocationID,
+			Delta:           difference,
+		})
+		levels = append(levels, at+difference)
+		indexes = append(indexes, i)
+	}
+	if len(adjustments) == 0 {
+		return errs, nil
+	}
+
+	// Record the echoes first, Shopify may deliver the webhooks before the mutation returns
+	for position, adjustment := range adjustments {
+		s.recordEcho(store, adjustment.InventoryItemID, adjustment.LocationID, levels[position])
+	}
+
+	adjustmentErrs, err := sendAdjustments(ctx, client, adjustments, reference)
+	for position, i := range indexes {
+		adjustment := adjustments[position]
+		errs[i] = adjustmentErrs[position]
+		if errs[i] != nil {
+			// Nothing was written, so no webhook will echo it
+			s.forgetEcho(store, adjustment.InventoryItemID, adjustment.LocationID, levels[position])
+			continue
+		}
+		s.rememberLevel(store, adjustment.InventoryItemID, adjustment.LocationID, levels[position])
+	}
+	if err != nil {
+		log.Error("Failed to adjust inventory levels in store %s: %v", store.ShopifyStoreStub, err)
+		return errs, err
+	}
+
+	log.Info("Adjusted %d inventory levels in store %s", len(adjustments), store.ShopifyStoreStub)
+	return errs, nil
+}
+
+// StoreLevels returns the level of inventory items in a store: their available quantity summed
+// over the store's synced locations. Items stocked at none of the locations are left out.
+func (s *AdjustmentService) StoreLevels(ctx context.Context, client *shopify.ShopifyClient, store *models.Store, syncedLocations, inventoryItemIDs []string) (map[string]int, error) {
+	current, err := readLevels(ctx, client, store, syncedLocations, inventoryItemIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]int, len(inventoryItemIDs))
+	for _, levels := range current {
+		for itemID, available := range levels {
+			totals[itemID] += available
+		}
+	}
+	return totals, nil
+}
+
+// readLevels reads the available quantity of inventory items at each of the locations of a store.
+func readLevels(ctx context.Context, client *shopify.ShopifyClient, store *models.Store, locations, inventoryItemIDs []string) (map[string]map[string]int, error) {
+	current := make(map[string]map[string]int, len(locations))
+	for _, locationID := range locations {
+		levels, err := client.GetInventoryLevels(ctx, inventoryItemIDs, locationID)
+		if err != nil {
+			logger.GetLogger().Error("Failed to read inventory levels at location %s of store %s: %v", locationID, store.ShopifyStoreStub, err)
+			return nil, err
+		}
+		current[locationID] = levels
+	}
+	return current, nil
+}
+
+// recordEcho remembers a level we wrote. Failing to record it only means the echo is propagated
+// to the other stores, which set the level they already have.
+func (s *AdjustmentService) recordEcho(store *models.Store, inventoryItemID, locationID string, available int) {
+	log := logger.GetLogger()
+
+	err := s.EchoRepo.CreateInventoryEcho(&models.InventoryEcho{
+		ID:              uuid.New(),
+		StoreID:         store.ID,
+		InventoryItemID: inventoryItemID,
+		LocationID:      locationID,
+		Available:       available,
+		ExpiresAt:       time.Now().Add(inventoryEchoTTL),
+	})
+	if err != nil {
+		log.Error("Failed to record inventory echo for item %s in store %s: %v", inventoryItemID, store.ShopifyStoreStub, err)
+	}
+}
+
+// forgetEcho removes a level recorded by recordEcho that was not written after all, so it cannot
+// hide a genuine change to the same level.
+func (s *AdjustmentService) forgetEcho(store *models.Store, inventoryItemID, locationID string, available int) {
+	if _, err := s.EchoRepo.ConsumeInventoryEcho(store.ID, inventoryItemID, locationID, available); err != nil {
+		logger.GetLogger().Error("Failed to remove inventory echo for item %s in store %s: %v", inventoryItemID, store.ShopifyStoreStub, err)
+	}
+}
+
+// rememberLevel makes a level we wrote the known level of its location, as the ledger accounts for
+// it. Failing to record it is only logged, as the write stands; a later report from the location is
+// then taken from the level known before.
+func (s *AdjustmentService) rememberLevel(store *models.Store, inventoryItemID, locationID string, available int) {
+	err := s.LedgerRepo.SetKnownLevel(&models.KnownInventoryLevel{
+		StoreID:         store.ID,
+		InventoryItemID: inventoryItemID,
+		LocationID:      locationID,
+		Available:       available,
+	})
+	if err != nil {
+		logger.GetLogger().Error("Failed to record known level of item %s in store %s: %v", inventoryItemID, store.ShopifyStoreStub, err)
+	}
+}
+
+// IsEcho reports whether an inventory level update is the echo of one of our own writes.
+func (s *AdjustmentService) IsEcho(store *models.Store, inventoryItemID, locationID string, available int) (bool, error) {
+	return s.EchoRepo.ConsumeInventoryEcho(store.ID, inventoryItemID, locationID, available)
+}
+
+// maxInventoryChangesPerMutation is the most changes Shopify accepts in one inventoryAdjustQuantities call.
+const maxInventoryChangesPerMutation = 250
+
+// sendAdjustments applies the changes in as few inventoryAdjustQuantities mutations as Shopify's
+// limits allow. It returns an error for every change that was not applied, at the change's index.
+// If a mutation fails outright, the changes it and the later mutations carried are all given its
+// error, which is also returned.
+func sendAdjustments(ctx context.Context, client *shopify.ShopifyClient, changes []shopify.QuantityChange, reference string) ([]error, error) {
+	errs := make([]error, len(changes))
+	for start := 0; start < len(changes); start += maxInventoryChangesPerMutation {
+		end := min(start+maxInventoryChangesPerMutation, len(changes))
+
+		chunkErrs, err := sendAdjustmentMutation(ctx, client, changes[start:end], reference)
+		copy(errs[start:end], chunkErrs)
+		if err != nil {
+			for i := end; i < len(changes); i++ {
+				errs[i] = err
+			}
+			return errs, err
+		}
+	}
+	return errs, nil
+}
+
+// sendAdjustmentMutation applies the changes in one inventoryAdjustQuantities mutation. It returns
+// an error for every change Shopify rejected. If the mutation fails outright, the changes still
+// pending are given its error, which is also returned.
+//
+// Shopify applies all changes of a mutation or none of them, so when only some changes are
+// rejected, the mutation is sent again without them.
+func sendAdjustmentMutation(ctx context.Context, client *shopify.ShopifyClient, changes []shopify.QuantityChange, reference string) ([]error, error) {
+	log := logger.GetLogger()
+
+	errs := make([]error, len(changes))
+	pending := make([]int, len(changes))
+	for i := range changes {
+		pending[i] = i
+	}
+
+	for len(pending) > 0 {
+		input := shopify.AdjustQuantitiesInput{
+			Reason:               "correction",
+			Name:                 "available",
+			ReferenceDocumentURI: reference,
+			Changes:              make([]shopify.QuantityChange, len(pending)),
+		}
+		for position, i := range pending {
+			input.Changes[position] = changes[i]
+		}
+
+		_, err := client.AdjustQuantities(ctx, input)
+		if err == nil {
+			log.Info("Successfully sent inventory adjustment mutation with %d changes to Shopify", len(pending))
+			return errs, nil
+		}
+
+		// Map user errors back to the changes they are about
+		var shopifyErr *shopify.Error
+		if !errors.As(err, &shopifyErr) || shopifyErr.Kind != shopify.ErrorUserErrors {
+			log.Error("Failed to send inventory adjustment mutation: %v", err)
+			for _, i := range pending {
+				errs[i] = err
+			}
+			return errs, err
+		}
+		rejected := make(map[int]bool)
+		for _, userError := range shopifyErr.UserErrors {
+			log.Error("Shopify user error: field=%v, message=%s", userError.Field, userError.Message)
+
+			position, ok := userError.InputIndex("changes")
+			if !ok || position >= len(pending) {
+				for _, i := range pending {
+					errs[i] = shopifyErr
+				}
+				return errs, shopifyErr
+			}
+			i := pending[position]
+			rejected[i] = true
+			errs[i] = fmt.Errorf("Shopify rejected inventory adjustment: %s", userError.Message)
+		}
+
+		var retry []int
+		for _, i := range pending {
+			if !rejected[i] {
+				retry = append(retry, i)
+			}
+		}
+		pending = retry
+	}
+	return errs, nil
+}
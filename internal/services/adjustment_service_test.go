@@ -0,0 +1,439 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/shopify/shopifytest"
+
+	"github.com/google/uuid"
+)
+
+// adjustmentFor returns an adjustment of a SKU at the store's location in the test stock group.
+func (env *integrationEnv) adjustmentFor(s integrationStore, sku string, inventoryItemID int64, delta int) InventoryAdjustment {
+	return InventoryAdjustment{
+		CompanyID:       env.company.ID,
+		StockGroupID:    env.stockGroup.ID,
+		StoreID:         s.store.ID,
+		SKU:             sku,
+		InventoryItemID: strconv.FormatInt(inventoryItemID, 10),
+		LocationID:      s.store.LocationID,
+		Delta:           delta,
+		MovementID:      uuid.New(),
+	}
+}
+
+// loadJob reads a job back from the queue.
+func (env *integrationEnv) loadJob(t *testing.T, jobID uuid.UUID) models.Job {
+	t.Helper()
+
+	var job models.Job
+	if err := env.db.First(&job, "id = ?", jobID).Error; err != nil {
+		t.Fatalf("failed to load job %s: %v", jobID, err)
+	}
+	return job
+}
+
+// claimJob marks a job as claimed by a worker for the given attempt, as ClaimNextJob would.
+func (env *integrationEnv) claimJob(t *testing.T, job *models.Job, workerID string, attempts int) {
+	t.Helper()
+
+	now := time.Now()
+	job.Status = models.JobRunning
+	job.Attempts = attempts
+	job.LockedAt = &now
+	job.LockedBy = workerID
+	err := env.db.Model(job).Updates(map[string]interface{}{
+		"status":    job.Status,
+		"attempts":  job.Attempts,
+		"locked_at": job.LockedAt,
+		"locked_by": job.LockedBy,
+	}).Error
+	if err != nil {
+		t.Fatalf("failed to claim job %s: %v", job.ID, err)
+	}
+}
+
+// setStockLevel sets the canonical level of a SKU in the test stock group.
+func (env *integrationEnv) setStockLevel(t *testing.T, sku string, quantity int) {
+	t.Helper()
+
+	err := env.ledgerRepo.SetQuantity(&models.StockMovement{
+		ID:            uuid.New(),
+		StockGroupID:  env.stockGroup.ID,
+		SKU:           sku,
+		QuantityAfter: quantity,
+		Reason:        models.StockMovementManual,
+	})
+	if err != nil {
+		t.Fatalf("failed to set stock level: %v", err)
+	}
+}
+
+func TestScheduleRetryQueuesWithBackoff(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	item := env.stock(t, s, "BAG-RED", 10)
+
+	adjustment := env.adjustmentFor(s, "BAG-RED", item, -2)
+	if err := env.adjustmentService.ScheduleRetry(adjustment, errors.New("connection reset")); err != nil {
+		t.Fatalf("ScheduleRetry failed: %v", err)
+	}
+
+	var job models.Job
+	err := env.db.Where("type = ? AND payload::jsonb->>'movement_id' = ?", JobTypeInventoryAdjustment, adjustment.MovementID.String()).
+		First(&job).Error
+	if err != nil {
+		t.Fatalf("failed to find the retry job: %v", err)
+	}
+	if job.Attempts != 1 || job.LastError != "connection reset" || !job.RunAt.After(job.CreatedAt) {
+		t.Fatalf("expected the first attempt to be counted and the next one backed off, got %+v", job)
+	}
+}
+
+func TestAdjustmentRetryIsIdempotent(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	const sku = "BAG-BLUE"
+	item := env.stock(t, s, sku, 10)
+	env.setStockLevel(t, sku, 8)
+
+	adjustment := env.adjustmentFor(s, sku, item, -2)
+	payload, _ := json.Marshal(adjustment)
+	job := &models.Job{ID: uuid.New(), Type: JobTypeInventoryAdjustment, Payload: payload, Attempts: 2}
+
+	// The first attempt timed out after Shopify applied it, and the job runs twice more
+	for i := 0; i < 2; i++ {
+		if err := env.adjustmentService.ProcessAdjustmentJob(context.Background(), job); err != nil {
+			t.Fatalf("ProcessAdjustmentJob failed: %v", err)
+		}
+		env.assertAvailable(t, s, item, 8)
+	}
+}
+
+func TestAdjustmentIsDeadLetteredAfterLastAttempt(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	// Without a canonical level the retry keeps failing
+	item := env.stock(t, s, "BAG-GREEN", 10)
+
+	job, err := env.jobService.Enqueue(JobTypeInventoryAdjustment, env.adjustmentFor(s, "BAG-GREEN", item, -1))
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	env.claimJob(t, job, "test-worker", job.MaxAttempts)
+	env.jobService.runJob(context.Background(), job)
+
+	if status := env.loadJob(t, job.ID).Status; status != models.JobFailed {
+		t.Fatalf("expected the job to be failed, got %s", status)
+	}
+	deadLetters, err := env.adjustmentService.GetDeadLettersByCompany(env.company.ID.String())
+	if err != nil {
+		t.Fatalf("failed to list dead letters: %v", err)
+	}
+	if len(deadLetters) != 1 || deadLetters[0].JobID != job.ID || deadLetters[0].SKU != "BAG-GREEN" || deadLetters[0].Attempts != job.MaxAttempts {
+		t.Fatalf("unexpected dead letters %+v", deadLetters)
+	}
+}
+
+func TestFailedDeadLetterKeepsJobQueued(t *testing.T) {
+	env := newIntegrationEnv(t)
+	jobType := "test.dead_letter." + uuid.New().String()[:8]
+	env.jobService.RegisterHandler(jobType, func(ctx context.Context, job *models.Job) error {
+		return errors.New("still failing")
+	})
+	env.jobService.RegisterDeadLetterHandler(jobType, func(job *models.Job, err error) error {
+		return errors.New("dead letter table unavailable")
+	})
+
+	job, err := env.jobService.Enqueue(jobType, struct{}{})
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	env.claimJob(t, job, "test-worker", job.MaxAttempts)
+	env.jobService.runJob(context.Background(), job)
+
+	if status := env.loadJob(t, job.ID).Status; status != models.JobQueued {
+		t.Fatalf("expected the job to stay queued when it cannot be dead lettered, got %s", status)
+	}
+}
+
+func TestJobOutcomeIsLeftToTheWorkerHoldingItsLock(t *testing.T) {
+	env := newIntegrationEnv(t)
+	jobType := "test.lock." + uuid.New().String()[:8]
+	env.jobService.RegisterHandler(jobType, func(ctx context.Context, job *models.Job) error {
+		return nil
+	})
+
+	job, err := env.jobService.Enqueue(jobType, struct{}{})
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	env.claimJob(t, job, "slow-worker", 1)
+
+	// The lock expired while the first attempt ran, and another worker claimed the job again
+	stale := *job
+	env.claimJob(t, job, "other-worker", 2)
+	env.jobService.runJob(context.Background(), &stale)
+
+	current := env.loadJob(t, job.ID)
+	if current.Status != models.JobRunning || current.LockedBy != "other-worker" {
+		t.Fatalf("expected the job to stay claimed by the other worker, got %s by %q", current.Status, current.LockedBy)
+	}
+	if err := env.jobService.JobRepo.CompleteJob(&stale); !errors.Is(err, repositories.ErrJobLockLost) {
+		t.Fatalf("expected ErrJobLockLost completing a stale attempt, got %v", err)
+	}
+	if err := env.jobService.JobRepo.CompleteJob(job); err != nil {
+		t.Fatalf("CompleteJob failed for the attempt holding the lock: %v", err)
+	}
+	if status := env.loadJob(t, job.ID).Status; status != models.JobCompleted {
+		t.Fatalf("expected the job to be completed, got %s", status)
+	}
+}
+
+func TestRetryDeadLetterQueuesOnce(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	adjustment := env.adjustmentFor(s, "BAG-GREY", 1, -1)
+
+	deadLetter := &models.DeadLetterAdjustment{
+		ID:              uuid.New(),
+		CompanyID:       env.company.ID,
+		StockGroupID:    env.stockGroup.ID,
+		StoreID:         s.store.ID,
+		SKU:             adjustment.SKU,
+		InventoryItemID: adjustment.InventoryItemID,
+		LocationID:      adjustment.LocationID,
+		Delta:           adjustment.Delta,
+		MovementID:      adjustment.MovementID,
+		JobID:           uuid.New(),
+		Attempts:        3,
+		LastError:       "throttled",
+	}
+	if err := repositories.NewDeadLetterAdjustmentRepository(env.db).CreateDeadLetterAdjustment(deadLetter); err != nil {
+		t.Fatalf("failed to create dead letter: %v", err)
+	}
+
+	companyID := env.company.ID.String()
+	if _, err := env.adjustmentService.RetryDeadLetter(uuid.New().String(), deadLetter.ID.String()); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("expected another company's retry to find nothing, got %v", err)
+	}
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := env.adjustmentService.RetryDeadLetter(companyID, deadLetter.ID.String())
+			results <- err
+		}()
+	}
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		err := <-results
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrDeadLetterNotFound):
+			t.Fatalf("RetryDeadLetter failed: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one retry to queue the adjustment, %d did", succeeded)
+	}
+
+	var queued int64
+	env.db.Model(&models.Job{}).
+		Where("type = ? AND payload::jsonb->>'movement_id' = ?", JobTypeInventoryAdjustment, adjustment.MovementID.String()).
+		Count(&queued)
+	if queued != 1 {
+		t.Fatalf("expected 1 queued adjustment, got %d", queued)
+	}
+	if _, err := env.adjustmentService.GetDeadLetterByID(companyID, deadLetter.ID.String()); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Fatalf("expected the dead letter to be gone, got %v", err)
+	}
+}
+
+func TestSyncLevelsKeepsEarlierMutations(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	stub := s.store.ShopifyStoreStub
+
+	const total = maxInventoryChangesPerMutation + 10
+	changes := make([]LevelChange, total)
+	items := make([]int64, total)
+	for i := range changes {
+		sku := fmt.Sprintf("SOCK-%03d", i)
+		variant := env.shopify.AddVariant(stub, "Socks", sku)
+		env.shopify.SetAvailable(stub, variant.InventoryItemID, s.location.ID, 10)
+		items[i] = variant.InventoryItemID
+		changes[i] = LevelChange{SKU: sku, InventoryItemID: strconv.FormatInt(variant.InventoryItemID, 10), LocationID: s.store.LocationID, Quantity: 9}
+	}
+	// The first mutation goes through, the second fails
+	env.shopify.FailAfter(stub, "inventoryAdjustQuantities", 1, 1, shopifytest.Failure{Status: http.StatusInternalServerError, Body: "internal error"})
+
+	errs, err := env.adjustmentService.SyncLevels(context.Background(), env.shopify.Client(stub), s.store,
+		[]string{s.store.LocationID}, changes, webhookReference("batch"))
+	if err == nil {
+		t.Fatal("expected the failed mutation to be reported")
+	}
+	if len(errs) != total {
+		t.Fatalf("expected an outcome for each of the %d changes, got %d", total, len(errs))
+	}
+
+	for i, item := range items {
+		sent := i < maxInventoryChangesPerMutation
+		if sent != (errs[i] == nil) {
+			t.Fatalf("change %d: expected sent=%t, got error %v", i, sent, errs[i])
+		}
+		want := 10
+		if sent {
+			want = 9
+		}
+		env.assertAvailable(t, s, item, want)
+	}
+
+	// Only the applied changes leave an echo behind
+	if echo, err := env.adjustmentService.IsEcho(s.store, changes[0].InventoryItemID, s.store.LocationID, 9); err != nil || !echo {
+		t.Fatalf("expected the applied change to be recorded as an echo, got %t (%v)", echo, err)
+	}
+	if echo, err := env.adjustmentService.IsEcho(s.store, changes[total-1].InventoryItemID, s.store.LocationID, 9); err != nil || echo {
+		t.Fatalf("expected no echo for the failed change, got %t (%v)", echo, err)
+	}
+
+	// Syncing again only sends the changes that failed
+	errs, err = env.adjustmentService.SyncLevels(context.Background(), env.shopify.Client(stub), s.store,
+		[]string{s.store.LocationID}, changes, webhookReference("batch"))
+	if err != nil || errs[total-1] != nil {
+		t.Fatalf("expected the second sync to succeed, got %v (%v)", err, errs[total-1])
+	}
+	for _, item := range items {
+		env.assertAvailable(t, s, item, 9)
+	}
+}
+
+func TestSyncLevelsAcrossSyncedLocations(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	stub := s.store.ShopifyStoreStub
+	east := env.shopify.AddLocation(stub, "East")
+	eastID := strconv.FormatInt(east.ID, 10)
+	synced := []string{s.store.LocationID, eastID}
+
+	variant := env.shopify.AddVariant(stub, "Lamp", "LAMP")
+	env.shopify.SetAvailable(stub, variant.InventoryItemID, s.location.ID, 10)
+	env.shopify.SetAvailable(stub, variant.InventoryItemID, east.ID, 5)
+	item := strconv.FormatInt(variant.InventoryItemID, 10)
+
+	sync := func(quantity int) {
+		t.Helper()
+		errs, err := env.adjustmentService.SyncLevels(context.Background(), env.shopify.Client(stub), s.store, synced,
+			[]LevelChange{{SKU: "LAMP", InventoryItemID: item, LocationID: eastID, Quantity: quantity}}, webhookReference("locations"))
+		if err != nil || errs[0] != nil {
+			t.Fatalf("SyncLevels failed: %v (%v)", err, errs[0])
+		}
+	}
+
+	// The difference to the total of both locations is made up at East
+	sync(12)
+	if got, _ := env.shopify.Available(stub, variant.InventoryItemID, east.ID); got != 2 {
+		t.Fatalf("expected 2 available at East, got %d", got)
+	}
+	env.assertAvailable(t, s, variant.InventoryItemID, 10)
+
+	// A store already at the level is left alone
+	before := len(env.shopify.Requests(stub))
+	sync(12)
+	for _, request := range env.shopify.Requests(stub)[before:] {
+		if request.Operation == "inventoryAdjustQuantities" {
+			t.Fatal("expected no write for a store already at its level")
+		}
+	}
+}
+
+// recordEchoAt records an echo of a level written to the store, expiring at the given time.
+func (env *integrationEnv) recordEchoAt(t *testing.T, s integrationStore, inventoryItemID string, available int, expiresAt time.Time) {
+	t.Helper()
+
+	err := env.adjustmentService.EchoRepo.CreateInventoryEcho(&models.InventoryEcho{
+		ID:              uuid.New(),
+		StoreID:         s.store.ID,
+		InventoryItemID: inventoryItemID,
+		LocationID:      s.store.LocationID,
+		Available:       available,
+		ExpiresAt:       expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("failed to record echo: %v", err)
+	}
+}
+
+func TestInventoryEchoes(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	isEcho := func(item string, available int) bool {
+		t.Helper()
+		echo, err := env.adjustmentService.IsEcho(s.store, item, s.store.LocationID, available)
+		if err != nil {
+			t.Fatalf("IsEcho failed: %v", err)
+		}
+		return echo
+	}
+
+	t.Run("is consumed once", func(t *testing.T) {
+		env.recordEchoAt(t, s, "1", 8, time.Now().Add(time.Minute))
+		if !isEcho("1", 8) {
+			t.Fatal("expected the update to be an echo")
+		}
+		if isEcho("1", 8) {
+			t.Fatal("expected the echo to be consumed")
+		}
+	})
+
+	t.Run("expired echo is not matched", func(t *testing.T) {
+		env.recordEchoAt(t, s, "2", 8, time.Now().Add(-time.Second))
+		if isEcho("2", 8) {
+			t.Fatal("expected an expired echo not to match")
+		}
+	})
+
+	t.Run("superseded echo does not hide a later change", func(t *testing.T) {
+		// Shopify only reported the second of two writes
+		env.recordEchoAt(t, s, "3", 8, time.Now().Add(time.Minute))
+		time.Sleep(10 * time.Millisecond)
+		env.recordEchoAt(t, s, "3", 6, time.Now().Add(time.Minute))
+		if !isEcho("3", 6) {
+			t.Fatal("expected the update to be an echo")
+		}
+		if isEcho("3", 8) {
+			t.Fatal("expected a genuine change back to the first level not to be taken for an echo")
+		}
+	})
+
+	t.Run("concurrent updates consume one echo once", func(t *testing.T) {
+		env.recordEchoAt(t, s, "4", 8, time.Now().Add(time.Minute))
+
+		results := make(chan bool, 4)
+		for i := 0; i < cap(results); i++ {
+			go func() {
+				echo, err := env.adjustmentService.IsEcho(s.store, "4", s.store.LocationID, 8)
+				results <- echo && err == nil
+			}()
+		}
+		consumed := 0
+		for i := 0; i < cap(results); i++ {
+			if <-results {
+				consumed++
+			}
+		}
+		if consumed != 1 {
+			t.Fatalf("expected the echo to be consumed once, got %d", consumed)
+		}
+	})
+}
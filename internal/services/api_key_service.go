@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from access tokens.
+const APIKeyPrefix = "gsk_"
+
+// apiKeyTouchInterval is how often the last use of an API key is written at most.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrAPIKeyNotFound   = repositories.ErrAPIKeyNotFound
+	ErrInvalidAPIKey    = errors.New("invalid, revoked or expired API key")
+	ErrAPIKeyNameNeeded = errors.New("API key name is required")
+	ErrInvalidScope     = errors.New("invalid scope, expected view, operate or manage_stores")
+	ErrInvalidExpiry    = errors.New("API key expiry must be in the future")
+)
+
+// APIKeyService manages the API keys companies call the API with from scripts and integrations.
+type APIKeyService struct {
+	APIKeyRepo *repositories.APIKeyRepository
+}
+
+func NewAPIKeyService(apiKeyRepo *repositories.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{APIKeyRepo: apiKeyRepo}
+}
+
+// CreateAPIKey creates an API key of the company with the given scopes, which expires at
+// expiresAt unless it is nil. It returns the key record and the key itself, which is only
+// stored hashed and cannot be retrieved again.
+func (s *APIKeyService) CreateAPIKey(companyID, createdBy, name string, scopes []models.Permission, expiresAt *time.Time) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrAPIKeyNameNeeded
+	}
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !models.ValidAPIKeyScope(scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	secret, err := newRandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey := &models.APIKey{
+		ID:        uuid.New(),
+		CompanyID: uuid.MustParse(companyID),
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		Scopes:    scopes,
+		CreatedBy: uuid.MustParse(createdBy),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.APIKeyRepo.CreateAPIKey(apiKey); err != nil {
+		return nil, "", err
+	}
+
+	logger.GetLogger().Info("User %s created API key %s (%s) for company %s with scopes %v", createdBy, apiKey.ID, name, companyID, scopes)
+	return apiKey, key, nil
+}
+
+// GetAPIKeys returns the API keys of the company that were not revoked.
+func (s *APIKeyService) GetAPIKeys(companyID string) ([]models.APIKey, error) {
+	return s.APIKeyRepo.GetAPIKeysByCompany(companyID)
+}
+
+// RevokeAPIKey revokes an API key of the company, or returns ErrAPIKeyNotFound.
+func (s *APIKeyService) RevokeAPIKey(companyID, keyID string) error {
+	if err := s.APIKeyRepo.RevokeAPIKey(companyID, keyID, time.Now()); err != nil {
+		return err
+	}
+	logger.GetLogger().Info("Revoked API key %s of company %s", keyID, companyID)
+	return nil
+}
+
+// Authenticate returns the API key a request was made with and records its use.
+func (s *APIKeyService) Authenticate(key string) (*models.APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	apiKey, err := s.APIKeyRepo.GetActiveAPIKey(hashToken(key), now)
+	if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.APIKeyRepo.TouchAPIKey(apiKey.ID, now, apiKeyTouchInterval); err != nil {
+		logger.GetLogger().Error("Failed to record use of API key %s: %v", apiKey.ID, err)
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	db := openTestDB(t)
+
+	suffix := uuid.New().String()[:8]
+	company := &models.Company{ID: uuid.New(), Name: "Keys " + suffix, Subdomain: "keys-" + suffix}
+	if err := repositories.NewCompanyRepository(db).CreateCompany(company); err != nil {
+		t.Fatalf("failed to create company: %v", err)
+	}
+	companyID, createdBy := company.ID.String(), uuid.New().String()
+	service := NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+
+	if _, _, err := service.CreateAPIKey(companyID, createdBy, "ERP", []models.Permission{models.PermissionManageUsers}, nil); !errors.Is(err, ErrInvalidScope) {
+		t.Fatalf("expected manage_users to be refused as a scope, got %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, _, err := service.CreateAPIKey(companyID, createdBy, "ERP", []models.Permission{models.PermissionView}, &past); !errors.Is(err, ErrInvalidExpiry) {
+		t.Fatalf("expected expiry in the past to be refused, got %v", err)
+	}
+
+	apiKey, key, err := service.CreateAPIKey(companyID, createdBy, "ERP", []models.Permission{models.PermissionView, models.PermissionOperate}, nil)
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+	if apiKey.KeyHash == key {
+		t.Fatal("expected the key to be stored hashed")
+	}
+
+	authenticated, err := service.Authenticate(key)
+	if err != nil {
+		t.Fatalf("expected key to authenticate: %v", err)
+	}
+	if authenticated.CompanyID != company.ID || !authenticated.Can(models.PermissionOperate) || authenticated.Can(models.PermissionManageStores) {
+		t.Fatalf("unexpected key: %+v", authenticated)
+	}
+
+	keys, err := service.GetAPIKeys(companyID)
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("expected one used key, got %+v (%v)", keys, err)
+	}
+
+	if err := service.RevokeAPIKey(uuid.New().String(), apiKey.ID.String()); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("expected key of another company to be not found, got %v", err)
+	}
+	if err := service.RevokeAPIKey(companyID, apiKey.ID.String()); err != nil {
+		t.Fatalf("failed to revoke API key: %v", err)
+	}
+	if _, err := service.Authenticate(key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("expected revoked key to be refused, got %v", err)
+	}
+}
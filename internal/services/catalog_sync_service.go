@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job types for catalog syncs.
+const (
+	JobTypeCatalogSyncStart = "catalog.sync_start"
+	JobTypeCatalogSyncPoll  = "catalog.sync_poll"
+)
+
+// catalogSyncPollInterval is how often a running bulk operation is checked on. The
+// bulk_operations/finish webhook usually finishes the sync first, polling covers stores
+// without the webhook subscription.
+const catalogSyncPollInterval = time.Minute
+
+// catalogImportStaleAfter is how long an import may run before a retry may take it over.
+const catalogImportStaleAfter = 15 * time.Minute
+
+// ErrStoreNotFound is returned for stores that do not exist or belong to another company.
+var ErrStoreNotFound = repositories.ErrStoreNotFound
+
+// CatalogSyncPayload is the job payload for the catalog sync job types.
+type CatalogSyncPayload struct {
+	SyncID uuid.UUID `json:"sync_id"`
+}
+
+type CatalogSyncService struct {
+	SyncRepo      *repositories.CatalogSyncRepository
+	StoreRepo     *repositories.StoreRepository
+	InventoryRepo *repositories.InventoryRepository
+	JobService    *JobService
+}
+
+func NewCatalogSyncService(
+	syncRepo *repositories.CatalogSyncRepository,
+	storeRepo *repositories.StoreRepository,
+	inventoryRepo *repositories.InventoryRepository,
+	jobService *JobService,
+) *CatalogSyncService {
+	s := &CatalogSyncService{
+		SyncRepo:      syncRepo,
+		StoreRepo:     storeRepo,
+		InventoryRepo: inventoryRepo,
+		JobService:    jobService,
+	}
+
+	jobService.RegisterHandler(JobTypeCatalogSyncStart, s.ProcessStartJob)
+	jobService.RegisterHandler(JobTypeCatalogSyncPoll, s.ProcessPollJob)
+
+	return s
+}
+
+// QueueSync records a catalog sync for the store and queues the job that starts its bulk operation.
+func (s *CatalogSyncService) QueueSync(store *models.Store) (*models.CatalogSync, error) {
+	sync := &models.CatalogSync{
+		ID:        uuid.New(),
+		CompanyID: store.CompanyID,
+		StoreID:   store.ID,
+		Status:    models.CatalogSyncPending,
+	}
+	if err := s.SyncRepo.CreateCatalogSync(sync); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.JobService.Enqueue(JobTypeCatalogSyncStart, CatalogSyncPayload{SyncID: sync.ID}); err != nil {
+		s.failSync(sync.ID, err)
+		return nil, err
+	}
+	return sync, nil
+}
+
+// QueueStoreSync queues a catalog sync for a store owned by the company.
+func (s *CatalogSyncService) QueueStoreSync(companyID, storeID string) (*models.CatalogSync, error) {
+	store, err := s.StoreRepo.GetCompanyStore(companyID, storeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueueSync(store)
+}
+
+// GetStoreSyncs returns the most recent catalog syncs of a store owned by the company.
+func (s *CatalogSyncService) GetStoreSyncs(companyID, storeID string, limit int) ([]models.CatalogSync, error) {
+	return s.SyncRepo.GetCatalogSyncsByStore(companyID, storeID, limit)
+}
+
+// ProcessStartJob is the job handler that starts the bulk operation of a pending sync.
+// It fails, and is retried with backoff, while another bulk operation runs in the store.
+func (s *CatalogSyncService) ProcessStartJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	sync, store, err := s.loadSync(job)
+	if err != nil || sync == nil {
+		return err
+	}
+	if sync.Status != models.CatalogSyncPending {
+		return nil
+	}
+
+	client := newStoreClient(store)
+	op, err := client.RunVariantBulkQuery(ctx)
+	if err != nil {
+		if job.Attempts >= job.MaxAttempts {
+			s.failSync(sync.ID, err)
+		}
+		return err
+	}
+
+	if err := s.SyncRepo.MarkCatalogSyncRunning(sync.ID, op.ID); err != nil {
+		return err
+	}
+	log.Info("Started bulk operation %s for catalog sync %s of store %s", op.ID, sync.ID, store.ShopifyStoreStub)
+
+	_, err = s.JobService.EnqueueAt(JobTypeCatalogSyncPoll, CatalogSyncPayload{SyncID: sync.ID}, time.Now().Add(catalogSyncPollInterval))
+	if err != nil {
+		log.Error("Failed to queue poll for catalog sync %s, waiting for the finish webhook: %v", sync.ID, err)
+	}
+	return nil
+}
+
+// ProcessPollJob is the job handler that checks on the bulk operation of a running sync and
+// imports its result once it has completed. The last attempt fails the sync with its error, as
+// nothing polls the sync after it.
+func (s *CatalogSyncService) ProcessPollJob(ctx context.Context, job *models.Job) error {
+	sync, store, err := s.loadSync(job)
+	if err != nil || sync == nil {
+		return err
+	}
+	if sync.Status != models.CatalogSyncRunning && sync.Status != models.CatalogSyncImporting {
+		return nil
+	}
+
+	err = s.poll(ctx, sync, store)
+	if err != nil && job.Attempts >= job.MaxAttempts {
+		s.failSync(sync.ID, err)
+	}
+	return err
+}
+
+// poll imports the result of the sync's bulk operation if it is done, or polls it again later.
+func (s *CatalogSyncService) poll(ctx context.Context, sync *models.CatalogSync, store *models.Store) error {
+	client := newStoreClient(store)
+	op, err := client.GetBulkOperation(ctx, sync.BulkOperationID)
+	if err != nil {
+		return err
+	}
+
+	if !op.Done() {
+		_, err := s.JobService.EnqueueAt(JobTypeCatalogSyncPoll, CatalogSyncPayload{SyncID: sync.ID}, time.Now().Add(catalogSyncPollInterval))
+		return err
+	}
+	return s.finish(ctx, sync, store, op)
+}
+
+// FinishBulkOperation completes the sync behind a bulk operation reported finished by the
+// bulk_operations/finish webhook. Bulk operations not started by a catalog sync are ignored.
+func (s *CatalogSyncService) FinishBulkOperation(ctx context.Context, store *models.Store, bulkOperationID string) error {
+	log := logger.GetLogger()
+
+	sync, err := s.SyncRepo.GetCatalogSyncByBulkOperationID(bulkOperationID)
+	if err != nil || sync.StoreID != store.ID {
+		log.Info("Ignoring bulk operation %s of store %s, it is not a catalog sync", bulkOperationID, store.ShopifyStoreStub)
+		return nil
+	}
+
+	client := newStoreClient(store)
+	op, err := client.GetBulkOperation(ctx, bulkOperationID)
+	if err != nil {
+		return err
+	}
+	return s.finish(ctx, sync, store, op)
+}
+
+// SyncNow runs a catalog sync of the store to completion, polling the bulk operation every
+// interval instead of going through the job queue.
+func (s *CatalogSyncService) SyncNow(ctx context.Context, store *models.Store, interval time.Duration) (*models.CatalogSync, error) {
+	sync := &models.CatalogSync{
+		ID:        uuid.New(),
+		CompanyID: store.CompanyID,
+		StoreID:   store.ID,
+		Status:    models.CatalogSyncPending,
+	}
+	if err := s.SyncRepo.CreateCatalogSync(sync); err != nil {
+		return nil, err
+	}
+
+	client := newStoreClient(store)
+	op, err := client.RunVariantBulkQuery(ctx)
+	if err != nil {
+		s.failSync(sync.ID, err)
+		return nil, err
+	}
+	if err := s.SyncRepo.MarkCatalogSyncRunning(sync.ID, op.ID); err != nil {
+		s.failSync(sync.ID, err)
+		return nil, err
+	}
+	sync.Status = models.CatalogSyncRunning
+	sync.BulkOperationID = op.ID
+
+	// Nothing retries a sync run here, so any error leaves it failed rather than running
+	op, err = client.WaitForBulkOperation(ctx, op.ID, interval)
+	if err != nil {
+		s.failSync(sync.ID, err)
+		return nil, err
+	}
+	if err := s.finish(ctx, sync, store, op); err != nil {
+		s.failSync(sync.ID, err)
+		return nil, err
+	}
+	return s.SyncRepo.GetCatalogSyncByID(sync.ID)
+}
+
+// finish imports the result of a finished bulk operation, or fails the sync if the operation failed.
+// Only one of the poll job and the finish webhook gets to import the result.
+func (s *CatalogSyncService) finish(ctx context.Context, sync *models.CatalogSync, store *models.Store, op *shopify.BulkOperation) error {
+	log := logger.GetLogger()
+
+	if op.Status != shopify.BulkOperationCompleted {
+		reason := fmt.Sprintf("bulk operation %s ended with status %s", op.ID, op.Status)
+		if op.ErrorCode != "" {
+			reason += " (" + op.ErrorCode + ")"
+		}
+		log.Error("Catalog sync %s of store %s failed: %s", sync.ID, store.ShopifyStoreStub, reason)
+		return s.SyncRepo.FailCatalogSync(sync.ID, reason)
+	}
+
+	claimed, err := s.SyncRepo.ClaimCatalogSyncImport(sync.ID, op.ObjectCount, time.Now().Add(-catalogImportStaleAfter))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Info("Catalog sync %s is already imported or being imported", sync.ID)
+		return nil
+	}
+
+	log.Info("Importing %d objects for catalog sync %s of store %s", op.ObjectCount, sync.ID, store.ShopifyStoreStub)
+	synced := 0
+	if op.URL != "" {
+		err = shopify.ReadBulkVariants(ctx, op.URL, func(variant shopify.Variant) error {
+			if variant.SKU == "" || variant.InventoryItemID == 0 {
+				return nil
+			}
+			if err := s.InventoryRepo.SaveInventoryItem(variant.SKU, store.ID, fmt.Sprintf("%d", variant.InventoryItemID)); err != nil {
+				return fmt.Errorf("failed to save inventory for SKU %s: %w", variant.SKU, err)
+			}
+			synced++
+			return nil
+		})
+	}
+	if err != nil {
+		// Saving is idempotent, so a retry simply imports the whole result again
+		log.Error("Failed to import catalog sync %s after %d variants: %v", sync.ID, synced, err)
+		if releaseErr := s.SyncRepo.ReleaseCatalogSyncImport(sync.ID, err.Error()); releaseErr != nil {
+			log.Error("Failed to release catalog sync %s: %v", sync.ID, releaseErr)
+		}
+		return err
+	}
+
+	log.Info("Finished catalog sync %s of store %s, synced %d variants", sync.ID, store.ShopifyStoreStub, synced)
+	return s.SyncRepo.CompleteCatalogSync(sync.ID, synced)
+}
+
+// failSync marks a sync failed with err. A sync that cannot be marked failed is only logged,
+// so the caller still returns the error that failed it.
+func (s *CatalogSyncService) failSync(syncID uuid.UUID, err error) {
+	if failErr := s.SyncRepo.FailCatalogSync(syncID, err.Error()); failErr != nil {
+		logger.GetLogger().Error("Failed to mark catalog sync %s failed: %v", syncID, failErr)
+	}
+}
+
+// loadSync loads the sync and store referenced by a catalog sync job. It returns a nil sync
+// when either has been removed since, leaving nothing to do.
+func (s *CatalogSyncService) loadSync(job *models.Job) (*models.CatalogSync, *models.Store, error) {
+	log := logger.GetLogger()
+
+	var payload CatalogSyncPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return nil, nil, err
+	}
+
+	sync, err := s.SyncRepo.GetCatalogSyncByID(payload.SyncID)
+	if err != nil {
+		log.Error("Dropping catalog sync job %s, sync %s not found: %v", job.ID, payload.SyncID, err)
+		return nil, nil, nil
+	}
+
+	store, err := s.StoreRepo.GetStoreByID(sync.StoreID.String())
+	if err != nil {
+		log.Error("Dropping catalog sync job %s, store %s not found: %v", job.ID, sync.StoreID, err)
+		return nil, nil, nil
+	}
+	return sync, store, nil
+}
@@ -0,0 +1,620 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/shopify/shopifytest"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// These tests run the services against Postgres and a fake Shopify. They are skipped unless
+// TEST_DATABASE_URL points at a database they may migrate and write to.
+
+type integrationEnv struct {
+	db         *gorm.DB
+	shopify    *shopifytest.Server
+	company    *models.Company
+	stockGroup *models.StockGroup
+
+	storeRepo           *repositories.StoreRepository
+	inventoryRepo       *repositories.InventoryRepository
+	stockGroupStoreRepo *repositories.StockGroupStoreRepository
+	deliveryRepo        *repositories.WebhookDeliveryRepository
+	ledgerRepo          *repositories.StockLedgerRepository
+
+	jobService         *JobService
+	adjustmentService  *AdjustmentService
+	stockService       *StockService
+	webhookService     *WebhookService
+	catalogSyncService *CatalogSyncService
+	reconcileService   *ReconcileService
+	locationService    *StoreLocationService
+}
+
+// integrationStore is a store of the test company together with its location in the fake Shopify.
+type integrationStore struct {
+	store    *models.Store
+	location shopifytest.Location
+}
+
+func newIntegrationEnv(t *testing.T) *integrationEnv {
+	t.Helper()
+
+	db := openTestDB(t)
+	server := shopifytest.NewServer()
+	server.Install(t)
+
+	env := &integrationEnv{
+		db:                  db,
+		shopify:             server,
+		storeRepo:           repositories.NewStoreRepository(db),
+		inventoryRepo:       repositories.NewInventoryRepository(db),
+		stockGroupStoreRepo: repositories.NewStockGroupStoreRepository(db),
+		deliveryRepo:        repositories.NewWebhookDeliveryRepository(db),
+		ledgerRepo:          repositories.NewStockLedgerRepository(db),
+	}
+	stockGroupRepo := repositories.NewStockGroupRepository(db)
+
+	env.jobService = NewJobService(repositories.NewJobRepository(db), 1, time.Second, time.Minute, 3)
+	env.catalogSyncService = NewCatalogSyncService(repositories.NewCatalogSyncRepository(db), env.storeRepo, env.inventoryRepo, env.jobService)
+	env.locationService = NewStoreLocationService(env.storeRepo, repositories.NewStoreLocationRepository(db))
+	env.adjustmentService = NewAdjustmentService(env.storeRepo, repositories.NewDeadLetterAdjustmentRepository(db),
+		repositories.NewInventoryEchoRepository(db), env.ledgerRepo, env.locationService, env.jobService)
+	env.stockService = NewStockService(env.ledgerRepo, stockGroupRepo, env.stockGroupStoreRepo, env.inventoryRepo,
+		env.adjustmentService, env.locationService, env.jobService)
+	env.reconcileService = NewReconcileService(stockGroupRepo, env.stockGroupStoreRepo, env.inventoryRepo, env.ledgerRepo,
+		repositories.NewReconciliationReportRepository(db), env.adjustmentService, env.locationService, env.jobService, time.Hour, false)
+	env.webhookService = NewWebhookService(env.storeRepo, env.inventoryRepo, env.stockGroupStoreRepo, env.deliveryRepo,
+		env.jobService, env.adjustmentService, env.stockService, env.catalogSyncService, env.locationService, 0)
+
+	suffix := uuid.New().String()[:8]
+	env.company = &models.Company{ID: uuid.New(), Name: "Integration " + suffix, Subdomain: "it-" + suffix}
+	if err := repositories.NewCompanyRepository(db).CreateCompany(env.company); err != nil {
+		t.Fatalf("failed to create company: %v", err)
+	}
+	env.stockGroup = &models.StockGroup{ID: uuid.New(), CompanyID: env.company.ID, Name: "Shared stock"}
+	if err := stockGroupRepo.CreateStockGroup(env.stockGroup); err != nil {
+		t.Fatalf("failed to create stock group: %v", err)
+	}
+	return env
+}
+
+// addStore creates a store of the company in the stock group, with a location in the fake Shopify.
+func (env *integrationEnv) addStore(t *testing.T) integrationStore {
+	t.Helper()
+
+	stub := "it-" + uuid.New().String()[:12]
+	location := env.shopify.AddLocation(stub, "Warehouse")
+	store := &models.Store{
+		ID:               uuid.New(),
+		CompanyID:        env.company.ID,
+		ShopifyStoreStub: stub,
+		AccessToken:      "test-token",
+		LocationID:       strconv.FormatInt(location.ID, 10),
+	}
+	if err := env.storeRepo.CreateStore(store); err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := env.stockGroupStoreRepo.AddStoreToStockGroup(env.stockGroup.ID, store.ID); err != nil {
+		t.Fatalf("failed to add store to stock group: %v", err)
+	}
+	return integrationStore{store: store, location: location}
+}
+
+// stock adds a variant for the SKU to the store, stocks it at the store's location and maps it
+// in the inventory table. It returns the variant's inventory item ID.
+func (env *integrationEnv) stock(t *testing.T, s integrationStore, sku string, available int) int64 {
+	t.Helper()
+
+	variant := env.shopify.AddVariant(s.store.ShopifyStoreStub, "Product "+sku, sku)
+	env.shopify.SetAvailable(s.store.ShopifyStoreStub, variant.InventoryItemID, s.location.ID, available)
+	err := env.inventoryRepo.SaveInventoryItem(sku, s.store.ID, strconv.FormatInt(variant.InventoryItemID, 10))
+	if err != nil {
+		t.Fatalf("failed to save inventory: %v", err)
+	}
+	return variant.InventoryItemID
+}
+
+func (env *integrationEnv) assertAvailable(t *testing.T, s integrationStore, inventoryItemID int64, want int) {
+	t.Helper()
+	got, ok := env.shopify.Available(s.store.ShopifyStoreStub, inventoryItemID, s.location.ID)
+	if !ok || got != want {
+		t.Fatalf("expected %d available in store %s, got %d (stocked: %t)", want, s.store.ShopifyStoreStub, got, ok)
+	}
+}
+
+func (env *integrationEnv) assertStockLevel(t *testing.T, sku string, want int) {
+	t.Helper()
+	level, err := env.ledgerRepo.GetStockLevel(env.stockGroup.ID, sku)
+	if err != nil {
+		t.Fatalf("failed to load stock level of %s: %v", sku, err)
+	}
+	if level.Quantity != want {
+		t.Fatalf("expected stock level %d for %s, got %d", want, sku, level.Quantity)
+	}
+}
+
+func TestIntegrationOrderFanOut(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+
+	source, second, third := env.addStore(t), env.addStore(t), env.addStore(t)
+	const sku = "MUG-BLUE"
+	// Shopify has already taken the order off the source store's stock
+	env.stock(t, source, sku, 8)
+	secondItem := env.stock(t, second, sku, 10)
+	thirdItem := env.stock(t, third, sku, 10)
+
+	err := env.ledgerRepo.SetQuantity(&models.StockMovement{
+		ID:            uuid.New(),
+		StockGroupID:  env.stockGroup.ID,
+		SKU:           sku,
+		QuantityAfter: 10,
+		Reason:        models.StockMovementManual,
+	})
+	if err != nil {
+		t.Fatalf("failed to set stock level: %v", err)
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"line_items": []map[string]interface{}{{"sku": sku, "quantity": 2}},
+	})
+	delivery, _, err := env.deliveryRepo.CreateOrGetDelivery(&models.WebhookDelivery{
+		ID:         uuid.New(),
+		WebhookID:  "order-" + uuid.New().String(),
+		Topic:      "orders/create",
+		ShopDomain: source.store.ShopifyStoreStub + ".myshopify.com",
+		Status:     models.WebhookDeliveryReceived,
+	})
+	if err != nil {
+		t.Fatalf("failed to create delivery: %v", err)
+	}
+	job, err := env.jobService.Enqueue(JobTypeOrderWebhook, WebhookJobPayload{DeliveryID: delivery.ID, Body: payload})
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+
+	if err := env.webhookService.ProcessOrderWebhook(ctx, job); err != nil {
+		t.Fatalf("ProcessOrderWebhook failed: %v", err)
+	}
+
+	env.assertAvailable(t, second, secondItem, 8)
+	env.assertAvailable(t, third, thirdItem, 8)
+	env.assertStockLevel(t, sku, 8)
+
+	// A redelivered job must not adjust the stores again
+	if err := env.webhookService.ProcessOrderWebhook(ctx, job); err != nil {
+		t.Fatalf("reprocessing the order webhook failed: %v", err)
+	}
+	env.assertAvailable(t, second, secondItem, 8)
+}
+
+// processOrder runs an orders/create webhook with the given payload from the source store.
+func (env *integrationEnv) processOrder(t *testing.T, source integrationStore, order map[string]interface{}) {
+	t.Helper()
+	env.processWebhook(t, source, "orders/create", JobTypeOrderWebhook, env.webhookService.ProcessOrderWebhook, order)
+}
+
+// processWebhook runs a webhook of the given topic from the source store through its job handler.
+// It returns the job so that tests can redeliver it.
+func (env *integrationEnv) processWebhook(t *testing.T, source integrationStore, topic, jobType string, process JobHandler, body map[string]interface{}) *models.Job {
+	t.Helper()
+
+	payload, _ := json.Marshal(body)
+	delivery, _, err := env.deliveryRepo.CreateOrGetDelivery(&models.WebhookDelivery{
+		ID:         uuid.New(),
+		WebhookID:  topic + "-" + uuid.New().String(),
+		Topic:      topic,
+		ShopDomain: source.store.ShopifyStoreStub + ".myshopify.com",
+		Status:     models.WebhookDeliveryReceived,
+	})
+	if err != nil {
+		t.Fatalf("failed to create delivery: %v", err)
+	}
+	job, err := env.jobService.Enqueue(jobType, WebhookJobPayload{DeliveryID: delivery.ID, Body: payload})
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	if err := process(context.Background(), job); err != nil {
+		t.Fatalf("processing the %s webhook failed: %v", topic, err)
+	}
+	return job
+}
+
+func TestIntegrationOrderFulfillmentLocation(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	companyID := env.company.ID.String()
+
+	source, target := env.addStore(t), env.addStore(t)
+	sourceEast := env.shopify.AddLocation(source.store.ShopifyStoreStub, "East")
+	sourceOutlet := env.shopify.AddLocation(source.store.ShopifyStoreStub, "Outlet")
+	targetEast := env.shopify.AddLocation(target.store.ShopifyStoreStub, "east")
+
+	for _, s := range []integrationStore{source, target} {
+		locations, err := env.locationService.DiscoverLocations(ctx, companyID, s.store.ID.String())
+		if err != nil {
+			t.Fatalf("failed to discover locations: %v", err)
+		}
+		for _, location := range locations {
+			// Only the primary location is synced until the user says otherwise
+			if location.Synced != (location.ShopifyLocationID == s.store.LocationID) {
+				t.Fatalf("unexpected synced=%t for discovered location %s", location.Synced, location.Name)
+			}
+		}
+	}
+	for _, s := range []struct {
+		store      integrationStore
+		locationID int64
+	}{{source, sourceEast.ID}, {target, targetEast.ID}} {
+		err := env.locationService.SetLocationSynced(companyID, s.store.store.ID.String(), strconv.FormatInt(s.locationID, 10), true)
+		if err != nil {
+			t.Fatalf("failed to sync location: %v", err)
+		}
+	}
+	err := env.locationService.SetLocationSynced(companyID, source.store.ID.String(), source.store.LocationID, false)
+	if !errors.Is(err, ErrPrimaryLocationSynced) {
+		t.Fatalf("expected ErrPrimaryLocationSynced ignoring the primary location, got %v", err)
+	}
+
+	const sku = "LAMP-RED"
+	env.stock(t, source, sku, 10)
+	targetItem := env.stock(t, target, sku, 10)
+	env.shopify.SetAvailable(target.store.ShopifyStoreStub, targetItem, targetEast.ID, 5)
+	err = env.ledgerRepo.SetQuantity(&models.StockMovement{
+		ID:            uuid.New(),
+		StockGroupID:  env.stockGroup.ID,
+		SKU:           sku,
+		QuantityAfter: 15,
+		Reason:        models.StockMovementManual,
+	})
+	if err != nil {
+		t.Fatalf("failed to set stock level: %v", err)
+	}
+
+	// Fulfilled from East, so the target store's East location is adjusted
+	env.processOrder(t, source, map[string]interface{}{
+		"line_items": []map[string]interface{}{{"id": 1, "sku": sku, "quantity": 2}},
+		"fulfillments": []map[string]interface{}{{
+			"location_id": sourceEast.ID,
+			"line_items":  []map[string]interface{}{{"id": 1}},
+		}},
+	})
+	if got, _ := env.shopify.Available(target.store.ShopifyStoreStub, targetItem, targetEast.ID); got != 3 {
+		t.Fatalf("expected 3 available at the East location of the target store, got %d", got)
+	}
+	env.assertAvailable(t, target, targetItem, 10)
+	env.assertStockLevel(t, sku, 13)
+
+	// The outlet is ignored, so its sales are not shared stock
+	env.processOrder(t, source, map[string]interface{}{
+		"location_id": sourceOutlet.ID,
+		"line_items":  []map[string]interface{}{{"id": 2, "sku": sku, "quantity": 1}},
+	})
+	if got, _ := env.shopify.Available(target.store.ShopifyStoreStub, targetItem, targetEast.ID); got != 3 {
+		t.Fatalf("expected an order at an ignored location to leave the target store alone, got %d", got)
+	}
+	env.assertAvailable(t, target, targetItem, 10)
+	env.assertStockLevel(t, sku, 13)
+}
+
+// syncLocation adds a location to a store in the fake Shopify and has the store sync it.
+func (env *integrationEnv) syncLocation(t *testing.T, s integrationStore, name string) shopifytest.Location {
+	t.Helper()
+
+	location := env.shopify.AddLocation(s.store.ShopifyStoreStub, name)
+	companyID := env.company.ID.String()
+	if _, err := env.locationService.DiscoverLocations(context.Background(), companyID, s.store.ID.String()); err != nil {
+		t.Fatalf("failed to discover locations: %v", err)
+	}
+	err := env.locationService.SetLocationSynced(companyID, s.store.ID.String(), strconv.FormatInt(location.ID, 10), true)
+	if err != nil {
+		t.Fatalf("failed to sync location: %v", err)
+	}
+	return location
+}
+
+func TestIntegrationLevelsSumSyncedLocations(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	source, target := env.addStore(t), env.addStore(t)
+	sourceEast, targetEast := env.syncLocation(t, source, "East"), env.syncLocation(t, target, "East")
+	sourceStub, targetStub := source.store.ShopifyStoreStub, target.store.ShopifyStoreStub
+
+	const sku = "VASE-RED"
+	sourceItem := env.stock(t, source, sku, 10)
+	targetItem := env.stock(t, target, sku, 10)
+	env.shopify.SetAvailable(targetStub, targetItem, targetEast.ID, 4)
+	// Shopify has already taken the order below off the source store's East stock
+	env.shopify.SetAvailable(sourceStub, sourceItem, sourceEast.ID, 3)
+
+	// The order is not fulfilled yet, Shopify assigned it to East
+	lineItem := env.shopify.AddLineItem(sourceStub, sku)
+	env.shopify.AddFulfillmentOrder(sourceStub, 500, sourceEast.ID, lineItem)
+	env.processOrder(t, source, map[string]interface{}{
+		"id":         500,
+		"line_items": []map[string]interface{}{{"id": lineItem, "sku": sku, "quantity": 1}},
+	})
+	// The level is seeded with the total across the source store's synced locations
+	env.assertStockLevel(t, sku, 13)
+	if got, _ := env.shopify.Available(targetStub, targetItem, targetEast.ID); got != 3 {
+		t.Fatalf("expected the order to be taken out at the target store's East location, got %d", got)
+	}
+	env.assertAvailable(t, target, targetItem, 10)
+
+	// A stock count at East changes the level by the difference from the seeded East level
+	env.shopify.SetAvailable(sourceStub, sourceItem, sourceEast.ID, 6)
+	level := func(locationID int64, available int) map[string]interface{} {
+		return map[string]interface{}{"inventory_item_id": sourceItem, "location_id": locationID, "available": available}
+	}
+	env.processWebhook(t, source, "inventory_levels/update", JobTypeInventoryLevelWebhook,
+		env.webhookService.ProcessInventoryLevelWebhook, level(sourceEast.ID, 6))
+	env.assertStockLevel(t, sku, 16)
+
+	movements, err := env.ledgerRepo.GetMovements(env.stockGroup.ID, sku, 1)
+	if err != nil || len(movements) != 1 {
+		t.Fatalf("failed to load the latest movement: %v", err)
+	}
+	if err := env.stockService.ProcessStockPushJob(ctx, env.pushJob(t, movements[0].ID)); err != nil {
+		t.Fatalf("ProcessStockPushJob failed: %v", err)
+	}
+	if got, _ := env.shopify.Available(targetStub, targetItem, targetEast.ID); got != 6 {
+		t.Fatalf("expected the count to be made up at the target store's East location, got %d", got)
+	}
+
+	// Stock at a location the source store does not sync is its own
+	outlet := env.shopify.AddLocation(sourceStub, "Outlet")
+	if _, err := env.locationService.DiscoverLocations(ctx, env.company.ID.String(), source.store.ID.String()); err != nil {
+		t.Fatalf("failed to discover locations: %v", err)
+	}
+	env.processWebhook(t, source, "inventory_levels/update", JobTypeInventoryLevelWebhook,
+		env.webhookService.ProcessInventoryLevelWebhook, level(outlet.ID, 50))
+	env.assertStockLevel(t, sku, 16)
+
+	// Reconciliation compares the totals and makes up a drift at the primary location
+	env.shopify.SetAvailable(targetStub, targetItem, target.location.ID, 12)
+	err = env.db.Model(&models.StockLevel{}).Where("stock_group_id = ? AND sku = ?", env.stockGroup.ID, sku).
+		Update("updated_at", time.Now().Add(-time.Hour)).Error
+	if err != nil {
+		t.Fatalf("failed to age the stock level: %v", err)
+	}
+	report, err := env.reconcileService.Reconcile(ctx, env.stockGroup, true)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if report.DriftCount != 1 || report.Corrected != 1 || len(report.Drifts[0].Stores) != 2 {
+		t.Fatalf("expected the target store to be corrected, got %+v", report)
+	}
+	for _, store := range report.Drifts[0].Stores {
+		if want := map[bool]int{true: 16, false: 18}[store.StoreID == source.store.ID]; store.Available == nil || *store.Available != want {
+			t.Fatalf("expected store %s to report %d available, got %v", store.Store, want, store.Available)
+		}
+	}
+	env.assertAvailable(t, target, targetItem, 10)
+	if got, _ := env.shopify.Available(targetStub, targetItem, targetEast.ID); got != 6 {
+		t.Fatalf("expected the target store's East location to be left at 6, got %d", got)
+	}
+}
+
+func TestIntegrationLateLevelsKeepConcurrentSales(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	first, second := env.addStore(t), env.addStore(t)
+
+	const sku = "BOWL-GREEN"
+	firstItem := env.stock(t, first, sku, 10)
+	secondItem := env.stock(t, second, sku, 10)
+	env.setStockLevel(t, sku, 10)
+	for _, s := range []struct {
+		store integrationStore
+		item  int64
+	}{{first, firstItem}, {second, secondItem}} {
+		err := env.ledgerRepo.SetKnownLevel(&models.KnownInventoryLevel{
+			StoreID:         s.store.store.ID,
+			InventoryItemID: strconv.FormatInt(s.item, 10),
+			LocationID:      s.store.store.LocationID,
+			Available:       10,
+		})
+		if err != nil {
+			t.Fatalf("failed to set known level: %v", err)
+		}
+	}
+
+	// Both stores sell one at the same time, Shopify has taken them off before the orders arrive
+	env.shopify.SetAvailable(first.store.ShopifyStoreStub, firstItem, first.location.ID, 9)
+	env.shopify.SetAvailable(second.store.ShopifyStoreStub, secondItem, second.location.ID, 9)
+	sale := map[string]interface{}{"line_items": []map[string]interface{}{{"sku": sku, "quantity": 1}}}
+	env.processOrder(t, first, sale)
+	env.processOrder(t, second, sale)
+	env.assertStockLevel(t, sku, 8)
+	env.assertAvailable(t, first, firstItem, 8)
+
+	// The stores report their sales and our write late, all of which the ledger has already recorded
+	level := func(item, locationID int64, available int) map[string]interface{} {
+		return map[string]interface{}{"inventory_item_id": item, "location_id": locationID, "available": available}
+	}
+	for _, report := range []struct {
+		store integrationStore
+		body  map[string]interface{}
+	}{
+		{first, level(firstItem, first.location.ID, 9)},
+		{second, level(secondItem, second.location.ID, 9)},
+		{first, level(firstItem, first.location.ID, 8)},
+	} {
+		env.processWebhook(t, report.store, "inventory_levels/update", JobTypeInventoryLevelWebhook,
+			env.webhookService.ProcessInventoryLevelWebhook, report.body)
+	}
+	env.assertStockLevel(t, sku, 8)
+
+	// A count is taken from the level the ledger knows the location at, once however often it is delivered
+	env.shopify.SetAvailable(second.store.ShopifyStoreStub, secondItem, second.location.ID, 12)
+	job := env.processWebhook(t, second, "inventory_levels/update", JobTypeInventoryLevelWebhook,
+		env.webhookService.ProcessInventoryLevelWebhook, level(secondItem, second.location.ID, 12))
+	env.assertStockLevel(t, sku, 11)
+	if err := env.webhookService.ProcessInventoryLevelWebhook(ctx, job); err != nil {
+		t.Fatalf("reprocessing the inventory level webhook failed: %v", err)
+	}
+	env.assertStockLevel(t, sku, 11)
+}
+
+func TestIntegrationProductSync(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+
+	items := make(map[string]int64)
+	for i := 0; i < 3; i++ {
+		sku := fmt.Sprintf("TEE-%d", i)
+		items[sku] = env.shopify.AddVariant(s.store.ShopifyStoreStub, "Tee", sku).InventoryItemID
+	}
+	// Variants without a SKU cannot be shared and are left out
+	env.shopify.AddVariant(s.store.ShopifyStoreStub, "Gift card", "")
+
+	sync, err := env.catalogSyncService.SyncNow(context.Background(), s.store, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("SyncNow failed: %v", err)
+	}
+	if sync.Status != models.CatalogSyncCompleted || sync.VariantsSynced != len(items) {
+		t.Fatalf("unexpected catalog sync %+v", sync)
+	}
+
+	inventories, err := env.inventoryRepo.GetInventoryByStore(s.store.ID)
+	if err != nil {
+		t.Fatalf("failed to load inventory: %v", err)
+	}
+	if len(inventories) != len(items) {
+		t.Fatalf("expected %d inventory rows, got %d", len(items), len(inventories))
+	}
+	for _, inventory := range inventories {
+		if want := strconv.FormatInt(items[inventory.SKU], 10); inventory.InventoryItemID != want {
+			t.Fatalf("expected inventory item %s for %s, got %s", want, inventory.SKU, inventory.InventoryItemID)
+		}
+	}
+}
+
+func TestIntegrationProductSyncFailsWhenPollingFails(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+	env.shopify.AddVariant(s.store.ShopifyStoreStub, "Tee", "TEE-0")
+	env.shopify.Fail(s.store.ShopifyStoreStub, "bulkOperation", 1, shopifytest.Failure{GraphQLErrors: []string{"Internal error"}})
+
+	if _, err := env.catalogSyncService.SyncNow(context.Background(), s.store, 10*time.Millisecond); err == nil {
+		t.Fatal("expected SyncNow to fail")
+	}
+
+	syncs, err := env.catalogSyncService.GetStoreSyncs(env.company.ID.String(), s.store.ID.String(), 1)
+	if err != nil || len(syncs) != 1 {
+		t.Fatalf("failed to load catalog syncs: %v", err)
+	}
+	if syncs[0].Status != models.CatalogSyncFailed || syncs[0].LastError == "" {
+		t.Fatalf("expected the sync to be failed, got %+v", syncs[0])
+	}
+}
+
+func TestIntegrationProductSyncFailsOnLastPoll(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	s := env.addStore(t)
+	env.shopify.AddVariant(s.store.ShopifyStoreStub, "Tee", "TEE-0")
+
+	sync, err := env.catalogSyncService.QueueSync(s.store)
+	if err != nil {
+		t.Fatalf("QueueSync failed: %v", err)
+	}
+	payload := CatalogSyncPayload{SyncID: sync.ID}
+	start, err := env.jobService.Enqueue(JobTypeCatalogSyncStart, payload)
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	if err := env.catalogSyncService.ProcessStartJob(ctx, start); err != nil {
+		t.Fatalf("ProcessStartJob failed: %v", err)
+	}
+
+	env.shopify.Fail(s.store.ShopifyStoreStub, "bulkOperation", 2, shopifytest.Failure{GraphQLErrors: []string{"Internal error"}})
+	status := func() string {
+		syncs, err := env.catalogSyncService.GetStoreSyncs(env.company.ID.String(), s.store.ID.String(), 1)
+		if err != nil || len(syncs) != 1 {
+			t.Fatalf("failed to load catalog syncs: %v", err)
+		}
+		return syncs[0].Status
+	}
+	poll, err := env.jobService.Enqueue(JobTypeCatalogSyncPoll, payload)
+	if err != nil {
+		t.Fatalf("failed to enqueue job: %v", err)
+	}
+	for _, attempt := range []struct {
+		attempts int
+		want     string
+	}{{1, models.CatalogSyncRunning}, {poll.MaxAttempts, models.CatalogSyncFailed}} {
+		poll.Attempts = attempt.attempts
+		if err := env.catalogSyncService.ProcessPollJob(ctx, poll); err == nil {
+			t.Fatalf("expected attempt %d to fail", attempt.attempts)
+		}
+		if got := status(); got != attempt.want {
+			t.Fatalf("expected the sync to be %s after attempt %d, got %s", attempt.want, attempt.attempts, got)
+		}
+	}
+}
+
+func TestSaveInventoryItemKeepsOneMapping(t *testing.T) {
+	env := newIntegrationEnv(t)
+	s := env.addStore(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := env.inventoryRepo.SaveInventoryItem("TEE-0", s.store.ID, strconv.Itoa(100+i)); err != nil {
+				t.Errorf("SaveInventoryItem failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := env.inventoryRepo.SaveInventoryItem("TEE-0", s.store.ID, "200"); err != nil {
+		t.Fatalf("SaveInventoryItem failed: %v", err)
+	}
+
+	inventories, err := env.inventoryRepo.GetInventoryByStore(s.store.ID)
+	if err != nil {
+		t.Fatalf("failed to load inventory: %v", err)
+	}
+	if len(inventories) != 1 || inventories[0].InventoryItemID != "200" {
+		t.Fatalf("expected one mapping to inventory item 200, got %+v", inventories)
+	}
+}
+
+func TestIntegrationReconciliation(t *testing.T) {
+	env := newIntegrationEnv(t)
+
+	first, second, drifted := env.addStore(t), env.addStore(t), env.addStore(t)
+	const sku = "CAP-RED"
+	firstItem := env.stock(t, first, sku, 4)
+	env.stock(t, second, sku, 4)
+	driftedItem := env.stock(t, drifted, sku, 9)
+
+	report, err := env.reconcileService.Reconcile(context.Background(), env.stockGroup, true)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if report.DriftCount != 1 || report.Corrected != 1 {
+		t.Fatalf("expected 1 drift and 1 correction, got %d and %d", report.DriftCount, report.Corrected)
+	}
+	if source := report.Drifts[0].Source; source != DriftSourceMajority {
+		t.Fatalf("expected the quantity to be agreed by majority, got %s", source)
+	}
+	env.assertAvailable(t, drifted, driftedItem, 4)
+	env.assertAvailable(t, first, firstItem, 4)
+	env.assertStockLevel(t, sku, 4)
+}
@@ -1,12 +1,9 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"gostockly/internal/repositories"
-	"io/ioutil"
-	"net/http"
+	"gostockly/pkg/shopify"
 
 	"github.com/google/uuid"
 )
@@ -23,84 +20,40 @@ func NewInventoryService(inventoryRepo *repositories.InventoryRepository, storeR
 	}
 }
 
-// ShopifyRequest sends a GraphQL request to Shopify
-func (s *InventoryService) ShopifyRequest(apiKey, apiSecret, query string, variables map[string]interface{}) ([]byte, error) {
-	url := "https://your-shopify-store.myshopify.com/admin/api/2023-01/graphql.json"
-
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"query":     query,
-		"variables": variables,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, err
-	}
-
-	req.SetBasicAuth(apiKey, apiSecret)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, errors.New(string(body))
-	}
-
-	return ioutil.ReadAll(resp.Body)
+// DecrementSingleSKU decrements inventory for a specific SKU and store
+func (s *InventoryService) DecrementSingleSKU(ctx context.Context, companyID, sku string, amount int, storeID uuid.UUID, locationID string) error {
+	return s.DecrementBulkSKUs(ctx, companyID, []string{sku}, amount, storeID, locationID)
 }
 
-// DecrementSingleSKU decrements inventory for a specific SKU and store
-func (s *InventoryService) DecrementSingleSKU(sku string, amount int, storeID uuid.UUID, locationID, apiKey, apiSecret string) error {
-	inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(sku, storeID)
+// DecrementBulkSKUs decrements inventory for multiple SKUs for a specific store in a single
+// mutation. The store must be owned by the company, and its own location is used when
+// locationID is empty.
+func (s *InventoryService) DecrementBulkSKUs(ctx context.Context, companyID string, skus []string, amount int, storeID uuid.UUID, locationID string) error {
+	store, err := s.StoreRepo.GetCompanyStore(companyID, storeID.String())
 	if err != nil {
 		return err
 	}
-
-	query := `
-		mutation AdjustInventoryLevel($inventoryItemId: ID!, $locationId: ID!, $adjustment: Int!) {
-			inventoryAdjustQuantity(
-				inventoryItemId: $inventoryItemId,
-				locationId: $locationId,
-				availableDelta: $adjustment
-			) {
-				inventoryLevel {
-					id
-					available
-				}
-				userErrors {
-					field
-					message
-				}
-			}
-		}
-	`
-
-	variables := map[string]interface{}{
-		"inventoryItemId": inventory.InventoryItemID,
-		"locationId":      locationID,
-		"adjustment":      -amount,
+	if locationID == "" {
+		locationID = store.LocationID
 	}
 
-	_, err = s.ShopifyRequest(apiKey, apiSecret, query, variables)
-	return err
-}
-
-// DecrementBulkSKUs decrements inventory for multiple SKUs for a specific store
-func (s *InventoryService) DecrementBulkSKUs(skus []string, amount int, storeID uuid.UUID, locationID, apiKey, apiSecret string) error {
+	input := shopify.AdjustQuantitiesInput{
+		Reason: "movement_created",
+		Name:   "available",
+	}
 	for _, sku := range skus {
-		err := s.DecrementSingleSKU(sku, amount, storeID, locationID, apiKey, apiSecret)
+		inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(sku, storeID)
 		if err != nil {
 			return err
 		}
+		input.Changes = append(input.Changes, shopify.QuantityChange{
+			InventoryItemID: inventory.InventoryItemID,
+			LocationID:      locationID,
+			Delta:           -amount,
+		})
 	}
-	return nil
+
+	client := newStoreClient(store)
+	_, err = client.AdjustQuantities(ctx, input)
+	return err
 }
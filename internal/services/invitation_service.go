@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/mailer"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// invitationTTL is how long an invitation can be accepted after it was created.
+const invitationTTL = 7 * 24 * time.Hour
+
+// minPasswordLength is the shortest password accepted for new accounts.
+const minPasswordLength = 8
+
+var (
+	ErrInvitationNotFound = repositories.ErrInvitationNotFound
+	ErrInvalidInvitation  = errors.New("invalid or expired invitation")
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrEmailTaken         = errors.New("a user with this email address already exists")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+	ErrInvitationNotSent  = errors.New("failed to send invitation email")
+)
+
+// InvitationService lets owners invite people to join their company with a role. Invitations
+// are emailed to the invitee, so accepting one proves they own the address.
+type InvitationService struct {
+	InvitationRepo *repositories.InvitationRepository
+	UserRepo       *repositories.UserRepository
+	CompanyRepo    *repositories.CompanyRepository
+	Mailer         mailer.Mailer
+	// AcceptURL is the frontend page invitees accept their invitation on
+	AcceptURL string
+}
+
+func NewInvitationService(invitationRepo *repositories.InvitationRepository, userRepo *repositories.UserRepository, companyRepo *repositories.CompanyRepository, m mailer.Mailer, acceptURL string) *InvitationService {
+	return &InvitationService{
+		InvitationRepo: invitationRepo,
+		UserRepo:       userRepo,
+		CompanyRepo:    companyRepo,
+		Mailer:         m,
+		AcceptURL:      acceptURL,
+	}
+}
+
+// CreateInvitation invites email to join the company with a role and emails them the
+// invitation. The token is only part of the email and stored hashed.
+func (s *InvitationService) CreateInvitation(companyID, invitedBy, email string, role models.Role) (*models.Invitation, error) {
+	email = normalizeEmail(email)
+	if !strings.Contains(email, "@") {
+		return nil, ErrInvalidEmail
+	}
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	if _, err := s.UserRepo.GetUserByEmail(email); err == nil {
+		return nil, ErrEmailTaken
+	}
+	company, err := s.CompanyRepo.GetCompanyByID(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &models.Invitation{
+		ID:        uuid.New(),
+		CompanyID: uuid.MustParse(companyID),
+		Email:     email,
+		Role:      role,
+		TokenHash: hashToken(token),
+		InvitedBy: uuid.MustParse(invitedBy),
+		ExpiresAt: time.Now().Add(invitationTTL),
+	}
+	if err := s.InvitationRepo.CreateInvitation(invitation); err != nil {
+		return nil, err
+	}
+
+	// An invitation nobody received cannot be accepted, so it is not kept
+	if err := s.Mailer.Send(s.invitationMessage(company, invitation, token)); err != nil {
+		if err := s.InvitationRepo.DeleteInvitation(companyID, invitation.ID.String()); err != nil {
+			logger.GetLogger().Error("Failed to delete undelivered invitation %s: %v", invitation.ID, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvitationNotSent, err)
+	}
+
+	logger.GetLogger().Info("User %s invited %s to company %s as %s", invitedBy, email, companyID, role)
+	return invitation, nil
+}
+
+// invitationMessage is the email an invitee receives.
+func (s *InvitationService) invitationMessage(company *models.Company, invitation *models.Invitation, token string) mailer.Message {
+	link := s.AcceptURL + "?token=" + url.QueryEscape(token)
+	return mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You have been invited to join %s", company.Name),
+		Body: fmt.Sprintf("You have been invited to join %s as %s.\n\n"+
+			"Choose a password to accept the invitation:\n%s\n\n"+
+			"The invitation expires on %s. If you did not expect it, you can ignore this email.\n",
+			company.Name, invitation.Role, link, invitation.ExpiresAt.Format("2 January 2006 15:04 MST")),
+	}
+}
+
+// GetPendingInvitations returns the invitations of the company that can still be accepted.
+func (s *InvitationService) GetPendingInvitations(companyID string) ([]models.Invitation, error) {
+	return s.InvitationRepo.GetPendingInvitationsByCompany(companyID, time.Now())
+}
+
+// RevokeInvitation deletes an invitation of the company, or returns ErrInvitationNotFound.
+func (s *InvitationService) RevokeInvitation(companyID, invitationID string) error {
+	return s.InvitationRepo.DeleteInvitation(companyID, invitationID)
+}
+
+// AcceptInvitation creates the account of an invitee, with the company and role they were
+// invited with.
+func (s *InvitationService) AcceptInvitation(token, password string) (*models.User, error) {
+	if len(password) < minPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	tokenHash := hashToken(token)
+	pending, err := s.InvitationRepo.GetPendingInvitation(tokenHash, time.Now())
+	if errors.Is(err, repositories.ErrInvitationNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.UserRepo.GetUserByEmail(pending.Email); err == nil {
+		return nil, ErrEmailTaken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.New("failed to hash password")
+	}
+
+	// Claiming makes sure a token racing itself creates one account
+	invitation, err := s.InvitationRepo.ClaimInvitation(tokenHash, time.Now())
+	if errors.Is(err, repositories.ErrInvitationNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The invitation was emailed, so accepting it proves the invitee owns the address
+	now := time.Now()
+	user := &models.User{
+		ID:              uuid.New(),
+		Email:           invitation.Email,
+		Password:        string(hashedPassword),
+		CompanyID:       invitation.CompanyID,
+		Role:            invitation.Role,
+		EmailVerifiedAt: &now,
+	}
+	if err := s.UserRepo.CreateUser(user); err != nil {
+		return nil, err
+	}
+
+	logger.GetLogger().Info("User %s joined company %s as %s through invitation %s", user.ID, user.CompanyID, user.Role, invitation.ID)
+	return user, nil
+}
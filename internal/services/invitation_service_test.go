@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/mailer"
+
+	"github.com/google/uuid"
+)
+
+// recordingMailer keeps the messages it is asked to send.
+type recordingMailer struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var invitationLink = regexp.MustCompile(`https://app\.test/invitations/accept\?token=(\S+)`)
+
+func TestInviteBasedOnboarding(t *testing.T) {
+	db := openTestDB(t)
+	userRepo := repositories.NewUserRepository(db)
+	companyRepo := repositories.NewCompanyRepository(db)
+	sessions := NewSessionService(userRepo, repositories.NewRefreshTokenRepository(db), "test-secret", time.Minute, time.Hour)
+	mail := &recordingMailer{}
+	accounts := NewAccountService(userRepo, repositories.NewAccountTokenRepository(db), sessions, mail, "https://app.test")
+	userService := NewUserService(userRepo, companyRepo, sessions, accounts)
+	invitationService := NewInvitationService(repositories.NewInvitationRepository(db), userRepo, companyRepo, mail, "https://app.test/invitations/accept")
+
+	suffix := uuid.New().String()[:8]
+	owner, err := userService.RegisterUser("owner-"+suffix+"@onboarding.test", "password", "Onboarding "+suffix, "onboarding-"+suffix)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if owner.Role != models.RoleOwner {
+		t.Fatalf("expected the registering user to own the company, got %s", owner.Role)
+	}
+	mail.sent = nil
+
+	// Knowing the subdomain is not enough to join the company
+	_, err = userService.RegisterUser("intruder-"+suffix+"@onboarding.test", "password", "Other "+suffix, "onboarding-"+suffix)
+	if !errors.Is(err, ErrSubdomainTaken) {
+		t.Fatalf("expected registering an existing subdomain to fail, got %v", err)
+	}
+
+	invitee := "operator-" + suffix + "@onboarding.test"
+	invitation, err := invitationService.CreateInvitation(owner.CompanyID.String(), owner.ID.String(), invitee, models.RoleOperator)
+	if err != nil {
+		t.Fatalf("failed to invite: %v", err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To != invitee {
+		t.Fatalf("expected one invitation email to %s, got %+v", invitee, mail.sent)
+	}
+	match := invitationLink.FindStringSubmatch(mail.sent[0].Body)
+	if match == nil {
+		t.Fatalf("invitation email has no link: %s", mail.sent[0].Body)
+	}
+	token, _ := url.QueryUnescape(match[1])
+	if token == invitation.TokenHash {
+		t.Fatal("expected the email to carry the token, not its hash")
+	}
+
+	user, err := invitationService.AcceptInvitation(token, "password")
+	if err != nil {
+		t.Fatalf("failed to accept invitation: %v", err)
+	}
+	if user.CompanyID != owner.CompanyID || user.Role != models.RoleOperator || user.EmailVerifiedAt == nil {
+		t.Fatalf("expected a verified operator of the owner's company, got %+v", user)
+	}
+	if _, err := invitationService.AcceptInvitation(token, "password"); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expected a used invitation to be refused, got %v", err)
+	}
+
+	// An invitation that could not be delivered is not kept
+	mail.err = errors.New("mail server down")
+	_, err = invitationService.CreateInvitation(owner.CompanyID.String(), owner.ID.String(), "viewer-"+suffix+"@onboarding.test", models.RoleViewer)
+	if !errors.Is(err, ErrInvitationNotSent) {
+		t.Fatalf("expected delivery failure, got %v", err)
+	}
+	pending, err := invitationService.GetPendingInvitations(owner.CompanyID.String())
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending invitations, got %+v (%v)", pending, err)
+	}
+}
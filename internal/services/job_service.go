@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobHandler processes a single job. Returning an error schedules a retry.
+// Jobs are delivered at least once, so handlers must be idempotent. ctx ends when the job's
+// lock expires, after which another worker may claim it.
+type JobHandler func(ctx context.Context, job *models.Job) error
+
+// DeadLetterHandler is called once a job has failed its last attempt. Returning an error keeps
+// the job queued, to run and be dead lettered again after a backoff.
+type DeadLetterHandler func(job *models.Job, err error) error
+
+type JobService struct {
+	JobRepo      *repositories.JobRepository
+	Concurrency  int
+	PollInterval time.Duration
+	LockTimeout  time.Duration
+	MaxAttempts  int
+
+	mu                 sync.RWMutex
+	handlers           map[string]JobHandler
+	deadLetterHandlers map[string]DeadLetterHandler
+	schedules          []jobSchedule
+}
+
+// jobSchedule is a job that is queued again every interval.
+type jobSchedule struct {
+	jobType  string
+	payload  interface{}
+	interval time.Duration
+}
+
+func NewJobService(jobRepo *repositories.JobRepository, concurrency int, pollInterval, lockTimeout time.Duration, maxAttempts int) *JobService {
+	return &JobService{
+		JobRepo:            jobRepo,
+		Concurrency:        concurrency,
+		PollInterval:       pollInterval,
+		LockTimeout:        lockTimeout,
+		MaxAttempts:        maxAttempts,
+		handlers:           make(map[string]JobHandler),
+		deadLetterHandlers: make(map[string]DeadLetterHandler),
+	}
+}
+
+// RegisterHandler sets the handler for jobs of the given type.
+func (s *JobService) RegisterHandler(jobType string, handler JobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[jobType] = handler
+}
+
+// RegisterDeadLetterHandler sets the handler called when a job of the given type runs out of attempts.
+func (s *JobService) RegisterDeadLetterHandler(jobType string, handler DeadLetterHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetterHandlers[jobType] = handler
+}
+
+// Schedule queues a job of the given type every interval while Run is running. No new job is
+// queued while an earlier one of the same type is still queued or running, so running several
+// instances does not multiply the work. A zero interval disables the schedule.
+func (s *JobService) Schedule(jobType string, payload interface{}, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules = append(s.schedules, jobSchedule{jobType: jobType, payload: payload, interval: interval})
+}
+
+// Enqueue adds a job of the given type to the queue. The payload is stored as JSON.
+func (s *JobService) Enqueue(jobType string, payload interface{}) (*models.Job, error) {
+	return s.EnqueueAt(jobType, payload, time.Now())
+}
+
+// EnqueueAt adds a job that will not run before runAt.
+func (s *JobService) EnqueueAt(jobType string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	job := &models.Job{
+		ID:          uuid.New(),
+		Type:        jobType,
+		Payload:     data,
+		Status:      models.JobQueued,
+		MaxAttempts: s.MaxAttempts,
+		RunAt:       runAt,
+	}
+	if err := s.JobRepo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// EnqueueRetry adds a job for work that has already failed once outside the queue,
+// scheduling its next attempt after the usual backoff.
+func (s *JobService) EnqueueRetry(jobType string, payload interface{}, cause error) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	job := &models.Job{
+		ID:          uuid.New(),
+		Type:        jobType,
+		Payload:     data,
+		Status:      models.JobQueued,
+		Attempts:    1,
+		MaxAttempts: s.MaxAttempts,
+		RunAt:       time.Now().Add(jobBackoff(1)),
+		LastError:   cause.Error(),
+	}
+	if err := s.JobRepo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run starts the worker pool and blocks until ctx is cancelled and all workers have stopped.
+func (s *JobService) Run(ctx context.Context) {
+	log := logger.GetLogger()
+
+	hostname, _ := os.Hostname()
+	log.Info("Starting %d job workers", s.Concurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.Concurrency; i++ {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			s.work(ctx, workerID)
+		}(fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i))
+	}
+
+	s.mu.RLock()
+	schedules := s.schedules
+	s.mu.RUnlock()
+	for _, schedule := range schedules {
+		wg.Add(1)
+		go func(schedule jobSchedule) {
+			defer wg.Done()
+			s.runSchedule(ctx, schedule)
+		}(schedule)
+	}
+
+	wg.Wait()
+	log.Info("All job workers stopped")
+}
+
+// work claims and runs jobs until ctx is cancelled, sleeping when the queue is empty.
+func (s *JobService) work(ctx context.Context, workerID string) {
+	log := logger.GetLogger()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, err := s.JobRepo.ClaimNextJob(workerID, s.LockTimeout)
+		if err != nil {
+			log.Error("Worker %s failed to claim job: %v", workerID, err)
+		}
+		if err != nil || job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.PollInterval):
+			}
+			continue
+		}
+
+		s.runJob(ctx, job)
+	}
+}
+
+// runSchedule queues the scheduled job every interval until ctx is cancelled.
+func (s *JobService) runSchedule(ctx context.Context, schedule jobSchedule) {
+	log := logger.GetLogger()
+	log.Info("Scheduling %s jobs every %s", schedule.jobType, schedule.interval)
+
+	ticker := time.NewTicker(schedule.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pending, err := s.JobRepo.HasPendingJob(schedule.jobType)
+		if err != nil {
+			log.Error("Failed to check for pending %s jobs: %v", schedule.jobType, err)
+			continue
+		}
+		if pending {
+			log.Info("Skipping scheduled %s job, an earlier one has not finished", schedule.jobType)
+			continue
+		}
+
+		if _, err := s.Enqueue(schedule.jobType, schedule.payload); err != nil {
+			log.Error("Failed to queue scheduled %s job: %v", schedule.jobType, err)
+		}
+	}
+}
+
+// runJob runs the handler for a claimed job and records the outcome. A job that has started
+// is allowed to finish when the worker is stopped, but not to outlive its lock.
+func (s *JobService) runJob(ctx context.Context, job *models.Job) {
+	log := logger.GetLogger()
+	log.Info("Running job %s (type: %s, attempt: %d)", job.ID, job.Type, job.Attempts)
+
+	s.mu.RLock()
+	handler, ok := s.handlers[job.Type]
+	s.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %s", job.Type)
+	} else {
+		jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.LockTimeout)
+		err = s.safeHandle(jobCtx, handler, job)
+		cancel()
+	}
+
+	if err == nil {
+		if err := s.JobRepo.CompleteJob(job); err != nil {
+			s.logReleaseError(job, "completed", err)
+		}
+		return
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		// The job is only failed once its dead letter is safely stored, otherwise it is kept in
+		// the queue so the work is not lost
+		if deadLetterErr := s.deadLetter(job, err); deadLetterErr != nil {
+			runAt := time.Now().Add(jobBackoff(job.Attempts))
+			log.Error("Failed to dead letter job %s, retrying at %s: %v", job.ID, runAt.Format(time.RFC3339), deadLetterErr)
+			lastError := fmt.Sprintf("%v (dead letter failed: %v)", err, deadLetterErr)
+			if err := s.JobRepo.RescheduleJob(job, runAt, lastError); err != nil {
+				s.logReleaseError(job, "queued again", err)
+			}
+			return
+		}
+
+		log.Error("Job %s failed permanently after %d attempts: %v", job.ID, job.Attempts, err)
+		if err := s.JobRepo.FailJob(job, err.Error()); err != nil {
+			s.logReleaseError(job, "failed", err)
+		}
+		return
+	}
+
+	runAt := time.Now().Add(jobBackoff(job.Attempts))
+	log.Error("Job %s failed, retrying at %s: %v", job.ID, runAt.Format(time.RFC3339), err)
+	if err := s.JobRepo.RescheduleJob(job, runAt, err.Error()); err != nil {
+		s.logReleaseError(job, "queued again", err)
+	}
+}
+
+// logReleaseError logs a failure to record the outcome of a job. A job whose lock expired while
+// it ran belongs to whichever worker claimed it again, so its outcome is left to that worker.
+func (s *JobService) logReleaseError(job *models.Job, status string, err error) {
+	log := logger.GetLogger()
+	if errors.Is(err, repositories.ErrJobLockLost) {
+		log.Error("Job %s lost its lock while attempt %d ran, leaving it to the worker that claimed it", job.ID, job.Attempts)
+		return
+	}
+	log.Error("Failed to mark job %s as %s: %v", job.ID, status, err)
+}
+
+// deadLetter hands a job that ran out of attempts to the dead letter handler for its type, if any,
+// and returns the handler's error.
+func (s *JobService) deadLetter(job *models.Job, cause error) error {
+	s.mu.RLock()
+	handler, ok := s.deadLetterHandlers[job.Type]
+	s.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return handler(job, cause)
+}
+
+// safeHandle runs a handler, turning a panic into an error so the worker survives it.
+func (s *JobService) safeHandle(ctx context.Context, handler JobHandler, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// jobBackoff returns how long to wait before the next attempt. The delay grows as 2^attempts
+// seconds, capped at an hour, with random jitter over its upper half so that jobs which
+// failed together do not all retry at the same moment.
+func jobBackoff(attempts int) time.Duration {
+	backoff := time.Hour
+	if attempts <= 12 {
+		backoff = min(time.Duration(1<<attempts)*time.Second, time.Hour)
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// DecodeJobPayload decodes the JSON payload of a job into v.
+func DecodeJobPayload(job *models.Job, v interface{}) error {
+	if err := json.Unmarshal(job.Payload, v); err != nil {
+		return errors.New("failed to decode job payload")
+	}
+	return nil
+}
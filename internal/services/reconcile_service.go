@@ -0,0 +1,364 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job types for reconciliation.
+const (
+	JobTypeReconcile    = "stock.reconcile"
+	JobTypeReconcileAll = "stock.reconcile_all"
+)
+
+// recentChangeWindow is how long after a change to a canonical level the reconciler leaves the
+// SKU alone in auto-correct runs. Webhooks for the change may still be queued or settling, so a
+// store that looks drifted may just not have caught up yet.
+const recentChangeWindow = 5 * time.Minute
+
+// Sources of the agreed quantity in a drift report.
+const (
+	DriftSourceLedger   = "ledger"
+	DriftSourceMajority = "majority"
+)
+
+// ReconcilePayload is the job payload for JobTypeReconcile and JobTypeReconcileAll.
+type ReconcilePayload struct {
+	StockGroupID uuid.UUID `json:"stock_group_id,omitempty"`
+	AutoCorrect  bool      `json:"auto_correct"`
+}
+
+type ReconcileService struct {
+	StockGroupRepo      *repositories.StockGroupRepository
+	StockGroupStoreRepo *repositories.StockGroupStoreRepository
+	InventoryRepo       *repositories.InventoryRepository
+	LedgerRepo          *repositories.StockLedgerRepository
+	ReportRepo          *repositories.ReconciliationReportRepository
+	AdjustmentService   *AdjustmentService
+	LocationService     *StoreLocationService
+	JobService          *JobService
+}
+
+// NewReconcileService creates the reconciler and schedules a run over every stock group each
+// interval. A zero interval leaves reconciliation to the API and the CLI.
+func NewReconcileService(
+	stockGroupRepo *repositories.StockGroupRepository,
+	stockGroupStoreRepo *repositories.StockGroupStoreRepository,
+	inventoryRepo *repositories.InventoryRepository,
+	ledgerRepo *repositories.StockLedgerRepository,
+	reportRepo *repositories.ReconciliationReportRepository,
+	adjustmentService *AdjustmentService,
+	locationService *StoreLocationService,
+	jobService *JobService,
+	interval time.Duration,
+	autoCorrect bool,
+) *ReconcileService {
+	s := &ReconcileService{
+		StockGroupRepo:      stockGroupRepo,
+		StockGroupStoreRepo: stockGroupStoreRepo,
+		InventoryRepo:       inventoryRepo,
+		LedgerRepo:          ledgerRepo,
+		ReportRepo:          reportRepo,
+		AdjustmentService:   adjustmentService,
+		LocationService:     locationService,
+		JobService:          jobService,
+	}
+
+	jobService.RegisterHandler(JobTypeReconcile, s.ProcessReconcileJob)
+	jobService.RegisterHandler(JobTypeReconcileAll, s.ProcessReconcileAllJob)
+	jobService.Schedule(JobTypeReconcileAll, ReconcilePayload{AutoCorrect: autoCorrect}, interval)
+
+	return s
+}
+
+// ProcessReconcileAllJob is the job handler that queues a reconciliation of every stock group.
+func (s *ReconcileService) ProcessReconcileAllJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	var payload ReconcilePayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+
+	stockGroups, err := s.StockGroupRepo.GetAllStockGroups()
+	if err != nil {
+		return err
+	}
+
+	for _, stockGroup := range stockGroups {
+		_, err := s.JobService.Enqueue(JobTypeReconcile, ReconcilePayload{StockGroupID: stockGroup.ID, AutoCorrect: payload.AutoCorrect})
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Queued reconciliation of %d stock groups", len(stockGroups))
+	return nil
+}
+
+// ProcessReconcileJob is the job handler that reconciles a single stock group.
+func (s *ReconcileService) ProcessReconcileJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	var payload ReconcilePayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+
+	stockGroup, err := s.StockGroupRepo.GetStockGroupByID(payload.StockGroupID.String())
+	if err != nil {
+		// The stock group has been removed since, so there is nothing left to reconcile
+		log.Error("Dropping reconcile job %s, stock group %s not found: %v", job.ID, payload.StockGroupID, err)
+		return nil
+	}
+
+	_, err = s.Reconcile(ctx, stockGroup, payload.AutoCorrect)
+	return err
+}
+
+// ReconcileStockGroup reconciles a stock group owned by the company and returns the report.
+func (s *ReconcileService) ReconcileStockGroup(ctx context.Context, companyID, stockGroupID string, autoCorrect bool) (*models.ReconciliationReport, error) {
+	stockGroup, err := s.StockGroupRepo.GetCompanyStockGroup(companyID, stockGroupID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Reconcile(ctx, stockGroup, autoCorrect)
+}
+
+// GetReports returns the most recent reconciliation reports of a stock group owned by the company.
+func (s *ReconcileService) GetReports(companyID, stockGroupID string, limit int) ([]models.ReconciliationReport, error) {
+	return s.ReportRepo.GetReconciliationReports(companyID, stockGroupID, limit)
+}
+
+// storeLevels is the level of every mapped SKU in one store.
+type storeLevels struct {
+	store     models.Store
+	synced    []string          // the locations of the store the levels are summed over
+	items     map[string]string // SKU to inventory item ID
+	available map[string]int    // inventory item ID to available quantity
+}
+
+// Reconcile reads the level of every mapped SKU in every store of the stock group, compares
+// them with the quantity the stores should agree on and stores the drift report. A store's level
+// is its available quantity summed over its synced locations, as in SyncLevels.
+//
+// The agreed quantity is the canonical level from the stock ledger. SKUs without one agree on
+// the quantity held by most stores. With autoCorrect, drifted stores are set to the agreed
+// quantity, and a majority quantity becomes the SKU's canonical level.
+func (s *ReconcileService) Reconcile(ctx context.Context, stockGroup *models.StockGroup, autoCorrect bool) (*models.ReconciliationReport, error) {
+	log := logger.GetLogger()
+	log.Info("Reconciling stock group %s (auto-correct: %t)", stockGroup.ID, autoCorrect)
+
+	stores, err := s.StockGroupStoreRepo.GetStoresByStockGroup(stockGroup.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stores in stock group %s: %w", stockGroup.ID, err)
+	}
+	routing, err := s.LocationService.routing(stores)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve locations of stock group %s: %w", stockGroup.ID, err)
+	}
+
+	var levels []storeLevels
+	skuSet := make(map[string]bool)
+	for _, store := range stores {
+		inventories, err := s.InventoryRepo.GetInventoryByStore(store.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve inventory of store %s: %w", store.ShopifyStoreStub, err)
+		}
+		if len(inventories) == 0 {
+			continue
+		}
+
+		items := make(map[string]string, len(inventories))
+		itemIDs := make([]string, 0, len(inventories))
+		for _, inventory := range inventories {
+			items[inventory.SKU] = inventory.InventoryItemID
+			itemIDs = append(itemIDs, inventory.InventoryItemID)
+			skuSet[inventory.SKU] = true
+		}
+
+		synced := routing.synced(&store)
+		available, err := s.AdjustmentService.StoreLevels(ctx, newStoreClient(&store), &store, synced, itemIDs)
+		if err != nil {
+			// Comparing against a partial picture would report every SKU of the store as drifted
+			return nil, fmt.Errorf("failed to read inventory levels of store %s: %w", store.ShopifyStoreStub, err)
+		}
+
+		levels = append(levels, storeLevels{store: store, synced: synced, items: items, available: available})
+	}
+
+	canonical, err := s.LedgerRepo.GetStockLevelsByStockGroup(stockGroup.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stock levels of stock group %s: %w", stockGroup.ID, err)
+	}
+	canonicalBySKU := make(map[string]models.StockLevel, len(canonical))
+	for _, level := range canonical {
+		canonicalBySKU[level.SKU] = level
+	}
+
+	skus := make([]string, 0, len(skuSet))
+	for sku := range skuSet {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+
+	report := &models.ReconciliationReport{
+		ID:           uuid.New(),
+		CompanyID:    stockGroup.CompanyID,
+		StockGroupID: stockGroup.ID,
+		AutoCorrect:  autoCorrect,
+		SKUsChecked:  len(skus),
+		Drifts:       []models.SKUDrift{},
+	}
+
+	for _, sku := range skus {
+		drift := models.SKUDrift{SKU: sku}
+		level, hasLevel := canonicalBySKU[sku]
+		if hasLevel {
+			quantity := level.Quantity
+			drift.Expected = &quantity
+			drift.Source = DriftSourceLedger
+		} else {
+			drift.Expected = majorityQuantity(levels, sku)
+			drift.Source = DriftSourceMajority
+		}
+
+		drifted := false
+		for _, l := range levels {
+			itemID, ok := l.items[sku]
+			if !ok {
+				continue
+			}
+
+			storeDrift := models.StoreDrift{StoreID: l.store.ID, Store: l.store.ShopifyStoreStub}
+			if available, ok := l.available[itemID]; ok {
+				storeDrift.Available = &available
+			} else {
+				storeDrift.Error = "inventory item is not stocked at any synced location of the store"
+			}
+			if storeDrift.Available == nil || drift.Expected == nil || *storeDrift.Available != *drift.Expected {
+				drifted = true
+			}
+			drift.Stores = append(drift.Stores, storeDrift)
+		}
+		if !drifted {
+			continue
+		}
+
+		if autoCorrect {
+			s.correctDrift(ctx, report, &drift, levels, hasLevel && time.Since(level.UpdatedAt) < recentChangeWindow)
+		}
+		report.DriftCount++
+		report.Drifts = append(report.Drifts, drift)
+	}
+
+	if err := s.ReportRepo.CreateReconciliationReport(report); err != nil {
+		log.Error("Failed to store reconciliation report for stock group %s: %v", stockGroup.ID, err)
+		return report, errors.New("failed to store reconciliation report")
+	}
+
+	log.Info("Reconciled stock group %s: %d SKUs checked, %d drifted, %d stores corrected",
+		stockGroup.ID, report.SKUsChecked, report.DriftCount, report.Corrected)
+	return report, nil
+}
+
+// correctDrift brings every drifted store to the agreed quantity of a SKU, making up the
+// difference at its primary location, and records the quantity in the ledger first if it was
+// agreed by majority.
+func (s *ReconcileService) correctDrift(ctx context.Context, report *models.ReconciliationReport, drift *models.SKUDrift, levels []storeLevels, recentlyChanged bool) {
+	log := logger.GetLogger()
+
+	if drift.Expected == nil {
+		drift.Skipped = "stores disagree and there is no canonical level"
+		return
+	}
+	if recentlyChanged {
+		drift.Skipped = "canonical level changed recently, stores may still be catching up"
+		return
+	}
+
+	if drift.Source == DriftSourceMajority {
+		movement := &models.StockMovement{
+			ID:            uuid.New(),
+			StockGroupID:  report.StockGroupID,
+			SKU:           drift.SKU,
+			QuantityAfter: *drift.Expected,
+			Reason:        models.StockMovementReconcile,
+			Note:          fmt.Sprintf("agreed by most stores in reconciliation %s", report.ID),
+		}
+		if err := s.LedgerRepo.SetQuantity(movement); err != nil {
+			log.Error("Failed to record stock level of SKU %s in stock group %s: %v", drift.SKU, report.StockGroupID, err)
+			drift.Skipped = "failed to record the agreed quantity in the stock ledger"
+			return
+		}
+	}
+
+	for i := range drift.Stores {
+		storeDrift := &drift.Stores[i]
+		if storeDrift.Available == nil || *storeDrift.Available == *drift.Expected {
+			continue
+		}
+
+		for _, l := range levels {
+			if l.store.ID != storeDrift.StoreID {
+				continue
+			}
+
+			log.Info("Correcting SKU %s in store %s from %d to %d", drift.SKU, l.store.ShopifyStoreStub, *storeDrift.Available, *drift.Expected)
+			errs, err := s.AdjustmentService.SyncLevels(ctx, newStoreClient(&l.store), &l.store, l.synced, []LevelChange{{
+				SKU:             drift.SKU,
+				InventoryItemID: l.items[drift.SKU],
+				LocationID:      l.store.LocationID,
+				Quantity:        *drift.Expected,
+			}}, reconcileReference(report.ID))
+			if err == nil {
+				err = errs[0]
+			}
+			if err != nil {
+				log.Error("Failed to correct SKU %s in store %s: %v", drift.SKU, l.store.ShopifyStoreStub, err)
+				storeDrift.Error = err.Error()
+				continue
+			}
+			storeDrift.Corrected = true
+			report.Corrected++
+		}
+	}
+}
+
+// majorityQuantity returns the available quantity of a SKU held by the most stores,
+// or nil if no single quantity is held by more stores than any other.
+func majorityQuantity(levels []storeLevels, sku string) *int {
+	counts := make(map[int]int)
+	for _, l := range levels {
+		itemID, ok := l.items[sku]
+		if !ok {
+			continue
+		}
+		if available, ok := l.available[itemID]; ok {
+			counts[available]++
+		}
+	}
+
+	var best *int
+	bestCount, tied := 0, false
+	for quantity, count := range counts {
+		switch {
+		case count > bestCount:
+			q := quantity
+			best, bestCount, tied = &q, count, false
+		case count == bestCount:
+			tied = true
+		}
+	}
+	if tied {
+		return nil
+	}
+	return best
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultAccessTokenTTL is how long access tokens are valid unless configured otherwise.
+const defaultAccessTokenTTL = 15 * time.Minute
+
+var (
+	ErrInvalidAccessToken  = errors.New("invalid or expired access token")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token is used twice. Either the client
+	// retried or the token leaked, so every token of that login is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// TokenPair is what a client receives when a session starts or is refreshed.
+type TokenPair struct {
+	AccessToken string `json:"access_token"`
+	// AccessTokenExpiresAt is when the client has to exchange the refresh token for a new pair
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// SessionService issues short-lived access tokens and rotating refresh tokens. Raising a
+// user's token version ends all their sessions: access tokens carry the version they were
+// issued with and are refused once it is outdated, refresh tokens are revoked.
+type SessionService struct {
+	UserRepo         *repositories.UserRepository
+	RefreshTokenRepo *repositories.RefreshTokenRepository
+	JWTSecret        string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+}
+
+func NewSessionService(userRepo *repositories.UserRepository, refreshTokenRepo *repositories.RefreshTokenRepository, jwtSecret string, accessTokenTTL, refreshTokenTTL time.Duration) *SessionService {
+	return &SessionService{
+		UserRepo:         userRepo,
+		RefreshTokenRepo: refreshTokenRepo,
+		JWTSecret:        jwtSecret,
+		AccessTokenTTL:   accessTokenTTL,
+		RefreshTokenTTL:  refreshTokenTTL,
+	}
+}
+
+// StartSession issues the first token pair of a new login.
+func (s *SessionService) StartSession(user *models.User) (*TokenPair, error) {
+	// Refresh tokens are kept past their expiry for a day so reuse is still recognised
+	if err := s.RefreshTokenRepo.DeleteExpiredRefreshTokens(time.Now().Add(-24 * time.Hour)); err != nil {
+		logger.GetLogger().Error("Failed to delete expired refresh tokens: %v", err)
+	}
+	return s.issue(user, uuid.New())
+}
+
+// Authenticate validates an access token and returns the user it was issued to.
+func (s *SessionService) Authenticate(accessToken string) (*models.User, error) {
+	claims, err := utils.ValidateJWT(accessToken, s.JWTSecret)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.UserRepo.GetUserByID(claims.UserID)
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenVersion != user.TokenVersion {
+		return nil, ErrInvalidAccessToken
+	}
+	return user, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair. Each refresh token can be used
+// once; using it again revokes every token rotated from the same login.
+func (s *SessionService) Refresh(refreshToken string) (*TokenPair, error) {
+	log := logger.GetLogger()
+
+	token, err := s.RefreshTokenRepo.GetRefreshTokenByHash(hashToken(refreshToken))
+	if errors.Is(err, repositories.ErrRefreshTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case token.RevokedAt != nil:
+		return nil, ErrInvalidRefreshToken
+	case token.UsedAt != nil:
+		log.Error("Refresh token %s of user %s was reused, revoking its session", token.ID, token.UserID)
+		return nil, s.revokeFamily(token, ErrRefreshTokenReused)
+	case !now.Before(token.ExpiresAt):
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.UserRepo.GetUserByID(token.UserID.String())
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return nil, s.revokeFamily(token, ErrInvalidRefreshToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.TokenVersion != token.TokenVersion {
+		return nil, s.revokeFamily(token, ErrInvalidRefreshToken)
+	}
+
+	// Another request may have used the token since it was read
+	marked, err := s.RefreshTokenRepo.MarkRefreshTokenUsed(token.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		log.Error("Refresh token %s of user %s was used concurrently, revoking its session", token.ID, token.UserID)
+		return nil, s.revokeFamily(token, ErrRefreshTokenReused)
+	}
+
+	return s.issue(user, token.FamilyID)
+}
+
+// Logout revokes the session a refresh token belongs to. With everywhere set, every session
+// of the user ends, including access tokens that were already issued.
+func (s *SessionService) Logout(refreshToken string, everywhere bool) error {
+	token, err := s.RefreshTokenRepo.GetRefreshTokenByHash(hashToken(refreshToken))
+	if errors.Is(err, repositories.ErrRefreshTokenNotFound) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if everywhere {
+		return s.RevokeSessions(token.UserID)
+	}
+	return s.RefreshTokenRepo.RevokeRefreshTokenFamily(token.FamilyID, time.Now())
+}
+
+// RevokeSessions ends every session of a user by raising their token version and revoking
+// their refresh tokens.
+func (s *SessionService) RevokeSessions(userID uuid.UUID) error {
+	if err := s.UserRepo.IncrementTokenVersion(userID); err != nil {
+		return err
+	}
+	if err := s.RefreshTokenRepo.RevokeUserRefreshTokens(userID, time.Now()); err != nil {
+		return err
+	}
+	logger.GetLogger().Info("Revoked all sessions of user %s", userID)
+	return nil
+}
+
+// issue creates an access token and a refresh token in the given family for a user.
+func (s *SessionService) issue(user *models.User, familyID uuid.UUID) (*TokenPair, error) {
+	now := time.Now()
+	accessToken, err := utils.GenerateJWT(user.ID.String(), user.TokenVersion, s.JWTSecret, s.AccessTokenTTL)
+	if err != nil {
+		return nil, errors.New("failed to generate token")
+	}
+
+	refreshToken, err := newRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &models.RefreshToken{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		FamilyID:     familyID,
+		TokenHash:    hashToken(refreshToken),
+		TokenVersion: user.TokenVersion,
+		ExpiresAt:    now.Add(s.RefreshTokenTTL),
+	}
+	if err := s.RefreshTokenRepo.CreateRefreshToken(token); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  now.Add(s.AccessTokenTTL),
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: token.ExpiresAt,
+	}, nil
+}
+
+// revokeFamily revokes the login a refresh token belongs to and returns reason, or the error
+// revoking failed with.
+func (s *SessionService) revokeFamily(token *models.RefreshToken, reason error) error {
+	if err := s.RefreshTokenRepo.RevokeRefreshTokenFamily(token.FamilyID, time.Now()); err != nil {
+		return err
+	}
+	return reason
+}
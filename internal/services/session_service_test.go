@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// newSessionTestService returns a session service backed by TEST_DATABASE_URL and a user to
+// sign in as. It skips the test when no database is configured.
+func newSessionTestService(t *testing.T) (*SessionService, *models.User) {
+	t.Helper()
+
+	db := openTestDB(t)
+
+	suffix := uuid.New().String()[:8]
+	company := &models.Company{ID: uuid.New(), Name: "Sessions " + suffix, Subdomain: "sessions-" + suffix}
+	if err := repositories.NewCompanyRepository(db).CreateCompany(company); err != nil {
+		t.Fatalf("failed to create company: %v", err)
+	}
+	userRepo := repositories.NewUserRepository(db)
+	user := &models.User{ID: uuid.New(), Email: suffix + "@sessions.test", Password: "-", CompanyID: company.ID, Role: models.RoleOwner}
+	if err := userRepo.CreateUser(user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	service := NewSessionService(userRepo, repositories.NewRefreshTokenRepository(db), "test-secret", time.Minute, time.Hour)
+	return service, user
+}
+
+func TestSessionRefreshRotatesAndDetectsReuse(t *testing.T) {
+	service, user := newSessionTestService(t)
+
+	first, err := service.StartSession(user)
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+	if _, err := service.Authenticate(first.AccessToken); err != nil {
+		t.Fatalf("expected access token to authenticate: %v", err)
+	}
+
+	second, err := service.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("expected refresh to rotate the refresh token")
+	}
+
+	// Presenting the first token again revokes the whole session
+	if _, err := service.Refresh(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse to be detected, got %v", err)
+	}
+	if _, err := service.Refresh(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected rotated token to be revoked after reuse, got %v", err)
+	}
+
+	if _, err := service.Refresh("unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected unknown token to be refused, got %v", err)
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	service, user := newSessionTestService(t)
+
+	phone, _ := service.StartSession(user)
+	laptop, _ := service.StartSession(user)
+
+	if err := service.Logout(phone.RefreshToken, false); err != nil {
+		t.Fatalf("failed to log out: %v", err)
+	}
+	if _, err := service.Refresh(phone.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected logged out session to be refused, got %v", err)
+	}
+	if _, err := service.Refresh(laptop.RefreshToken); err != nil {
+		t.Fatalf("expected other session to survive logout: %v", err)
+	}
+}
+
+func TestSessionRevokeSessions(t *testing.T) {
+	service, user := newSessionTestService(t)
+
+	tokens, _ := service.StartSession(user)
+	if err := service.RevokeSessions(user.ID); err != nil {
+		t.Fatalf("failed to revoke sessions: %v", err)
+	}
+
+	if _, err := service.Authenticate(tokens.AccessToken); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Fatalf("expected access token of a revoked session to be refused, got %v", err)
+	}
+	if _, err := service.Refresh(tokens.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected refresh token of a revoked session to be refused, got %v", err)
+	}
+
+	// A session started afterwards carries the new token version
+	user, _ = service.UserRepo.GetUserByID(user.ID.String())
+	fresh, _ := service.StartSession(user)
+	if _, err := service.Authenticate(fresh.AccessToken); err != nil {
+		t.Fatalf("expected new session to authenticate: %v", err)
+	}
+}
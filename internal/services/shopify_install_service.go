@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// InstallStateTTL is how long a merchant has to approve the app after starting an install.
+	InstallStateTTL = 15 * time.Minute
+	// InstallCookieName is the cookie that ties an install to the browser that started it.
+	InstallCookieName = "shopify_install"
+	// installCallbackMaxAge is how far the timestamp Shopify signs a callback with may be from now.
+	installCallbackMaxAge = 5 * time.Minute
+)
+
+var (
+	ErrShopifyAppNotConfigured = errors.New("the Shopify app is not configured")
+	ErrInvalidShopDomain       = errors.New("invalid shop domain, expected a domain such as example.myshopify.com")
+	ErrInvalidInstallCallback  = errors.New("invalid or expired Shopify install callback")
+	ErrStoreOwnedElsewhere     = errors.New("store is already connected to another company")
+	ErrMissingScopes           = errors.New("the store did not grant every required access scope")
+)
+
+// ShopifyInstallService onboards stores by installing the Shopify app on them through OAuth.
+type ShopifyInstallService struct {
+	InstallRepo                *repositories.ShopifyInstallRepository
+	StoreRepo                  *repositories.StoreRepository
+	StoreService               *StoreService
+	WebhookSubscriptionService *WebhookSubscriptionService
+	LocationService            *StoreLocationService
+	OAuth                      *shopify.OAuthConfig
+}
+
+func NewShopifyInstallService(
+	installRepo *repositories.ShopifyInstallRepository,
+	storeRepo *repositories.StoreRepository,
+	storeService *StoreService,
+	webhookSubscriptionService *WebhookSubscriptionService,
+	locationService *StoreLocationService,
+	oauth *shopify.OAuthConfig,
+) *ShopifyInstallService {
+	return &ShopifyInstallService{
+		InstallRepo:                installRepo,
+		StoreRepo:                  storeRepo,
+		StoreService:               storeService,
+		WebhookSubscriptionService: webhookSubscriptionService,
+		LocationService:            locationService,
+		OAuth:                      oauth,
+	}
+}
+
+// NormalizeShopDomain turns a store stub or domain as typed by a user into its myshopify.com domain.
+func NormalizeShopDomain(shop string) string {
+	shop = strings.ToLower(strings.TrimSpace(shop))
+	shop = strings.TrimPrefix(shop, "https://")
+	shop = strings.TrimPrefix(shop, "http://")
+	shop = strings.TrimSuffix(shop, "/")
+	if !strings.Contains(shop, ".") {
+		shop += ".myshopify.com"
+	}
+	return shop
+}
+
+// BeginInstall starts an install of the app on a shop for the company. It returns the URL the
+// merchant approves the app at and a nonce to keep in a cookie of the merchant's browser, as
+// the callback is only accepted from the browser presenting it.
+func (s *ShopifyInstallService) BeginInstall(companyID, userID, shop string) (string, string, error) {
+	log := logger.GetLogger()
+
+	if s.OAuth.APIKey == "" || s.OAuth.APISecret == "" || s.OAuth.RedirectURL == "" {
+		return "", "", ErrShopifyAppNotConfigured
+	}
+	shopDomain := NormalizeShopDomain(shop)
+	if !shopify.ValidShopDomain(shopDomain) {
+		return "", "", ErrInvalidShopDomain
+	}
+
+	state, err := newInstallState()
+	if err != nil {
+		return "", "", err
+	}
+
+	install := &models.ShopifyInstall{
+		ID:         uuid.New(),
+		CompanyID:  uuid.MustParse(companyID),
+		UserID:     uuid.MustParse(userID),
+		ShopDomain: shopDomain,
+		State:      state,
+		ExpiresAt:  time.Now().Add(InstallStateTTL),
+	}
+	if err := s.InstallRepo.CreateInstall(install); err != nil {
+		return "", "", err
+	}
+
+	if err := s.InstallRepo.DeleteExpiredInstalls(time.Now().Add(-24 * time.Hour)); err != nil {
+		log.Error("Failed to delete expired Shopify installs: %v", err)
+	}
+
+	log.Info("Started Shopify install %s of %s for company %s", install.ID, shopDomain, companyID)
+	return s.OAuth.AuthorizeURL(shopDomain, state), s.installNonce(state), nil
+}
+
+// CompleteInstall handles the OAuth callback of an install. It checks that the callback was
+// recently signed by Shopify, reached the browser holding the nonce of the install and belongs
+// to a pending install, exchanges the code for an access token and connects the store to the
+// company that started the install.
+func (s *ShopifyInstallService) CompleteInstall(ctx context.Context, query url.Values, nonce string) (*models.Store, error) {
+	log := logger.GetLogger()
+
+	if s.OAuth.APIKey == "" || s.OAuth.APISecret == "" {
+		return nil, ErrShopifyAppNotConfigured
+	}
+	shopDomain := query.Get("shop")
+	if !shopify.ValidShopDomain(shopDomain) || !s.OAuth.ValidCallback(query) {
+		log.Error("Rejected Shopify install callback for shop %q with an invalid signature or domain", shopDomain)
+		return nil, ErrInvalidInstallCallback
+	}
+	if !freshCallback(query, time.Now()) {
+		log.Error("Rejected Shopify install callback for shop %s with a missing or stale timestamp", shopDomain)
+		return nil, ErrInvalidInstallCallback
+	}
+	// Checked before claiming, so a callback forwarded to another browser cannot use up the install
+	if !hmac.Equal([]byte(nonce), []byte(s.installNonce(query.Get("state")))) {
+		log.Error("Rejected Shopify install callback for shop %s from a browser that did not start the install", shopDomain)
+		return nil, ErrInvalidInstallCallback
+	}
+
+	install, err := s.InstallRepo.ClaimInstall(query.Get("state"), shopDomain, time.Now())
+	if errors.Is(err, repositories.ErrInstallNotFound) {
+		log.Error("Rejected Shopify install callback for shop %s with an unknown or expired state", shopDomain)
+		return nil, ErrInvalidInstallCallback
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.OAuth.ExchangeCode(ctx, shopDomain, query.Get("code"))
+	if err != nil {
+		log.Error("Failed to exchange the Shopify install code of %s: %v", shopDomain, err)
+		return nil, fmt.Errorf("failed to obtain an access token from %s: %w", shopDomain, err)
+	}
+	if missing := s.OAuth.MissingScopes(token); len(missing) > 0 {
+		log.Error("Shopify install of %s is missing scopes %v", shopDomain, missing)
+		return nil, fmt.Errorf("%w: %s", ErrMissingScopes, strings.Join(missing, ", "))
+	}
+
+	store, err := s.connectStore(ctx, install, token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.InstallRepo.SetInstallStore(install.ID, store.ID); err != nil {
+		log.Error("Failed to record store of Shopify install %s: %v", install.ID, err)
+	}
+
+	// Webhooks registered by an earlier install of the app are repaired rather than duplicated
+	if _, err := s.WebhookSubscriptionService.SyncStore(ctx, store, true); err != nil {
+		log.Error("Failed to subscribe store %s to webhooks: %v", store.ShopifyStoreStub, err)
+	}
+
+	log.Info("Completed Shopify install %s, store %s is connected to company %s", install.ID, store.ShopifyStoreStub, store.CompanyID)
+	return store, nil
+}
+
+// connectStore creates the installed store, or updates its credentials if the company had
+// already connected it, and records the store's locations.
+func (s *ShopifyInstallService) connectStore(ctx context.Context, install *models.ShopifyInstall, accessToken string) (*models.Store, error) {
+	stub := shopify.ShopStub(install.ShopDomain)
+	client := shopify.NewShopifyClient(accessToken, stub)
+
+	existing, err := s.StoreRepo.GetStoreByShopifyStub(stub)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.CompanyID != install.CompanyID {
+		return nil, ErrStoreOwnedElsewhere
+	}
+
+	// Locations that cannot be read now can be discovered by the user later
+	locations, err := client.ListLocations(ctx)
+	if err != nil {
+		logger.GetLogger().Error("Failed to discover locations of store %s: %v", client.Shop, err)
+	}
+
+	store, err := s.saveStore(install, existing, stub, accessToken, locations)
+	if err != nil {
+		return nil, err
+	}
+	if len(locations) > 0 {
+		if err := s.LocationService.SaveLocations(store, locations); err != nil {
+			logger.GetLogger().Error("Failed to record locations of store %s: %v", store.ShopifyStoreStub, err)
+		}
+	}
+	return store, nil
+}
+
+// saveStore creates the installed store or updates the one already connected.
+func (s *ShopifyInstallService) saveStore(install *models.ShopifyInstall, existing *models.Store, stub, accessToken string, locations []shopify.Location) (*models.Store, error) {
+	if existing != nil {
+		existing.AccessToken = accessToken
+		// Webhooks registered by the app are signed with its secret
+		existing.WebhookSignature = s.OAuth.APISecret
+		if existing.LocationID == "" {
+			existing.LocationID = primaryLocation(stub, locations)
+		}
+		if err := s.StoreRepo.UpdateStore(existing); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	return s.StoreService.CreateStore(install.CompanyID.String(), stub, accessToken, s.OAuth.APISecret,
+		primaryLocation(stub, locations), "")
+}
+
+// primaryLocation returns the primary location of a newly connected store: the first active
+// location that fulfills online orders, or else the first active location. It returns an empty
+// ID if there is none, to be set by the user later.
+func primaryLocation(stub string, locations []shopify.Location) string {
+	var fallback string
+	for _, location := range locations {
+		if !location.IsActive {
+			continue
+		}
+		if location.FulfillsOnlineOrders {
+			return strconv.FormatInt(location.ID, 10)
+		}
+		if fallback == "" {
+			fallback = strconv.FormatInt(location.ID, 10)
+		}
+	}
+	if fallback == "" {
+		logger.GetLogger().Error("Store %s has no active location", stub)
+	}
+	return fallback
+}
+
+// installNonce returns the nonce of an install state: the state signed with the app's secret,
+// so only the API can issue nonces.
+func (s *ShopifyInstallService) installNonce(state string) string {
+	mac := hmac.New(sha256.New, []byte(s.OAuth.APISecret))
+	mac.Write([]byte("install:" + state))
+	return state + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// freshCallback reports whether the timestamp of a callback is within installCallbackMaxAge of now.
+func freshCallback(query url.Values, now time.Time) bool {
+	timestamp, err := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	return age < installCallbackMaxAge && age > -installCallbackMaxAge
+}
+
+// newInstallState returns a random, unguessable OAuth state.
+func newInstallState() (string, error) {
+	state := make([]byte, 32)
+	if _, err := rand.Read(state); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(state), nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/shopify"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const installTestShop = "install-test.myshopify.com"
+
+type installEnv struct {
+	db             *gorm.DB
+	installService *ShopifyInstallService
+}
+
+// newInstallEnv returns an install service whose code exchanges are rejected by a fake Shopify,
+// so a callback that is accepted stops right after claiming its install.
+func newInstallEnv(t *testing.T) *installEnv {
+	t.Helper()
+
+	db := openTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+	}))
+	previous := shopify.OAuthURL
+	shopify.OAuthURL = func(shopDomain string) string { return server.URL + "/" + shopDomain + "/admin/oauth" }
+	t.Cleanup(func() {
+		shopify.OAuthURL = previous
+		server.Close()
+	})
+
+	oauth := &shopify.OAuthConfig{APIKey: "key", APISecret: "secret", RedirectURL: "https://api.example.com/shopify/callback"}
+	return &installEnv{
+		db:             db,
+		installService: NewShopifyInstallService(repositories.NewShopifyInstallRepository(db), nil, nil, nil, nil, oauth),
+	}
+}
+
+// begin starts an install and returns its state and nonce.
+func (env *installEnv) begin(t *testing.T) (string, string) {
+	t.Helper()
+
+	authorizeURL, nonce, err := env.installService.BeginInstall(uuid.New().String(), uuid.New().String(), installTestShop)
+	if err != nil {
+		t.Fatalf("BeginInstall failed: %v", err)
+	}
+	parsed, err := url.Parse(authorizeURL)
+	if err != nil {
+		t.Fatalf("invalid authorize URL %s: %v", authorizeURL, err)
+	}
+	return parsed.Query().Get("state"), nonce
+}
+
+// callback returns the query Shopify signs a callback of an install with at the given time.
+func (env *installEnv) callback(state string, at time.Time) url.Values {
+	query := url.Values{
+		"code":      {"code"},
+		"shop":      {installTestShop},
+		"state":     {state},
+		"timestamp": {strconv.FormatInt(at.Unix(), 10)},
+	}
+	mac := hmac.New(sha256.New, []byte(env.installService.OAuth.APISecret))
+	mac.Write([]byte(query.Encode()))
+	query.Set("hmac", hex.EncodeToString(mac.Sum(nil)))
+	return query
+}
+
+func TestShopifyInstallCallback(t *testing.T) {
+	env := newInstallEnv(t)
+	ctx := context.Background()
+	state, nonce := env.begin(t)
+	_, otherNonce := env.begin(t)
+
+	rejected := []struct {
+		name  string
+		query url.Values
+		nonce string
+	}{
+		{name: "without a nonce", query: env.callback(state, time.Now())},
+		{name: "with the nonce of another install", query: env.callback(state, time.Now()), nonce: otherNonce},
+		{name: "with a forged nonce", query: env.callback(state, time.Now()), nonce: state + ".00"},
+		{name: "with a stale timestamp", query: env.callback(state, time.Now().Add(-time.Hour)), nonce: nonce},
+	}
+	for _, tt := range rejected {
+		if _, err := env.installService.CompleteInstall(ctx, tt.query, tt.nonce); !errors.Is(err, ErrInvalidInstallCallback) {
+			t.Fatalf("expected a callback %s to be rejected, got %v", tt.name, err)
+		}
+	}
+
+	// The rejected callbacks leave the install to its browser, which gets as far as exchanging the code
+	_, err := env.installService.CompleteInstall(ctx, env.callback(state, time.Now()), nonce)
+	if err == nil || errors.Is(err, ErrInvalidInstallCallback) {
+		t.Fatalf("expected the callback to be accepted and the code exchange to fail, got %v", err)
+	}
+
+	if _, err := env.installService.CompleteInstall(ctx, env.callback(state, time.Now()), nonce); !errors.Is(err, ErrInvalidInstallCallback) {
+		t.Fatalf("expected a replayed callback to be rejected, got %v", err)
+	}
+}
+
+func TestShopifyInstallExpires(t *testing.T) {
+	env := newInstallEnv(t)
+	state, nonce := env.begin(t)
+
+	err := env.db.Model(&models.ShopifyInstall{}).Where("state = ?", state).Update("expires_at", time.Now().Add(-time.Minute)).Error
+	if err != nil {
+		t.Fatalf("failed to expire install: %v", err)
+	}
+
+	if _, err := env.installService.CompleteInstall(context.Background(), env.callback(state, time.Now()), nonce); !errors.Is(err, ErrInvalidInstallCallback) {
+		t.Fatalf("expected the callback of an expired install to be rejected, got %v", err)
+	}
+}
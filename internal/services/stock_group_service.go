@@ -40,12 +40,14 @@ func (s *StockGroupService) GetStockGroupsByCompany(companyID string) ([]models.
 	return s.StockGroupRepo.GetStockGroupsByCompany(companyID)
 }
 
-func (s *StockGroupService) GetStockGroupByID(stockGroupID string) (*models.StockGroup, error) {
-	return s.StockGroupRepo.GetStockGroupByID(stockGroupID)
+// GetStockGroupByID returns a stock group owned by the company, or ErrStockGroupNotFound.
+func (s *StockGroupService) GetStockGroupByID(companyID, stockGroupID string) (*models.StockGroup, error) {
+	return s.StockGroupRepo.GetCompanyStockGroup(companyID, stockGroupID)
 }
 
-func (s *StockGroupService) UpdateStockGroup(stockGroupID, name string) (*models.StockGroup, error) {
-	stockGroup, err := s.StockGroupRepo.GetStockGroupByID(stockGroupID)
+// UpdateStockGroup renames a stock group owned by the company, or returns ErrStockGroupNotFound.
+func (s *StockGroupService) UpdateStockGroup(companyID, stockGroupID, name string) (*models.StockGroup, error) {
+	stockGroup, err := s.StockGroupRepo.GetCompanyStockGroup(companyID, stockGroupID)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +61,7 @@ func (s *StockGroupService) UpdateStockGroup(stockGroupID, name string) (*models
 	return stockGroup, nil
 }
 
-func (s *StockGroupService) DeleteStockGroup(stockGroupID string) error {
-	return s.StockGroupRepo.DeleteStockGroup(stockGroupID)
+// DeleteStockGroup removes a stock group owned by the company, or returns ErrStockGroupNotFound.
+func (s *StockGroupService) DeleteStockGroup(companyID, stockGroupID string) error {
+	return s.StockGroupRepo.DeleteStockGroup(companyID, stockGroupID)
 }
@@ -1,7 +1,6 @@
 package services
 
 import (
-	"errors"
 	"gostockly/internal/repositories"
 
 	"github.com/google/uuid"
@@ -25,18 +24,17 @@ func NewStockGroupStoreService(
 	}
 }
 
-// AddStoreToStockGroup adds a store to a stock group.
-func (s *StockGroupStoreService) AddStoreToStockGroup(stockGroupID, storeID uuid.UUID) error {
-	// Ensure the stock group exists
-	stockGroup, err := s.StockGroupRepo.GetStockGroupsByCompany(stockGroupID.String())
-	if err != nil || stockGroup == nil {
-		return errors.New("stock group not found")
-	}
+// ErrStoreAlreadyGrouped is returned when adding a store that is already part of a stock group.
+var ErrStoreAlreadyGrouped = repositories.ErrStoreAlreadyGrouped
 
-	// Ensure the store exists
-	_, err = s.StoreRepo.GetStoreByID(storeID.String())
-	if err != nil {
-		return errors.New("store not found")
+// AddStoreToStockGroup adds a store to a stock group, both of which must be owned by the company.
+func (s *StockGroupStoreService) AddStoreToStockGroup(companyID string, stockGroupID, storeID uuid.UUID) error {
+	// Ensure the stock group and the store exist and belong to the company
+	if _, err := s.StockGroupRepo.GetCompanyStockGroup(companyID, stockGroupID.String()); err != nil {
+		return err
+	}
+	if _, err := s.StoreRepo.GetCompanyStore(companyID, storeID.String()); err != nil {
+		return err
 	}
 
 	// Add the store to the stock group
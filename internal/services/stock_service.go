@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+
+	"github.com/google/uuid"
+)
+
+// JobTypeStockPush sets every store of a stock group to the canonical level of a SKU.
+const JobTypeStockPush = "stock.push"
+
+var (
+	ErrStockGroupNotFound = repositories.ErrStockGroupNotFound
+	ErrNoStockLevel       = errors.New("SKU has no stock level yet, set a quantity first")
+)
+
+// StockPushPayload is the job payload for JobTypeStockPush.
+type StockPushPayload struct {
+	StockGroupID uuid.UUID `json:"stock_group_id"`
+	SKU          string    `json:"sku"`
+	MovementID   uuid.UUID `json:"movement_id"`
+	// SkipStoreID is a store that is already at the canonical level, such as the store a change came from
+	SkipStoreID uuid.UUID `json:"skip_store_id"`
+	// LocationID is the location of SkipStoreID the change happened at, if known. The other
+	// stores make up the difference at their location of the same name.
+	LocationID string `json:"location_id,omitempty"`
+}
+
+type StockService struct {
+	LedgerRepo          *repositories.StockLedgerRepository
+	StockGroupRepo      *repositories.StockGroupRepository
+	StockGroupStoreRepo *repositories.StockGroupStoreRepository
+	InventoryRepo       *repositories.InventoryRepository
+	AdjustmentService   *AdjustmentService
+	LocationService     *StoreLocationService
+	JobService          *JobService
+}
+
+func NewStockService(
+	ledgerRepo *repositories.StockLedgerRepository,
+	stockGroupRepo *repositories.StockGroupRepository,
+	stockGroupStoreRepo *repositories.StockGroupStoreRepository,
+	inventoryRepo *repositories.InventoryRepository,
+	adjustmentService *AdjustmentService,
+	locationService *StoreLocationService,
+	jobService *JobService,
+) *StockService {
+	s := &StockService{
+		LedgerRepo:          ledgerRepo,
+		StockGroupRepo:      stockGroupRepo,
+		StockGroupStoreRepo: stockGroupStoreRepo,
+		InventoryRepo:       inventoryRepo,
+		AdjustmentService:   adjustmentService,
+		LocationService:     locationService,
+		JobService:          jobService,
+	}
+
+	jobService.RegisterHandler(JobTypeStockPush, s.ProcessStockPushJob)
+
+	return s
+}
+
+// RecordWebhookDelta records a change reported by a webhook from sourceStore in the ledger.
+// The change was made at locationID, or at the store's primary location if it is empty, whose
+// known level moves with it so the location's inventory_levels/update for the change is ignored.
+// If the SKU has no canonical level yet, it is seeded from the source store's current level,
+// which already includes the change, and the returned movement has reason StockMovementSeed.
+// Recording the same webhook again returns the movement recorded the first time.
+func (s *StockService) RecordWebhookDelta(ctx context.Context, stockGroupID uuid.UUID, sourceStore *models.Store, sku, locationID string, delta int, reason, webhookID string) (*models.StockMovement, error) {
+	existing, err := s.LedgerRepo.GetMovementByWebhook(stockGroupID, sku, webhookID)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	movement := &models.StockMovement{
+		ID:           uuid.New(),
+		StockGroupID: stockGroupID,
+		SKU:          sku,
+		Delta:        delta,
+		Reason:       reason,
+		StoreID:      &sourceStore.ID,
+		WebhookID:    webhookID,
+	}
+
+	err = s.applyStoreDelta(sourceStore, locationID, movement)
+	if !errors.Is(err, repositories.ErrStockLevelNotFound) {
+		return movement, err
+	}
+
+	created, err := s.seedStockLevel(ctx, sourceStore, movement, reason)
+	if err != nil || created {
+		return movement, err
+	}
+
+	// Someone else seeded the level in the meantime, apply the change on top of it
+	movement.Reason = reason
+	movement.Delta = delta
+	movement.Note = ""
+	return movement, s.applyStoreDelta(sourceStore, locationID, movement)
+}
+
+// applyStoreDelta applies a change sourceStore made at locationID to the ledger together with the
+// known level of the location. The level the change leaves the location at is remembered like an
+// echo, so its report is ignored even after later writes have moved the known level on.
+func (s *StockService) applyStoreDelta(sourceStore *models.Store, locationID string, movement *models.StockMovement) error {
+	inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(movement.SKU, sourceStore.ID)
+	if err != nil {
+		// Without the store's inventory item there is no level of it to keep track of
+		return s.LedgerRepo.ApplyDelta(movement)
+	}
+	if locationID == "" {
+		locationID = sourceStore.LocationID
+	}
+
+	at := &models.KnownInventoryLevel{
+		StoreID:         sourceStore.ID,
+		InventoryItemID: inventory.InventoryItemID,
+		LocationID:      locationID,
+	}
+	known, err := s.LedgerRepo.ApplyStoreDelta(movement, at)
+	if err != nil || !known {
+		return err
+	}
+	s.AdjustmentService.recordEcho(sourceStore, at.InventoryItemID, at.LocationID, at.Available)
+	return nil
+}
+
+// RecordWebhookLevel records the level an inventory_levels webhook from sourceStore reported for
+// one of its locations. The ledger changes by the difference from the level it already accounts
+// for at the location, never to the reported level itself, so a report of a change the ledger has
+// already recorded changes nothing. A location whose level is not known yet only has it
+// remembered, and the returned movement has no delta. If the SKU has no canonical level yet, it is
+// seeded from the source store's current level. Recording the same webhook again returns the
+// movement recorded the first time.
+func (s *StockService) RecordWebhookLevel(ctx context.Context, stockGroupID uuid.UUID, sourceStore *models.Store, sku string, reported *models.KnownInventoryLevel, webhookID string) (*models.StockMovement, error) {
+	existing, err := s.LedgerRepo.GetMovementByWebhook(stockGroupID, sku, webhookID)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	movement := &models.StockMovement{
+		ID:           uuid.New(),
+		StockGroupID: stockGroupID,
+		SKU:          sku,
+		Reason:       models.StockMovementInventoryLevel,
+		StoreID:      &sourceStore.ID,
+		WebhookID:    webhookID,
+	}
+
+	err = s.LedgerRepo.ApplyReportedLevel(movement, reported)
+	if !errors.Is(err, repositories.ErrStockLevelNotFound) {
+		return movement, err
+	}
+
+	created, err := s.seedStockLevel(ctx, sourceStore, movement, models.StockMovementInventoryLevel)
+	if err != nil || created {
+		return movement, err
+	}
+
+	// Someone else seeded the level in the meantime, apply the report on top of it
+	movement.Reason = models.StockMovementInventoryLevel
+	movement.Delta = 0
+	movement.Note = ""
+	return movement, s.LedgerRepo.ApplyReportedLevel(movement, reported)
+}
+
+// seedStockLevel creates the canonical level of movement.SKU from sourceStore's current level and
+// records it with the movement. The location levels it was read from become known, as the ledger
+// now accounts for them. It returns false if someone else seeded the level first.
+func (s *StockService) seedStockLevel(ctx context.Context, sourceStore *models.Store, movement *models.StockMovement, reason string) (bool, error) {
+	log := logger.GetLogger()
+
+	quantity, levels, err := s.fetchStoreQuantity(ctx, sourceStore, movement.SKU)
+	if err != nil {
+		return false, fmt.Errorf("failed to seed stock level for SKU %s: %w", movement.SKU, err)
+	}
+
+	movement.Reason = models.StockMovementSeed
+	movement.QuantityAfter = quantity
+	movement.Note = fmt.Sprintf("seeded from %s while applying %s", sourceStore.ShopifyStoreStub, reason)
+	created, err := s.LedgerRepo.SeedStockLevel(movement)
+	if err != nil || !created {
+		return false, err
+	}
+	log.Info("Seeded stock level of SKU %s in stock group %s at %d", movement.SKU, movement.StockGroupID, quantity)
+
+	for i := range levels {
+		if err := s.LedgerRepo.SetKnownLevel(&levels[i]); err != nil {
+			log.Error("Failed to record known level of SKU %s at location %s of store %s: %v",
+				movement.SKU, levels[i].LocationID, sourceStore.ShopifyStoreStub, err)
+		}
+	}
+	return true, nil
+}
+
+// QueuePush queues a job that sets every store of the stock group, except sourceStoreID, to the
+// canonical level of a SKU. sourceLocationID is the location of the source store the change
+// happened at, if known.
+func (s *StockService) QueuePush(stockGroupID uuid.UUID, sku string, movementID, sourceStoreID uuid.UUID, sourceLocationID string) error {
+	_, err := s.JobService.Enqueue(JobTypeStockPush, StockPushPayload{
+		StockGroupID: stockGroupID,
+		SKU:          sku,
+		MovementID:   movementID,
+		SkipStoreID:  sourceStoreID,
+		LocationID:   sourceLocationID,
+	})
+	return err
+}
+
+// ProcessStockPushJob is the job handler that sets every store to the canonical level of a SKU.
+// The level is read when the job runs, so a late push never overwrites a newer level, and
+// stores already at the level are left alone, so running the job again changes nothing.
+func (s *StockService) ProcessStockPushJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	var payload StockPushPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+
+	level, err := s.LedgerRepo.GetStockLevel(payload.StockGroupID, payload.SKU)
+	if err != nil {
+		return err
+	}
+
+	stores, err := s.StockGroupStoreRepo.GetStoresByStockGroup(payload.StockGroupID)
+	if err != nil {
+		return err
+	}
+	routing, err := s.LocationService.routing(stores)
+	if err != nil {
+		return err
+	}
+	var sourceStore *models.Store
+	for i := range stores {
+		if stores[i].ID == payload.SkipStoreID {
+			sourceStore = &stores[i]
+		}
+	}
+
+	failed := 0
+	for _, store := range stores {
+		if store.ID == payload.SkipStoreID {
+			continue
+		}
+
+		inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(payload.SKU, store.ID)
+		if err != nil {
+			log.Debug("Failed to find inventory for SKU %s in store %s: %v", payload.SKU, store.ID, err)
+			continue
+		}
+
+		locationID := store.LocationID
+		if sourceStore != nil {
+			locationID = routing.target(sourceStore, payload.LocationID, &store)
+		}
+
+		log.Info("Setting available quantity of SKU %s to %d in store %s", payload.SKU, level.Quantity, store.ShopifyStoreStub)
+		errs, err := s.AdjustmentService.SyncLevels(ctx, newStoreClient(&store), &store, routing.synced(&store), []LevelChange{{
+			SKU:             payload.SKU,
+			InventoryItemID: inventory.InventoryItemID,
+			LocationID:      locationID,
+			Quantity:        level.Quantity,
+		}}, movementReference(payload.MovementID))
+		if err == nil {
+			err = errs[0]
+		}
+		if err != nil {
+			log.Error("Failed to set inventory for SKU %s in store %s: %v", payload.SKU, store.ShopifyStoreStub, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d inventory updates failed", failed)
+	}
+	return nil
+}
+
+// GetStockLevels returns the canonical levels of a stock group owned by the company.
+func (s *StockService) GetStockLevels(companyID, stockGroupID string) ([]models.StockLevel, error) {
+	stockGroup, err := s.getCompanyStockGroup(companyID, stockGroupID)
+	if err != nil {
+		return nil, err
+	}
+	return s.LedgerRepo.GetStockLevelsByStockGroup(stockGroup.ID)
+}
+
+// GetMovements returns the most recent ledger movements of a SKU in a stock group owned by the company.
+func (s *StockService) GetMovements(companyID, stockGroupID, sku string, limit int) ([]models.StockMovement, error) {
+	stockGroup, err := s.getCompanyStockGroup(companyID, stockGroupID)
+	if err != nil {
+		return nil, err
+	}
+	return s.LedgerRepo.GetMovements(stockGroup.ID, sku, limit)
+}
+
+// RecordManualChange changes the canonical level of a SKU on behalf of a user, either by delta
+// or to an absolute quantity, and pushes the new level to every store in the group.
+func (s *StockService) RecordManualChange(companyID, userID, stockGroupID, sku string, delta, quantity *int, note string) (*models.StockMovement, error) {
+	if (delta == nil) == (quantity == nil) {
+		return nil, errors.New("exactly one of delta or quantity is required")
+	}
+
+	stockGroup, err := s.getCompanyStockGroup(companyID, stockGroupID)
+	if err != nil {
+		return nil, err
+	}
+
+	movement := &models.StockMovement{
+		ID:           uuid.New(),
+		StockGroupID: stockGroup.ID,
+		SKU:          sku,
+		Reason:       models.StockMovementManual,
+		Note:         note,
+	}
+	if userUUID, err := uuid.Parse(userID); err == nil {
+		movement.UserID = &userUUID
+	}
+
+	if delta != nil {
+		movement.Delta = *delta
+		err = s.LedgerRepo.ApplyDelta(movement)
+		if errors.Is(err, repositories.ErrStockLevelNotFound) {
+			return nil, ErrNoStockLevel
+		}
+	} else {
+		movement.QuantityAfter = *quantity
+		err = s.LedgerRepo.SetQuantity(movement)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.QueuePush(stockGroup.ID, sku, movement.ID, uuid.Nil, ""); err != nil {
+		return movement, fmt.Errorf("recorded the change but failed to queue the push to stores: %w", err)
+	}
+	return movement, nil
+}
+
+// getCompanyStockGroup loads a stock group, treating groups of other companies as not found.
+func (s *StockService) getCompanyStockGroup(companyID, stockGroupID string) (*models.StockGroup, error) {
+	return s.StockGroupRepo.GetCompanyStockGroup(companyID, stockGroupID)
+}
+
+// fetchStoreQuantity reads the level of a SKU in a store from Shopify: its available quantity
+// summed over the store's synced locations. The quantities it was summed from are returned too,
+// one for each location the SKU is stocked at.
+func (s *StockService) fetchStoreQuantity(ctx context.Context, store *models.Store, sku string) (int, []models.KnownInventoryLevel, error) {
+	inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(sku, store.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	routing, err := s.LocationService.routing([]models.Store{*store})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	itemID := inventory.InventoryItemID
+	current, err := readLevels(ctx, newStoreClient(store), store, routing.synced(store), []string{itemID})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	quantity := 0
+	var levels []models.KnownInventoryLevel
+	for _, locationID := range routing.synced(store) {
+		available, ok := current[locationID][itemID]
+		if !ok {
+			continue
+		}
+		quantity += available
+		levels = append(levels, models.KnownInventoryLevel{
+			StoreID:         store.ID,
+			InventoryItemID: itemID,
+			LocationID:      locationID,
+			Available:       available,
+		})
+	}
+	if len(levels) == 0 {
+		return 0, nil, fmt.Errorf("inventory item %s is not stocked at any synced location of store %s: %w",
+			itemID, store.ShopifyStoreStub, shopify.ErrNotStocked)
+	}
+	return quantity, levels, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+func TestStockLedger(t *testing.T) {
+	env := newIntegrationEnv(t)
+	const sku = "CUP-WHITE"
+	movement := func() *models.StockMovement {
+		return &models.StockMovement{ID: uuid.New(), StockGroupID: env.stockGroup.ID, SKU: sku, Reason: models.StockMovementManual}
+	}
+
+	delta := movement()
+	delta.Delta = -1
+	if err := env.ledgerRepo.ApplyDelta(delta); !errors.Is(err, repositories.ErrStockLevelNotFound) {
+		t.Fatalf("expected ErrStockLevelNotFound without a level, got %v", err)
+	}
+
+	seed := movement()
+	seed.Reason = models.StockMovementSeed
+	seed.QuantityAfter = 10
+	if created, err := env.ledgerRepo.SeedStockLevel(seed); err != nil || !created {
+		t.Fatalf("expected the level to be seeded, got %t (%v)", created, err)
+	}
+	if seed.Delta != 10 {
+		t.Fatalf("expected the seed to record a delta of 10, got %d", seed.Delta)
+	}
+	again := movement()
+	again.QuantityAfter = 50
+	if created, err := env.ledgerRepo.SeedStockLevel(again); err != nil || created {
+		t.Fatalf("expected an existing level not to be seeded again, got %t (%v)", created, err)
+	}
+	env.assertStockLevel(t, sku, 10)
+
+	// Concurrent changes are applied one after the other
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delta := movement()
+			delta.Delta = -1
+			if err := env.ledgerRepo.ApplyDelta(delta); err != nil {
+				t.Errorf("ApplyDelta failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	env.assertStockLevel(t, sku, 5)
+
+	set := movement()
+	set.QuantityAfter = 12
+	if err := env.ledgerRepo.SetQuantity(set); err != nil {
+		t.Fatalf("SetQuantity failed: %v", err)
+	}
+	if set.Delta != 7 {
+		t.Fatalf("expected SetQuantity to record a delta of 7, got %d", set.Delta)
+	}
+	env.assertStockLevel(t, sku, 12)
+
+	movements, err := env.ledgerRepo.GetMovements(env.stockGroup.ID, sku, 100)
+	if err != nil {
+		t.Fatalf("GetMovements failed: %v", err)
+	}
+	if len(movements) != 7 || movements[0].ID != set.ID || movements[0].QuantityAfter != 12 {
+		t.Fatalf("expected 7 movements, newest first, got %+v", movements)
+	}
+}
+
+func TestRecordWebhookDelta(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	source := env.addStore(t)
+	const sku = "CUP-BLACK"
+	// Shopify has already taken the change off the source store's stock
+	item := env.stock(t, source, sku, 8)
+
+	first, err := env.stockService.RecordWebhookDelta(ctx, env.stockGroup.ID, source.store, sku, "", -2, models.StockMovementOrder, "webhook-1")
+	if err != nil {
+		t.Fatalf("RecordWebhookDelta failed: %v", err)
+	}
+	if first.Reason != models.StockMovementSeed || first.QuantityAfter != 8 {
+		t.Fatalf("expected the level to be seeded from the source store, got %+v", first)
+	}
+
+	again, err := env.stockService.RecordWebhookDelta(ctx, env.stockGroup.ID, source.store, sku, "", -2, models.StockMovementOrder, "webhook-1")
+	if err != nil || again.ID != first.ID {
+		t.Fatalf("expected the same webhook to return its movement, got %+v (%v)", again, err)
+	}
+
+	next, err := env.stockService.RecordWebhookDelta(ctx, env.stockGroup.ID, source.store, sku, "", -3, models.StockMovementOrder, "webhook-2")
+	if err != nil {
+		t.Fatalf("RecordWebhookDelta failed: %v", err)
+	}
+	if next.Reason != models.StockMovementOrder || next.QuantityAfter != 5 {
+		t.Fatalf("expected the change to be applied to the level, got %+v", next)
+	}
+	env.assertStockLevel(t, sku, 5)
+
+	// The seed made the store's level known, and the change moved it along
+	known, err := env.ledgerRepo.GetKnownLevel(source.store.ID, strconv.FormatInt(item, 10), source.store.LocationID)
+	if err != nil || known == nil || known.Available != 5 {
+		t.Fatalf("expected the known level to follow the change to 5, got %+v (%v)", known, err)
+	}
+}
+
+func TestRecordManualChangePushesLevel(t *testing.T) {
+	env := newIntegrationEnv(t)
+	companyID, stockGroupID := env.company.ID.String(), env.stockGroup.ID.String()
+	first, second := env.addStore(t), env.addStore(t)
+	const sku = "CUP-RED"
+	firstItem := env.stock(t, first, sku, 4)
+	secondItem := env.stock(t, second, sku, 6)
+
+	delta := -1
+	if _, err := env.stockService.RecordManualChange(companyID, "", stockGroupID, sku, &delta, nil, ""); !errors.Is(err, ErrNoStockLevel) {
+		t.Fatalf("expected ErrNoStockLevel changing a SKU without a level, got %v", err)
+	}
+
+	quantity := 9
+	movement, err := env.stockService.RecordManualChange(companyID, "", stockGroupID, sku, nil, &quantity, "stock count")
+	if err != nil {
+		t.Fatalf("RecordManualChange failed: %v", err)
+	}
+	job := env.pushJob(t, movement.ID)
+	for i := 0; i < 2; i++ {
+		if err := env.stockService.ProcessStockPushJob(context.Background(), job); err != nil {
+			t.Fatalf("ProcessStockPushJob failed: %v", err)
+		}
+		env.assertAvailable(t, first, firstItem, 9)
+		env.assertAvailable(t, second, secondItem, 9)
+	}
+}
+
+func TestStockPushSkipsSourceAndCountsSyncedLocations(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	source, target := env.addStore(t), env.addStore(t)
+	const sku = "CUP-GREEN"
+	sourceItem := env.stock(t, source, sku, 10)
+	targetItem := env.stock(t, target, sku, 10)
+
+	east := env.syncLocation(t, target, "East")
+	env.shopify.SetAvailable(target.store.ShopifyStoreStub, targetItem, east.ID, 3)
+
+	env.setStockLevel(t, sku, 7)
+	if err := env.stockService.QueuePush(env.stockGroup.ID, sku, uuid.New(), source.store.ID, ""); err != nil {
+		t.Fatalf("QueuePush failed: %v", err)
+	}
+	var job models.Job
+	if err := env.db.Where("type = ? AND payload::jsonb->>'sku' = ?", JobTypeStockPush, sku).First(&job).Error; err != nil {
+		t.Fatalf("failed to find the push job: %v", err)
+	}
+	if err := env.stockService.ProcessStockPushJob(ctx, &job); err != nil {
+		t.Fatalf("ProcessStockPushJob failed: %v", err)
+	}
+
+	// The target store's 13 across both locations is brought down to 7 at its primary location
+	env.assertAvailable(t, target, targetItem, 4)
+	if got, _ := env.shopify.Available(target.store.ShopifyStoreStub, targetItem, east.ID); got != 3 {
+		t.Fatalf("expected East to be left at 3, got %d", got)
+	}
+	env.assertAvailable(t, source, sourceItem, 10)
+}
+
+func TestIntegrationRefundSetsStoresToLevel(t *testing.T) {
+	env := newIntegrationEnv(t)
+	source, target := env.addStore(t), env.addStore(t)
+	const sku = "CUP-BLUE"
+	env.stock(t, source, sku, 11)
+	// The target store has drifted from the canonical level
+	targetItem := env.stock(t, target, sku, 9)
+	env.setStockLevel(t, sku, 10)
+
+	env.processWebhook(t, source, "refunds/create", JobTypeRefundWebhook, env.webhookService.ProcessRefundWebhook, map[string]interface{}{
+		"refund_line_items": []map[string]interface{}{
+			{"quantity": 1, "restock_type": "return", "line_item": map[string]interface{}{"sku": sku}},
+		},
+	})
+
+	env.assertStockLevel(t, sku, 11)
+	env.assertAvailable(t, target, targetItem, 11)
+}
+
+// pushJob finds the stock push job queued for a movement.
+func (env *integrationEnv) pushJob(t *testing.T, movementID uuid.UUID) *models.Job {
+	t.Helper()
+
+	var job models.Job
+	err := env.db.Where("type = ? AND payload::jsonb->>'movement_id' = ?", JobTypeStockPush, movementID.String()).First(&job).Error
+	if err != nil {
+		t.Fatalf("failed to find the push job for movement %s: %v", movementID, err)
+	}
+	return &job
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrStoreLocationNotFound = repositories.ErrStoreLocationNotFound
+	ErrPrimaryLocationSynced = errors.New("the primary location of a store is always synced")
+)
+
+// StoreLocationService keeps track of the Shopify locations of each store and which of them
+// take part in the stock group.
+type StoreLocationService struct {
+	StoreRepo    *repositories.StoreRepository
+	LocationRepo *repositories.StoreLocationRepository
+}
+
+func NewStoreLocationService(storeRepo *repositories.StoreRepository, locationRepo *repositories.StoreLocationRepository) *StoreLocationService {
+	return &StoreLocationService{StoreRepo: storeRepo, LocationRepo: locationRepo}
+}
+
+// GetStoreLocations returns the known locations of a store owned by the company.
+func (s *StoreLocationService) GetStoreLocations(companyID, storeID string) ([]models.StoreLocation, error) {
+	store, err := s.StoreRepo.GetCompanyStore(companyID, storeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.LocationRepo.GetLocationsByStore(store.ID)
+}
+
+// DiscoverLocations reads the locations of a store owned by the company from Shopify and
+// returns them.
+func (s *StoreLocationService) DiscoverLocations(ctx context.Context, companyID, storeID string) ([]models.StoreLocation, error) {
+	store, err := s.StoreRepo.GetCompanyStore(companyID, storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := newStoreClient(store).ListLocations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read locations of %s: %w", store.ShopifyStoreStub, err)
+	}
+	if err := s.SaveLocations(store, locations); err != nil {
+		return nil, err
+	}
+	return s.LocationRepo.GetLocationsByStore(store.ID)
+}
+
+// SaveLocations records the locations Shopify reported for a store. New locations are ignored
+// until the user marks them synced, except for the store's primary location.
+func (s *StoreLocationService) SaveLocations(store *models.Store, locations []shopify.Location) error {
+	discovered := make([]models.StoreLocation, 0, len(locations))
+	for _, location := range locations {
+		id := strconv.FormatInt(location.ID, 10)
+		discovered = append(discovered, models.StoreLocation{
+			ID:                   uuid.New(),
+			StoreID:              store.ID,
+			ShopifyLocationID:    id,
+			Name:                 location.Name,
+			Active:               location.IsActive,
+			FulfillsOnlineOrders: location.FulfillsOnlineOrders,
+			Synced:               id == store.LocationID,
+		})
+	}
+	if err := s.LocationRepo.SaveDiscoveredLocations(discovered); err != nil {
+		return err
+	}
+
+	// The primary location may have been changed since it was first discovered
+	if store.LocationID != "" {
+		err := s.LocationRepo.SetLocationSynced(store.ID, store.LocationID, true)
+		if err != nil && !errors.Is(err, repositories.ErrStoreLocationNotFound) {
+			return err
+		}
+	}
+
+	logger.GetLogger().Info("Discovered %d locations of store %s", len(locations), store.ShopifyStoreStub)
+	return nil
+}
+
+// SetLocationSynced marks a location of a store owned by the company as synced or ignored.
+// The primary location cannot be ignored, as stock levels are read and set there.
+func (s *StoreLocationService) SetLocationSynced(companyID, storeID, shopifyLocationID string, synced bool) error {
+	store, err := s.StoreRepo.GetCompanyStore(companyID, storeID)
+	if err != nil {
+		return err
+	}
+	if !synced && shopifyLocationID == store.LocationID {
+		return ErrPrimaryLocationSynced
+	}
+	if err := s.LocationRepo.SetLocationSynced(store.ID, shopifyLocationID, synced); err != nil {
+		return err
+	}
+
+	logger.GetLogger().Info("Location %s of store %s is now synced=%t", shopifyLocationID, store.ShopifyStoreStub, synced)
+	return nil
+}
+
+// locationRouting decides where stock changes are applied, from the locations of the stores in
+// the stock group.
+type locationRouting struct {
+	// byStore holds the locations of each store by Shopify location ID
+	byStore map[uuid.UUID]map[string]models.StoreLocation
+}
+
+func (s *StoreLocationService) routing(stores []models.Store) (*locationRouting, error) {
+	routing := &locationRouting{byStore: make(map[uuid.UUID]map[string]models.StoreLocation, len(stores))}
+	for _, store := range stores {
+		locations, err := s.LocationRepo.GetLocationsByStore(store.ID)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[string]models.StoreLocation, len(locations))
+		for _, location := range locations {
+			byID[location.ShopifyLocationID] = location
+		}
+		routing.byStore[store.ID] = byID
+	}
+	return routing, nil
+}
+
+// synced returns the locations of a store that take part in the stock group: its primary
+// location first, then the discovered locations marked synced, in order of their IDs. The level
+// of a SKU in a store is the total available across them. Until its locations are discovered a
+// store takes part with its primary location alone.
+func (r *locationRouting) synced(store *models.Store) []string {
+	locations := []string{store.LocationID}
+	if r == nil {
+		return locations
+	}
+	for id, location := range r.byStore[store.ID] {
+		if location.Synced && id != store.LocationID {
+			locations = append(locations, id)
+		}
+	}
+	sortLocationIDs(locations[1:])
+	return locations
+}
+
+// ignored reports whether a location of a store is not one of its synced locations, so changes
+// there leave the store's level alone. Changes without a location count as made at the primary
+// location.
+func (r *locationRouting) ignored(store *models.Store, locationID string) bool {
+	return locationID != "" && !slices.Contains(r.synced(store), locationID)
+}
+
+// target returns the location of targetStore that matches the location of sourceStore stock
+// left from. Locations are matched by name, ignoring case and surrounding spaces, as the stores
+// of a group are separate shops whose locations share nothing but their names: a change at the
+// source store's "East" location is made up at the target store's synced "East" location. If
+// several synced locations of the target store have the name, the primary location wins, and
+// else the one with the lowest ID, so the same change always lands at the same location. Without
+// a matching location, or without routing, changes are made up at the primary location.
+func (r *locationRouting) target(sourceStore *models.Store, sourceLocationID string, targetStore *models.Store) string {
+	if r == nil {
+		return targetStore.LocationID
+	}
+	source, ok := r.byStore[sourceStore.ID][sourceLocationID]
+	if !ok {
+		return targetStore.LocationID
+	}
+	for _, id := range r.synced(targetStore) {
+		location, ok := r.byStore[targetStore.ID][id]
+		if ok && strings.EqualFold(strings.TrimSpace(location.Name), strings.TrimSpace(source.Name)) {
+			return id
+		}
+	}
+	return targetStore.LocationID
+}
+
+// sortLocationIDs sorts numeric Shopify location IDs in ascending order.
+func sortLocationIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+}
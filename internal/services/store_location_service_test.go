@@ -0,0 +1,73 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"gostockly/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// testRouting returns the routing of stores with the given locations, each of which is synced.
+func testRouting(locations map[*models.Store]map[string]string) *locationRouting {
+	routing := &locationRouting{byStore: make(map[uuid.UUID]map[string]models.StoreLocation)}
+	for store, names := range locations {
+		byID := make(map[string]models.StoreLocation, len(names))
+		for id, name := range names {
+			byID[id] = models.StoreLocation{StoreID: store.ID, ShopifyLocationID: id, Name: name, Synced: true}
+		}
+		routing.byStore[store.ID] = byID
+	}
+	return routing
+}
+
+func TestLocationRoutingTarget(t *testing.T) {
+	source := &models.Store{ID: uuid.New(), LocationID: "1"}
+	target := &models.Store{ID: uuid.New(), LocationID: "10"}
+	routing := testRouting(map[*models.Store]map[string]string{
+		source: {"1": "Main", "2": "East", "3": "West"},
+		// Two synced locations of the target store share the name East
+		target: {"10": "Warehouse", "100": "East", "20": " east ", "30": "North"},
+	})
+
+	// The location with the lowest ID wins, however the map is iterated
+	for i := 0; i < 20; i++ {
+		if got := routing.target(source, "2", target); got != "20" {
+			t.Fatalf("expected East to be routed to location 20, got %s", got)
+		}
+	}
+	if got := routing.target(source, "3", target); got != "10" {
+		t.Fatalf("expected a location without a match to be routed to the primary location, got %s", got)
+	}
+	if got := routing.target(source, "", target); got != "10" {
+		t.Fatalf("expected an unknown location to be routed to the primary location, got %s", got)
+	}
+
+	// The primary location wins a name it shares
+	routing.byStore[target.ID]["10"] = models.StoreLocation{ShopifyLocationID: "10", Name: "East", Synced: true}
+	if got := routing.target(source, "2", target); got != "10" {
+		t.Fatalf("expected East to be routed to the primary location, got %s", got)
+	}
+}
+
+func TestLocationRoutingSynced(t *testing.T) {
+	store := &models.Store{ID: uuid.New(), LocationID: "5"}
+	routing := testRouting(map[*models.Store]map[string]string{store: {"5": "Main", "100": "East", "20": "West", "30": "Outlet"}})
+	routing.byStore[store.ID]["30"] = models.StoreLocation{ShopifyLocationID: "30", Name: "Outlet"}
+
+	if got, want := routing.synced(store), []string{"5", "20", "100"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected synced locations %v, got %v", want, got)
+	}
+	for locationID, want := range map[string]bool{"": false, "5": false, "100": false, "30": true, "999": true} {
+		if got := routing.ignored(store, locationID); got != want {
+			t.Fatalf("expected ignored(%q) to be %t, got %t", locationID, want, got)
+		}
+	}
+
+	// A store whose locations are not discovered yet takes part with its primary location alone
+	var none *locationRouting
+	if got, want := none.synced(store), []string{"5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected synced locations %v, got %v", want, got)
+	}
+}
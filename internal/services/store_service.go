@@ -1,28 +1,52 @@
 package services
 
 import (
+	"errors"
 	"gostockly/internal/models"
 	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrInvalidAPIVersion     = errors.New("invalid Shopify API version")
+	ErrStoreAlreadyConnected = errors.New("store is already connected")
+)
+
 type StoreService struct {
-	Repo *repositories.StoreRepository
+	Repo               *repositories.StoreRepository
+	CatalogSyncService *CatalogSyncService
 }
 
-func NewStoreService(repo *repositories.StoreRepository) *StoreService {
-	return &StoreService{Repo: repo}
+func NewStoreService(repo *repositories.StoreRepository, catalogSyncService *CatalogSyncService) *StoreService {
+	return &StoreService{Repo: repo, CatalogSyncService: catalogSyncService}
 }
 
-func (s *StoreService) CreateStore(companyID, shopifyStoreStub, accessToken, webhookSignature, locationID string) (*models.Store, error) {
+// newStoreClient creates a Shopify client for the store, using its API version override if it has one.
+func newStoreClient(store *models.Store) *shopify.ShopifyClient {
+	return shopify.NewShopifyClientWithVersion(store.AccessToken, store.ShopifyStoreStub, store.ShopifyAPIVersion)
+}
+
+func (s *StoreService) CreateStore(companyID, shopifyStoreStub, accessToken, webhookSignature, locationID, apiVersion string) (*models.Store, error) {
+	if apiVersion != "" && !shopify.ValidAPIVersion(apiVersion) {
+		return nil, ErrInvalidAPIVersion
+	}
+
+	if err := s.checkStubAvailable(companyID, uuid.Nil, shopifyStoreStub); err != nil {
+		return nil, err
+	}
+
 	store := &models.Store{
-		ID:               uuid.New(),
-		CompanyID:        uuid.MustParse(companyID),
-		ShopifyStoreStub: shopifyStoreStub,
-		AccessToken:      accessToken,
-		WebhookSignature: webhookSignature,
-		LocationID:       locationID,
+		ID:                uuid.New(),
+		CompanyID:         uuid.MustParse(companyID),
+		ShopifyStoreStub:  shopifyStoreStub,
+		AccessToken:       accessToken,
+		WebhookSignature:  webhookSignature,
+		LocationID:        locationID,
+		ShopifyAPIVersion: apiVersion,
 	}
 
 	err := s.Repo.CreateStore(store)
@@ -30,6 +54,11 @@ func (s *StoreService) CreateStore(companyID, shopifyStoreStub, accessToken, web
 		return nil, err
 	}
 
+	// Import the store's catalog so its SKUs are mapped before the first webhook arrives
+	if _, err := s.CatalogSyncService.QueueSync(store); err != nil {
+		logger.GetLogger().Error("Failed to queue catalog sync for new store %s: %v", store.ID, err)
+	}
+
 	return store, nil
 }
 
@@ -37,20 +66,42 @@ func (s *StoreService) GetStoresByCompany(companyID string) ([]models.Store, err
 	return s.Repo.GetStoresByCompany(companyID)
 }
 
-func (s *StoreService) GetStoreByID(storeID string) (*models.Store, error) {
-	return s.Repo.GetStoreByID(storeID)
+// GetStoreByID returns a store owned by the company, or ErrStoreNotFound.
+func (s *StoreService) GetStoreByID(companyID, storeID string) (*models.Store, error) {
+	return s.Repo.GetCompanyStore(companyID, storeID)
 }
 
-func (s *StoreService) UpdateStore(storeID, shopifyStoreStub, accessToken, webhookSignature, locationID string) (*models.Store, error) {
-	store, err := s.Repo.GetStoreByID(storeID)
+// UpdateStore updates a store owned by the company, or returns ErrStoreNotFound.
+func (s *StoreService) UpdateStore(companyID, storeID, shopifyStoreStub, accessToken, webhookSignature, locationID, apiVersion string) (*models.Store, error) {
+	if apiVersion != "" && !shopify.ValidAPIVersion(apiVersion) {
+		return nil, ErrInvalidAPIVersion
+	}
+
+	store, err := s.Repo.GetCompanyStore(companyID, storeID)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.checkStubAvailable(companyID, store.ID, shopifyStoreStub); err != nil {
+		return nil, err
+	}
+
+	// A deprecation reported for the old version says nothing about the new one
+	if apiVersion != store.ShopifyAPIVersion {
+		store.APIDeprecationReason = ""
+		store.APIDeprecationVersion = ""
+		store.APIDeprecatedAt = nil
+	}
 
 	store.ShopifyStoreStub = shopifyStoreStub
-	store.AccessToken = accessToken
-	store.WebhookSignature = webhookSignature
+	// Credentials are never returned to clients, so leaving them out keeps the stored ones
+	if accessToken != "" {
+		store.AccessToken = accessToken
+	}
+	if webhookSignature != "" {
+		store.WebhookSignature = webhookSignature
+	}
 	store.LocationID = locationID
+	store.ShopifyAPIVersion = apiVersion
 
 	if err := s.Repo.UpdateStore(store); err != nil {
 		return nil, err
@@ -59,6 +110,64 @@ func (s *StoreService) UpdateStore(storeID, shopifyStoreStub, accessToken, webho
 	return store, nil
 }
 
-func (s *StoreService) DeleteStore(storeID string) error {
-	return s.Repo.DeleteStore(storeID)
+// checkStubAvailable returns ErrStoreOwnedElsewhere if another company has connected the shop,
+// or ErrStoreAlreadyConnected if the company has connected it as a store other than storeID.
+func (s *StoreService) checkStubAvailable(companyID string, storeID uuid.UUID, shopifyStoreStub string) error {
+	existing, err := s.Repo.GetStoreByShopifyStub(shopifyStoreStub)
+	if err != nil || existing == nil || existing.ID == storeID {
+		return err
+	}
+	if existing.CompanyID.String() != companyID {
+		return ErrStoreOwnedElsewhere
+	}
+	return ErrStoreAlreadyConnected
+}
+
+// DeleteStore removes a store owned by the company, or returns ErrStoreNotFound.
+func (s *StoreService) DeleteStore(companyID, storeID string) error {
+	return s.Repo.DeleteStore(companyID, storeID)
+}
+
+// RecordAPIDeprecation stores a deprecation reported by Shopify for a request of the store.
+// It is registered with shopify.OnDeprecatedCall.
+func (s *StoreService) RecordAPIDeprecation(shop, apiVersion, reason string) {
+	if err := s.Repo.RecordAPIDeprecation(shop, apiVersion, reason, time.Now()); err != nil {
+		logger.GetLogger().Error("Failed to record API deprecation for store %s: %v", shop, err)
+	}
+}
+
+// StoreDeprecation describes the deprecated API usage last reported for a store.
+type StoreDeprecation struct {
+	StoreID           uuid.UUID `json:"store_id"`
+	Shop              string    `json:"shop"`
+	APIVersion        string    `json:"api_version"`
+	DeprecatedVersion string    `json:"deprecated_version"`
+	Reason            string    `json:"reason"`
+	LastSeenAt        time.Time `json:"last_seen_at"`
+}
+
+// GetDeprecatedStores returns the company's stores whose requests Shopify has flagged as deprecated,
+// along with the API version each store uses now.
+func (s *StoreService) GetDeprecatedStores(companyID string) ([]StoreDeprecation, error) {
+	stores, err := s.Repo.GetDeprecatedStoresByCompany(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	deprecations := make([]StoreDeprecation, 0, len(stores))
+	for _, store := range stores {
+		apiVersion := store.ShopifyAPIVersion
+		if apiVersion == "" {
+			apiVersion = shopify.DefaultVersion()
+		}
+		deprecations = append(deprecations, StoreDeprecation{
+			StoreID:           store.ID,
+			Shop:              store.ShopifyStoreStub,
+			APIVersion:        apiVersion,
+			DeprecatedVersion: store.APIDeprecationVersion,
+			Reason:            store.APIDeprecationReason,
+			LastSeenAt:        *store.APIDeprecatedAt,
+		})
+	}
+	return deprecations, nil
 }
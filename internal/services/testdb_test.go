@@ -0,0 +1,37 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"gostockly/pkg/database"
+	"gostockly/pkg/secrets"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// openTestDB connects to and migrates the database at TEST_DATABASE_URL, skipping the test
+// when it is not set. It also installs a test keyring for the encrypted store columns.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	keyring, err := secrets.NewKeyring(map[string][]byte{"test": make([]byte, 32)}, "test")
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	secrets.SetKeyring(keyring)
+	return db
+}
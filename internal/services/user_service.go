@@ -2,81 +2,203 @@ package services
 
 import (
 	"errors"
+	"fmt"
 	"gostockly/internal/models"
 	"gostockly/internal/repositories"
-	"gostockly/pkg/utils"
+	"gostockly/pkg/logger"
+	"strings"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrUserNotFound         = repositories.ErrUserNotFound
+	ErrInvalidRole          = errors.New("invalid role, expected owner, admin, operator or viewer")
+	ErrLastOwner            = errors.New("a company needs at least one owner")
+	ErrWrongPassword        = errors.New("current password is incorrect")
+	ErrSubdomainTaken       = errors.New("a company with this subdomain already exists, ask one of its owners for an invitation")
+	ErrCompanyDetailsNeeded = errors.New("company name and subdomain are required")
+)
+
 type UserService struct {
 	UserRepo    *repositories.UserRepository
 	CompanyRepo *repositories.CompanyRepository
-	JWTSecret   string
+	Sessions    *SessionService
+	Accounts    *AccountService
 }
 
-func NewUserService(userRepo *repositories.UserRepository, companyRepo *repositories.CompanyRepository, jwtSecret string) *UserService {
+func NewUserService(userRepo *repositories.UserRepository, companyRepo *repositories.CompanyRepository, sessions *SessionService, accounts *AccountService) *UserService {
 	return &UserService{
 		UserRepo:    userRepo,
 		CompanyRepo: companyRepo,
-		JWTSecret:   jwtSecret,
+		Sessions:    sessions,
+		Accounts:    accounts,
 	}
 }
 
-// RegisterUser creates a new user attached to a company.
+// RegisterUser creates a new company and its owner, and emails the owner a link to verify
+// their address. People join an existing company through an invitation of one of its owners,
+// see InvitationService.
 func (s *UserService) RegisterUser(email, password, companyName, subdomain string) (*models.User, error) {
+	email = normalizeEmail(email)
+	companyName = strings.TrimSpace(companyName)
+	subdomain = strings.ToLower(strings.TrimSpace(subdomain))
+	if !strings.Contains(email, "@") {
+		return nil, ErrInvalidEmail
+	}
+	if len(password) < minPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+	if companyName == "" || subdomain == "" {
+		return nil, ErrCompanyDetailsNeeded
+	}
+
+	if _, err := s.UserRepo.GetUserByEmail(email); err == nil {
+		return nil, ErrEmailTaken
+	} else if !errors.Is(err, repositories.ErrUserNotFound) {
+		return nil, err
+	}
+	if _, err := s.CompanyRepo.GetCompanyBySubdomain(subdomain); err == nil {
+		return nil, ErrSubdomainTaken
+	} else if !errors.Is(err, repositories.ErrCompanyNotFound) {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, errors.New("failed to hash password")
 	}
 
-	// Get or create company
-	company, err := s.CompanyRepo.GetCompanyBySubdomain(subdomain)
-	if err != nil && err.Error() == "company not found" {
-		company = &models.Company{
-			ID:        uuid.New(),
-			Name:      companyName,
-			Subdomain: subdomain,
-		}
-		if err := s.CompanyRepo.CreateCompany(company); err != nil {
-			return nil, errors.New("failed to create company")
-		}
-	} else if err != nil {
-		return nil, err
+	company := &models.Company{
+		ID:        uuid.New(),
+		Name:      companyName,
+		Subdomain: subdomain,
 	}
 
-	// Create user
+	// Whoever creates a company owns it
 	user := &models.User{
 		ID:        uuid.New(),
 		Email:     email,
 		Password:  string(hashedPassword),
 		CompanyID: company.ID,
+		Role:      models.RoleOwner,
 	}
 
-	if err := s.UserRepo.CreateUser(user); err != nil {
-		return nil, err
+	// A company without its owner could never be used, so they are created together
+	if err := s.UserRepo.CreateUserWithCompany(company, user); err != nil {
+		return nil, fmt.Errorf("failed to create company: %w", err)
+	}
+
+	// The account exists either way, a failed email can be sent again
+	if err := s.Accounts.SendVerification(user); err != nil {
+		logger.GetLogger().Error("Failed to send verification email to user %s: %v", user.ID, err)
 	}
 
 	return user, nil
 }
 
-// AuthenticateUser authenticates a user and generates a JWT.
-func (s *UserService) AuthenticateUser(email, password string) (string, error) {
-	user, err := s.UserRepo.GetUserByEmail(email)
+// AuthenticateUser checks the credentials of a user and starts a session. Users have to verify
+// their email address before they can sign in.
+func (s *UserService) AuthenticateUser(email, password string) (*TokenPair, error) {
+	user, err := s.UserRepo.GetUserByEmail(normalizeEmail(email))
 	if err != nil {
-		return "", errors.New("invalid email or password")
+		return nil, errors.New("invalid email or password")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid email or password")
+		return nil, errors.New("invalid email or password")
+	}
+	if user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
 	}
 
-	token, err := utils.GenerateJWT(user.ID.String(), s.JWTSecret)
+	return s.Sessions.StartSession(user)
+}
+
+// ChangePassword replaces the password of a user, which ends every session of the user. A new
+// session is started so the caller stays signed in.
+func (s *UserService) ChangePassword(userID, currentPassword, newPassword string) (*TokenPair, error) {
+	user, err := s.UserRepo.GetUserByID(userID)
 	if err != nil {
-		return "", errors.New("failed to generate token")
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		return nil, ErrWrongPassword
+	}
+	if len(newPassword) < minPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.New("failed to hash password")
+	}
+	if err := s.UserRepo.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		return nil, err
 	}
+	if err := s.Sessions.RevokeSessions(user.ID); err != nil {
+		return nil, err
+	}
+	logger.GetLogger().Info("User %s changed their password", user.ID)
+
+	user, err = s.UserRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Sessions.StartSession(user)
+}
+
+// RevokeUserSessions signs a user of the company out everywhere.
+func (s *UserService) RevokeUserSessions(companyID, userID string) error {
+	user, err := s.UserRepo.GetCompanyUser(companyID, userID)
+	if err != nil {
+		return err
+	}
+	return s.Sessions.RevokeSessions(user.ID)
+}
+
+// GetCompanyUsers returns the users of a company.
+func (s *UserService) GetCompanyUsers(companyID string) ([]models.User, error) {
+	return s.UserRepo.GetUsersByCompany(companyID)
+}
+
+// UpdateUserRole changes the role of a user of the company. The last owner of a company
+// cannot be given another role.
+func (s *UserService) UpdateUserRole(companyID, userID string, role models.Role) (*models.User, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+
+	user, err := s.UserRepo.GetCompanyUser(companyID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+
+	if user.Role == models.RoleOwner {
+		owners, err := s.UserRepo.CountUsersWithRole(companyID, models.RoleOwner)
+		if err != nil {
+			return nil, err
+		}
+		if owners <= 1 {
+			return nil, ErrLastOwner
+		}
+	}
+
+	if err := s.UserRepo.UpdateUserRole(user.ID, role); err != nil {
+		return nil, err
+	}
+	logger.GetLogger().Info("Changed role of user %s of company %s from %s to %s", user.ID, companyID, user.Role, role)
+	user.Role = role
+	return user, nil
+}
 
-	return token, nil
+// normalizeEmail returns an email address as it is stored, trimmed and lowercased, so an
+// address is the same account however it is typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
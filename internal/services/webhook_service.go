@@ -1,67 +1,558 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gostockly/internal/models"
 	"gostockly/internal/repositories"
 	"gostockly/pkg/logger"
-	"gostockly/pkg/shopify"
+	"slices"
+	"strconv"
+	"time"
 
 	"sync"
 
 	"github.com/google/uuid"
 )
 
+// Job types for webhook processing.
+const (
+	JobTypeOrderWebhook          = "webhook.order"
+	JobTypeRefundWebhook         = "webhook.refund"
+	JobTypeOrderCancelledWebhook = "webhook.order_cancelled"
+	JobTypeOrderEditedWebhook    = "webhook.order_edited"
+	JobTypeProductWebhook        = "webhook.product"
+	JobTypeInventoryLevelWebhook = "webhook.inventory_level"
+	JobTypeBulkOperationWebhook  = "webhook.bulk_operation"
+)
+
+var (
+	ErrDuplicateWebhook  = errors.New("webhook has already been accepted")
+	ErrWebhookInProgress = errors.New("webhook is already being processed")
+)
+
+// WebhookJobPayload is the job queue payload for a webhook delivery.
+type WebhookJobPayload struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+	Body       []byte    `json:"body"`
+}
+
 type WebhookService struct {
 	StoreRepo           *repositories.StoreRepository
 	InventoryRepo       *repositories.InventoryRepository
 	StockGroupStoreRepo *repositories.StockGroupStoreRepository
+	DeliveryRepo        *repositories.WebhookDeliveryRepository
+	JobService          *JobService
+	AdjustmentService   *AdjustmentService
+	StockService        *StockService
+	CatalogSyncService  *CatalogSyncService
+	LocationService     *StoreLocationService
+
+	// InventoryLevelDelay holds back inventory_levels/update webhooks so that the order,
+	// refund or edit behind a level change is processed before the level itself.
+	InventoryLevelDelay time.Duration
 }
 
 func NewWebhookService(
 	storeRepo *repositories.StoreRepository,
 	inventoryRepo *repositories.InventoryRepository,
 	stockGroupStoreRepo *repositories.StockGroupStoreRepository,
+	deliveryRepo *repositories.WebhookDeliveryRepository,
+	jobService *JobService,
+	adjustmentService *AdjustmentService,
+	stockService *StockService,
+	catalogSyncService *CatalogSyncService,
+	locationService *StoreLocationService,
+	inventoryLevelDelay time.Duration,
 ) *WebhookService {
-	return &WebhookService{
+	s := &WebhookService{
 		StoreRepo:           storeRepo,
 		InventoryRepo:       inventoryRepo,
 		StockGroupStoreRepo: stockGroupStoreRepo,
+		DeliveryRepo:        deliveryRepo,
+		JobService:          jobService,
+		AdjustmentService:   adjustmentService,
+		StockService:        stockService,
+		CatalogSyncService:  catalogSyncService,
+		LocationService:     locationService,
+		InventoryLevelDelay: inventoryLevelDelay,
 	}
+
+	jobService.RegisterHandler(JobTypeOrderWebhook, s.ProcessOrderWebhook)
+	jobService.RegisterHandler(JobTypeRefundWebhook, s.ProcessRefundWebhook)
+	jobService.RegisterHandler(JobTypeOrderCancelledWebhook, s.ProcessOrderCancelledWebhook)
+	jobService.RegisterHandler(JobTypeOrderEditedWebhook, s.ProcessOrderEditedWebhook)
+	jobService.RegisterHandler(JobTypeProductWebhook, s.ProcessProductWebhook)
+	jobService.RegisterHandler(JobTypeInventoryLevelWebhook, s.ProcessInventoryLevelWebhook)
+	jobService.RegisterHandler(JobTypeBulkOperationWebhook, s.ProcessBulkOperationWebhook)
+
+	return s
 }
 
-// ProcessOrderWebhook processes an order webhook from Shopify and updates stock across the stock group.
-func (s *WebhookService) ProcessOrderWebhook(shopDomain string, payload []byte) error {
+// AcceptDelivery records a webhook delivery in the ledger and queues a job of jobType to process it.
+// It returns ErrDuplicateWebhook if the delivery has already been queued, in which case the
+// job queue is responsible for it and nothing is queued again.
+func (s *WebhookService) AcceptDelivery(jobType, webhookID, topic, shopDomain string, payload []byte) error {
 	log := logger.GetLogger()
-	log.Info("Processing order webhook for shop: %s", shopDomain)
 
-	// Validate store existence
-	sourceStore, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
+	hash := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(hash[:])
+
+	delivery, created, err := s.DeliveryRepo.CreateOrGetDelivery(&models.WebhookDelivery{
+		ID:          uuid.New(),
+		WebhookID:   webhookID,
+		Topic:       topic,
+		ShopDomain:  shopDomain,
+		PayloadHash: payloadHash,
+		Status:      models.WebhookDeliveryReceived,
+	})
 	if err != nil {
-		log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
-		return errors.New("invalid store domain")
+		log.Error("Failed to record webhook delivery %s: %v", webhookID, err)
+		return errors.New("failed to record webhook delivery")
 	}
-	log.Info("Found source store: %s (ID: %s)", sourceStore.ShopifyStoreStub, sourceStore.ID)
 
-	// Parse the webhook payload
-	var order struct {
-		LineItems []struct {
-			SKU      string `json:"sku"`
-			Quantity int    `json:"quantity"`
-		} `json:"line_items"`
+	if !created {
+		log.Info("Received repeated delivery of webhook %s (status: %s)", webhookID, delivery.Status)
+		if delivery.PayloadHash != payloadHash {
+			log.Error("Webhook %s was redelivered with a different payload", webhookID)
+		}
+		if delivery.Status != models.WebhookDeliveryReceived {
+			return ErrDuplicateWebhook
+		}
+	}
+
+	runAt := time.Now()
+	if jobType == JobTypeInventoryLevelWebhook {
+		runAt = runAt.Add(s.InventoryLevelDelay)
+	}
+
+	job, err := s.JobService.EnqueueAt(jobType, WebhookJobPayload{DeliveryID: delivery.ID, Body: payload}, runAt)
+	if err != nil {
+		log.Error("Failed to queue webhook %s: %v", webhookID, err)
+		return errors.New("failed to queue webhook")
+	}
+	log.Info("Queued webhook %s as job %s", webhookID, job.ID)
+
+	if err := s.DeliveryRepo.MarkDeliveryQueued(delivery.ID); err != nil {
+		// A redelivery will queue a second job, which skips whatever the first one applied
+		log.Error("Failed to mark webhook delivery %s as queued: %v", webhookID, err)
+	}
+	return nil
+}
+
+// ProcessOrderWebhook is the job handler for order webhooks.
+func (s *WebhookService) ProcessOrderWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processOrderWebhook)
+}
+
+// ProcessRefundWebhook is the job handler for refund webhooks.
+func (s *WebhookService) ProcessRefundWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processRefundWebhook)
+}
+
+// ProcessOrderCancelledWebhook is the job handler for order cancellation webhooks.
+func (s *WebhookService) ProcessOrderCancelledWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processOrderCancelledWebhook)
+}
+
+// ProcessOrderEditedWebhook is the job handler for order edit webhooks.
+func (s *WebhookService) ProcessOrderEditedWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processOrderEditedWebhook)
+}
+
+// ProcessProductWebhook is the job handler for product webhooks.
+func (s *WebhookService) ProcessProductWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processProductWebhook)
+}
+
+// ProcessInventoryLevelWebhook is the job handler for inventory level webhooks.
+func (s *WebhookService) ProcessInventoryLevelWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processInventoryLevelWebhook)
+}
+
+// ProcessBulkOperationWebhook is the job handler for bulk operation webhooks.
+func (s *WebhookService) ProcessBulkOperationWebhook(ctx context.Context, job *models.Job) error {
+	return s.runDelivery(ctx, job, s.processBulkOperationWebhook)
+}
+
+// runDelivery claims the delivery referenced by a webhook job, runs process and records the outcome.
+func (s *WebhookService) runDelivery(ctx context.Context, job *models.Job, process func(context.Context, *models.WebhookDelivery, []byte) error) error {
+	log := logger.GetLogger()
+
+	var payload WebhookJobPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+
+	delivery, err := s.DeliveryRepo.GetDeliveryByID(payload.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery.Status == models.WebhookDeliveryProcessed {
+		log.Info("Webhook %s has already been processed, skipping job %s", delivery.WebhookID, job.ID)
+		return nil
 	}
-	err = json.Unmarshal(payload, &order)
+
+	// A delivery left in processing is only taken over once the job that claimed it has lost its
+	// lock, as until then the job may still be running
+	claimed, err := s.DeliveryRepo.ClaimDelivery(delivery.ID, time.Now().Add(-s.JobService.LockTimeout))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrWebhookInProgress
+	}
+
+	processErr := process(ctx, delivery, payload.Body)
+	if processErr != nil {
+		if err := s.DeliveryRepo.MarkDeliveryFailed(delivery.ID, processErr.Error()); err != nil {
+			log.Error("Failed to mark webhook delivery %s as failed: %v", delivery.WebhookID, err)
+		}
+		return processErr
+	}
+
+	return s.DeliveryRepo.MarkDeliveryProcessed(delivery.ID)
+}
+
+// processOrderWebhook processes an order webhook from Shopify and decrements stock across the stock group.
+// Each item is taken out at the location that fulfilled it, see applyStockDeltas.
+func (s *WebhookService) processOrderWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
+	log := logger.GetLogger()
+	log.Info("Processing order webhook for shop: %s", shopDomain)
+
+	// Parse the webhook payload
+	var order shopifyOrder
+	err := json.Unmarshal(payload, &order)
 	if err != nil {
 		log.Error("Failed to parse webhook payload for shop %s: %v", shopDomain, err)
 		return errors.New("failed to parse webhook payload")
 	}
 	log.Info("Parsed %d line items for shop %s", len(order.LineItems), shopDomain)
 
+	// orders/create arrives before anything is fulfilled, the locations Shopify assigned say where the items will leave from
+	var assigned map[int64]string
+	if order.ID != 0 && len(order.Fulfillments) < len(order.LineItems) {
+		sourceStore, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
+		if err != nil || sourceStore == nil {
+			log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
+			return errors.New("invalid store domain")
+		}
+		assigned, err = newStoreClient(sourceStore).GetAssignedLocations(ctx, order.ID)
+		if err != nil {
+			log.Error("Failed to look up the assigned locations of order %d in shop %s: %v", order.ID, shopDomain, err)
+			return errors.New("failed to look up order locations")
+		}
+	}
+
+	deltas := orderDeltas(order, assigned)
+	return s.applyStockDeltas(ctx, delivery, deltas, models.StockMovementOrder)
+}
+
+// processRefundWebhook processes a refunds/create webhook and puts restocked items back
+// across the stock group. Items restocked by cancelling the order are left to
+// processOrderCancelledWebhook, so they are not counted twice.
+func (s *WebhookService) processRefundWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
+	log := logger.GetLogger()
+	log.Info("Processing refund webhook for shop: %s", shopDomain)
+
+	var refund shopifyRefund
+	if err := json.Unmarshal(payload, &refund); err != nil {
+		log.Error("Failed to parse refund webhook payload for shop %s: %v", shopDomain, err)
+		return errors.New("failed to parse refund webhook payload")
+	}
+	log.Info("Parsed %d refund line items for shop %s", len(refund.RefundLineItems), shopDomain)
+
+	return s.applyStockDeltas(ctx, delivery, refundDeltas(refund), models.StockMovementRefund)
+}
+
+// processOrderCancelledWebhook processes an orders/cancelled webhook and puts the items
+// restocked by the cancellation back across the stock group.
+func (s *WebhookService) processOrderCancelledWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
+	log := logger.GetLogger()
+	log.Info("Processing order cancellation webhook for shop: %s", shopDomain)
+
+	var order struct {
+		Refunds []shopifyRefund `json:"refunds"`
+	}
+	if err := json.Unmarshal(payload, &order); err != nil {
+		log.Error("Failed to parse order cancellation webhook payload for shop %s: %v", shopDomain, err)
+		return errors.New("failed to parse order cancellation webhook payload")
+	}
+
+	return s.applyStockDeltas(ctx, delivery, cancellationDeltas(order.Refunds), models.StockMovementOrderCancelled)
+}
+
+// processOrderEditedWebhook processes an orders/edited webhook. Added quantities are taken
+// out of stock and removed quantities that were restocked are put back across the stock group.
+func (s *WebhookService) processOrderEditedWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
+	log := logger.GetLogger()
+	log.Info("Processing order edit webhook for shop: %s", shopDomain)
+
+	var edit struct {
+		OrderEdit shopifyOrderEdit `json:"order_edit"`
+	}
+	if err := json.Unmarshal(payload, &edit); err != nil {
+		log.Error("Failed to parse order edit webhook payload for shop %s: %v", shopDomain, err)
+		return errors.New("failed to parse order edit webhook payload")
+	}
+
+	additions := edit.OrderEdit.LineItems.Additions
+	removals := edit.OrderEdit.LineItems.Removals
+	if len(additions) == 0 && len(removals) == 0 {
+		log.Info("Order edit for shop %s does not change any line items", shopDomain)
+		return nil
+	}
+
+	// The edit only references line item IDs, so look up their SKUs in the source store
+	sourceStore, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
+	if err != nil || sourceStore == nil {
+		log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
+		return errors.New("invalid store domain")
+	}
+
+	var lineItemIDs []int64
+	for _, item := range additions {
+		lineItemIDs = append(lineItemIDs, item.ID)
+	}
+	for _, item := range removals {
+		lineItemIDs = append(lineItemIDs, item.ID)
+	}
+
+	client := newStoreClient(sourceStore)
+	skus, err := client.GetLineItemSKUs(ctx, lineItemIDs)
+	if err != nil {
+		log.Error("Failed to look up line items for order edit in shop %s: %v", shopDomain, err)
+		return errors.New("failed to look up edited line items")
+	}
+
+	// The webhook does not say whether removed quantities went back in stock, the refund the edit created does
+	var restocked map[int64]int
+	if len(removals) > 0 {
+		restocked, err = client.GetRestockedQuantities(ctx, edit.OrderEdit.OrderID, edit.OrderEdit.CreatedAt)
+		if err != nil {
+			log.Error("Failed to look up restocked quantities for order edit in shop %s: %v", shopDomain, err)
+			return errors.New("failed to look up restocked line items")
+		}
+	}
+
+	return s.applyStockDeltas(ctx, delivery, orderEditDeltas(edit.OrderEdit, skus, restocked), models.StockMovementOrderEdited)
+}
+
+// shopifyOrder is the part of a Shopify order needed to work out where its items left stock.
+type shopifyOrder struct {
+	ID int64 `json:"id"`
+	// LocationID is set for orders taken at a point of sale
+	LocationID *int64 `json:"location_id"`
+	LineItems  []struct {
+		ID       int64  `json:"id"`
+		SKU      string `json:"sku"`
+		Quantity int    `json:"quantity"`
+	} `json:"line_items"`
+	Fulfillments []struct {
+		LocationID *int64 `json:"location_id"`
+		LineItems  []struct {
+			ID int64 `json:"id"`
+		} `json:"line_items"`
+	} `json:"fulfillments"`
+}
+
+// shopifyOrderEdit is the part of a Shopify order edit needed to work out the changed quantities.
+type shopifyOrderEdit struct {
+	OrderID   int64     `json:"order_id"`
+	CreatedAt time.Time `json:"created_at"`
+	LineItems struct {
+		Additions []shopifyLineItemDelta `json:"additions"`
+		Removals  []shopifyLineItemDelta `json:"removals"`
+	} `json:"line_items"`
+}
+
+// shopifyLineItemDelta is a change to the quantity of an order line item.
+type shopifyLineItemDelta struct {
+	ID    int64 `json:"id"`
+	Delta int   `json:"delta"`
+}
+
+// orderDeltas takes every line item of an order out of stock at the location it left from: the
+// location that fulfilled it, or else the location Shopify assigned it to, as listed in
+// assigned, or else the order's own location, if it has one.
+func orderDeltas(order shopifyOrder, assigned map[int64]string) *stockDeltas {
+	fulfilledFrom := make(map[int64]string)
+	for _, fulfillment := range order.Fulfillments {
+		if fulfillment.LocationID == nil {
+			continue
+		}
+		for _, item := range fulfillment.LineItems {
+			fulfilledFrom[item.ID] = strconv.FormatInt(*fulfillment.LocationID, 10)
+		}
+	}
+	var orderLocation string
+	if order.LocationID != nil {
+		orderLocation = strconv.FormatInt(*order.LocationID, 10)
+	}
+
+	deltas := newStockDeltas()
+	for _, item := range order.LineItems {
+		location, ok := fulfilledFrom[item.ID]
+		if !ok {
+			location, ok = assigned[item.ID]
+		}
+		if !ok {
+			location = orderLocation
+		}
+		deltas.addAt(item.SKU, -item.Quantity, location)
+	}
+	return deltas
+}
+
+// refundDeltas puts the items a refund returned to stock back. Items restocked by cancelling the
+// order are left to cancellationDeltas.
+func refundDeltas(refund shopifyRefund) *stockDeltas {
+	deltas := newStockDeltas()
+	for _, item := range refund.RefundLineItems {
+		if item.RestockType == "return" || item.RestockType == "legacy_restock" {
+			deltas.add(item.LineItem.SKU, item.Quantity)
+		}
+	}
+	return deltas
+}
+
+// cancellationDeltas puts the items restocked by cancelling an order back. Shopify records the
+// restock of a cancelled order as a refund with restock type "cancel".
+func cancellationDeltas(refunds []shopifyRefund) *stockDeltas {
+	deltas := newStockDeltas()
+	for _, refund := range refunds {
+		for _, item := range refund.RefundLineItems {
+			if item.RestockType == "cancel" {
+				deltas.add(item.LineItem.SKU, item.Quantity)
+			}
+		}
+	}
+	return deltas
+}
+
+// orderEditDeltas takes added quantities out of stock and puts removed ones back, as far as they
+// were restocked. skus holds the SKUs of the edited line items and restocked the quantities put
+// back in stock by the edit, both keyed by line item ID.
+func orderEditDeltas(edit shopifyOrderEdit, skus map[int64]string, restocked map[int64]int) *stockDeltas {
+	deltas := newStockDeltas()
+	for _, item := range edit.LineItems.Additions {
+		deltas.add(skus[item.ID], -item.Delta)
+	}
+
+	remaining := make(map[int64]int, len(restocked))
+	for id, quantity := range restocked {
+		remaining[id] = quantity
+	}
+	for _, item := range edit.LineItems.Removals {
+		quantity := min(item.Delta, remaining[item.ID])
+		remaining[item.ID] -= quantity
+		deltas.add(skus[item.ID], quantity)
+	}
+	return deltas
+}
+
+// shopifyProduct is the part of a Shopify product needed to map its SKUs to inventory items.
+type shopifyProduct struct {
+	Variants []struct {
+		SKU string `json:"sku"`
+		// Webhooks send the numeric ID of the item, which is how the inventory table stores it
+		InventoryItemID int64 `json:"inventory_item_id"`
+	} `json:"variants"`
+}
+
+// shopifyRefund is the part of a Shopify refund needed to work out restocked quantities.
+type shopifyRefund struct {
+	RefundLineItems []struct {
+		Quantity    int    `json:"quantity"`
+		RestockType string `json:"restock_type"`
+		LineItem    struct {
+			SKU string `json:"sku"`
+		} `json:"line_item"`
+	} `json:"refund_line_items"`
+}
+
+// stockDeltas holds the net stock change per SKU, keeping SKUs in the order they were first seen.
+type stockDeltas struct {
+	skus   []string
+	deltas map[string]int
+	// locations holds the Shopify location of the source store each SKU's change happened at,
+	// if it is known. Changes without one happened at the primary location.
+	locations map[string]string
+}
+
+func newStockDeltas() *stockDeltas {
+	return &stockDeltas{deltas: make(map[string]int), locations: make(map[string]string)}
+}
+
+// addAt records a change for a SKU that happened at a location of the source store. A SKU that
+// changed at several locations is treated as changed at the primary location.
+func (d *stockDeltas) addAt(sku string, delta int, locationID string) {
+	if sku == "" {
+		return
+	}
+	if existing, ok := d.locations[sku]; !ok {
+		d.locations[sku] = locationID
+	} else if existing != locationID {
+		d.locations[sku] = ""
+	}
+	d.add(sku, delta)
+}
+
+// add combines a change for a SKU with the changes already recorded for it.
+func (d *stockDeltas) add(sku string, delta int) {
+	if sku == "" {
+		return
+	}
+	if _, ok := d.deltas[sku]; !ok {
+		d.skus = append(d.skus, sku)
+	}
+	d.deltas[sku] += delta
+}
+
+// applyStockDeltas records the given deltas in the stock group's ledger and brings every other
+// store in the group to the resulting canonical levels with SyncLevels. The stores are set to
+// the levels rather than adjusted by the deltas, so stores that had drifted are corrected and a
+// change that already landed is not applied twice. Stores already brought to the levels by an
+// earlier attempt of the same delivery are skipped.
+//
+// Changes at a location the source store ignores are the store's own and are left out. The
+// other stores make up any difference at their synced location of the same name as the one the
+// change happened at, or else at their primary location.
+func (s *WebhookService) applyStockDeltas(ctx context.Context, delivery *models.WebhookDelivery, deltas *stockDeltas, reason string) error {
+	shopDomain := delivery.ShopDomain
+	log := logger.GetLogger()
+
+	// Drop SKUs whose changes cancel out
+	var skus []string
+	for _, sku := range deltas.skus {
+		if deltas.deltas[sku] != 0 {
+			skus = append(skus, sku)
+		}
+	}
+	if len(skus) == 0 {
+		log.Info("No stock changes to apply for webhook %s", delivery.WebhookID)
+		return nil
+	}
+
+	// Validate store existence
+	sourceStore, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
+	if err != nil || sourceStore == nil {
+		log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
+		return errors.New("invalid store domain")
+	}
+	log.Info("Found source store: %s (ID: %s)", sourceStore.ShopifyStoreStub, sourceStore.ID)
+
 	// Get the stock group for the source store
 	stockGroup, err := s.StockGroupStoreRepo.GetStockGroupsByStore(sourceStore.ID)
-	if err != nil {
+	if err != nil || stockGroup == nil {
 		log.Error("No stock group found for store %s: %v", sourceStore.ID, err)
 		return errors.New("no stock group found for this store")
 	}
@@ -75,11 +566,62 @@ func (s *WebhookService) ProcessOrderWebhook(shopDomain string, payload []byte)
 	}
 	log.Info("Found %d stores in stock group %s", len(stores), stockGroup.ID)
 
+	routing, err := s.LocationService.routing(stores)
+	if err != nil {
+		log.Error("Failed to load locations of stock group %s: %v", stockGroup.ID, err)
+		return errors.New("failed to load store locations")
+	}
+
+	var synced []string
+	for _, sku := range skus {
+		if routing.ignored(sourceStore, deltas.locations[sku]) {
+			log.Info("Ignoring SKU %s, it changed at location %s which store %s does not sync", sku, deltas.locations[sku], sourceStore.ShopifyStoreStub)
+			continue
+		}
+		synced = append(synced, sku)
+	}
+	skus = synced
+	if len(skus) == 0 {
+		log.Info("No stock changes at synced locations for webhook %s", delivery.WebhookID)
+		return nil
+	}
+
+	// Load adjustments applied by earlier attempts of this delivery
+	appliedItems, err := s.DeliveryRepo.GetAppliedItems(delivery.ID)
+	if err != nil {
+		log.Error("Failed to load applied adjustments for webhook %s: %v", delivery.WebhookID, err)
+		return errors.New("failed to load webhook delivery state")
+	}
+	applied := make(map[string]bool, len(appliedItems))
+	for _, item := range appliedItems {
+		applied[item.StoreID.String()+"/"+item.SKU] = true
+	}
+
+	// Record the changes in the ledger, which every store is derived from
+	movements := make(map[string]*models.StockMovement, len(skus))
+	levels := make(map[string]int, len(skus))
+	for _, sku := range skus {
+		movement, err := s.StockService.RecordWebhookDelta(ctx, stockGroup.ID, sourceStore, sku, deltas.locations[sku], deltas.deltas[sku], reason, delivery.WebhookID)
+		if err != nil {
+			log.Error("Failed to record stock movement for SKU %s in stock group %s: %v", sku, stockGroup.ID, err)
+			return errors.New("failed to record stock movement")
+		}
+		movements[sku] = movement
+
+		// Read the level back, as later changes may have been recorded on top of this one
+		level, err := s.StockService.LedgerRepo.GetStockLevel(stockGroup.ID, sku)
+		if err != nil {
+			log.Error("Failed to read stock level of SKU %s in stock group %s: %v", sku, stockGroup.ID, err)
+			return errors.New("failed to read stock level")
+		}
+		levels[sku] = level.Quantity
+	}
+
 	// Create a WaitGroup to wait for all goroutines to finish
 	var wg sync.WaitGroup
 
-	// Create a channel to collect errors (optional)
-	errChan := make(chan error, len(stores))
+	// Create a channel to collect errors, with room for every SKU of every store
+	errChan := make(chan error, len(stores)*len(skus))
 
 	// Iterate through stores in the stock group
 	for _, targetStore := range stores {
@@ -93,28 +635,73 @@ func (s *WebhookService) ProcessOrderWebhook(shopDomain string, payload []byte)
 			defer wg.Done()
 			log.Info("Processing stock updates for target store: %s (ID: %s)", targetStore.ShopifyStoreStub, targetStore.ID)
 
-			shopifyClient := shopify.NewShopifyClient(targetStore.AccessToken, targetStore.ShopifyStoreStub)
+			var changes []LevelChange
+			for _, sku := range skus {
+				if applied[targetStore.ID.String()+"/"+sku] {
+					log.Info("Skipping SKU %s for store %s, already adjusted by an earlier attempt", sku, targetStore.ShopifyStoreStub)
+					continue
+				}
 
-			// Process each line item individually
-			for _, item := range order.LineItems {
-				inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(item.SKU, targetStore.ID)
+				inventory, err := s.InventoryRepo.GetInventoryBySKUAndStore(sku, targetStore.ID)
 				if err != nil {
-					log.Debug("Failed to find inventory for SKU %s in store %s: %v", item.SKU, targetStore.ID, err)
+					log.Debug("Failed to find inventory for SKU %s in store %s: %v", sku, targetStore.ID, err)
 					continue
 				}
 
-				adjustment := map[string]interface{}{
-					"inventoryItemId": inventory.InventoryItemID,
-					"locationId":      targetStore.LocationID,
-					"adjustment":      -item.Quantity,
+				changes = append(changes, LevelChange{
+					SKU:             sku,
+					InventoryItemID: inventory.InventoryItemID,
+					LocationID:      routing.target(sourceStore, deltas.locations[sku], &targetStore),
+					Quantity:        levels[sku],
+				})
+			}
+			if len(changes) == 0 {
+				return
+			}
+
+			// The movements all come from this webhook, so it is the reference for the batch
+			log.Info("Setting %d inventory levels in store: %s", len(changes), targetStore.ShopifyStoreStub)
+			changeErrs, err := s.AdjustmentService.SyncLevels(ctx, newStoreClient(&targetStore), &targetStore,
+				routing.synced(&targetStore), changes, webhookReference(delivery.WebhookID))
+			if err != nil {
+				// The changes sent before the failure are applied, the rest carry the error
+				log.Error("Failed to set inventory levels for store %s: %v", targetStore.ShopifyStoreStub, err)
+			}
+
+			for i, change := range changes {
+				sku := change.SKU
+				if changeErrs[i] != nil {
+					log.Error("Failed to adjust SKU %s in store %s: %v", sku, targetStore.ShopifyStoreStub, changeErrs[i])
+
+					// Hand the adjustment over to the retry queue
+					retryErr := s.AdjustmentService.ScheduleRetry(InventoryAdjustment{
+						CompanyID:       targetStore.CompanyID,
+						StockGroupID:    stockGroup.ID,
+						StoreID:         targetStore.ID,
+						SKU:             sku,
+						InventoryItemID: change.InventoryItemID,
+						LocationID:      change.LocationID,
+						Delta:           movements[sku].Delta,
+						WebhookID:       delivery.WebhookID,
+						MovementID:      movements[sku].ID,
+					}, changeErrs[i])
+					if retryErr != nil {
+						log.Error("Failed to schedule retry for SKU %s in store %s: %v", sku, targetStore.ShopifyStoreStub, retryErr)
+						errChan <- changeErrs[i] // Send error to the channel
+						continue
+					}
 				}
 
-				// Send the adjustment
-				log.Info("Sending inventory adjustment for SKU: %s to store: %s", item.SKU, targetStore.ShopifyStoreStub)
-				err = s.sendInventoryAdjustment(shopifyClient, adjustment)
+				// Remember the adjustment so a retried delivery does not apply or schedule it twice
+				err = s.DeliveryRepo.CreateAppliedItem(&models.WebhookDeliveryItem{
+					ID:         uuid.New(),
+					DeliveryID: delivery.ID,
+					StoreID:    targetStore.ID,
+					SKU:        sku,
+					Delta:      movements[sku].Delta,
+				})
 				if err != nil {
-					log.Error("Failed to send inventory adjustment for store %s: %v", targetStore.ShopifyStoreStub, err)
-					errChan <- err // Send error to the channel
+					log.Error("Failed to record adjustment for SKU %s in store %s: %v", sku, targetStore.ShopifyStoreStub, err)
 				}
 			}
 		}(targetStore)
@@ -125,34 +712,36 @@ func (s *WebhookService) ProcessOrderWebhook(shopDomain string, payload []byte)
 	close(errChan)
 
 	// Check for errors
+	failed := 0
 	for err := range errChan {
 		log.Error("Error occurred during inventory adjustment: %v", err)
+		failed++
+	}
+	if failed > 0 {
+		// Let the job queue retry, the applied adjustments will be skipped
+		return fmt.Errorf("%d inventory adjustments failed", failed)
 	}
 
-	log.Info("Finished processing order webhook for shop: %s", shopDomain)
+	log.Info("Finished applying stock changes for webhook %s from shop: %s", delivery.WebhookID, shopDomain)
 	return nil
 }
 
-// ProcessProductWebhook processes a product creation/update webhook from Shopify.
-func (s *WebhookService) ProcessProductWebhook(shopDomain string, payload []byte) error {
+// processProductWebhook processes a product creation/update webhook from Shopify.
+func (s *WebhookService) processProductWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
 	log := logger.GetLogger()
 	log.Info("Processing product webhook for shop: %s", shopDomain)
 
 	// Validate store existence
 	store, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
-	if err != nil {
+	if err != nil || store == nil {
 		log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
 		return errors.New("invalid store domain")
 	}
 	log.Info("Found store: %s (ID: %s)", store.ShopifyStoreStub, store.ID)
 
 	// Parse the webhook payload
-	var product struct {
-		Variants []struct {
-			SKU             string `json:"sku"`
-			InventoryItemID string `json:"inventory_item_id"`
-		} `json:"variants"`
-	}
+	var product shopifyProduct
 	err = json.Unmarshal(payload, &product)
 	if err != nil {
 		log.Error("Failed to parse product webhook payload for shop %s: %v", shopDomain, err)
@@ -162,30 +751,16 @@ func (s *WebhookService) ProcessProductWebhook(shopDomain string, payload []byte
 
 	// Update the database with new or updated SKUs and InventoryItemIDs
 	for _, variant := range product.Variants {
-		if variant.SKU == "" || variant.InventoryItemID == "" {
+		if variant.SKU == "" || variant.InventoryItemID == 0 {
 			log.Debug("Skipping variant with missing SKU or InventoryItemID for shop %s", shopDomain)
 			continue
 		}
+		inventoryItemID := fmt.Sprintf("%d", variant.InventoryItemID)
 
-		_, err := s.InventoryRepo.GetInventoryBySKUAndStore(variant.SKU, store.ID)
-		if err != nil {
-			log.Info("Creating new inventory for SKU %s in store %s", variant.SKU, store.ID)
-			newInventory := &models.Inventory{
-				ID:              uuid.New(),
-				SKU:             variant.SKU,
-				InventoryItemID: variant.InventoryItemID,
-				StoreID:         store.ID,
-			}
-			err := s.InventoryRepo.CreateInventory(newInventory)
-			if err != nil {
-				log.Error("Failed to create inventory for SKU %s in store %s: %v", variant.SKU, store.ID, err)
-			}
-		} else {
-			log.Info("Updating inventory for SKU %s in store %s", variant.SKU, store.ID)
-			err := s.InventoryRepo.UpdateInventoryItemID(variant.SKU, store.ID, variant.InventoryItemID)
-			if err != nil {
-				log.Error("Failed to update inventory for SKU %s in store %s: %v", variant.SKU, store.ID, err)
-			}
+		// Saving is idempotent per store and SKU, so a retried delivery saves the same mappings again
+		if err := s.InventoryRepo.SaveInventoryItem(variant.SKU, store.ID, inventoryItemID); err != nil {
+			log.Error("Failed to save inventory for SKU %s in store %s: %v", variant.SKU, store.ID, err)
+			return errors.New("failed to save inventory")
 		}
 	}
 
@@ -193,88 +768,125 @@ func (s *WebhookService) ProcessProductWebhook(shopDomain string, payload []byte
 	return nil
 }
 
-func (s *WebhookService) sendInventoryAdjustment(client *shopify.ShopifyClient, adjustment map[string]interface{}) error {
+// processInventoryLevelWebhook processes an inventory_levels/update webhook, caused by stock counts,
+// received deliveries, POS sales and other changes made in the Shopify admin, and sets the store's
+// new level in the stock group's ledger and in every other store of the group. The level is the
+// total available across the store's synced locations, so an update at one of them is added to
+// the current quantities of the others, and updates at other locations are ignored.
+//
+// The webhook does not say who made the change, so the levels Gostockly writes itself are
+// remembered as echoes and updates matching them are ignored. Without that, every write would
+// bounce back and forth between the stores.
+func (s *WebhookService) processInventoryLevelWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
 	log := logger.GetLogger()
+	log.Info("Processing inventory level webhook for shop: %s", shopDomain)
 
-	// Define the GraphQL mutation
-	query := `
-		mutation inventoryAdjustQuantities($input: InventoryAdjustQuantitiesInput!) {
-			inventoryAdjustQuantities(input: $input) {
-				userErrors {
-					field
-					message
-				}
-				inventoryAdjustmentGroup {
-					createdAt
-					reason
-					referenceDocumentUri
-					changes {
-						name
-						delta
-					}
-				}
-			}
-		}
-	`
-
-	// Ensure inventoryItemId and locationId are formatted as global IDs
-	inventoryItemId := fmt.Sprintf("gid://shopify/InventoryItem/%s", adjustment["inventoryItemId"])
-	locationId := fmt.Sprintf("gid://shopify/Location/%s", adjustment["locationId"])
-
-	// Construct the mutation variables
-	variables := map[string]interface{}{
-		"input": map[string]interface{}{
-			"reason":               "movement_created",
-			"name":                 "available",
-			"referenceDocumentUri": "logistics://some.warehouse/take/2023-01/13",
-			"changes": []map[string]interface{}{
-				{
-					"delta":           adjustment["adjustment"],
-					"inventoryItemId": inventoryItemId,
-					"locationId":      locationId,
-				},
-			},
-		},
-	}
-
-	// Log the query and variables for debugging
-	log.Debug("Query: %s", query)
-	log.Debug("Variables: %+v", variables)
-
-	// Send the GraphQL request
-	body, err := client.SendGraphQLRequest(query, variables)
+	var level struct {
+		InventoryItemID int64 `json:"inventory_item_id"`
+		LocationID      int64 `json:"location_id"`
+		Available       *int  `json:"available"`
+	}
+	if err := json.Unmarshal(payload, &level); err != nil {
+		log.Error("Failed to parse inventory level webhook payload for shop %s: %v", shopDomain, err)
+		return errors.New("failed to parse inventory level webhook payload")
+	}
+	if level.Available == nil {
+		log.Info("Inventory item %d is not tracked in shop %s, ignoring", level.InventoryItemID, shopDomain)
+		return nil
+	}
+	inventoryItemID := fmt.Sprintf("%d", level.InventoryItemID)
+	locationID := fmt.Sprintf("%d", level.LocationID)
+
+	sourceStore, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
+	if err != nil || sourceStore == nil {
+		log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
+		return errors.New("invalid store domain")
+	}
+
+	routing, err := s.LocationService.routing([]models.Store{*sourceStore})
 	if err != nil {
-		log.Error("Failed to send inventory adjustment mutation: %v", err)
-		return err
+		log.Error("Failed to load locations of store %s: %v", sourceStore.ShopifyStoreStub, err)
+		return errors.New("failed to load store locations")
+	}
+	synced := routing.synced(sourceStore)
+	if !slices.Contains(synced, locationID) {
+		log.Info("Ignoring inventory level for location %s, store %s does not sync it", locationID, sourceStore.ShopifyStoreStub)
+		return nil
 	}
 
-	// Log the response for debugging
-	log.Debug("Response body: %s", body)
+	echo, err := s.AdjustmentService.IsEcho(sourceStore, inventoryItemID, locationID, *level.Available)
+	if err != nil {
+		log.Error("Failed to check inventory echo for item %s in store %s: %v", inventoryItemID, sourceStore.ShopifyStoreStub, err)
+		return errors.New("failed to check inventory echo")
+	}
+	if echo {
+		log.Info("Ignoring echo of our own write for item %s in store %s", inventoryItemID, sourceStore.ShopifyStoreStub)
+		return nil
+	}
 
-	// Parse the response to check for errors
-	var response struct {
-		Data struct {
-			InventoryAdjustQuantities struct {
-				UserErrors []struct {
-					Field   string `json:"field"`
-					Message string `json:"message"`
-				} `json:"userErrors"`
-			} `json:"inventoryAdjustQuantities"`
-		} `json:"data"`
+	sourceInventory, err := s.InventoryRepo.GetInventoryByInventoryItemIDAndStore(inventoryItemID, sourceStore.ID)
+	if err != nil {
+		log.Info("Inventory item %s is not mapped in store %s, ignoring", inventoryItemID, sourceStore.ShopifyStoreStub)
+		return nil
 	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		log.Error("Failed to parse response from Shopify: %v", err)
-		return err
+	sku := sourceInventory.SKU
+
+	stockGroup, err := s.StockGroupStoreRepo.GetStockGroupsByStore(sourceStore.ID)
+	if err != nil || stockGroup == nil {
+		log.Error("No stock group found for store %s: %v", sourceStore.ID, err)
+		return errors.New("no stock group found for this store")
 	}
 
-	// Log user errors if any are present
-	if len(response.Data.InventoryAdjustQuantities.UserErrors) > 0 {
-		for _, userError := range response.Data.InventoryAdjustQuantities.UserErrors {
-			log.Error("Shopify user error: field=%s, message=%s", userError.Field, userError.Message)
-		}
-		return errors.New("Shopify returned user errors for inventory adjustment")
+	// The ledger takes the change from the level it accounts for at the location, so a late report
+	// of a sale it has already recorded cannot undo the sales recorded since
+	movement, err := s.StockService.RecordWebhookLevel(ctx, stockGroup.ID, sourceStore, sku, &models.KnownInventoryLevel{
+		StoreID:         sourceStore.ID,
+		InventoryItemID: inventoryItemID,
+		LocationID:      locationID,
+		Available:       *level.Available,
+	}, delivery.WebhookID)
+	if err != nil {
+		log.Error("Failed to record stock movement for SKU %s in stock group %s: %v", sku, stockGroup.ID, err)
+		return errors.New("failed to record stock movement")
+	}
+	if movement.Delta == 0 {
+		log.Info("Inventory level of SKU %s at location %s changes nothing in stock group %s", sku, locationID, stockGroup.ID)
+		return nil
+	}
+
+	// The push reads the canonical level when it runs, so a retried delivery sets the same level again
+	if err := s.StockService.QueuePush(stockGroup.ID, sku, movement.ID, sourceStore.ID, locationID); err != nil {
+		log.Error("Failed to queue stock push for SKU %s in stock group %s: %v", sku, stockGroup.ID, err)
+		return errors.New("failed to queue stock push")
 	}
 
-	log.Info("Successfully sent inventory adjustment mutation to Shopify")
+	log.Info("Finished processing inventory level webhook for shop: %s", shopDomain)
 	return nil
 }
+
+// processBulkOperationWebhook processes a bulk_operations/finish webhook and finishes the
+// catalog sync that started the operation.
+func (s *WebhookService) processBulkOperationWebhook(ctx context.Context, delivery *models.WebhookDelivery, payload []byte) error {
+	shopDomain := delivery.ShopDomain
+	log := logger.GetLogger()
+	log.Info("Processing bulk operation webhook for shop: %s", shopDomain)
+
+	var operation struct {
+		AdminGraphQLAPIID string `json:"admin_graphql_api_id"`
+		Status            string `json:"status"`
+	}
+	if err := json.Unmarshal(payload, &operation); err != nil || operation.AdminGraphQLAPIID == "" {
+		log.Error("Failed to parse bulk operation webhook payload for shop %s: %v", shopDomain, err)
+		return errors.New("failed to parse bulk operation webhook payload")
+	}
+
+	store, err := s.StoreRepo.GetStoreByShopifyDomain(shopDomain)
+	if err != nil || store == nil {
+		log.Error("Failed to find store for shop domain %s: %v", shopDomain, err)
+		return errors.New("invalid store domain")
+	}
+
+	log.Info("Bulk operation %s finished with status %s in shop %s", operation.AdminGraphQLAPIID, operation.Status, shopDomain)
+	return s.CatalogSyncService.FinishBulkOperation(ctx, store, operation.AdminGraphQLAPIID)
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+
+	"github.com/google/uuid"
+)
+
+// netDeltas returns the non-zero deltas, which are the ones applyStockDeltas applies.
+func netDeltas(d *stockDeltas) map[string]int {
+	net := make(map[string]int)
+	for sku, delta := range d.deltas {
+		if delta != 0 {
+			net[sku] = delta
+		}
+	}
+	return net
+}
+
+func TestOrderDeltas(t *testing.T) {
+	var order shopifyOrder
+	err := json.Unmarshal([]byte(`{
+		"location_id": 7,
+		"line_items": [
+			{"id": 1, "sku": "SHIRT-S", "quantity": 2},
+			{"id": 2, "sku": "SHIRT-M", "quantity": 1},
+			{"id": 3, "sku": "", "quantity": 4},
+			{"id": 4, "sku": "SHIRT-L", "quantity": 1}
+		],
+		"fulfillments": [{"location_id": 9, "line_items": [{"id": 2}]}]
+	}`), &order)
+	if err != nil {
+		t.Fatalf("failed to parse order: %v", err)
+	}
+
+	// A fulfillment says where an item left, over the location it was assigned to
+	deltas := orderDeltas(order, map[int64]string{2: "5", 4: "8"})
+	if got, want := netDeltas(deltas), map[string]int{"SHIRT-S": -2, "SHIRT-M": -1, "SHIRT-L": -1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected deltas %v, got %v", want, got)
+	}
+	if got, want := deltas.locations, map[string]string{"SHIRT-S": "7", "SHIRT-M": "9", "SHIRT-L": "8"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected locations %v, got %v", want, got)
+	}
+}
+
+func TestRefundDeltas(t *testing.T) {
+	var refund shopifyRefund
+	err := json.Unmarshal([]byte(`{"refund_line_items": [
+		{"quantity": 1, "restock_type": "return", "line_item": {"sku": "SHIRT-S"}},
+		{"quantity": 2, "restock_type": "legacy_restock", "line_item": {"sku": "SHIRT-S"}},
+		{"quantity": 1, "restock_type": "no_restock", "line_item": {"sku": "SHIRT-M"}},
+		{"quantity": 3, "restock_type": "cancel", "line_item": {"sku": "SHIRT-L"}}
+	]}`), &refund)
+	if err != nil {
+		t.Fatalf("failed to parse refund: %v", err)
+	}
+
+	if got, want := netDeltas(refundDeltas(refund)), map[string]int{"SHIRT-S": 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected deltas %v, got %v", want, got)
+	}
+}
+
+func TestCancellationDeltas(t *testing.T) {
+	var order struct {
+		Refunds []shopifyRefund `json:"refunds"`
+	}
+	err := json.Unmarshal([]byte(`{"refunds": [
+		{"refund_line_items": [
+			{"quantity": 2, "restock_type": "cancel", "line_item": {"sku": "SHIRT-S"}},
+			{"quantity": 1, "restock_type": "no_restock", "line_item": {"sku": "SHIRT-M"}}
+		]},
+		{"refund_line_items": [
+			{"quantity": 1, "restock_type": "cancel", "line_item": {"sku": "SHIRT-S"}},
+			{"quantity": 1, "restock_type": "return", "line_item": {"sku": "SHIRT-L"}}
+		]}
+	]}`), &order)
+	if err != nil {
+		t.Fatalf("failed to parse order: %v", err)
+	}
+
+	if got, want := netDeltas(cancellationDeltas(order.Refunds)), map[string]int{"SHIRT-S": 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected deltas %v, got %v", want, got)
+	}
+}
+
+func TestOrderEditDeltas(t *testing.T) {
+	var edit shopifyOrderEdit
+	err := json.Unmarshal([]byte(`{
+		"order_id": 100,
+		"created_at": "2024-05-01T10:00:00-04:00",
+		"line_items": {
+			"additions": [{"id": 1, "delta": 2}],
+			"removals": [{"id": 2, "delta": 3}, {"id": 3, "delta": 1}, {"id": 4, "delta": 2}]
+		}
+	}`), &edit)
+	if err != nil {
+		t.Fatalf("failed to parse order edit: %v", err)
+	}
+	if edit.OrderID != 100 || !edit.CreatedAt.Equal(time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected order edit %+v", edit)
+	}
+
+	skus := map[int64]string{1: "SHIRT-S", 2: "SHIRT-M", 3: "SHIRT-L", 4: "SHIRT-XL"}
+	tests := []struct {
+		name      string
+		restocked map[int64]int
+		want      map[string]int
+	}{
+		{
+			name: "nothing restocked",
+			want: map[string]int{"SHIRT-S": -2},
+		},
+		{
+			name:      "everything restocked",
+			restocked: map[int64]int{2: 3, 3: 1, 4: 2},
+			want:      map[string]int{"SHIRT-S": -2, "SHIRT-M": 3, "SHIRT-L": 1, "SHIRT-XL": 2},
+		},
+		{
+			name:      "part restocked",
+			restocked: map[int64]int{2: 1, 4: 5},
+			want:      map[string]int{"SHIRT-S": -2, "SHIRT-M": 1, "SHIRT-XL": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := netDeltas(orderEditDeltas(edit, skus, tt.restocked)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected deltas %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProductVariants(t *testing.T) {
+	var product shopifyProduct
+	err := json.Unmarshal([]byte(`{"variants": [
+		{"sku": "SHIRT-S", "inventory_item_id": 42867537936571},
+		{"sku": "SHIRT-M", "inventory_item_id": null}
+	]}`), &product)
+	if err != nil {
+		t.Fatalf("failed to parse product: %v", err)
+	}
+	if len(product.Variants) != 2 || product.Variants[0].InventoryItemID != 42867537936571 || product.Variants[1].InventoryItemID != 0 {
+		t.Fatalf("expected the numeric inventory item IDs, got %+v", product.Variants)
+	}
+}
+
+func TestWebhookRejectionsAreCountedPerMinute(t *testing.T) {
+	db := openTestDB(t)
+	repo := repositories.NewWebhookRejectionRepository(db)
+	shopDomain := "forged-" + uuid.NewString() + ".myshopify.com"
+
+	for i := 0; i < 3; i++ {
+		err := repo.RecordWebhookRejection(&models.WebhookRejection{ShopDomain: shopDomain, Reason: "invalid HMAC signature"})
+		if err != nil {
+			t.Fatalf("RecordWebhookRejection failed: %v", err)
+		}
+	}
+	err := repo.RecordWebhookRejection(&models.WebhookRejection{ShopDomain: shopDomain, Reason: "unknown shop domain"})
+	if err != nil {
+		t.Fatalf("RecordWebhookRejection failed: %v", err)
+	}
+
+	// A minute may have started between the calls, so sum the rows of each reason
+	var counts []struct {
+		Reason string
+		Total  int
+	}
+	err = db.Model(&models.WebhookRejection{}).Select("reason, SUM(count) AS total").
+		Where("shop_domain = ?", shopDomain).Group("reason").Order("reason").Scan(&counts).Error
+	if err != nil {
+		t.Fatalf("failed to count rejections: %v", err)
+	}
+	if len(counts) != 2 || counts[0].Total != 3 || counts[1].Total != 1 {
+		t.Fatalf("expected 3 invalid signatures and 1 unknown shop, got %+v", counts)
+	}
+
+	var rows int64
+	db.Model(&models.WebhookRejection{}).Where("shop_domain = ?", shopDomain).Count(&rows)
+	if rows > 3 {
+		t.Fatalf("expected the rejections to share rows, got %d rows", rows)
+	}
+}
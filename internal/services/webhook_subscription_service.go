@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job types for webhook subscription management.
+const (
+	JobTypeWebhookRepair    = "shopify.webhook_repair"
+	JobTypeWebhookRepairAll = "shopify.webhook_repair_all"
+)
+
+var ErrPublicURLNotConfigured = errors.New("PUBLIC_URL is not configured, webhooks cannot be subscribed")
+
+// RequiredWebhook is a webhook topic every store must deliver, with the path of the route
+// that receives it.
+type RequiredWebhook struct {
+	Topic string
+	Path  string
+}
+
+// RequiredWebhooks are the topics the stock sync depends on.
+var RequiredWebhooks = []RequiredWebhook{
+	{Topic: "ORDERS_CREATE", Path: "/webhook/orders"},
+	{Topic: "ORDERS_CANCELLED", Path: "/webhook/orders/cancelled"},
+	{Topic: "ORDERS_EDITED", Path: "/webhook/orders/edited"},
+	{Topic: "REFUNDS_CREATE", Path: "/webhook/refunds"},
+	{Topic: "PRODUCTS_CREATE", Path: "/webhook/products"},
+	{Topic: "PRODUCTS_UPDATE", Path: "/webhook/products"},
+	{Topic: "INVENTORY_LEVELS_UPDATE", Path: "/webhook/inventory_levels"},
+	{Topic: "BULK_OPERATIONS_FINISH", Path: "/webhook/bulk_operations"},
+}
+
+// WebhookRepairPayload is the job payload for JobTypeWebhookRepair.
+type WebhookRepairPayload struct {
+	StoreID uuid.UUID `json:"store_id"`
+}
+
+// WebhookSubscriptionService makes sure every store has exactly the required webhook
+// subscriptions, pointing at the API's public URL.
+type WebhookSubscriptionService struct {
+	StoreRepo  *repositories.StoreRepository
+	HealthRepo *repositories.WebhookHealthRepository
+	JobService *JobService
+
+	// PublicURL is where Shopify reaches the API, such as https://api.example.com
+	PublicURL string
+}
+
+// NewWebhookSubscriptionService creates the service and schedules a repair of every store's
+// subscriptions each interval. A zero interval leaves repairs to the API and new installs.
+func NewWebhookSubscriptionService(
+	storeRepo *repositories.StoreRepository,
+	healthRepo *repositories.WebhookHealthRepository,
+	jobService *JobService,
+	publicURL string,
+	interval time.Duration,
+) *WebhookSubscriptionService {
+	s := &WebhookSubscriptionService{
+		StoreRepo:  storeRepo,
+		HealthRepo: healthRepo,
+		JobService: jobService,
+		PublicURL:  strings.TrimRight(publicURL, "/"),
+	}
+
+	jobService.RegisterHandler(JobTypeWebhookRepair, s.ProcessRepairJob)
+	jobService.RegisterHandler(JobTypeWebhookRepairAll, s.ProcessRepairAllJob)
+	if s.PublicURL != "" {
+		jobService.Schedule(JobTypeWebhookRepairAll, struct{}{}, interval)
+	}
+
+	return s
+}
+
+// ProcessRepairAllJob is the job handler that queues a repair of every store's subscriptions.
+func (s *WebhookSubscriptionService) ProcessRepairAllJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	stores, err := s.StoreRepo.GetAllStores()
+	if err != nil {
+		return err
+	}
+
+	for _, store := range stores {
+		if _, err := s.JobService.Enqueue(JobTypeWebhookRepair, WebhookRepairPayload{StoreID: store.ID}); err != nil {
+			return err
+		}
+	}
+	log.Info("Queued webhook subscription repair of %d stores", len(stores))
+	return nil
+}
+
+// ProcessRepairJob is the job handler that repairs the subscriptions of a single store.
+func (s *WebhookSubscriptionService) ProcessRepairJob(ctx context.Context, job *models.Job) error {
+	log := logger.GetLogger()
+
+	var payload WebhookRepairPayload
+	if err := DecodeJobPayload(job, &payload); err != nil {
+		return err
+	}
+
+	store, err := s.StoreRepo.GetStoreByID(payload.StoreID.String())
+	if err != nil {
+		// The store has been removed since, so there is nothing left to repair
+		log.Error("Dropping webhook repair job %s, store %s not found: %v", job.ID, payload.StoreID, err)
+		return nil
+	}
+
+	_, err = s.SyncStore(ctx, store, true)
+	return err
+}
+
+// RepairStore checks and repairs the subscriptions of a store owned by the company.
+func (s *WebhookSubscriptionService) RepairStore(ctx context.Context, companyID, storeID string) (*models.WebhookHealth, error) {
+	store, err := s.StoreRepo.GetCompanyStore(companyID, storeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.SyncStore(ctx, store, true)
+}
+
+// StoreWebhookHealth is the webhook subscription health of a store. Health is nil until the
+// store's subscriptions have been checked.
+type StoreWebhookHealth struct {
+	StoreID uuid.UUID             `json:"store_id"`
+	Shop    string                `json:"shop"`
+	Health  *models.WebhookHealth `json:"health"`
+}
+
+// GetWebhookHealth returns the last subscription check of each of the company's stores.
+func (s *WebhookSubscriptionService) GetWebhookHealth(companyID string) ([]StoreWebhookHealth, error) {
+	stores, err := s.StoreRepo.GetStoresByCompany(companyID)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := s.HealthRepo.GetWebhookHealthByCompany(companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	byStore := make(map[uuid.UUID]*models.WebhookHealth, len(checks))
+	for i := range checks {
+		byStore[checks[i].StoreID] = &checks[i]
+	}
+
+	health := make([]StoreWebhookHealth, 0, len(stores))
+	for _, store := range stores {
+		health = append(health, StoreWebhookHealth{StoreID: store.ID, Shop: store.ShopifyStoreStub, Health: byStore[store.ID]})
+	}
+	return health, nil
+}
+
+// SyncStore compares the store's webhook subscriptions with the required ones and stores the
+// result. With repair, missing subscriptions are created and all others deleted, so the store
+// is left with exactly the required topics pointing at the public URL.
+func (s *WebhookSubscriptionService) SyncStore(ctx context.Context, store *models.Store, repair bool) (*models.WebhookHealth, error) {
+	log := logger.GetLogger()
+
+	if s.PublicURL == "" {
+		return nil, ErrPublicURLNotConfigured
+	}
+
+	health := &models.WebhookHealth{
+		StoreID:       store.ID,
+		CompanyID:     store.CompanyID,
+		Missing:       []string{},
+		Misconfigured: []models.WebhookIssue{},
+		CheckedAt:     time.Now(),
+	}
+
+	client := newStoreClient(store)
+	subscriptions, err := client.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		log.Error("Failed to list webhook subscriptions of store %s: %v", store.ShopifyStoreStub, err)
+		health.LastError = err.Error()
+		return health, s.saveHealth(health, err)
+	}
+
+	required := make(map[string]bool, len(RequiredWebhooks))
+	for _, webhook := range RequiredWebhooks {
+		required[webhook.Topic+" "+s.PublicURL+webhook.Path] = true
+	}
+
+	// Keep the first subscription of each required topic and URL, everything else is misconfigured
+	found := make(map[string]bool, len(subscriptions))
+	for _, subscription := range subscriptions {
+		key := subscription.Topic + " " + subscription.CallbackURL
+		problem := ""
+		switch {
+		case found[key]:
+			problem = "duplicate subscription"
+		case required[key]:
+			found[key] = true
+			continue
+		case !strings.HasPrefix(subscription.CallbackURL, s.PublicURL+"/"):
+			problem = "callback URL is not the API's public URL"
+		default:
+			problem = "topic is not required at this URL"
+		}
+		health.Misconfigured = append(health.Misconfigured, models.WebhookIssue{
+			SubscriptionID: subscription.ID,
+			Topic:          subscription.Topic,
+			CallbackURL:    subscription.CallbackURL,
+			Problem:        problem,
+		})
+	}
+
+	var missing []RequiredWebhook
+	for _, webhook := range RequiredWebhooks {
+		if !found[webhook.Topic+" "+s.PublicURL+webhook.Path] {
+			missing = append(missing, webhook)
+			health.Missing = append(health.Missing, webhook.Topic)
+		}
+	}
+
+	if repair {
+		s.repair(ctx, store, health, missing)
+	}
+
+	health.Healthy = len(health.Missing) == 0 && len(health.Misconfigured) == 0
+	if !health.Healthy {
+		log.Error("Webhook subscriptions of store %s are unhealthy: %d missing, %d misconfigured",
+			store.ShopifyStoreStub, len(health.Missing), len(health.Misconfigured))
+	}
+	return health, s.saveHealth(health, nil)
+}
+
+// repair creates the missing subscriptions before deleting the misconfigured ones, so a
+// subscription moving to a new URL never stops delivering. Whatever could not be repaired
+// is left in health.
+func (s *WebhookSubscriptionService) repair(ctx context.Context, store *models.Store, health *models.WebhookHealth, missing []RequiredWebhook) {
+	log := logger.GetLogger()
+	client := newStoreClient(store)
+
+	var errs []string
+	health.Missing = []string{}
+	for _, webhook := range missing {
+		_, err := client.CreateWebhookSubscription(ctx, webhook.Topic, s.PublicURL+webhook.Path)
+		if err != nil {
+			log.Error("Failed to subscribe store %s to webhook %s: %v", store.ShopifyStoreStub, webhook.Topic, err)
+			health.Missing = append(health.Missing, webhook.Topic)
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Info("Subscribed store %s to webhook %s", store.ShopifyStoreStub, webhook.Topic)
+		health.Repaired++
+	}
+
+	remaining := []models.WebhookIssue{}
+	for _, issue := range health.Misconfigured {
+		if err := client.DeleteWebhookSubscription(ctx, issue.SubscriptionID); err != nil {
+			log.Error("Failed to delete webhook subscription %s of store %s: %v", issue.SubscriptionID, store.ShopifyStoreStub, err)
+			remaining = append(remaining, issue)
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Info("Deleted webhook subscription %s (%s) of store %s: %s", issue.SubscriptionID, issue.Topic, store.ShopifyStoreStub, issue.Problem)
+		health.Repaired++
+	}
+	health.Misconfigured = remaining
+	health.LastError = strings.Join(errs, "; ")
+}
+
+// saveHealth stores a check and returns checkErr, or the error of storing the check.
+func (s *WebhookSubscriptionService) saveHealth(health *models.WebhookHealth, checkErr error) error {
+	if err := s.HealthRepo.SaveWebhookHealth(health); err != nil {
+		logger.GetLogger().Error("Failed to store webhook health of store %s: %v", health.StoreID, err)
+		if checkErr == nil {
+			return err
+		}
+	}
+	return checkErr
+}
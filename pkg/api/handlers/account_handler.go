@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterAccountRoutes registers email verification and password reset, which are used
+// without being signed in.
+func RegisterAccountRoutes(r *mux.Router, accountService *services.AccountService) {
+	r.HandleFunc("/auth/verify-email", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/auth/verify-email/resend", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/auth/password/forgot", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/auth/password/reset", HandleOptions).Methods(http.MethodOptions)
+
+	r.HandleFunc("/auth/verify-email", func(w http.ResponseWriter, r *http.Request) {
+		VerifyEmail(w, r, accountService)
+	}).Methods(http.MethodPost)
+
+	r.HandleFunc("/auth/verify-email/resend", func(w http.ResponseWriter, r *http.Request) {
+		ResendVerification(w, r, accountService)
+	}).Methods(http.MethodPost)
+
+	r.HandleFunc("/auth/password/forgot", func(w http.ResponseWriter, r *http.Request) {
+		ForgotPassword(w, r, accountService)
+	}).Methods(http.MethodPost)
+
+	r.HandleFunc("/auth/password/reset", func(w http.ResponseWriter, r *http.Request) {
+		ResetPassword(w, r, accountService)
+	}).Methods(http.MethodPost)
+}
+
+// VerifyEmail verifies the email address of a user from the token of a verification link.
+func VerifyEmail(w http.ResponseWriter, r *http.Request, accountService *services.AccountService) {
+	log := logger.GetLogger()
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := accountService.VerifyEmail(req.Token)
+	if errors.Is(err, services.ErrInvalidAccountToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify email address", http.StatusInternalServerError)
+		log.Error("Error verifying email address: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// ResendVerification sends a new verification link. The response is the same whether or not
+// the address belongs to an account.
+func ResendVerification(w http.ResponseWriter, r *http.Request, accountService *services.AccountService) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	acceptEmailRequest(w, "verification", accountService.ResendVerification(req.Email))
+}
+
+// ForgotPassword sends a password reset link. The response is the same whether or not the
+// address belongs to an account.
+func ForgotPassword(w http.ResponseWriter, r *http.Request, accountService *services.AccountService) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	acceptEmailRequest(w, "password reset", accountService.RequestPasswordReset(req.Email))
+}
+
+// ResetPassword sets a new password from the token of a password reset link.
+func ResetPassword(w http.ResponseWriter, r *http.Request, accountService *services.AccountService) {
+	log := logger.GetLogger()
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := accountService.ResetPassword(req.Token, req.Password)
+	switch {
+	case errors.Is(err, services.ErrPasswordTooShort), errors.Is(err, services.ErrInvalidAccountToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		log.Error("Error resetting password: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptEmailRequest answers a request to email an address. Being rate limited is answered
+// like success, as refusing would tell that the address belongs to an account.
+func acceptEmailRequest(w http.ResponseWriter, kind string, err error) {
+	log := logger.GetLogger()
+
+	if errors.Is(err, services.ErrTooManyEmails) {
+		log.Info("Not sending %s email, too many were sent recently", kind)
+	} else if err != nil {
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		log.Error("Error sending %s email: %v", kind, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
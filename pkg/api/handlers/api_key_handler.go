@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/models"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterAPIKeyRoutes(r *mux.Router, apiKeyService *services.APIKeyService) {
+	r.HandleFunc("/apikeys", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/apikeys/{id}", HandleOptions).Methods(http.MethodOptions)
+
+	r.HandleFunc("/apikeys", func(w http.ResponseWriter, r *http.Request) {
+		CreateAPIKey(w, r, apiKeyService)
+	}).Methods(http.MethodPost)
+
+	r.HandleFunc("/apikeys", func(w http.ResponseWriter, r *http.Request) {
+		ListAPIKeys(w, r, apiKeyService)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/apikeys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		RevokeAPIKey(w, r, apiKeyService)
+	}).Methods(http.MethodDelete)
+}
+
+// CreateAPIKey creates an API key of the company. The response carries the key, which is not
+// shown again.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request, apiKeyService *services.APIKeyService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+	userID, ok := r.Context().Value("user_id").(string)
+	if !ok || userID == "" {
+		http.Error(w, "API keys can only be created by a signed in user", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Name      string              `json:"name"`
+		Scopes    []models.Permission `json:"scopes"`
+		ExpiresAt *time.Time          `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	apiKey, key, err := apiKeyService.CreateAPIKey(companyID, userID, req.Name, req.Scopes, req.ExpiresAt)
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNameNeeded), errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidExpiry):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		log.Error("Error creating API key: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*models.APIKey
+		Key string `json:"key"`
+	}{apiKey, key})
+}
+
+// ListAPIKeys returns the company's API keys that were not revoked, without the keys themselves.
+func ListAPIKeys(w http.ResponseWriter, r *http.Request, apiKeyService *services.APIKeyService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	keys, err := apiKeyService.GetAPIKeys(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve API keys", http.StatusInternalServerError)
+		log.Error("Error retrieving API keys: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey revokes an API key so it can no longer be used.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request, apiKeyService *services.APIKeyService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	err := apiKeyService.RevokeAPIKey(companyID, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		log.Error("Error revoking API key: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"gostockly/internal/services"
 	"log"
 	"net/http"
@@ -9,7 +10,7 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func RegisterAuthRoutes(r *mux.Router, userService *services.UserService) {
+func RegisterAuthRoutes(r *mux.Router, userService *services.UserService, sessionService *services.SessionService) {
 	r.HandleFunc("/auth/register", HandleOptions).Methods(http.MethodOptions)
 	r.HandleFunc("/auth/register", func(w http.ResponseWriter, r *http.Request) {
 		RegisterUser(w, r, userService)
@@ -19,6 +20,23 @@ func RegisterAuthRoutes(r *mux.Router, userService *services.UserService) {
 	r.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
 		LoginUser(w, r, userService)
 	}).Methods("POST")
+
+	r.HandleFunc("/auth/refresh", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		RefreshSession(w, r, sessionService)
+	}).Methods("POST")
+
+	r.HandleFunc("/auth/logout", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		Logout(w, r, sessionService)
+	}).Methods("POST")
+}
+
+// sessionResponse is a token pair. The access token is also given as token, for clients
+// written before sessions could be refreshed.
+type sessionResponse struct {
+	Token string `json:"token"`
+	*services.TokenPair
 }
 
 type RegisterUserRequest struct {
@@ -28,6 +46,7 @@ type RegisterUserRequest struct {
 	Subdomain   string `json:"subdomain"`
 }
 
+// RegisterUser creates a new company and the account of its owner.
 func RegisterUser(w http.ResponseWriter, r *http.Request, userService *services.UserService) {
 	var req RegisterUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -37,8 +56,15 @@ func RegisterUser(w http.ResponseWriter, r *http.Request, userService *services.
 	}
 
 	user, err := userService.RegisterUser(req.Email, req.Password, req.CompanyName, req.Subdomain)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail), errors.Is(err, services.ErrPasswordTooShort), errors.Is(err, services.ErrCompanyDetailsNeeded):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrEmailTaken), errors.Is(err, services.ErrSubdomainTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to register", http.StatusInternalServerError)
 		log.Printf("Error registering user: %v", err)
 		return
 	}
@@ -60,7 +86,11 @@ func LoginUser(w http.ResponseWriter, r *http.Request, userService *services.Use
 		return
 	}
 
-	token, err := userService.AuthenticateUser(req.Email, req.Password)
+	tokens, err := userService.AuthenticateUser(req.Email, req.Password)
+	if errors.Is(err, services.ErrEmailNotVerified) {
+		http.Error(w, "Verify your email address before signing in", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		log.Printf("Error authenticating user: %v", err)
@@ -68,5 +98,57 @@ func LoginUser(w http.ResponseWriter, r *http.Request, userService *services.Use
 	}
 
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+	_ = json.NewEncoder(w).Encode(sessionResponse{Token: tokens.AccessToken, TokenPair: tokens})
+}
+
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshSession exchanges a refresh token for a new access token and refresh token.
+func RefreshSession(w http.ResponseWriter, r *http.Request, sessionService *services.SessionService) {
+	var req RefreshSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := sessionService.Refresh(req.RefreshToken)
+	switch {
+	case errors.Is(err, services.ErrInvalidRefreshToken), errors.Is(err, services.ErrRefreshTokenReused):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		log.Printf("Error refreshing session: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(sessionResponse{Token: tokens.AccessToken, TokenPair: tokens})
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	// Everywhere ends every session of the user instead of only this one
+	Everywhere bool `json:"everywhere"`
+}
+
+// Logout revokes the session of a refresh token. Logging out with an unknown token succeeds,
+// as there is no session left to end.
+func Logout(w http.ResponseWriter, r *http.Request, sessionService *services.SessionService) {
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := sessionService.Logout(req.RefreshToken, req.Everywhere)
+	if err != nil && !errors.Is(err, services.ErrInvalidRefreshToken) {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		log.Printf("Error logging out: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
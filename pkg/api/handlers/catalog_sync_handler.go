@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultSyncLimit is how many catalog syncs are returned when no limit is given.
+const defaultSyncLimit = 20
+
+func RegisterCatalogSyncRoutes(r *mux.Router, catalogSyncService *services.CatalogSyncService) {
+	syncRouter := r.PathPrefix("/stores/{id}/syncs").Subrouter()
+
+	syncRouter.HandleFunc("", HandleOptions).Methods(http.MethodOptions)
+
+	syncRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		StartCatalogSync(w, r, catalogSyncService)
+	}).Methods(http.MethodPost)
+
+	syncRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		ListCatalogSyncs(w, r, catalogSyncService)
+	}).Methods(http.MethodGet)
+}
+
+// StartCatalogSync queues a full import of a store's catalog through a Shopify bulk operation.
+func StartCatalogSync(w http.ResponseWriter, r *http.Request, catalogSyncService *services.CatalogSyncService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	storeID := mux.Vars(r)["id"]
+	sync, err := catalogSyncService.QueueStoreSync(companyID, storeID)
+	if errors.Is(err, services.ErrStoreNotFound) {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start catalog sync", http.StatusInternalServerError)
+		log.Error("Error starting catalog sync for store %s: %v", storeID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(sync)
+}
+
+func ListCatalogSyncs(w http.ResponseWriter, r *http.Request, catalogSyncService *services.CatalogSyncService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	limit := defaultSyncLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	syncs, err := catalogSyncService.GetStoreSyncs(companyID, mux.Vars(r)["id"], limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve catalog syncs", http.StatusInternalServerError)
+		log.Error("Error retrieving catalog syncs: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(syncs)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterDeadLetterRoutes(r *mux.Router, adjustmentService *services.AdjustmentService) {
+	deadLetterRouter := r.PathPrefix("/deadletters").Subrouter()
+
+	deadLetterRouter.HandleFunc("", HandleOptions).Methods(http.MethodOptions)
+	deadLetterRouter.HandleFunc("/{id}", HandleOptions).Methods(http.MethodOptions)
+	deadLetterRouter.HandleFunc("/{id}/retry", HandleOptions).Methods(http.MethodOptions)
+
+	deadLetterRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		ListDeadLetters(w, r, adjustmentService)
+	}).Methods(http.MethodGet)
+
+	deadLetterRouter.HandleFunc("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		GetDeadLetterByID(w, r, adjustmentService)
+	}).Methods(http.MethodGet)
+
+	deadLetterRouter.HandleFunc("/{id}/retry", func(w http.ResponseWriter, r *http.Request) {
+		RetryDeadLetter(w, r, adjustmentService)
+	}).Methods(http.MethodPost)
+
+	deadLetterRouter.HandleFunc("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		DiscardDeadLetter(w, r, adjustmentService)
+	}).Methods(http.MethodDelete)
+}
+
+func ListDeadLetters(w http.ResponseWriter, r *http.Request, adjustmentService *services.AdjustmentService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	deadLetters, err := adjustmentService.GetDeadLettersByCompany(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve dead letters", http.StatusInternalServerError)
+		log.Error("Error retrieving dead letters: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deadLetters)
+}
+
+func GetDeadLetterByID(w http.ResponseWriter, r *http.Request, adjustmentService *services.AdjustmentService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	deadLetter, err := adjustmentService.GetDeadLetterByID(companyID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deadLetter)
+}
+
+func RetryDeadLetter(w http.ResponseWriter, r *http.Request, adjustmentService *services.AdjustmentService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	deadLetterID := mux.Vars(r)["id"]
+	job, err := adjustmentService.RetryDeadLetter(companyID, deadLetterID)
+	if errors.Is(err, services.ErrDeadLetterNotFound) {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retry dead letter", http.StatusInternalServerError)
+		log.Error("Error retrying dead letter %s: %v", deadLetterID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"job_id": job.ID.String()})
+}
+
+func DiscardDeadLetter(w http.ResponseWriter, r *http.Request, adjustmentService *services.AdjustmentService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	deadLetterID := mux.Vars(r)["id"]
+	err := adjustmentService.DiscardDeadLetter(companyID, deadLetterID)
+	if errors.Is(err, services.ErrDeadLetterNotFound) {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to discard dead letter", http.StatusInternalServerError)
+		log.Error("Error discarding dead letter %s: %v", deadLetterID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
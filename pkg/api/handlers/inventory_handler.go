@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"gostockly/internal/services"
+	"gostockly/pkg/logger"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -19,13 +21,20 @@ func RegisterInventoryRoutes(r *mux.Router, service *services.InventoryService)
 }
 
 func (h *InventoryHandler) DecrementInventory(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
 	var req struct {
 		SKUs       []string `json:"skus"`
 		Amount     int      `json:"amount"`
 		StoreID    string   `json:"store_id"`
 		LocationID string   `json:"location_id"`
-		APIKey     string   `json:"api_key"`
-		APISecret  string   `json:"api_secret"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -40,17 +49,17 @@ func (h *InventoryHandler) DecrementInventory(w http.ResponseWriter, r *http.Req
 	}
 
 	if len(req.SKUs) == 1 {
-		err := h.InventoryService.DecrementSingleSKU(req.SKUs[0], req.Amount, storeID, req.LocationID, req.APIKey, req.APISecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		err = h.InventoryService.DecrementSingleSKU(r.Context(), companyID, req.SKUs[0], req.Amount, storeID, req.LocationID)
 	} else {
-		err := h.InventoryService.DecrementBulkSKUs(req.SKUs, req.Amount, storeID, req.LocationID, req.APIKey, req.APISecret)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		err = h.InventoryService.DecrementBulkSKUs(r.Context(), companyID, req.SKUs, req.Amount, storeID, req.LocationID)
+	}
+	if errors.Is(err, services.ErrStoreNotFound) {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/models"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterInvitationRoutes registers the management of invitations on the protected router and
+// accepting an invitation, which is done before the invitee has an account, on the public router.
+func RegisterInvitationRoutes(protected, public *mux.Router, invitationService *services.InvitationService) {
+	protected.HandleFunc("/invitations", HandleOptions).Methods(http.MethodOptions)
+	protected.HandleFunc("/invitations/{id}", HandleOptions).Methods(http.MethodOptions)
+
+	protected.HandleFunc("/invitations", func(w http.ResponseWriter, r *http.Request) {
+		CreateInvitation(w, r, invitationService)
+	}).Methods(http.MethodPost)
+
+	protected.HandleFunc("/invitations", func(w http.ResponseWriter, r *http.Request) {
+		ListInvitations(w, r, invitationService)
+	}).Methods(http.MethodGet)
+
+	protected.HandleFunc("/invitations/{id}", func(w http.ResponseWriter, r *http.Request) {
+		RevokeInvitation(w, r, invitationService)
+	}).Methods(http.MethodDelete)
+
+	public.HandleFunc("/auth/invitations/accept", HandleOptions).Methods(http.MethodOptions)
+	public.HandleFunc("/auth/invitations/accept", func(w http.ResponseWriter, r *http.Request) {
+		AcceptInvitation(w, r, invitationService)
+	}).Methods(http.MethodPost)
+}
+
+// CreateInvitation invites someone to the company with a role and emails them the invitation.
+func CreateInvitation(w http.ResponseWriter, r *http.Request, invitationService *services.InvitationService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req struct {
+		Email string      `json:"email"`
+		Role  models.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := invitationService.CreateInvitation(companyID, userID, req.Email, req.Role)
+	switch {
+	case errors.Is(err, services.ErrInvalidEmail), errors.Is(err, services.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrInvitationNotSent):
+		http.Error(w, "Failed to send invitation email", http.StatusBadGateway)
+		log.Error("Error sending invitation: %v", err)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		log.Error("Error creating invitation: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// ListInvitations returns the company's invitations that have not been accepted or expired.
+func ListInvitations(w http.ResponseWriter, r *http.Request, invitationService *services.InvitationService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	invitations, err := invitationService.GetPendingInvitations(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve invitations", http.StatusInternalServerError)
+		log.Error("Error retrieving invitations: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// RevokeInvitation deletes an invitation so it can no longer be accepted.
+func RevokeInvitation(w http.ResponseWriter, r *http.Request, invitationService *services.InvitationService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	err := invitationService.RevokeInvitation(companyID, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrInvitationNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+		log.Error("Error revoking invitation: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitation creates the account of an invitee from the invitation token and a password.
+func AcceptInvitation(w http.ResponseWriter, r *http.Request, invitationService *services.InvitationService) {
+	log := logger.GetLogger()
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := invitationService.AcceptInvitation(req.Token, req.Password)
+	switch {
+	case errors.Is(err, services.ErrPasswordTooShort):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrInvalidInvitation):
+		http.Error(w, "Invalid or expired invitation", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+		log.Error("Error accepting invitation: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
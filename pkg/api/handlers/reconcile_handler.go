@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultReportLimit is how many reconciliation reports are returned when no limit is given.
+const defaultReportLimit = 20
+
+func RegisterReconcileRoutes(r *mux.Router, reconcileService *services.ReconcileService) {
+	stockGroupRouter := r.PathPrefix("/stockgroups/{id}").Subrouter()
+
+	stockGroupRouter.HandleFunc("/reconcile", HandleOptions).Methods(http.MethodOptions)
+	stockGroupRouter.HandleFunc("/reconciliations", HandleOptions).Methods(http.MethodOptions)
+
+	stockGroupRouter.HandleFunc("/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		ReconcileStockGroup(w, r, reconcileService)
+	}).Methods(http.MethodPost)
+
+	stockGroupRouter.HandleFunc("/reconciliations", func(w http.ResponseWriter, r *http.Request) {
+		GetReconciliationReports(w, r, reconcileService)
+	}).Methods(http.MethodGet)
+}
+
+// ReconcileStockGroup compares every store of a stock group and returns the drift report.
+// Drifted stores are only corrected when auto_correct=true is passed.
+func ReconcileStockGroup(w http.ResponseWriter, r *http.Request, reconcileService *services.ReconcileService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	autoCorrect := false
+	if value := r.URL.Query().Get("auto_correct"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid auto_correct", http.StatusBadRequest)
+			return
+		}
+		autoCorrect = parsed
+	}
+
+	stockGroupID := mux.Vars(r)["id"]
+	report, err := reconcileService.ReconcileStockGroup(r.Context(), companyID, stockGroupID, autoCorrect)
+	if errors.Is(err, services.ErrStockGroupNotFound) {
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to reconcile stock group", http.StatusBadGateway)
+		log.Error("Error reconciling stock group %s: %v", stockGroupID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+func GetReconciliationReports(w http.ResponseWriter, r *http.Request, reconcileService *services.ReconcileService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	limit := defaultReportLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	reports, err := reconcileService.GetReports(companyID, mux.Vars(r)["id"], limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve reconciliation reports", http.StatusInternalServerError)
+		log.Error("Error retrieving reconciliation reports: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reports)
+}
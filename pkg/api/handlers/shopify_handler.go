@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/shopify"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterShopifyRoutes(r *mux.Router, storeService *services.StoreService) {
+	shopifyRouter := r.PathPrefix("/shopify").Subrouter()
+
+	shopifyRouter.HandleFunc("/ratelimits", HandleOptions).Methods(http.MethodOptions)
+	shopifyRouter.HandleFunc("/deprecations", HandleOptions).Methods(http.MethodOptions)
+
+	shopifyRouter.HandleFunc("/ratelimits", func(w http.ResponseWriter, r *http.Request) {
+		GetShopifyRateLimits(w, r, storeService)
+	}).Methods(http.MethodGet)
+
+	shopifyRouter.HandleFunc("/deprecations", func(w http.ResponseWriter, r *http.Request) {
+		GetShopifyDeprecations(w, r, storeService)
+	}).Methods(http.MethodGet)
+}
+
+// GetShopifyRateLimits returns the Shopify query cost bucket of each of the company's stores
+// and how long requests have waited for it since startup.
+func GetShopifyRateLimits(w http.ResponseWriter, r *http.Request, storeService *services.StoreService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	stores, err := storeService.GetStoresByCompany(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve stores", http.StatusInternalServerError)
+		log.Error("Error retrieving stores: %v", err)
+		return
+	}
+
+	shops := make([]string, 0, len(stores))
+	for _, store := range stores {
+		shops = append(shops, store.ShopifyStoreStub)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(shopify.RateLimits(shops...))
+}
+
+// GetShopifyDeprecations returns the company's stores whose Shopify requests were flagged as
+// using deprecated fields or API versions, so they can be moved to a newer version in time.
+func GetShopifyDeprecations(w http.ResponseWriter, r *http.Request, storeService *services.StoreService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	deprecations, err := storeService.GetDeprecatedStores(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve deprecations", http.StatusInternalServerError)
+		log.Error("Error retrieving deprecated stores: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deprecations)
+}
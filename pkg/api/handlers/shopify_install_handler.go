@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterShopifyInstallRoutes registers the start of an app install on the protected router and
+// the OAuth callback, which Shopify sends the merchant's browser to, on the public router.
+// After the callback merchants are sent back to frontendURL, if it is set.
+func RegisterShopifyInstallRoutes(protected, public *mux.Router, installService *services.ShopifyInstallService, frontendURL string) {
+	protected.HandleFunc("/shopify/install", HandleOptions).Methods(http.MethodOptions)
+
+	protected.HandleFunc("/shopify/install", func(w http.ResponseWriter, r *http.Request) {
+		BeginShopifyInstall(w, r, installService)
+	}).Methods(http.MethodGet)
+
+	public.HandleFunc("/shopify/callback", func(w http.ResponseWriter, r *http.Request) {
+		CompleteShopifyInstall(w, r, installService, frontendURL)
+	}).Methods(http.MethodGet)
+}
+
+// BeginShopifyInstall starts installing the app on the shop given by the shop query parameter
+// and returns the URL the frontend sends the merchant to for approving it. The install is tied
+// to the browser with a cookie, so the frontend has to call it with credentials included.
+func BeginShopifyInstall(w http.ResponseWriter, r *http.Request, installService *services.ShopifyInstallService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+	userID, ok := r.Context().Value("user_id").(string)
+	if !ok || userID == "" {
+		http.Error(w, "Stores can only be installed by a signed in user", http.StatusForbidden)
+		return
+	}
+
+	shop := r.URL.Query().Get("shop")
+	if shop == "" {
+		http.Error(w, "Missing shop query parameter", http.StatusBadRequest)
+		return
+	}
+
+	authorizeURL, nonce, err := installService.BeginInstall(companyID, userID, shop)
+	switch {
+	case errors.Is(err, services.ErrInvalidShopDomain):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrShopifyAppNotConfigured):
+		http.Error(w, "Installing stores through Shopify is not configured", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, "Failed to start Shopify install", http.StatusInternalServerError)
+		log.Error("Error starting Shopify install of %s: %v", shop, err)
+		return
+	}
+
+	// The callback is a top level navigation to the API, which a Lax cookie is sent with
+	http.SetCookie(w, &http.Cookie{
+		Name:     services.InstallCookieName,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(services.InstallStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(installService.OAuth.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"authorize_url": authorizeURL})
+}
+
+// CompleteShopifyInstall handles the OAuth callback Shopify redirects the merchant to after
+// approving the app.
+func CompleteShopifyInstall(w http.ResponseWriter, r *http.Request, installService *services.ShopifyInstallService, frontendURL string) {
+	log := logger.GetLogger()
+
+	var nonce string
+	if cookie, err := r.Cookie(services.InstallCookieName); err == nil {
+		nonce = cookie.Value
+	}
+	// The nonce is of no use after the callback, whether it succeeds or not
+	http.SetCookie(w, &http.Cookie{Name: services.InstallCookieName, Path: "/", MaxAge: -1, HttpOnly: true})
+
+	store, err := installService.CompleteInstall(r.Context(), r.URL.Query(), nonce)
+	if err != nil {
+		log.Error("Error completing Shopify install: %v", err)
+
+		status, message := http.StatusBadGateway, "Failed to connect the store"
+		switch {
+		case errors.Is(err, services.ErrInvalidInstallCallback):
+			status, message = http.StatusBadRequest, "Invalid or expired install link, please start the install again"
+		case errors.Is(err, services.ErrStoreOwnedElsewhere):
+			status, message = http.StatusConflict, err.Error()
+		case errors.Is(err, services.ErrMissingScopes):
+			status, message = http.StatusForbidden, err.Error()
+		case errors.Is(err, services.ErrShopifyAppNotConfigured):
+			status, message = http.StatusServiceUnavailable, "Installing stores through Shopify is not configured"
+		}
+
+		if frontendURL != "" {
+			http.Redirect(w, r, strings.TrimRight(frontendURL, "/")+"/stores?install_error="+url.QueryEscape(message), http.StatusFound)
+			return
+		}
+		http.Error(w, message, status)
+		return
+	}
+
+	if frontendURL != "" {
+		http.Redirect(w, r, strings.TrimRight(frontendURL, "/")+"/stores/"+store.ID.String()+"?installed=true", http.StatusFound)
+		return
+	}
+	// The callback is reached without a session, so only identify the store
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"store_id": store.ID.String(), "shop": store.ShopifyStoreStub})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"gostockly/internal/services"
 	"gostockly/pkg/logger"
 	"net/http"
@@ -84,19 +85,42 @@ func GetStockGroupsByCompany(w http.ResponseWriter, r *http.Request, stockGroupS
 }
 
 func GetStockGroupByID(w http.ResponseWriter, r *http.Request, stockGroupService *services.StockGroupService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
 	stockGroupID := mux.Vars(r)["id"]
 
-	stockGroup, err := stockGroupService.GetStockGroupByID(stockGroupID)
-	if err != nil {
+	stockGroup, err := stockGroupService.GetStockGroupByID(companyID, stockGroupID)
+	if errors.Is(err, services.ErrStockGroupNotFound) {
 		http.Error(w, "Stock group not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve stock group", http.StatusInternalServerError)
+		log.Error("Error retrieving stock group %s: %v", stockGroupID, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(stockGroup)
 }
 
 func UpdateStockGroup(w http.ResponseWriter, r *http.Request, stockGroupService *services.StockGroupService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
 	stockGroupID := mux.Vars(r)["id"]
 
 	var req struct {
@@ -108,9 +132,14 @@ func UpdateStockGroup(w http.ResponseWriter, r *http.Request, stockGroupService
 		return
 	}
 
-	updatedStockGroup, err := stockGroupService.UpdateStockGroup(stockGroupID, req.Name)
+	updatedStockGroup, err := stockGroupService.UpdateStockGroup(companyID, stockGroupID, req.Name)
+	if errors.Is(err, services.ErrStockGroupNotFound) {
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update stock group", http.StatusInternalServerError)
+		log.Error("Error updating stock group %s: %v", stockGroupID, err)
 		return
 	}
 
@@ -119,10 +148,25 @@ func UpdateStockGroup(w http.ResponseWriter, r *http.Request, stockGroupService
 }
 
 func DeleteStockGroup(w http.ResponseWriter, r *http.Request, stockGroupService *services.StockGroupService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
 	stockGroupID := mux.Vars(r)["id"]
 
-	if err := stockGroupService.DeleteStockGroup(stockGroupID); err != nil {
+	err := stockGroupService.DeleteStockGroup(companyID, stockGroupID)
+	if errors.Is(err, services.ErrStockGroupNotFound) {
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to delete stock group", http.StatusInternalServerError)
+		log.Error("Error deleting stock group %s: %v", stockGroupID, err)
 		return
 	}
 
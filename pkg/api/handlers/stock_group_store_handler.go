@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"gostockly/internal/services"
+	"gostockly/pkg/logger"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -18,6 +20,15 @@ func NewStockGroupStoreHandler(service *services.StockGroupStoreService) *StockG
 }
 
 func (h *StockGroupStoreHandler) AddStoreToStockGroup(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
 	var req struct {
 		StockGroupID string `json:"stock_group_id"`
 		StoreID      string `json:"store_id"`
@@ -40,9 +51,20 @@ func (h *StockGroupStoreHandler) AddStoreToStockGroup(w http.ResponseWriter, r *
 		return
 	}
 
-	err = h.StockGroupStoreService.AddStoreToStockGroup(stockGroupID, storeID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err = h.StockGroupStoreService.AddStoreToStockGroup(companyID, stockGroupID, storeID)
+	switch {
+	case errors.Is(err, services.ErrStockGroupNotFound):
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrStoreNotFound):
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrStoreAlreadyGrouped):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to add store to stock group", http.StatusInternalServerError)
+		log.Error("Error adding store %s to stock group %s: %v", storeID, stockGroupID, err)
 		return
 	}
 
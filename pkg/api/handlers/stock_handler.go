@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultMovementLimit is how many ledger movements are returned when no limit is given.
+const defaultMovementLimit = 100
+
+func RegisterStockRoutes(r *mux.Router, stockService *services.StockService) {
+	stockRouter := r.PathPrefix("/stockgroups/{id}/stock").Subrouter()
+
+	stockRouter.HandleFunc("", HandleOptions).Methods(http.MethodOptions)
+	stockRouter.HandleFunc("/{sku}", HandleOptions).Methods(http.MethodOptions)
+	stockRouter.HandleFunc("/{sku}/movements", HandleOptions).Methods(http.MethodOptions)
+
+	stockRouter.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		GetStockLevels(w, r, stockService)
+	}).Methods(http.MethodGet)
+
+	stockRouter.HandleFunc("/{sku}", func(w http.ResponseWriter, r *http.Request) {
+		RecordStockChange(w, r, stockService)
+	}).Methods(http.MethodPost)
+
+	stockRouter.HandleFunc("/{sku}/movements", func(w http.ResponseWriter, r *http.Request) {
+		GetStockMovements(w, r, stockService)
+	}).Methods(http.MethodGet)
+}
+
+func GetStockLevels(w http.ResponseWriter, r *http.Request, stockService *services.StockService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	levels, err := stockService.GetStockLevels(companyID, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(levels)
+}
+
+func GetStockMovements(w http.ResponseWriter, r *http.Request, stockService *services.StockService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	limit := defaultMovementLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	vars := mux.Vars(r)
+	movements, err := stockService.GetMovements(companyID, vars["id"], vars["sku"], limit)
+	if err != nil {
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(movements)
+}
+
+// RecordStockChange changes the canonical level of a SKU by hand, either by a delta or to an
+// absolute quantity, and pushes the new level to every store in the stock group.
+func RecordStockChange(w http.ResponseWriter, r *http.Request, stockService *services.StockService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req struct {
+		Delta    *int   `json:"delta"`
+		Quantity *int   `json:"quantity"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if (req.Delta == nil) == (req.Quantity == nil) {
+		http.Error(w, "Exactly one of delta or quantity is required", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	movement, err := stockService.RecordManualChange(companyID, userID, vars["id"], vars["sku"], req.Delta, req.Quantity, req.Note)
+	if errors.Is(err, services.ErrStockGroupNotFound) {
+		http.Error(w, "Stock group not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrNoStockLevel) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to record stock change", http.StatusInternalServerError)
+		log.Error("Error recording stock change for SKU %s in stock group %s: %v", vars["sku"], vars["id"], err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(movement)
+}
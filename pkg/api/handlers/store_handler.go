@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"gostockly/internal/models"
 	"gostockly/internal/services"
 	"log"
 	"net/http"
@@ -44,6 +46,7 @@ func CreateStore(w http.ResponseWriter, r *http.Request, storeService *services.
 		AccessToken      string `json:"access_token"`
 		WebhookSignature string `json:"webhook_signature"`
 		LocationID       string `json:"location_id"`
+		APIVersion       string `json:"shopify_api_version"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -59,7 +62,15 @@ func CreateStore(w http.ResponseWriter, r *http.Request, storeService *services.
 		return
 	}
 
-	store, err := storeService.CreateStore(companyID, req.ShopifyStoreStub, req.AccessToken, req.WebhookSignature, req.LocationID)
+	store, err := storeService.CreateStore(companyID, req.ShopifyStoreStub, req.AccessToken, req.WebhookSignature, req.LocationID, req.APIVersion)
+	if errors.Is(err, services.ErrInvalidAPIVersion) {
+		http.Error(w, "Invalid shopify_api_version, expected a version such as 2025-01", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, services.ErrStoreOwnedElsewhere) || errors.Is(err, services.ErrStoreAlreadyConnected) {
+		http.Error(w, "Shopify store is already connected", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create store", http.StatusInternalServerError)
 		log.Printf("Error creating store: %v", err)
@@ -67,7 +78,7 @@ func CreateStore(w http.ResponseWriter, r *http.Request, storeService *services.
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(store)
+	_ = json.NewEncoder(w).Encode(models.NewStoreResponse(store))
 }
 
 func ListStores(w http.ResponseWriter, r *http.Request, storeService *services.StoreService) {
@@ -86,24 +97,42 @@ func ListStores(w http.ResponseWriter, r *http.Request, storeService *services.S
 	}
 
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(stores)
+	_ = json.NewEncoder(w).Encode(models.NewStoreResponses(stores))
 }
 
 func GetStoreByID(w http.ResponseWriter, r *http.Request, storeService *services.StoreService) {
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Println("Error: missing company_id in context")
+		return
+	}
+
 	storeID := mux.Vars(r)["id"]
 
-	store, err := storeService.GetStoreByID(storeID)
-	if err != nil {
+	store, err := storeService.GetStoreByID(companyID, storeID)
+	if errors.Is(err, services.ErrStoreNotFound) {
 		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve store", http.StatusInternalServerError)
 		log.Printf("Error retrieving store: %v", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(store)
+	_ = json.NewEncoder(w).Encode(models.NewStoreResponse(store))
 }
 
 func UpdateStore(w http.ResponseWriter, r *http.Request, storeService *services.StoreService) {
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Println("Error: missing company_id in context")
+		return
+	}
+
 	storeID := mux.Vars(r)["id"]
 
 	var req struct {
@@ -111,6 +140,7 @@ func UpdateStore(w http.ResponseWriter, r *http.Request, storeService *services.
 		AccessToken      string `json:"access_token"`
 		WebhookSignature string `json:"webhook_signature"`
 		LocationID       string `json:"location_id"`
+		APIVersion       string `json:"shopify_api_version"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -119,7 +149,19 @@ func UpdateStore(w http.ResponseWriter, r *http.Request, storeService *services.
 		return
 	}
 
-	updatedStore, err := storeService.UpdateStore(storeID, req.ShopifyStoreStub, req.AccessToken, req.WebhookSignature, req.LocationID)
+	updatedStore, err := storeService.UpdateStore(companyID, storeID, req.ShopifyStoreStub, req.AccessToken, req.WebhookSignature, req.LocationID, req.APIVersion)
+	if errors.Is(err, services.ErrInvalidAPIVersion) {
+		http.Error(w, "Invalid shopify_api_version, expected a version such as 2025-01", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, services.ErrStoreOwnedElsewhere) || errors.Is(err, services.ErrStoreAlreadyConnected) {
+		http.Error(w, "Shopify store is already connected", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, services.ErrStoreNotFound) {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to update store", http.StatusInternalServerError)
 		log.Printf("Error updating store: %v", err)
@@ -127,13 +169,25 @@ func UpdateStore(w http.ResponseWriter, r *http.Request, storeService *services.
 	}
 
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(updatedStore)
+	_ = json.NewEncoder(w).Encode(models.NewStoreResponse(updatedStore))
 }
 
 func DeleteStore(w http.ResponseWriter, r *http.Request, storeService *services.StoreService) {
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Println("Error: missing company_id in context")
+		return
+	}
+
 	storeID := mux.Vars(r)["id"]
 
-	if err := storeService.DeleteStore(storeID); err != nil {
+	err := storeService.DeleteStore(companyID, storeID)
+	if errors.Is(err, services.ErrStoreNotFound) {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to delete store", http.StatusInternalServerError)
 		log.Printf("Error deleting store: %v", err)
 		return
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterStoreLocationRoutes(r *mux.Router, locationService *services.StoreLocationService) {
+	r.HandleFunc("/stores/{id}/locations", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/stores/{id}/locations/discover", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/stores/{id}/locations/{locationId}", HandleOptions).Methods(http.MethodOptions)
+
+	r.HandleFunc("/stores/{id}/locations", func(w http.ResponseWriter, r *http.Request) {
+		ListStoreLocations(w, r, locationService)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/stores/{id}/locations/discover", func(w http.ResponseWriter, r *http.Request) {
+		DiscoverStoreLocations(w, r, locationService)
+	}).Methods(http.MethodPost)
+
+	r.HandleFunc("/stores/{id}/locations/{locationId}", func(w http.ResponseWriter, r *http.Request) {
+		UpdateStoreLocation(w, r, locationService)
+	}).Methods(http.MethodPut)
+}
+
+// ListStoreLocations returns the known Shopify locations of a store and whether they are synced.
+func ListStoreLocations(w http.ResponseWriter, r *http.Request, locationService *services.StoreLocationService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	storeID := mux.Vars(r)["id"]
+	locations, err := locationService.GetStoreLocations(companyID, storeID)
+	if errors.Is(err, services.ErrStoreNotFound) {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to retrieve locations", http.StatusInternalServerError)
+		log.Error("Error retrieving locations of store %s: %v", storeID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(locations)
+}
+
+// DiscoverStoreLocations reads the locations of a store from Shopify and returns them. New
+// locations are ignored until they are marked synced.
+func DiscoverStoreLocations(w http.ResponseWriter, r *http.Request, locationService *services.StoreLocationService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	storeID := mux.Vars(r)["id"]
+	locations, err := locationService.DiscoverLocations(r.Context(), companyID, storeID)
+	if errors.Is(err, services.ErrStoreNotFound) {
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to discover locations", http.StatusBadGateway)
+		log.Error("Error discovering locations of store %s: %v", storeID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(locations)
+}
+
+// UpdateStoreLocation marks a location of a store, by its Shopify location ID, as synced or
+// ignored within the stock group. The store's level of a SKU is the total across its synced
+// locations, and changes at a synced location are made up at the other stores' synced location
+// of the same name, so the same warehouse needs the same name in every store.
+func UpdateStoreLocation(w http.ResponseWriter, r *http.Request, locationService *services.StoreLocationService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	var req struct {
+		Synced *bool `json:"synced"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Synced == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	storeID, locationID := mux.Vars(r)["id"], mux.Vars(r)["locationId"]
+	err := locationService.SetLocationSynced(companyID, storeID, locationID, *req.Synced)
+	switch {
+	case errors.Is(err, services.ErrStoreNotFound):
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrStoreLocationNotFound):
+		http.Error(w, "Location not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrPrimaryLocationSynced):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update location", http.StatusInternalServerError)
+		log.Error("Error updating location %s of store %s: %v", locationID, storeID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
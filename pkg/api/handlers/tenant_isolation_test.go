@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"gostockly/internal/models"
+	"gostockly/internal/repositories"
+	"gostockly/internal/services"
+	"gostockly/pkg/database"
+	"gostockly/pkg/secrets"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// These tests run the store and stock group routes against Postgres, with two companies that
+// must never see each other's data. They are skipped unless TEST_DATABASE_URL points at a
+// database they may migrate and write to.
+
+type tenant struct {
+	company    *models.Company
+	store      *models.Store
+	stockGroup *models.StockGroup
+}
+
+type isolationEnv struct {
+	router    http.Handler
+	storeRepo *repositories.StoreRepository
+	groupRepo *repositories.StockGroupRepository
+	a, b      tenant
+}
+
+func newIsolationEnv(t *testing.T) *isolationEnv {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	keyring, err := secrets.NewKeyring(map[string][]byte{"test": make([]byte, 32)}, "test")
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+	secrets.SetKeyring(keyring)
+
+	env := &isolationEnv{
+		storeRepo: repositories.NewStoreRepository(db),
+		groupRepo: repositories.NewStockGroupRepository(db),
+	}
+	stockGroupStoreRepo := repositories.NewStockGroupStoreRepository(db)
+	inventoryRepo := repositories.NewInventoryRepository(db)
+
+	// The company is taken from a header in place of AuthMiddleware
+	r := mux.NewRouter()
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), "company_id", r.Header.Get("X-Test-Company"))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	RegisterStoreRoutes(protected, services.NewStoreService(env.storeRepo, nil))
+	RegisterStockGroupRoutes(protected, services.NewStockGroupService(env.groupRepo))
+	RegisterStockGroupStoreRoutes(protected, services.NewStockGroupStoreService(stockGroupStoreRepo, env.groupRepo, env.storeRepo))
+	RegisterInventoryRoutes(protected, services.NewInventoryService(inventoryRepo, env.storeRepo))
+	env.router = r
+
+	companyRepo := repositories.NewCompanyRepository(db)
+	for _, tn := range []*tenant{&env.a, &env.b} {
+		suffix := uuid.New().String()[:8]
+		tn.company = &models.Company{ID: uuid.New(), Name: "Tenant " + suffix, Subdomain: "tenant-" + suffix}
+		if err := companyRepo.CreateCompany(tn.company); err != nil {
+			t.Fatalf("failed to create company: %v", err)
+		}
+		tn.store = &models.Store{
+			ID:               uuid.New(),
+			CompanyID:        tn.company.ID,
+			ShopifyStoreStub: "tenant-" + suffix,
+			AccessToken:      "token-" + suffix,
+			WebhookSignature: "secret-" + suffix,
+		}
+		if err := env.storeRepo.CreateStore(tn.store); err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		tn.stockGroup = &models.StockGroup{ID: uuid.New(), CompanyID: tn.company.ID, Name: "Group " + suffix}
+		if err := env.groupRepo.CreateStockGroup(tn.stockGroup); err != nil {
+			t.Fatalf("failed to create stock group: %v", err)
+		}
+	}
+	return env
+}
+
+// do sends a request on behalf of a company and returns the recorded response.
+func (env *isolationEnv) do(companyID uuid.UUID, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("X-Test-Company", companyID.String())
+	rec := httptest.NewRecorder()
+	env.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTenantIsolationCrossTenantAccessIsNotFound(t *testing.T) {
+	env := newIsolationEnv(t)
+	a, b := env.a, env.b
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{name: "get store", method: http.MethodGet, path: "/api/stores/" + a.store.ID.String()},
+		{name: "update store", method: http.MethodPut, path: "/api/stores/" + a.store.ID.String(),
+			body: map[string]string{"shopify_store_stub": "hijacked", "access_token": "stolen"}},
+		{name: "delete store", method: http.MethodDelete, path: "/api/stores/" + a.store.ID.String()},
+		{name: "get stock group", method: http.MethodGet, path: "/api/stockgroups/" + a.stockGroup.ID.String()},
+		{name: "update stock group", method: http.MethodPut, path: "/api/stockgroups/" + a.stockGroup.ID.String(),
+			body: map[string]string{"name": "hijacked"}},
+		{name: "delete stock group", method: http.MethodDelete, path: "/api/stockgroups/" + a.stockGroup.ID.String()},
+		{name: "add own store to other group", method: http.MethodPost, path: "/api/stockgroupstore/add",
+			body: map[string]string{"stock_group_id": a.stockGroup.ID.String(), "store_id": b.store.ID.String()}},
+		{name: "add other store to own group", method: http.MethodPost, path: "/api/stockgroupstore/add",
+			body: map[string]string{"stock_group_id": b.stockGroup.ID.String(), "store_id": a.store.ID.String()}},
+		{name: "decrement other store", method: http.MethodPost, path: "/api/inventory/decrement",
+			body: map[string]interface{}{"skus": []string{"SKU-1"}, "amount": 1, "store_id": a.store.ID.String()}},
+		{name: "malformed store ID", method: http.MethodGet, path: "/api/stores/not-a-uuid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do(b.company.ID, tt.method, tt.path, tt.body)
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("expected 404, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Nothing of company A was changed by company B
+	store, err := env.storeRepo.GetStoreByID(a.store.ID.String())
+	if err != nil {
+		t.Fatalf("store of company A is gone: %v", err)
+	}
+	if store.ShopifyStoreStub != a.store.ShopifyStoreStub || store.AccessToken != a.store.AccessToken {
+		t.Fatalf("store of company A was modified: %+v", store)
+	}
+	group, err := env.groupRepo.GetStockGroupByID(a.stockGroup.ID.String())
+	if err != nil {
+		t.Fatalf("stock group of company A is gone: %v", err)
+	}
+	if group.Name != a.stockGroup.Name {
+		t.Fatalf("stock group of company A was renamed to %q", group.Name)
+	}
+}
+
+func TestTenantIsolationOwnerAccess(t *testing.T) {
+	env := newIsolationEnv(t)
+	a := env.a
+
+	rec := env.do(a.company.ID, http.MethodGet, "/api/stores", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 listing stores, got %d", rec.Code)
+	}
+	var stores []models.StoreResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &stores); err != nil {
+		t.Fatalf("invalid store list: %v", err)
+	}
+	if len(stores) != 1 || stores[0].ID != a.store.ID {
+		t.Fatalf("expected only the company's own store, got %+v", stores)
+	}
+
+	rec = env.do(a.company.ID, http.MethodGet, "/api/stores/"+a.store.ID.String(), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for own store, got %d", rec.Code)
+	}
+	if bytes.Contains(rec.Body.Bytes(), []byte(a.store.AccessToken)) || bytes.Contains(rec.Body.Bytes(), []byte(a.store.WebhookSignature)) {
+		t.Fatalf("store response leaks its credentials: %s", rec.Body.String())
+	}
+
+	rec = env.do(a.company.ID, http.MethodPost, "/api/stockgroupstore/add",
+		map[string]string{"stock_group_id": a.stockGroup.ID.String(), "store_id": a.store.ID.String()})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding own store to own group, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = env.do(a.company.ID, http.MethodPut, "/api/stockgroups/"+a.stockGroup.ID.String(), map[string]string{"name": "Renamed"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 renaming own stock group, got %d", rec.Code)
+	}
+
+	extra := &models.Store{ID: uuid.New(), CompanyID: a.company.ID, ShopifyStoreStub: "extra-" + a.store.ShopifyStoreStub, AccessToken: "token"}
+	if err := env.storeRepo.CreateStore(extra); err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	rec = env.do(a.company.ID, http.MethodDelete, "/api/stores/"+extra.ID.String(), nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 deleting own store, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTenantIsolationStoreStubIsNotShared(t *testing.T) {
+	env := newIsolationEnv(t)
+	a, b := env.a, env.b
+
+	tests := []struct {
+		name    string
+		company uuid.UUID
+		method  string
+		path    string
+	}{
+		{name: "create store of other company", company: b.company.ID, method: http.MethodPost, path: "/api/stores"},
+		{name: "update own store to other company's shop", company: b.company.ID, method: http.MethodPut, path: "/api/stores/" + b.store.ID.String()},
+		{name: "create own store again", company: a.company.ID, method: http.MethodPost, path: "/api/stores"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := env.do(tt.company, tt.method, tt.path,
+				map[string]string{"shopify_store_stub": a.store.ShopifyStoreStub, "access_token": "stolen"})
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("expected 409, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Webhooks of the shop still reach company A's store
+	store, err := env.storeRepo.GetStoreByShopifyStub(a.store.ShopifyStoreStub)
+	if err != nil || store == nil || store.ID != a.store.ID || store.AccessToken != a.store.AccessToken {
+		t.Fatalf("expected the shop to stay connected to company A, got %+v (%v)", store, err)
+	}
+}
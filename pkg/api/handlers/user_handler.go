@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/models"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterUserRoutes(r *mux.Router, userService *services.UserService) {
+	r.HandleFunc("/users", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/users/{id}/role", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/users/{id}/sessions", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/account/password", HandleOptions).Methods(http.MethodOptions)
+
+	r.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		ListUsers(w, r, userService)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/users/{id}/role", func(w http.ResponseWriter, r *http.Request) {
+		UpdateUserRole(w, r, userService)
+	}).Methods(http.MethodPut)
+
+	r.HandleFunc("/users/{id}/sessions", func(w http.ResponseWriter, r *http.Request) {
+		RevokeUserSessions(w, r, userService)
+	}).Methods(http.MethodDelete)
+
+	r.HandleFunc("/account/password", func(w http.ResponseWriter, r *http.Request) {
+		ChangePassword(w, r, userService)
+	}).Methods(http.MethodPut)
+}
+
+// ListUsers returns the users of the company with their roles.
+func ListUsers(w http.ResponseWriter, r *http.Request, userService *services.UserService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	users, err := userService.GetCompanyUsers(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		log.Error("Error retrieving users: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// UpdateUserRole changes the role of a user of the company.
+func UpdateUserRole(w http.ResponseWriter, r *http.Request, userService *services.UserService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	var req struct {
+		Role models.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userService.UpdateUserRole(companyID, mux.Vars(r)["id"], req.Role)
+	switch {
+	case errors.Is(err, services.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		log.Error("Error updating role of user %s: %v", mux.Vars(r)["id"], err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// RevokeUserSessions signs a user of the company out of every session.
+func RevokeUserSessions(w http.ResponseWriter, r *http.Request, userService *services.UserService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	err := userService.RevokeUserSessions(companyID, mux.Vars(r)["id"])
+	if errors.Is(err, services.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		log.Error("Error revoking sessions of user %s: %v", mux.Vars(r)["id"], err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword changes the password of the signed in user. Every other session of the user
+// ends; the response carries a new session for the caller.
+func ChangePassword(w http.ResponseWriter, r *http.Request, userService *services.UserService) {
+	log := logger.GetLogger()
+
+	userID, ok := r.Context().Value("user_id").(string)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized: missing user_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing user_id in context")
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := userService.ChangePassword(userID, req.CurrentPassword, req.NewPassword)
+	switch {
+	case errors.Is(err, services.ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrPasswordTooShort):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		log.Error("Error changing password of user %s: %v", userID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessionResponse{Token: tokens.AccessToken, TokenPair: tokens})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"gostockly/internal/services"
 	"gostockly/pkg/utils"
 	"net/http"
@@ -14,51 +15,71 @@ type WebhookHandler struct {
 
 func RegisterWebhookRoutes(r *mux.Router, service *services.WebhookService) {
 	handler := &WebhookHandler{WebhookService: service}
-	r.HandleFunc("/webhook/orders", HandleOptions).Methods(http.MethodOptions)
-	r.HandleFunc("/webhook/orders", handler.HandleOrderWebhook).Methods("POST")
-	r.HandleFunc("/webhook/products", handler.HandleProductWebhook).Methods("POST")
+	r.HandleFunc("/orders", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/orders", handler.HandleOrderWebhook).Methods("POST")
+	r.HandleFunc("/orders/cancelled", handler.HandleOrderCancelledWebhook).Methods("POST")
+	r.HandleFunc("/orders/edited", handler.HandleOrderEditedWebhook).Methods("POST")
+	r.HandleFunc("/refunds", handler.HandleRefundWebhook).Methods("POST")
+	r.HandleFunc("/products", handler.HandleProductWebhook).Methods("POST")
+	r.HandleFunc("/inventory_levels", handler.HandleInventoryLevelWebhook).Methods("POST")
+	r.HandleFunc("/bulk_operations", handler.HandleBulkOperationWebhook).Methods("POST")
 }
 
 func (h *WebhookHandler) HandleOrderWebhook(w http.ResponseWriter, r *http.Request) {
-	shopDomain := r.Header.Get("X-Shopify-Shop-Domain")
-	if shopDomain == "" {
-		http.Error(w, "Missing X-Shopify-Shop-Domain header", http.StatusBadRequest)
-		return
-	}
+	h.acceptDelivery(w, r, services.JobTypeOrderWebhook)
+}
 
-	payload, err := utils.ReadRequestBody(r)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
+func (h *WebhookHandler) HandleOrderCancelledWebhook(w http.ResponseWriter, r *http.Request) {
+	h.acceptDelivery(w, r, services.JobTypeOrderCancelledWebhook)
+}
 
-	err = h.WebhookService.ProcessOrderWebhook(shopDomain, payload)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+func (h *WebhookHandler) HandleOrderEditedWebhook(w http.ResponseWriter, r *http.Request) {
+	h.acceptDelivery(w, r, services.JobTypeOrderEditedWebhook)
+}
 
-	w.WriteHeader(http.StatusOK)
+func (h *WebhookHandler) HandleRefundWebhook(w http.ResponseWriter, r *http.Request) {
+	h.acceptDelivery(w, r, services.JobTypeRefundWebhook)
 }
 
 func (h *WebhookHandler) HandleProductWebhook(w http.ResponseWriter, r *http.Request) {
+	h.acceptDelivery(w, r, services.JobTypeProductWebhook)
+}
+
+func (h *WebhookHandler) HandleInventoryLevelWebhook(w http.ResponseWriter, r *http.Request) {
+	h.acceptDelivery(w, r, services.JobTypeInventoryLevelWebhook)
+}
+
+func (h *WebhookHandler) HandleBulkOperationWebhook(w http.ResponseWriter, r *http.Request) {
+	h.acceptDelivery(w, r, services.JobTypeBulkOperationWebhook)
+}
+
+// acceptDelivery records the delivery in the webhook ledger and queues it as a job of jobType,
+// so Shopify gets a response well within its timeout.
+func (h *WebhookHandler) acceptDelivery(w http.ResponseWriter, r *http.Request, jobType string) {
 	shopDomain := r.Header.Get("X-Shopify-Shop-Domain")
 	if shopDomain == "" {
 		http.Error(w, "Missing X-Shopify-Shop-Domain header", http.StatusBadRequest)
 		return
 	}
 
+	webhookID := r.Header.Get("X-Shopify-Webhook-Id")
+	if webhookID == "" {
+		http.Error(w, "Missing X-Shopify-Webhook-Id header", http.StatusBadRequest)
+		return
+	}
+
 	payload, err := utils.ReadRequestBody(r)
 	if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
 		return
 	}
 
-	err = h.WebhookService.ProcessProductWebhook(shopDomain, payload)
-	if err != nil {
+	err = h.WebhookService.AcceptDelivery(jobType, webhookID, r.Header.Get("X-Shopify-Topic"), shopDomain, payload)
+	if err != nil && !errors.Is(err, services.ErrDuplicateWebhook) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Duplicates are acknowledged so Shopify stops retrying, without queueing them again
 	w.WriteHeader(http.StatusOK)
 }
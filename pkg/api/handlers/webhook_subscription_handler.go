@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gostockly/internal/services"
+	"gostockly/pkg/logger"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func RegisterWebhookSubscriptionRoutes(r *mux.Router, webhookSubscriptionService *services.WebhookSubscriptionService) {
+	r.HandleFunc("/shopify/webhooks", HandleOptions).Methods(http.MethodOptions)
+	r.HandleFunc("/stores/{id}/webhooks/repair", HandleOptions).Methods(http.MethodOptions)
+
+	r.HandleFunc("/shopify/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		GetWebhookHealth(w, r, webhookSubscriptionService)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/stores/{id}/webhooks/repair", func(w http.ResponseWriter, r *http.Request) {
+		RepairStoreWebhooks(w, r, webhookSubscriptionService)
+	}).Methods(http.MethodPost)
+}
+
+// GetWebhookHealth returns the result of the last webhook subscription check of each of the
+// company's stores.
+func GetWebhookHealth(w http.ResponseWriter, r *http.Request, webhookSubscriptionService *services.WebhookSubscriptionService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	health, err := webhookSubscriptionService.GetWebhookHealth(companyID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve webhook health", http.StatusInternalServerError)
+		log.Error("Error retrieving webhook health: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(health)
+}
+
+// RepairStoreWebhooks checks a store's webhook subscriptions now, creating missing ones and
+// deleting misconfigured ones, and returns the result.
+func RepairStoreWebhooks(w http.ResponseWriter, r *http.Request, webhookSubscriptionService *services.WebhookSubscriptionService) {
+	log := logger.GetLogger()
+
+	companyID, ok := r.Context().Value("company_id").(string)
+	if !ok || companyID == "" {
+		http.Error(w, "Unauthorized: missing company_id in context", http.StatusUnauthorized)
+		log.Error("Error: missing company_id in context")
+		return
+	}
+
+	storeID := mux.Vars(r)["id"]
+	health, err := webhookSubscriptionService.RepairStore(r.Context(), companyID, storeID)
+	switch {
+	case errors.Is(err, services.ErrStoreNotFound):
+		http.Error(w, "Store not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrPublicURLNotConfigured):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, "Failed to check webhook subscriptions", http.StatusBadGateway)
+		log.Error("Error repairing webhooks of store %s: %v", storeID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,58 @@
+package api
+
+import "gostockly/internal/models"
+
+// routePermissions is the permission each route of the protected router requires, by
+// middleware.RouteKey. Routes missing here are refused by middleware.PermissionMiddleware.
+var routePermissions = map[string]models.Permission{
+	// Stores and their credentials
+	"GET /api/stores":                             models.PermissionView,
+	"GET /api/stores/{id}":                        models.PermissionView,
+	"POST /api/stores":                            models.PermissionManageStores,
+	"PUT /api/stores/{id}":                        models.PermissionManageStores,
+	"DELETE /api/stores/{id}":                     models.PermissionManageStores,
+	"GET /api/shopify/install":                    models.PermissionManageStores,
+	"GET /api/shopify/webhooks":                   models.PermissionView,
+	"POST /api/stores/{id}/webhooks/repair":       models.PermissionManageStores,
+	"GET /api/shopify/ratelimits":                 models.PermissionView,
+	"GET /api/shopify/deprecations":               models.PermissionView,
+	"GET /api/stores/{id}/syncs":                  models.PermissionView,
+	"POST /api/stores/{id}/syncs":                 models.PermissionOperate,
+	"GET /api/stores/{id}/locations":              models.PermissionView,
+	"POST /api/stores/{id}/locations/discover":    models.PermissionManageStores,
+	"PUT /api/stores/{id}/locations/{locationId}": models.PermissionManageStores,
+
+	// Stock groups and their membership
+	"GET /api/stockgroups":          models.PermissionView,
+	"GET /api/stockgroups/{id}":     models.PermissionView,
+	"POST /api/stockgroups":         models.PermissionManageStores,
+	"PUT /api/stockgroups/{id}":     models.PermissionManageStores,
+	"DELETE /api/stockgroups/{id}":  models.PermissionManageStores,
+	"POST /api/stockgroupstore/add": models.PermissionManageStores,
+
+	// Stock
+	"GET /api/stockgroups/{id}/stock":                 models.PermissionView,
+	"POST /api/stockgroups/{id}/stock/{sku}":          models.PermissionOperate,
+	"GET /api/stockgroups/{id}/stock/{sku}/movements": models.PermissionView,
+	"POST /api/stockgroups/{id}/reconcile":            models.PermissionOperate,
+	"GET /api/stockgroups/{id}/reconciliations":       models.PermissionView,
+	"POST /api/inventory/decrement":                   models.PermissionOperate,
+	"GET /api/deadletters":                            models.PermissionView,
+	"GET /api/deadletters/{id}":                       models.PermissionView,
+	"POST /api/deadletters/{id}/retry":                models.PermissionOperate,
+	"DELETE /api/deadletters/{id}":                    models.PermissionOperate,
+
+	// Users
+	"GET /api/users":                  models.PermissionView,
+	"PUT /api/users/{id}/role":        models.PermissionManageUsers,
+	"DELETE /api/users/{id}/sessions": models.PermissionManageUsers,
+	"PUT /api/account/password":       models.PermissionView,
+	"GET /api/invitations":            models.PermissionManageUsers,
+	"POST /api/invitations":           models.PermissionManageUsers,
+	"DELETE /api/invitations/{id}":    models.PermissionManageUsers,
+
+	// API keys
+	"GET /api/apikeys":         models.PermissionManageUsers,
+	"POST /api/apikeys":        models.PermissionManageUsers,
+	"DELETE /api/apikeys/{id}": models.PermissionManageUsers,
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gostockly/config"
+	"gostockly/internal/repositories"
+	"gostockly/internal/services"
+	"gostockly/pkg/middleware"
+
+	"github.com/gorilla/mux"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestEveryProtectedRouteHasAPermission builds the real router, without connecting to a
+// database, and checks that routePermissions covers exactly the routes under /api.
+func TestEveryProtectedRouteHasAPermission(t *testing.T) {
+	db, err := gorm.Open(postgres.Open("host=localhost dbname=unused sslmode=disable"), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	jobService := services.NewJobService(repositories.NewJobRepository(db), 1, time.Second, time.Minute, 1)
+	router := NewRouter(&config.Config{JWTSecret: "secret"}, db, jobService)
+
+	routes := make(map[string]bool)
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if method != http.MethodOptions {
+				routes[middleware.RouteKey(method, template)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+
+	if len(routes) == 0 {
+		t.Fatal("expected protected routes")
+	}
+	for route := range routes {
+		if _, ok := routePermissions[route]; !ok {
+			t.Errorf("route %s has no permission", route)
+		}
+	}
+	for route := range routePermissions {
+		if !routes[route] {
+			t.Errorf("permission configured for unknown route %s", route)
+		}
+	}
+}
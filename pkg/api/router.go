@@ -7,45 +7,96 @@ import (
 	"gostockly/pkg/api/handlers"
 	"gostockly/pkg/logger"
 	"gostockly/pkg/middleware"
+	"gostockly/pkg/secrets"
+	"gostockly/pkg/shopify"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
-func NewRouter(cfg *config.Config, db *gorm.DB) *mux.Router {
+func NewRouter(cfg *config.Config, db *gorm.DB, jobService *services.JobService) *mux.Router {
 	log := logger.GetLogger()
 
+	secrets.SetKeyring(cfg.EncryptionKeyring)
+
 	userRepo := repositories.NewUserRepository(db)
 	companyRepo := repositories.NewCompanyRepository(db)
 	storeRepo := repositories.NewStoreRepository(db)
 	inventoryRepo := repositories.NewInventoryRepository(db)
 	stockGroupStoreRepo := repositories.NewStockGroupStoreRepository(db)
 	stockGroupRepository := repositories.NewStockGroupRepository(db)
+	webhookRejectionRepo := repositories.NewWebhookRejectionRepository(db)
+	webhookDeliveryRepo := repositories.NewWebhookDeliveryRepository(db)
+	deadLetterRepo := repositories.NewDeadLetterAdjustmentRepository(db)
+	inventoryEchoRepo := repositories.NewInventoryEchoRepository(db)
+	stockLedgerRepo := repositories.NewStockLedgerRepository(db)
+	reconciliationReportRepo := repositories.NewReconciliationReportRepository(db)
+	catalogSyncRepo := repositories.NewCatalogSyncRepository(db)
+	shopifyInstallRepo := repositories.NewShopifyInstallRepository(db)
+	webhookHealthRepo := repositories.NewWebhookHealthRepository(db)
+	invitationRepo := repositories.NewInvitationRepository(db)
+	refreshTokenRepo := repositories.NewRefreshTokenRepository(db)
+	apiKeyRepo := repositories.NewAPIKeyRepository(db)
+	accountTokenRepo := repositories.NewAccountTokenRepository(db)
+	storeLocationRepo := repositories.NewStoreLocationRepository(db)
 
-	userService := services.NewUserService(userRepo, companyRepo, cfg.JWTSecret)
-	storeService := services.NewStoreService(storeRepo)
+	sessionService := services.NewSessionService(userRepo, refreshTokenRepo, cfg.JWTSecret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	accountService := services.NewAccountService(userRepo, accountTokenRepo, sessionService, cfg.Mailer, cfg.FrontendURL)
+	userService := services.NewUserService(userRepo, companyRepo, sessionService, accountService)
+	invitationService := services.NewInvitationService(invitationRepo, userRepo, companyRepo, cfg.Mailer, strings.TrimRight(cfg.FrontendURL, "/")+"/invitations/accept")
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo)
+	catalogSyncService := services.NewCatalogSyncService(catalogSyncRepo, storeRepo, inventoryRepo, jobService)
+	storeService := services.NewStoreService(storeRepo, catalogSyncService)
+	shopify.SetDefaultAPIVersion(cfg.ShopifyAPIVersion)
+	shopify.OnDeprecatedCall(storeService.RecordAPIDeprecation)
+	storeLocationService := services.NewStoreLocationService(storeRepo, storeLocationRepo)
 	inventoryService := services.NewInventoryService(inventoryRepo, storeRepo)
-	webhookService := services.NewWebhookService(storeRepo, inventoryRepo, stockGroupStoreRepo)
+	adjustmentService := services.NewAdjustmentService(storeRepo, deadLetterRepo, inventoryEchoRepo, stockLedgerRepo, storeLocationService, jobService)
+	stockService := services.NewStockService(stockLedgerRepo, stockGroupRepository, stockGroupStoreRepo, inventoryRepo, adjustmentService, storeLocationService, jobService)
+	reconcileService := services.NewReconcileService(stockGroupRepository, stockGroupStoreRepo, inventoryRepo, stockLedgerRepo, reconciliationReportRepo, adjustmentService, storeLocationService, jobService, cfg.ReconcileInterval, cfg.ReconcileAutoCorrect)
+	webhookService := services.NewWebhookService(storeRepo, inventoryRepo, stockGroupStoreRepo, webhookDeliveryRepo, jobService, adjustmentService, stockService, catalogSyncService, storeLocationService, cfg.InventoryLevelSettleDelay)
 	stockGroupStoreService := services.NewStockGroupStoreService(stockGroupStoreRepo, stockGroupRepository, storeRepo)
 	stockGroupService := services.NewStockGroupService(stockGroupRepository)
+	webhookSubscriptionService := services.NewWebhookSubscriptionService(storeRepo, webhookHealthRepo, jobService, cfg.PublicURL, cfg.WebhookRepairInterval)
+	oauthConfig := &shopify.OAuthConfig{APIKey: cfg.ShopifyAPIKey, APISecret: cfg.ShopifyAPISecret, Scopes: cfg.ShopifyScopes}
+	if cfg.PublicURL != "" {
+		oauthConfig.RedirectURL = strings.TrimRight(cfg.PublicURL, "/") + "/shopify/callback"
+	}
+	shopifyInstallService := services.NewShopifyInstallService(shopifyInstallRepo, storeRepo, storeService, webhookSubscriptionService, storeLocationService, oauthConfig)
 
 	r := mux.NewRouter()
 	r.Use(middleware.LoggingMiddleware)
 	r.Use(middleware.CORSMiddleware)
 
 	log.Info("Registering routes...")
-	handlers.RegisterAuthRoutes(r, userService)
+	handlers.RegisterAuthRoutes(r, userService, sessionService)
+	handlers.RegisterAccountRoutes(r, accountService)
 	log.Info("Auth routes registered")
 
-	handlers.RegisterWebhookRoutes(r, webhookService)
+	webhooks := r.PathPrefix("/webhook").Subrouter()
+	webhooks.Use(middleware.ShopifyWebhookMiddleware(storeRepo, webhookRejectionRepo))
+	handlers.RegisterWebhookRoutes(webhooks, webhookService)
 	log.Info("Webhook routes registered")
 
 	protected := r.PathPrefix("/api").Subrouter()
-	protected.Use(middleware.AuthMiddleware(userService))
+	protected.Use(middleware.AuthMiddleware(sessionService, apiKeyService))
+	protected.Use(middleware.PermissionMiddleware(routePermissions))
 	handlers.RegisterStoreRoutes(protected, storeService)
+	handlers.RegisterStoreLocationRoutes(protected, storeLocationService)
+	handlers.RegisterCatalogSyncRoutes(protected, catalogSyncService)
+	handlers.RegisterShopifyRoutes(protected, storeService)
+	handlers.RegisterShopifyInstallRoutes(protected, r, shopifyInstallService, cfg.FrontendURL)
+	handlers.RegisterWebhookSubscriptionRoutes(protected, webhookSubscriptionService)
 	handlers.RegisterInventoryRoutes(protected, inventoryService)
 	handlers.RegisterStockGroupRoutes(protected, stockGroupService)
 	handlers.RegisterStockGroupStoreRoutes(protected, stockGroupStoreService)
+	handlers.RegisterStockRoutes(protected, stockService)
+	handlers.RegisterReconcileRoutes(protected, reconcileService)
+	handlers.RegisterDeadLetterRoutes(protected, adjustmentService)
+	handlers.RegisterUserRoutes(protected, userService)
+	handlers.RegisterInvitationRoutes(protected, r, invitationService)
+	handlers.RegisterAPIKeyRoutes(protected, apiKeyService)
 	log.Info("Inventory routes registered")
 
 	log.Info("All routes registered successfully")
@@ -18,10 +18,129 @@ func Connect() *gorm.DB {
 	}
 
 	// Run migrations
-	err = db.AutoMigrate(&models.User{}, &models.Store{}, &models.Inventory{}, &models.StockGroup{}, &models.Company{}, &models.StockGroupStore{})
-	if err != nil {
+	if err := Migrate(db); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
 	return db
 }
+
+// Migrate creates or updates the tables of every model.
+func Migrate(db *gorm.DB) error {
+	// Users created before roles existed could do everything, so they become owners
+	backfillRoles := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "Role")
+	// Users created before email verification existed are trusted with their address
+	backfillVerified := db.Migrator().HasTable(&models.User{}) && !db.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	// Mappings saved before SKUs were unique per store may be duplicated, keep the newest
+	if db.Migrator().HasTable(&models.Inventory{}) && !db.Migrator().HasIndex(&models.Inventory{}, "idx_inventory_store_sku") {
+		err := db.Exec(`DELETE FROM inventories a USING inventories b
+			WHERE a.store_id = b.store_id AND a.sku = b.sku
+			AND (a.updated_at < b.updated_at OR (a.updated_at = b.updated_at AND a.id < b.id))`).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// Shops connected more than once before stubs were unique keep their first store
+	if db.Migrator().HasTable(&models.Store{}) && !db.Migrator().HasIndex(&models.Store{}, "idx_stores_shopify_store_stub") {
+		if err := dedupeStores(db); err != nil {
+			return err
+		}
+	}
+
+	// Rejections recorded one row each before they were counted per minute are folded together
+	if db.Migrator().HasTable(&models.WebhookRejection{}) && !db.Migrator().HasColumn(&models.WebhookRejection{}, "WindowStart") {
+		if err := countWebhookRejections(db); err != nil {
+			return err
+		}
+	}
+
+	err := db.AutoMigrate(
+		&models.User{},
+		&models.Store{},
+		&models.Inventory{},
+		&models.StockGroup{},
+		&models.Company{},
+		&models.StockGroupStore{},
+		&models.WebhookRejection{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryItem{},
+		&models.Job{},
+		&models.DeadLetterAdjustment{},
+		&models.InventoryEcho{},
+		&models.KnownInventoryLevel{},
+		&models.StockLevel{},
+		&models.StockMovement{},
+		&models.ReconciliationReport{},
+		&models.CatalogSync{},
+		&models.ShopifyInstall{},
+		&models.WebhookHealth{},
+		&models.Invitation{},
+		&models.RefreshToken{},
+		&models.APIKey{},
+		&models.AccountToken{},
+		&models.StoreLocation{},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Users are looked up by address regardless of case
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_users_lower_email ON users (lower(email))").Error; err != nil {
+		return err
+	}
+
+	if backfillRoles {
+		if err := db.Model(&models.User{}).Where("1 = 1").Update("role", models.RoleOwner).Error; err != nil {
+			return err
+		}
+	}
+	if backfillVerified {
+		return db.Model(&models.User{}).Where("1 = 1").Update("email_verified_at", gorm.Expr("created_at")).Error
+	}
+	return nil
+}
+
+// dedupeStores deletes every store but the first connected of each Shopify stub, together with
+// what belongs to the deleted stores.
+func dedupeStores(db *gorm.DB) error {
+	const duplicates = `SELECT a.id FROM stores a JOIN stores b ON a.shopify_store_stub = b.shopify_store_stub
+		AND (a.created_at > b.created_at OR (a.created_at = b.created_at AND a.id > b.id))`
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range []string{"stock_group_stores", "inventories", "store_locations"} {
+			if !tx.Migrator().HasTable(table) {
+				continue
+			}
+			if err := tx.Exec("DELETE FROM " + table + " WHERE store_id IN (" + duplicates + ")").Error; err != nil {
+				return err
+			}
+		}
+		return tx.Exec("DELETE FROM stores WHERE id IN (" + duplicates + ")").Error
+	})
+}
+
+// countWebhookRejections gives every rejection the minute it was recorded in and keeps the newest
+// rejection of each shop, reason and minute, counting the ones deleted.
+func countWebhookRejections(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			`ALTER TABLE webhook_rejections ADD COLUMN window_start timestamptz, ADD COLUMN count bigint NOT NULL DEFAULT 1`,
+			`UPDATE webhook_rejections SET window_start = date_trunc('minute', created_at)`,
+			`UPDATE webhook_rejections r SET count = g.count FROM (
+				SELECT shop_domain, reason, window_start, COUNT(*) AS count FROM webhook_rejections
+				GROUP BY shop_domain, reason, window_start) g
+			WHERE r.shop_domain = g.shop_domain AND r.reason = g.reason AND r.window_start = g.window_start`,
+			`DELETE FROM webhook_rejections a USING webhook_rejections b
+			WHERE a.shop_domain = b.shop_domain AND a.reason = b.reason AND a.window_start = b.window_start
+			AND (a.created_at < b.created_at OR (a.created_at = b.created_at AND a.id < b.id))`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"gostockly/pkg/secrets"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// EncryptedSerializer stores string fields tagged `gorm:"serializer:encrypted"` encrypted with
+// the keyring set by secrets.SetKeyring. Empty strings are stored as they are, and plaintext
+// values written before encryption was introduced are read unchanged until they are encrypted
+// by the encrypt_secrets command.
+type EncryptedSerializer struct{}
+
+// Scan implements schema.SerializerInterface.
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("cannot decrypt %T into %s", dbValue, field.Name)
+	}
+
+	if secrets.IsEncrypted(value) {
+		keyring, err := secrets.DefaultKeyring()
+		if err != nil {
+			return err
+		}
+		if value, err = keyring.Decrypt(value); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+// Value implements schema.SerializerInterface.
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot encrypt %T of %s, only strings are supported", fieldValue, field.Name)
+	}
+	if value == "" {
+		return "", nil
+	}
+
+	keyring, err := secrets.DefaultKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Encrypt(value)
+}
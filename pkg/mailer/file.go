@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes every email to its own file in a directory instead of delivering it, so
+// emails can be read in development and tests without a mail server.
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer returns a mailer writing into dir, which is created when it does not exist.
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+// Send writes the message as an .eml file named after the time it was sent.
+func (m *FileMailer) Send(msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), uuid.New().String()[:8])
+	return os.WriteFile(filepath.Join(m.dir, name), format("", msg), 0o600)
+}
@@ -0,0 +1,31 @@
+// Package mailer delivers the emails the API sends to people, such as invitations.
+package mailer
+
+import (
+	"gostockly/pkg/logger"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer writes emails to the log instead of delivering them, for local development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+// Send logs the message, including its body.
+func (m *LogMailer) Send(msg Message) error {
+	logger.GetLogger().Info("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
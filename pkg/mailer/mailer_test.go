@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("failed to create file mailer: %v", err)
+	}
+
+	if err := m.Send(Message{To: "jane@example.com", Subject: "Hello", Body: "First line\nSecond line\n"}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if err := m.Send(Message{To: "joe@example.com", Subject: "Hello", Body: "Hi"}); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected two emails, got %v (%v)", files, err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read email: %v", err)
+	}
+	email := string(content)
+	for _, want := range []string{"To: jane@example.com\r\n", "Subject: Hello\r\n", "\r\n\r\nFirst line\r\nSecond line\r\n"} {
+		if !strings.Contains(email, want) {
+			t.Fatalf("expected email to contain %q, got:\n%s", want, email)
+		}
+	}
+}
+
+func TestFormatStripsHeaderInjection(t *testing.T) {
+	email := string(format("app@example.com", Message{
+		To:      "jane@example.com\r\nBcc: everyone@example.com",
+		Subject: "Hello\nBcc: everyone@example.com",
+		Body:    "Hi",
+	}))
+	header := email[:strings.Index(email, "\r\n\r\n")]
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Fatalf("header was injected:\n%s", email)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -17,7 +18,10 @@ type contextKey string
 const userIDKey contextKey = "user_id"
 const companyIDKey contextKey = "company_id"
 
-func AuthMiddleware(userService *services.UserService) func(http.Handler) http.Handler {
+// AuthMiddleware authenticates requests by their access token and puts the user, their company
+// and their role in the context. Requests made with an API key get the key's company, its ID
+// as api_key_id and its scopes in place of a user and role.
+func AuthMiddleware(sessionService *services.SessionService, apiKeyService *services.APIKeyService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			log := logger.GetLogger()
@@ -38,21 +42,41 @@ func AuthMiddleware(userService *services.UserService) func(http.Handler) http.H
 			}
 			token := parts[1]
 
-			// Validate the JWT token to extract the user ID
-			userID, err := utils.ValidateJWT(token, userService.JWTSecret)
-			if err != nil {
-				log.Error("Invalid or expired token: %v", err)
-				utils.WriteErrorResponse(w, http.StatusUnauthorized, "Invalid or expired token")
+			if strings.HasPrefix(token, services.APIKeyPrefix) {
+				apiKey, err := apiKeyService.Authenticate(token)
+				if errors.Is(err, services.ErrInvalidAPIKey) {
+					log.Error("Invalid, revoked or expired API key")
+					utils.WriteErrorResponse(w, http.StatusUnauthorized, "Invalid, revoked or expired API key")
+					return
+				}
+				if err != nil {
+					log.Error("Error authenticating API key: %v", err)
+					utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to authenticate")
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), "api_key_id", apiKey.ID.String())
+				ctx = context.WithValue(ctx, "company_id", apiKey.CompanyID.String())
+				ctx = context.WithValue(ctx, "scopes", apiKey.Scopes)
+				log.Info("Authenticated API key ID: %s, Company ID: %s", apiKey.ID, apiKey.CompanyID)
+
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
-			// Fetch the user and associated company from the database
-			user, err := userService.UserRepo.GetUserByID(userID)
+			// Validate the access token and fetch the user it was issued to
+			user, err := sessionService.Authenticate(token)
+			if errors.Is(err, services.ErrInvalidAccessToken) {
+				log.Error("Invalid or expired token")
+				utils.WriteErrorResponse(w, http.StatusUnauthorized, "Invalid or expired token")
+				return
+			}
 			if err != nil {
-				log.Error("User not found: %v", userID)
-				utils.WriteErrorResponse(w, http.StatusForbidden, "User not found")
+				log.Error("Error authenticating token: %v", err)
+				utils.WriteErrorResponse(w, http.StatusInternalServerError, "Failed to authenticate")
 				return
 			}
+			userID := user.ID.String()
 
 			if user.CompanyID == uuid.Nil {
 				log.Error("No company associated with user ID: %v", userID)
@@ -60,9 +84,10 @@ func AuthMiddleware(userService *services.UserService) func(http.Handler) http.H
 				return
 			}
 
-			// Set user_id and company_id in the context
+			// Set user_id, company_id and role in the context
 			ctx := context.WithValue(r.Context(), "user_id", userID)
 			ctx = context.WithValue(ctx, "company_id", user.CompanyID.String())
+			ctx = context.WithValue(ctx, "role", string(user.Role))
 			log.Info("Authenticated user ID: %s, Company ID: %s", userID, user.CompanyID.String())
 
 			next.ServeHTTP(w, r.WithContext(ctx))
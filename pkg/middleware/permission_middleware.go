@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+
+	"gostockly/internal/models"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteKey identifies a route by its method and path template, such as "GET /api/stores/{id}".
+func RouteKey(method, pathTemplate string) string {
+	return method + " " + pathTemplate
+}
+
+// PermissionMiddleware only lets a request through if the role set by AuthMiddleware, or the
+// scopes of the API key it was made with, grant the permission its route requires. permissions
+// holds the permission of every route by RouteKey. Routes missing from it are refused, so a new
+// route cannot be reached before it has been given a permission.
+func PermissionMiddleware(permissions map[string]models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.GetLogger()
+
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var key string
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					key = RouteKey(r.Method, template)
+				}
+			}
+			permission, ok := permissions[key]
+			if !ok {
+				log.Error("No permission is configured for route %q, refusing %s %s", key, r.Method, r.URL.Path)
+				utils.WriteErrorResponse(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+
+			if scopes, ok := r.Context().Value("scopes").([]models.Permission); ok {
+				if !models.Grants(scopes, permission) {
+					apiKeyID, _ := r.Context().Value("api_key_id").(string)
+					log.Error("API key %s with scopes %v lacks permission %s for %s", apiKeyID, scopes, permission, key)
+					utils.WriteErrorResponse(w, http.StatusForbidden, "The API key's scopes do not allow this action")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			role, _ := r.Context().Value("role").(string)
+			if !models.Role(role).Can(permission) {
+				userID, _ := r.Context().Value("user_id").(string)
+				log.Error("User %s with role %q lacks permission %s for %s", userID, role, permission, key)
+				utils.WriteErrorResponse(w, http.StatusForbidden, "Your role does not allow this action")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gostockly/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func newPermissionTestRouter() *mux.Router {
+	r := mux.NewRouter()
+	protected := r.PathPrefix("/api").Subrouter()
+	protected.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), "role", r.Header.Get("X-Test-Role"))
+			if scopes, ok := r.Header["X-Test-Scope"]; ok {
+				permissions := []models.Permission{}
+				for _, scope := range scopes {
+					permissions = append(permissions, models.Permission(scope))
+				}
+				ctx = context.WithValue(ctx, "scopes", permissions)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	protected.Use(PermissionMiddleware(map[string]models.Permission{
+		"GET /api/stores":         models.PermissionView,
+		"POST /api/stores/{id}":   models.PermissionOperate,
+		"DELETE /api/stores/{id}": models.PermissionManageStores,
+		"PUT /api/users/{id}":     models.PermissionManageUsers,
+	}))
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	protected.HandleFunc("/stores", ok).Methods(http.MethodGet)
+	protected.HandleFunc("/stores/{id}", ok).Methods(http.MethodPost, http.MethodDelete, http.MethodOptions)
+	protected.HandleFunc("/users/{id}", ok).Methods(http.MethodPut)
+	protected.HandleFunc("/unlisted", ok).Methods(http.MethodGet)
+	return r
+}
+
+func TestPermissionMiddleware(t *testing.T) {
+	router := newPermissionTestRouter()
+
+	tests := []struct {
+		role   models.Role
+		method string
+		path   string
+		want   int
+	}{
+		{models.RoleViewer, http.MethodGet, "/api/stores", http.StatusOK},
+		{models.RoleViewer, http.MethodPost, "/api/stores/1", http.StatusForbidden},
+		{models.RoleOperator, http.MethodPost, "/api/stores/1", http.StatusOK},
+		{models.RoleOperator, http.MethodDelete, "/api/stores/1", http.StatusForbidden},
+		{models.RoleAdmin, http.MethodDelete, "/api/stores/1", http.StatusOK},
+		{models.RoleAdmin, http.MethodPut, "/api/users/1", http.StatusForbidden},
+		{models.RoleOwner, http.MethodPut, "/api/users/1", http.StatusOK},
+		{models.RoleOwner, http.MethodGet, "/api/unlisted", http.StatusForbidden},
+		{"", http.MethodGet, "/api/stores", http.StatusForbidden},
+		{"superuser", http.MethodGet, "/api/stores", http.StatusForbidden},
+		{models.RoleViewer, http.MethodOptions, "/api/stores/1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.role)+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-Test-Role", string(tt.role))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestPermissionMiddlewareAPIKeyScopes(t *testing.T) {
+	router := newPermissionTestRouter()
+
+	tests := []struct {
+		scopes []models.Permission
+		method string
+		path   string
+		want   int
+	}{
+		{[]models.Permission{models.PermissionView}, http.MethodGet, "/api/stores", http.StatusOK},
+		{[]models.Permission{models.PermissionView}, http.MethodPost, "/api/stores/1", http.StatusForbidden},
+		{[]models.Permission{models.PermissionOperate}, http.MethodPost, "/api/stores/1", http.StatusOK},
+		{[]models.Permission{models.PermissionOperate}, http.MethodGet, "/api/stores", http.StatusForbidden},
+		{[]models.Permission{models.PermissionView, models.PermissionManageStores}, http.MethodDelete, "/api/stores/1", http.StatusOK},
+		{[]models.Permission{models.PermissionManageStores}, http.MethodPut, "/api/users/1", http.StatusForbidden},
+		{[]models.Permission{}, http.MethodGet, "/api/stores", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		var names []string
+		for _, scope := range tt.scopes {
+			names = append(names, string(scope))
+		}
+		t.Run(strings.Join(names, ",")+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			// Scopes decide even when a role that would allow the request is present
+			req.Header.Set("X-Test-Role", string(models.RoleOwner))
+			req.Header["X-Test-Scope"] = names
+			if names == nil {
+				req.Header["X-Test-Scope"] = []string{}
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"gostockly/internal/models"
+	"gostockly/pkg/logger"
+	"gostockly/pkg/utils"
+)
+
+// WebhookStoreFinder looks up the store a webhook claims to come from.
+type WebhookStoreFinder interface {
+	GetStoreByShopifyDomain(domain string) (*models.Store, error)
+}
+
+// WebhookRejectionRecorder counts webhook deliveries that failed verification.
+type WebhookRejectionRecorder interface {
+	RecordWebhookRejection(rejection *models.WebhookRejection) error
+}
+
+// maxWebhookBodyBytes bounds the webhook payloads read into memory, Shopify's are far smaller.
+const maxWebhookBodyBytes = 2 << 20
+
+// ShopifyWebhookMiddleware verifies the X-Shopify-Hmac-Sha256 signature of every
+// webhook against the WebhookSignature of the store named in X-Shopify-Shop-Domain.
+func ShopifyWebhookMiddleware(stores WebhookStoreFinder, rejections WebhookRejectionRecorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.GetLogger()
+
+			shopDomain := r.Header.Get("X-Shopify-Shop-Domain")
+			if shopDomain == "" {
+				rejectWebhook(w, r, rejections, "missing X-Shopify-Shop-Domain header")
+				return
+			}
+
+			hmacHeader := r.Header.Get("X-Shopify-Hmac-Sha256")
+			if hmacHeader == "" {
+				rejectWebhook(w, r, rejections, "missing X-Shopify-Hmac-Sha256 header")
+				return
+			}
+
+			store, err := stores.GetStoreByShopifyDomain(shopDomain)
+			if err != nil || store == nil {
+				rejectWebhook(w, r, rejections, "unknown shop domain")
+				return
+			}
+
+			if store.WebhookSignature == "" {
+				rejectWebhook(w, r, rejections, "store has no webhook signature configured")
+				return
+			}
+
+			// Read the body and reset it so the handler can read it again
+			r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes)
+			payload, err := utils.ReadRequestBody(r)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				log.Error("Webhook body for shop %s exceeds %d bytes", shopDomain, tooLarge.Limit)
+				utils.WriteErrorResponse(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			if err != nil {
+				log.Error("Failed to read webhook body for shop %s: %v", shopDomain, err)
+				utils.WriteErrorResponse(w, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+
+			if !utils.ValidateHMAC(payload, hmacHeader, store.WebhookSignature) {
+				rejectWebhook(w, r, rejections, "invalid HMAC signature")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectWebhook records the failed attempt and responds with 401.
+func rejectWebhook(w http.ResponseWriter, r *http.Request, rejections WebhookRejectionRecorder, reason string) {
+	log := logger.GetLogger()
+
+	shopDomain := r.Header.Get("X-Shopify-Shop-Domain")
+	log.Error("Rejected webhook: shop=%s, path=%s, remote_addr=%s, reason=%s", shopDomain, r.URL.Path, r.RemoteAddr, reason)
+
+	rejection := &models.WebhookRejection{
+		ShopDomain: shopDomain,
+		Topic:      r.Header.Get("X-Shopify-Topic"),
+		WebhookID:  r.Header.Get("X-Shopify-Webhook-Id"),
+		Path:       r.URL.Path,
+		Reason:     reason,
+		RemoteAddr: r.RemoteAddr,
+	}
+	if err := rejections.RecordWebhookRejection(rejection); err != nil {
+		log.Error("Failed to record webhook rejection for shop %s: %v", shopDomain, err)
+	}
+
+	utils.WriteErrorResponse(w, http.StatusUnauthorized, "Webhook verification failed")
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gostockly/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const fixtureSecret = "fixture-webhook-secret"
+
+// Signatures of the files in testdata, computed with:
+//
+//	openssl dgst -sha256 -hmac fixture-webhook-secret -binary <file> | base64
+var signedFixtures = map[string]string{
+	"orders_create.json":   "o5991qycOcTGcZLduqLhcg0TjT/5DajiN/v1zhGJmXU=",
+	"products_update.json": "+GGd+cbz1+pz4QPe/EJ9wW9FoKmBQsTm8clKMSNHG6c=",
+}
+
+type fakeStoreFinder struct {
+	stores map[string]*models.Store
+}
+
+func (f *fakeStoreFinder) GetStoreByShopifyDomain(domain string) (*models.Store, error) {
+	store, ok := f.stores[domain]
+	if !ok {
+		return nil, errors.New("invalid Shopify domain")
+	}
+	return store, nil
+}
+
+type fakeRejectionRecorder struct {
+	rejections []*models.WebhookRejection
+}
+
+func (f *fakeRejectionRecorder) RecordWebhookRejection(rejection *models.WebhookRejection) error {
+	f.rejections = append(f.rejections, rejection)
+	return nil
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	payload, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", name, err)
+	}
+	return payload
+}
+
+func newWebhookTestServer() (http.Handler, *fakeRejectionRecorder, *[]byte) {
+	stores := &fakeStoreFinder{stores: map[string]*models.Store{
+		"signed-store.myshopify.com": {
+			ID:               uuid.New(),
+			ShopifyStoreStub: "signed-store",
+			WebhookSignature: fixtureSecret,
+		},
+		"unsigned-store.myshopify.com": {
+			ID:               uuid.New(),
+			ShopifyStoreStub: "unsigned-store",
+		},
+	}}
+	rejections := &fakeRejectionRecorder{}
+
+	var received []byte
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return ShopifyWebhookMiddleware(stores, rejections)(next), rejections, &received
+}
+
+func newWebhookRequest(shopDomain, signature string, payload []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook/orders", bytes.NewReader(payload))
+	req.Header.Set("X-Shopify-Topic", "orders/create")
+	req.Header.Set("X-Shopify-Webhook-Id", "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043")
+	if shopDomain != "" {
+		req.Header.Set("X-Shopify-Shop-Domain", shopDomain)
+	}
+	if signature != "" {
+		req.Header.Set("X-Shopify-Hmac-Sha256", signature)
+	}
+	return req
+}
+
+func TestShopifyWebhookMiddlewareAcceptsSignedFixtures(t *testing.T) {
+	for name, signature := range signedFixtures {
+		t.Run(name, func(t *testing.T) {
+			handler, rejections, received := newWebhookTestServer()
+			payload := loadFixture(t, name)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newWebhookRequest("signed-store.myshopify.com", signature, payload))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if !bytes.Equal(*received, payload) {
+				t.Fatalf("handler did not receive the original payload")
+			}
+			if len(rejections.rejections) != 0 {
+				t.Fatalf("expected no rejections, got %d", len(rejections.rejections))
+			}
+		})
+	}
+}
+
+func TestShopifyWebhookMiddlewareRejectsInvalidRequests(t *testing.T) {
+	payload := loadFixture(t, "orders_create.json")
+	signature := signedFixtures["orders_create.json"]
+
+	tests := []struct {
+		name       string
+		shopDomain string
+		signature  string
+		payload    []byte
+		reason     string
+	}{
+		{
+			name:       "tampered payload",
+			shopDomain: "signed-store.myshopify.com",
+			signature:  signature,
+			payload:    bytes.Replace(payload, []byte(`"quantity": 2`), []byte(`"quantity": 200`), 1),
+			reason:     "invalid HMAC signature",
+		},
+		{
+			name:       "signature from another payload",
+			shopDomain: "signed-store.myshopify.com",
+			signature:  signedFixtures["products_update.json"],
+			payload:    payload,
+			reason:     "invalid HMAC signature",
+		},
+		{
+			name:       "malformed signature",
+			shopDomain: "signed-store.myshopify.com",
+			signature:  "not-base64!",
+			payload:    payload,
+			reason:     "invalid HMAC signature",
+		},
+		{
+			name:      "missing shop domain",
+			signature: signature,
+			payload:   payload,
+			reason:    "missing X-Shopify-Shop-Domain header",
+		},
+		{
+			name:       "missing signature",
+			shopDomain: "signed-store.myshopify.com",
+			payload:    payload,
+			reason:     "missing X-Shopify-Hmac-Sha256 header",
+		},
+		{
+			name:       "unknown shop",
+			shopDomain: "other-store.myshopify.com",
+			signature:  signature,
+			payload:    payload,
+			reason:     "unknown shop domain",
+		},
+		{
+			name:       "store without secret",
+			shopDomain: "unsigned-store.myshopify.com",
+			signature:  signature,
+			payload:    payload,
+			reason:     "store has no webhook signature configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, rejections, received := newWebhookTestServer()
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newWebhookRequest(tt.shopDomain, tt.signature, tt.payload))
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected status 401, got %d", rec.Code)
+			}
+			if *received != nil {
+				t.Fatalf("handler should not have been called")
+			}
+			if len(rejections.rejections) != 1 {
+				t.Fatalf("expected 1 recorded rejection, got %d", len(rejections.rejections))
+			}
+
+			rejection := rejections.rejections[0]
+			if rejection.Reason != tt.reason {
+				t.Errorf("expected reason %q, got %q", tt.reason, rejection.Reason)
+			}
+			if rejection.ShopDomain != tt.shopDomain {
+				t.Errorf("expected shop domain %q, got %q", tt.shopDomain, rejection.ShopDomain)
+			}
+			if rejection.Topic != "orders/create" {
+				t.Errorf("expected topic orders/create, got %q", rejection.Topic)
+			}
+		})
+	}
+}
+
+func TestShopifyWebhookMiddlewareRejectsOversizedBodies(t *testing.T) {
+	handler, rejections, received := newWebhookTestServer()
+	payload := bytes.Repeat([]byte(" "), maxWebhookBodyBytes+1)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newWebhookRequest("signed-store.myshopify.com", signedFixtures["orders_create.json"], payload))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", rec.Code)
+	}
+	if *received != nil {
+		t.Fatalf("handler should not have been called")
+	}
+	if len(rejections.rejections) != 0 {
+		t.Fatalf("expected no rejections, got %d", len(rejections.rejections))
+	}
+}
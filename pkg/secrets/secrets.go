@@ -0,0 +1,216 @@
+// Package secrets encrypts credentials such as Shopify access tokens before they are stored.
+//
+// Values are envelope encrypted: every value is sealed with AES-256-GCM under its own random
+// data key, and the data key is sealed under a key encryption key from the keyring. The ID of
+// that key is kept with the value, so keys can be rotated by adding a new primary key while
+// the old ones are still able to decrypt existing values.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// prefix marks an encrypted value, followed by the key ID, the sealed data key and the sealed value.
+const prefix = "enc:v1:"
+
+var (
+	ErrNoKeyring  = errors.New("no encryption keys are configured")
+	ErrUnknownKey = errors.New("value is encrypted with an unknown key")
+	ErrMalformed  = errors.New("malformed encrypted value")
+)
+
+// Keyring holds the key encryption keys by ID. New values are encrypted with the primary key.
+type Keyring struct {
+	keys    map[string][]byte
+	primary string
+}
+
+// NewKeyring creates a keyring of 32 byte AES-256 keys by ID, encrypting with the key named primary.
+func NewKeyring(keys map[string][]byte, primary string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeyring
+	}
+	ring := &Keyring{keys: make(map[string][]byte, len(keys)), primary: primary}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid encryption key ID %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes, got %d", id, len(key))
+		}
+		ring.keys[id] = append([]byte(nil), key...)
+	}
+	if _, ok := ring.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary encryption key %q is not in the keyring", primary)
+	}
+	return ring, nil
+}
+
+// ParseKeyring parses keys given as comma separated id:base64 pairs, such as
+// "2024:q83v...,2025:Zm9v...". primary may be empty when there is only one key.
+func ParseKeyring(keys, primary string) (*Keyring, error) {
+	parsed := make(map[string][]byte)
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("encryption key %q must be given as id:base64", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s is not valid base64: %w", id, err)
+		}
+		parsed[id] = key
+	}
+
+	if primary == "" && len(parsed) == 1 {
+		for id := range parsed {
+			primary = id
+		}
+	}
+	return NewKeyring(parsed, primary)
+}
+
+// Primary returns the ID of the key new values are encrypted with.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Encrypt seals plaintext under a new data key, which is sealed with the primary key.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.primary + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedValue), nil
+}
+
+// Decrypt opens a value produced by Encrypt with whichever key of the keyring sealed it.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	id, sealedKey, sealedValue, err := split(value)
+	if err != nil {
+		return "", err
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	dataKey, err := open(key, sealedKey, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, sealedValue, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether a stored value is plaintext or sealed with a key other than
+// the primary one, and should be encrypted again.
+func (k *Keyring) NeedsRotation(value string) bool {
+	if !IsEncrypted(value) {
+		return value != ""
+	}
+	id, _, _, err := split(value)
+	return err == nil && id != k.primary
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// split returns the key ID, sealed data key and sealed value of an encrypted value.
+func split(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if !IsEncrypted(value) || len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+	sealedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	sealedValue, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], sealedKey, sealedValue, nil
+}
+
+// seal encrypts plaintext with AES-GCM, prepending the random nonce.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts the output of seal.
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// defaultKeyring is the keyring stored secrets are encrypted and decrypted with.
+var defaultKeyring atomic.Value
+
+// SetKeyring sets the keyring used to encrypt and decrypt stored secrets.
+func SetKeyring(keyring *Keyring) {
+	defaultKeyring.Store(keyring)
+}
+
+// DefaultKeyring returns the keyring set with SetKeyring, or ErrNoKeyring.
+func DefaultKeyring() (*Keyring, error) {
+	if keyring, ok := defaultKeyring.Load().(*Keyring); ok && keyring != nil {
+		return keyring, nil
+	}
+	return nil, ErrNoKeyring
+}
@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring, err := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1")
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	encrypted, err := keyring.Encrypt("shpat_secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "shpat_secret") {
+		t.Fatalf("expected an encrypted value, got %q", encrypted)
+	}
+	if again, _ := keyring.Encrypt("shpat_secret"); again == encrypted {
+		t.Fatal("expected every encryption to use a new data key and nonce")
+	}
+
+	decrypted, err := keyring.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if decrypted != "shpat_secret" {
+		t.Fatalf("expected shpat_secret, got %q", decrypted)
+	}
+
+	// Flipping a byte of the sealed value must fail authentication
+	parts := strings.Split(encrypted, ":")
+	sealed, _ := base64.RawStdEncoding.DecodeString(parts[4])
+	sealed[len(sealed)-1] ^= 1
+	parts[4] = base64.RawStdEncoding.EncodeToString(sealed)
+	if _, err := keyring.Decrypt(strings.Join(parts, ":")); err == nil {
+		t.Fatal("expected a tampered value to fail decryption")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, err := NewKeyring(map[string][]byte{"k1": testKey(1)}, "k1")
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	rotated, err := NewKeyring(map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k2")
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+
+	encrypted, _ := old.Encrypt("token")
+	if !rotated.NeedsRotation(encrypted) {
+		t.Fatal("expected a value sealed with k1 to need rotation")
+	}
+	if decrypted, err := rotated.Decrypt(encrypted); err != nil || decrypted != "token" {
+		t.Fatalf("expected the rotated keyring to decrypt k1 values, got %q, %v", decrypted, err)
+	}
+
+	reencrypted, _ := rotated.Encrypt("token")
+	if rotated.NeedsRotation(reencrypted) {
+		t.Fatal("expected a value sealed with the primary key not to need rotation")
+	}
+	if !rotated.NeedsRotation("plaintext") || rotated.NeedsRotation("") {
+		t.Fatal("expected plaintext but not empty values to need rotation")
+	}
+	if _, err := old.Decrypt(reencrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	tests := []struct {
+		name    string
+		keys    string
+		primary string
+		want    string
+		wantErr bool
+	}{
+		{name: "single key", keys: "a:" + k1, want: "a"},
+		{name: "primary of several", keys: "a:" + k1 + ", b:" + k2, primary: "b", want: "b"},
+		{name: "several without primary", keys: "a:" + k1 + ",b:" + k2, wantErr: true},
+		{name: "unknown primary", keys: "a:" + k1, primary: "c", wantErr: true},
+		{name: "missing id", keys: k1, wantErr: true},
+		{name: "short key", keys: "a:" + base64.StdEncoding.EncodeToString(testKey(1)[:16]), wantErr: true},
+		{name: "invalid base64", keys: "a:not base64", wantErr: true},
+		{name: "empty", keys: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := ParseKeyring(tt.keys, tt.primary)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyring: %v", err)
+			}
+			if keyring.Primary() != tt.want {
+				t.Fatalf("expected primary %s, got %s", tt.want, keyring.Primary())
+			}
+		})
+	}
+}
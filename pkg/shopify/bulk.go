@@ -0,0 +1,251 @@
+package shopify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Bulk operation statuses.
+const (
+	BulkOperationCreated   = "CREATED"
+	BulkOperationRunning   = "RUNNING"
+	BulkOperationCompleted = "COMPLETED"
+	BulkOperationCanceling = "CANCELING"
+	BulkOperationCanceled  = "CANCELED"
+	BulkOperationExpired   = "EXPIRED"
+	BulkOperationFailed    = "FAILED"
+)
+
+// bulkResultMaxLine is the longest JSONL line accepted from a bulk operation result.
+const bulkResultMaxLine = 1024 * 1024
+
+// variantBulkQuery selects every product variant with its SKU and inventory item.
+const variantBulkQuery = `
+	{
+		productVariants {
+			edges {
+				node {
+					id
+					sku
+					product {
+						id
+						title
+					}
+					inventoryItem {
+						id
+					}
+				}
+			}
+		}
+	}
+`
+
+// BulkOperation is an asynchronous query run by Shopify over a whole store.
+type BulkOperation struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	ErrorCode   string `json:"errorCode"`
+	ObjectCount int64  `json:"objectCount"`
+	// URL is where the JSONL result can be downloaded once the operation has completed.
+	// It is empty if the query matched nothing.
+	URL string `json:"url"`
+}
+
+// Done reports whether the operation has stopped running, successfully or not.
+func (op *BulkOperation) Done() bool {
+	switch op.Status {
+	case BulkOperationCompleted, BulkOperationCanceled, BulkOperationExpired, BulkOperationFailed:
+		return true
+	}
+	return false
+}
+
+// bulkOperationFields is the GraphQL selection decoded into a BulkOperation.
+const bulkOperationFields = `
+	id
+	status
+	errorCode
+	objectCount
+	url
+`
+
+// bulkOperationNode is a BulkOperation as returned by GraphQL, where objectCount is a string.
+type bulkOperationNode struct {
+	ID          string  `json:"id"`
+	Status      string  `json:"status"`
+	ErrorCode   *string `json:"errorCode"`
+	ObjectCount string  `json:"objectCount"`
+	URL         *string `json:"url"`
+}
+
+func (n *bulkOperationNode) operation() *BulkOperation {
+	op := &BulkOperation{ID: n.ID, Status: n.Status}
+	if n.ErrorCode != nil {
+		op.ErrorCode = *n.ErrorCode
+	}
+	if n.URL != nil {
+		op.URL = *n.URL
+	}
+	op.ObjectCount, _ = strconv.ParseInt(n.ObjectCount, 10, 64)
+	return op
+}
+
+// RunBulkQuery starts a bulk operation for the given query. Shopify runs one bulk query per
+// store at a time, so this fails with user errors while another one is still running.
+func (c *ShopifyClient) RunBulkQuery(ctx context.Context, query string) (*BulkOperation, error) {
+	mutation := `
+		mutation bulkOperationRunQuery($query: String!) {
+			bulkOperationRunQuery(query: $query) {
+				bulkOperation {` + bulkOperationFields + `}
+				userErrors {
+					field
+					message
+					code
+				}
+			}
+		}
+	`
+
+	var response struct {
+		BulkOperationRunQuery struct {
+			BulkOperation *bulkOperationNode `json:"bulkOperation"`
+			UserErrors    []UserError        `json:"userErrors"`
+		} `json:"bulkOperationRunQuery"`
+	}
+	if err := c.Query(ctx, "bulkOperationRunQuery", mutation, map[string]interface{}{"query": query}, &response); err != nil {
+		return nil, err
+	}
+
+	result := response.BulkOperationRunQuery
+	if err := checkUserErrors("bulkOperationRunQuery", result.UserErrors); err != nil {
+		return nil, err
+	}
+	if result.BulkOperation == nil {
+		return nil, errors.New("failed to start bulk operation: no operation returned")
+	}
+	return result.BulkOperation.operation(), nil
+}
+
+// RunVariantBulkQuery starts a bulk operation that exports every product variant of the store.
+// Read its result with ReadBulkVariants.
+func (c *ShopifyClient) RunVariantBulkQuery(ctx context.Context) (*BulkOperation, error) {
+	return c.RunBulkQuery(ctx, variantBulkQuery)
+}
+
+// GetBulkOperation retrieves the current state of a bulk operation by its global ID.
+func (c *ShopifyClient) GetBulkOperation(ctx context.Context, id string) (*BulkOperation, error) {
+	query := `
+		query bulkOperation($id: ID!) {
+			node(id: $id) {
+				... on BulkOperation {` + bulkOperationFields + `}
+			}
+		}
+	`
+
+	var response struct {
+		Node *bulkOperationNode `json:"node"`
+	}
+	if err := c.Query(ctx, "bulkOperation", query, map[string]interface{}{"id": id}, &response); err != nil {
+		return nil, err
+	}
+	if response.Node == nil || response.Node.ID == "" {
+		return nil, fmt.Errorf("bulk operation %s not found", id)
+	}
+	return response.Node.operation(), nil
+}
+
+// WaitForBulkOperation polls a bulk operation every interval until it is done or ctx is cancelled.
+// Prefer the bulk_operations/finish webhook where one is subscribed.
+func (c *ShopifyClient) WaitForBulkOperation(ctx context.Context, id string, interval time.Duration) (*BulkOperation, error) {
+	for {
+		op, err := c.GetBulkOperation(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if op.Done() {
+			return op, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ReadBulkResult downloads the JSONL result of a completed bulk operation and calls fn for
+// every line as it is read, without holding the whole result in memory.
+func ReadBulkResult(ctx context.Context, url string, fn func(line []byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download bulk operation result: %w", err)
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download bulk operation result: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to download bulk operation result: status %s, body: %s", resp.Status, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), bulkResultMaxLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read bulk operation result: %w", err)
+	}
+	return nil
+}
+
+// ReadBulkVariants streams the result of a RunVariantBulkQuery operation, calling fn for every
+// variant. Variants read this way carry no inventory levels.
+func ReadBulkVariants(ctx context.Context, url string, fn func(Variant) error) error {
+	return ReadBulkResult(ctx, url, func(line []byte) error {
+		var node struct {
+			ID       string `json:"id"`
+			SKU      string `json:"sku"`
+			ParentID string `json:"__parentId"`
+			Product  struct {
+				ID    string `json:"id"`
+				Title string `json:"title"`
+			} `json:"product"`
+			InventoryItem struct {
+				ID string `json:"id"`
+			} `json:"inventoryItem"`
+		}
+		if err := json.Unmarshal(line, &node); err != nil {
+			return fmt.Errorf("failed to decode bulk operation result line: %w", err)
+		}
+		if node.ParentID != "" {
+			return nil
+		}
+
+		return fn(Variant{
+			ID:              legacyID(node.ID),
+			ProductID:       legacyID(node.Product.ID),
+			ProductTitle:    node.Product.Title,
+			SKU:             node.SKU,
+			InventoryItemID: legacyID(node.InventoryItem.ID),
+		})
+	})
+}
@@ -0,0 +1,503 @@
+package shopify_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"gostockly/pkg/shopify"
+	"gostockly/pkg/shopify/shopifytest"
+)
+
+func newFakeShop(t *testing.T) (*shopifytest.Server, string) {
+	t.Helper()
+	server := shopifytest.NewServer()
+	t.Cleanup(server.Close)
+	// Rate limit and deprecation state is kept per shop across tests, so every test gets its own
+	return server, fmt.Sprintf("test-shop-%d", time.Now().UnixNano())
+}
+
+func id(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func TestAdjustQuantitiesUpdatesLevels(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	first := server.AddVariant(shop, "Shirt", "SHIRT-S")
+	second := server.AddVariant(shop, "Shirt", "SHIRT-M")
+	server.SetAvailable(shop, first.InventoryItemID, location.ID, 10)
+	server.SetAvailable(shop, second.InventoryItemID, location.ID, 3)
+
+	group, err := server.Client(shop).AdjustQuantities(context.Background(), shopify.AdjustQuantitiesInput{
+		Reason: "correction",
+		Name:   "available",
+		Changes: []shopify.QuantityChange{
+			{InventoryItemID: id(first.InventoryItemID), LocationID: id(location.ID), Delta: -4},
+			{InventoryItemID: id(second.InventoryItemID), LocationID: id(location.ID), Delta: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AdjustQuantities failed: %v", err)
+	}
+
+	if len(group.Changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(group.Changes))
+	}
+	if after := group.Changes[0].QuantityAfterChange; after == nil || *after != 6 {
+		t.Fatalf("expected quantity after change 6, got %v", after)
+	}
+	if quantity, _ := server.Available(shop, first.InventoryItemID, location.ID); quantity != 6 {
+		t.Fatalf("expected 6 available, got %d", quantity)
+	}
+	if quantity, _ := server.Available(shop, second.InventoryItemID, location.ID); quantity != 5 {
+		t.Fatalf("expected 5 available, got %d", quantity)
+	}
+}
+
+func TestAdjustQuantitiesRejectsUnstockedItem(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	stocked := server.AddVariant(shop, "Shirt", "SHIRT-S")
+	unstocked := server.AddVariant(shop, "Shirt", "SHIRT-M")
+	server.SetAvailable(shop, stocked.InventoryItemID, location.ID, 10)
+
+	_, err := server.Client(shop).AdjustQuantities(context.Background(), shopify.AdjustQuantitiesInput{
+		Reason: "correction",
+		Name:   "available",
+		Changes: []shopify.QuantityChange{
+			{InventoryItemID: id(stocked.InventoryItemID), LocationID: id(location.ID), Delta: -1},
+			{InventoryItemID: id(unstocked.InventoryItemID), LocationID: id(location.ID), Delta: -1},
+		},
+	})
+
+	var shopifyErr *shopify.Error
+	if !errors.As(err, &shopifyErr) || shopifyErr.Kind != shopify.ErrorUserErrors {
+		t.Fatalf("expected user errors, got %v", err)
+	}
+	if len(shopifyErr.UserErrors) != 1 {
+		t.Fatalf("expected 1 user error, got %d", len(shopifyErr.UserErrors))
+	}
+	if index, ok := shopifyErr.UserErrors[0].InputIndex("changes"); !ok || index != 1 {
+		t.Fatalf("expected the error to point at change 1, got %d, %v", index, ok)
+	}
+	// Shopify applies all changes of a mutation or none
+	if quantity, _ := server.Available(shop, stocked.InventoryItemID, location.ID); quantity != 10 {
+		t.Fatalf("expected the stocked item to be left at 10, got %d", quantity)
+	}
+}
+
+func TestSetQuantities(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+	server.SetAvailable(shop, variant.InventoryItemID, location.ID, 10)
+	client := server.Client(shop)
+
+	stale := 7
+	_, err := client.SetQuantities(context.Background(), shopify.SetQuantitiesInput{
+		Reason: "correction",
+		Name:   "available",
+		Quantities: []shopify.QuantitySet{
+			{InventoryItemID: id(variant.InventoryItemID), LocationID: id(location.ID), Quantity: 4, CompareQuantity: &stale},
+		},
+	})
+	if !shopify.IsErrorKind(err, shopify.ErrorUserErrors) {
+		t.Fatalf("expected a stale compare quantity to be rejected, got %v", err)
+	}
+
+	current := 10
+	_, err = client.SetQuantities(context.Background(), shopify.SetQuantitiesInput{
+		Reason: "correction",
+		Name:   "available",
+		Quantities: []shopify.QuantitySet{
+			{InventoryItemID: id(variant.InventoryItemID), LocationID: id(location.ID), Quantity: 4, CompareQuantity: &current},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetQuantities failed: %v", err)
+	}
+
+	available, err := client.GetInventoryLevel(context.Background(), id(variant.InventoryItemID), id(location.ID))
+	if err != nil {
+		t.Fatalf("GetInventoryLevel failed: %v", err)
+	}
+	if available != 4 {
+		t.Fatalf("expected 4 available, got %d", available)
+	}
+}
+
+func TestGetInventoryLevelNotStocked(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+
+	_, err := server.Client(shop).GetInventoryLevel(context.Background(), id(variant.InventoryItemID), id(location.ID))
+	if !errors.Is(err, shopify.ErrNotStocked) {
+		t.Fatalf("expected ErrNotStocked, got %v", err)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure shopifytest.Failure
+		times   int
+		kind    shopify.ErrorKind
+	}{
+		{
+			name:    "retries a throttled request",
+			failure: shopifytest.Failure{Throttled: true},
+			times:   2,
+		},
+		{
+			name:    "retries a 429",
+			failure: shopifytest.Failure{Status: http.StatusTooManyRequests},
+			times:   2,
+		},
+		{
+			name:    "gives up when throttling persists",
+			failure: shopifytest.Failure{Throttled: true},
+			times:   10,
+			kind:    shopify.ErrorThrottled,
+		},
+		{
+			name:    "reports an HTTP status",
+			failure: shopifytest.Failure{Status: http.StatusInternalServerError, Body: "internal error"},
+			times:   1,
+			kind:    shopify.ErrorHTTPStatus,
+		},
+		{
+			name:    "reports GraphQL errors",
+			failure: shopifytest.Failure{GraphQLErrors: []string{"Field 'foo' doesn't exist"}},
+			times:   1,
+			kind:    shopify.ErrorGraphQL,
+		},
+		{
+			name: "reports injected user errors",
+			failure: shopifytest.Failure{UserErrors: []shopify.UserError{
+				{Field: []string{"input", "changes", "0", "delta"}, Message: "Delta is invalid"},
+			}},
+			times: 1,
+			kind:  shopify.ErrorUserErrors,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, shop := newFakeShop(t)
+			location := server.AddLocation(shop, "Warehouse")
+			variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+			server.SetAvailable(shop, variant.InventoryItemID, location.ID, 10)
+			server.Fail(shop, "inventoryAdjustQuantities", tt.times, tt.failure)
+
+			_, err := server.Client(shop).AdjustQuantities(context.Background(), shopify.AdjustQuantitiesInput{
+				Reason: "correction",
+				Name:   "available",
+				Changes: []shopify.QuantityChange{
+					{InventoryItemID: id(variant.InventoryItemID), LocationID: id(location.ID), Delta: -1},
+				},
+			})
+
+			if tt.kind == "" {
+				if err != nil {
+					t.Fatalf("expected the request to succeed after retrying, got %v", err)
+				}
+				if quantity, _ := server.Available(shop, variant.InventoryItemID, location.ID); quantity != 9 {
+					t.Fatalf("expected the change to be applied once, got %d available", quantity)
+				}
+				return
+			}
+			if !shopify.IsErrorKind(err, tt.kind) {
+				t.Fatalf("expected a %s error, got %v", tt.kind, err)
+			}
+		})
+	}
+}
+
+func TestQueryWaitsForCostLimit(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+	server.SetAvailable(shop, variant.InventoryItemID, location.ID, 10)
+	server.SetCostLimit(shop, 2, 20)
+	client := server.Client(shop)
+
+	for i := 0; i < 6; i++ {
+		if _, err := client.GetInventoryLevel(context.Background(), id(variant.InventoryItemID), id(location.ID)); err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+	}
+}
+
+func TestQueryHonoursContext(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := server.Client(shop).GetInventoryLevel(ctx, id(variant.InventoryItemID), id(location.ID))
+	if !shopify.IsErrorKind(err, shopify.ErrorTransport) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a transport error wrapping context.Canceled, got %v", err)
+	}
+}
+
+func TestListVariantsPaginates(t *testing.T) {
+	server, shop := newFakeShop(t)
+	location := server.AddLocation(shop, "Warehouse")
+	const count = 260
+	for i := 0; i < count; i++ {
+		variant := server.AddVariant(shop, "Sticker", fmt.Sprintf("STICKER-%03d", i))
+		server.SetAvailable(shop, variant.InventoryItemID, location.ID, i)
+	}
+
+	var variants []shopify.Variant
+	err := server.Client(shop).ListVariants(context.Background(), func(variant shopify.Variant) error {
+		variants = append(variants, variant)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListVariants failed: %v", err)
+	}
+
+	if len(variants) != count {
+		t.Fatalf("expected %d variants, got %d", count, len(variants))
+	}
+	last := variants[count-1]
+	if last.SKU != "STICKER-259" {
+		t.Fatalf("expected the last variant to be STICKER-259, got %s", last.SKU)
+	}
+	if len(last.InventoryLevels) != 1 || last.InventoryLevels[0].LocationID != location.ID || last.InventoryLevels[0].Available != 259 {
+		t.Fatalf("unexpected inventory levels %+v", last.InventoryLevels)
+	}
+}
+
+func TestListVariantsFetchesRemainingLevels(t *testing.T) {
+	server, shop := newFakeShop(t)
+	variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+	const locations = 60
+	for i := 0; i < locations; i++ {
+		location := server.AddLocation(shop, fmt.Sprintf("Store %d", i))
+		server.SetAvailable(shop, variant.InventoryItemID, location.ID, 1)
+	}
+
+	var levels int
+	err := server.Client(shop).ListVariants(context.Background(), func(variant shopify.Variant) error {
+		levels += len(variant.InventoryLevels)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListVariants failed: %v", err)
+	}
+	if levels != locations {
+		t.Fatalf("expected %d inventory levels, got %d", locations, levels)
+	}
+}
+
+func TestListVariantsStaysWithinMaxQueryCost(t *testing.T) {
+	server, shop := newFakeShop(t)
+	const variants, locations = 120, 15
+	var shopLocations []shopifytest.Location
+	for i := 0; i < locations; i++ {
+		shopLocations = append(shopLocations, server.AddLocation(shop, fmt.Sprintf("Store %d", i)))
+	}
+	for i := 0; i < variants; i++ {
+		variant := server.AddVariant(shop, "Sticker", fmt.Sprintf("STICKER-%03d", i))
+		for _, location := range shopLocations {
+			server.SetAvailable(shop, variant.InventoryItemID, location.ID, i)
+		}
+	}
+
+	var levels int
+	err := server.Client(shop).ListVariants(context.Background(), func(variant shopify.Variant) error {
+		levels += len(variant.InventoryLevels)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListVariants failed: %v", err)
+	}
+	if levels != variants*locations {
+		t.Fatalf("expected %d inventory levels, got %d", variants*locations, levels)
+	}
+	for _, request := range server.Requests(shop) {
+		if request.Cost > 1000 {
+			t.Fatalf("%s requested %d points, more than a single query may cost", request.Operation, request.Cost)
+		}
+	}
+}
+
+func TestQueryOverMaxCostIsRejected(t *testing.T) {
+	server, shop := newFakeShop(t)
+	server.AddVariant(shop, "Shirt", "SHIRT-S")
+
+	query := `
+		query productVariants {
+			productVariants(first: 250) {
+				nodes {
+					inventoryItem {
+						inventoryLevels(first: 50) {
+							nodes {
+								id
+							}
+						}
+					}
+				}
+			}
+		}
+	`
+	err := server.Client(shop).Query(context.Background(), "productVariants", query, nil, nil)
+	if !shopify.IsErrorKind(err, shopify.ErrorGraphQL) {
+		t.Fatalf("expected a GraphQL error, got %v", err)
+	}
+	requests := server.Requests(shop)
+	if cost := requests[len(requests)-1].Cost; cost != 2+250+250*(2+50)+1 {
+		t.Fatalf("expected the fake to charge the connection cost, got %d", cost)
+	}
+}
+
+func TestListLocations(t *testing.T) {
+	server, shop := newFakeShop(t)
+	warehouse := server.AddLocation(shop, "Warehouse")
+	server.AddLocation(shop, "Shop floor")
+
+	locations, err := server.Client(shop).ListLocations(context.Background())
+	if err != nil {
+		t.Fatalf("ListLocations failed: %v", err)
+	}
+	if len(locations) != 2 {
+		t.Fatalf("expected 2 locations, got %d", len(locations))
+	}
+	if locations[0].ID != warehouse.ID || locations[0].Name != "Warehouse" || !locations[0].IsActive {
+		t.Fatalf("unexpected location %+v", locations[0])
+	}
+}
+
+func TestGetLineItemSKUs(t *testing.T) {
+	server, shop := newFakeShop(t)
+	lineItem := server.AddLineItem(shop, "SHIRT-S")
+
+	skus, err := server.Client(shop).GetLineItemSKUs(context.Background(), []int64{lineItem, 1})
+	if err != nil {
+		t.Fatalf("GetLineItemSKUs failed: %v", err)
+	}
+	if len(skus) != 1 || skus[lineItem] != "SHIRT-S" {
+		t.Fatalf("unexpected SKUs %v", skus)
+	}
+}
+
+func TestGetRestockedQuantities(t *testing.T) {
+	server, shop := newFakeShop(t)
+	shirt := server.AddLineItem(shop, "SHIRT-S")
+	socks := server.AddLineItem(shop, "SOCKS")
+	editedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// An earlier refund is not part of the edit
+	server.AddRefund(shop, 100, editedAt.Add(-time.Hour), shopifytest.RefundLineItem{LineItemID: shirt, Quantity: 1, Restocked: true})
+	server.AddRefund(shop, 100, editedAt,
+		shopifytest.RefundLineItem{LineItemID: shirt, Quantity: 2, Restocked: true},
+		shopifytest.RefundLineItem{LineItemID: socks, Quantity: 1, Restocked: false},
+	)
+	server.AddRefund(shop, 200, editedAt, shopifytest.RefundLineItem{LineItemID: socks, Quantity: 4, Restocked: true})
+
+	restocked, err := server.Client(shop).GetRestockedQuantities(context.Background(), 100, editedAt)
+	if err != nil {
+		t.Fatalf("GetRestockedQuantities failed: %v", err)
+	}
+	if len(restocked) != 1 || restocked[shirt] != 2 {
+		t.Fatalf("unexpected restocked quantities %v", restocked)
+	}
+}
+
+func TestGetAssignedLocations(t *testing.T) {
+	server, shop := newFakeShop(t)
+	east := server.AddLocation(shop, "East")
+	west := server.AddLocation(shop, "West")
+	shirt := server.AddLineItem(shop, "SHIRT-S")
+	socks := server.AddLineItem(shop, "SOCKS")
+	hat := server.AddLineItem(shop, "HAT")
+
+	server.AddFulfillmentOrder(shop, 100, east.ID, shirt, hat)
+	// Part of the hats leave from West, so they are not assigned to a single location
+	server.AddFulfillmentOrder(shop, 100, west.ID, socks, hat)
+	server.AddFulfillmentOrder(shop, 200, west.ID, shirt)
+
+	assigned, err := server.Client(shop).GetAssignedLocations(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("GetAssignedLocations failed: %v", err)
+	}
+	want := map[int64]string{shirt: id(east.ID), socks: id(west.ID), hat: ""}
+	if len(assigned) != len(want) {
+		t.Fatalf("expected assigned locations %v, got %v", want, assigned)
+	}
+	for lineItemID, locationID := range want {
+		if assigned[lineItemID] != locationID {
+			t.Fatalf("expected assigned locations %v, got %v", want, assigned)
+		}
+	}
+}
+
+func TestBulkVariantExport(t *testing.T) {
+	server, shop := newFakeShop(t)
+	server.AddVariant(shop, "Shirt", "SHIRT-S")
+	server.AddVariant(shop, "Shirt", "SHIRT-M")
+	client := server.Client(shop)
+	ctx := context.Background()
+
+	op, err := client.RunVariantBulkQuery(ctx)
+	if err != nil {
+		t.Fatalf("RunVariantBulkQuery failed: %v", err)
+	}
+	if _, err := client.RunVariantBulkQuery(ctx); !shopify.IsErrorKind(err, shopify.ErrorUserErrors) {
+		t.Fatalf("expected a second bulk query to be rejected while one runs, got %v", err)
+	}
+
+	op, err = client.WaitForBulkOperation(ctx, op.ID, time.Millisecond)
+	if err != nil {
+		t.Fatalf("WaitForBulkOperation failed: %v", err)
+	}
+	if op.Status != shopify.BulkOperationCompleted || op.ObjectCount != 2 {
+		t.Fatalf("unexpected bulk operation %+v", op)
+	}
+
+	var skus []string
+	err = shopify.ReadBulkVariants(ctx, op.URL, func(variant shopify.Variant) error {
+		skus = append(skus, variant.SKU)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadBulkVariants failed: %v", err)
+	}
+	if len(skus) != 2 || skus[0] != "SHIRT-S" || skus[1] != "SHIRT-M" {
+		t.Fatalf("unexpected SKUs %v", skus)
+	}
+}
+
+func TestDeprecatedCallsAreReported(t *testing.T) {
+	server, shop := newFakeShop(t)
+	variant := server.AddVariant(shop, "Shirt", "SHIRT-S")
+	location := server.AddLocation(shop, "Warehouse")
+	server.Deprecate(shop, "inventoryLevel is deprecated")
+
+	var reports []string
+	shopify.OnDeprecatedCall(func(reportedShop, apiVersion, reason string) {
+		if reportedShop == shop {
+			reports = append(reports, apiVersion+": "+reason)
+		}
+	})
+	t.Cleanup(func() { shopify.OnDeprecatedCall(nil) })
+
+	client := server.Client(shop)
+	for i := 0; i < 3; i++ {
+		client.GetInventoryLevel(context.Background(), id(variant.InventoryItemID), id(location.ID))
+	}
+
+	want := shopify.DefaultVersion() + ": inventoryLevel is deprecated"
+	if len(reports) != 1 || reports[0] != want {
+		t.Fatalf("expected a single report %q, got %v", want, reports)
+	}
+}
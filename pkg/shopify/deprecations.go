@@ -0,0 +1,51 @@
+package shopify
+
+import (
+	"gostockly/pkg/logger"
+	"sync"
+	"time"
+)
+
+// deprecatedReasonHeader is set by Shopify on responses to requests that use deprecated fields
+// or an API version that is about to become unsupported.
+const deprecatedReasonHeader = "X-Shopify-API-Deprecated-Reason"
+
+// deprecationReportInterval is how often the same deprecation of a shop is passed to the handler.
+const deprecationReportInterval = time.Hour
+
+// DeprecationHandler is called when a request to shop with apiVersion was flagged as deprecated.
+type DeprecationHandler func(shop, apiVersion, reason string)
+
+var (
+	deprecationMu       sync.Mutex
+	deprecationHandler  DeprecationHandler
+	deprecationReported = make(map[string]time.Time)
+)
+
+// OnDeprecatedCall sets the handler called when Shopify flags a request as deprecated.
+// Repeats of the same deprecation for a shop are reported at most once an hour.
+func OnDeprecatedCall(handler DeprecationHandler) {
+	deprecationMu.Lock()
+	defer deprecationMu.Unlock()
+	deprecationHandler = handler
+}
+
+// reportDeprecation logs a deprecated request and passes it to the handler unless it has been
+// reported recently.
+func reportDeprecation(shop, apiVersion, reason string) {
+	key := shop + "\x00" + apiVersion + "\x00" + reason
+
+	deprecationMu.Lock()
+	handler := deprecationHandler
+	if last, ok := deprecationReported[key]; ok && time.Since(last) < deprecationReportInterval {
+		deprecationMu.Unlock()
+		return
+	}
+	deprecationReported[key] = time.Now()
+	deprecationMu.Unlock()
+
+	logger.GetLogger().Error("Shopify flagged a request of shop %s on API version %s as deprecated: %s", shop, apiVersion, reason)
+	if handler != nil {
+		handler(shop, apiVersion, reason)
+	}
+}
@@ -0,0 +1,87 @@
+package shopify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorKind tells apart the ways a Shopify request can fail.
+type ErrorKind string
+
+const (
+	// ErrorTransport means the request did not get a response, for example a timeout or a refused connection.
+	ErrorTransport ErrorKind = "transport"
+	// ErrorHTTPStatus means Shopify answered with an unexpected HTTP status.
+	ErrorHTTPStatus ErrorKind = "http_status"
+	// ErrorGraphQL means the query was rejected with top-level GraphQL errors.
+	ErrorGraphQL ErrorKind = "graphql"
+	// ErrorThrottled means the request was still throttled after all retries.
+	ErrorThrottled ErrorKind = "throttled"
+	// ErrorUserErrors means a mutation ran but rejected its input with userErrors.
+	ErrorUserErrors ErrorKind = "user_errors"
+)
+
+// ErrNotStocked is returned when an inventory item is not stocked at the requested location.
+var ErrNotStocked = errors.New("inventory item is not stocked at this location")
+
+// UserError is an input error reported by a mutation. Field is the path to the input that
+// caused it, such as ["input", "changes", "2", "delta"].
+type UserError struct {
+	Field   []string `json:"field"`
+	Message string   `json:"message"`
+	Code    string   `json:"code"`
+}
+
+// Error is returned by every failed Shopify request.
+type Error struct {
+	Kind ErrorKind
+	// Operation is the query or mutation that failed
+	Operation string
+	// StatusCode is the HTTP status for ErrorHTTPStatus and throttled 429 responses
+	StatusCode int
+	// Messages holds the top-level GraphQL error messages, or the response body for ErrorHTTPStatus
+	Messages []string
+	// UserErrors holds the input errors for ErrorUserErrors
+	UserErrors []UserError
+	// Err is the underlying error for ErrorTransport
+	Err error
+}
+
+func (e *Error) Error() string {
+	prefix := "Shopify " + e.Operation + " failed"
+	switch e.Kind {
+	case ErrorTransport:
+		return fmt.Sprintf("%s: %v", prefix, e.Err)
+	case ErrorHTTPStatus:
+		return fmt.Sprintf("%s with status %d: %s", prefix, e.StatusCode, strings.Join(e.Messages, "; "))
+	case ErrorThrottled:
+		return prefix + ": throttled"
+	case ErrorUserErrors:
+		messages := make([]string, len(e.UserErrors))
+		for i, userError := range e.UserErrors {
+			messages[i] = userError.Message
+		}
+		return fmt.Sprintf("%s with user errors: %s", prefix, strings.Join(messages, "; "))
+	default:
+		return fmt.Sprintf("%s: %s", prefix, strings.Join(e.Messages, "; "))
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsErrorKind reports whether err is a Shopify Error of the given kind.
+func IsErrorKind(err error, kind ErrorKind) bool {
+	var shopifyErr *Error
+	return errors.As(err, &shopifyErr) && shopifyErr.Kind == kind
+}
+
+// checkUserErrors turns the userErrors of a mutation into an Error, or returns nil if there are none.
+func checkUserErrors(operation string, userErrors []UserError) error {
+	if len(userErrors) == 0 {
+		return nil
+	}
+	return &Error{Kind: ErrorUserErrors, Operation: operation, UserErrors: userErrors}
+}
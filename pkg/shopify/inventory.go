@@ -0,0 +1,354 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// QuantityChange changes a quantity of an inventory item at a location by Delta.
+// Both IDs are the numeric Shopify IDs.
+type QuantityChange struct {
+	InventoryItemID string
+	LocationID      string
+	Delta           int
+}
+
+// AdjustQuantitiesInput is the input of an inventoryAdjustQuantities mutation.
+type AdjustQuantitiesInput struct {
+	// Reason is one of Shopify's inventory change reasons, such as "movement_created"
+	Reason string
+	// Name is the quantity being changed, such as "available"
+	Name                 string
+	ReferenceDocumentURI string
+	Changes              []QuantityChange
+}
+
+// QuantitySet sets a quantity of an inventory item at a location. The set is rejected if the
+// current quantity is not CompareQuantity, unless the input ignores compare quantities.
+type QuantitySet struct {
+	InventoryItemID string
+	LocationID      string
+	Quantity        int
+	CompareQuantity *int
+}
+
+// SetQuantitiesInput is the input of an inventorySetQuantities mutation.
+type SetQuantitiesInput struct {
+	Reason                string
+	Name                  string
+	ReferenceDocumentURI  string
+	IgnoreCompareQuantity bool
+	Quantities            []QuantitySet
+}
+
+// AdjustmentChange is a quantity change recorded by Shopify.
+type AdjustmentChange struct {
+	Name                string
+	Delta               int
+	QuantityAfterChange *int
+	InventoryItemID     string
+	LocationID          string
+}
+
+// AdjustmentGroup is the set of changes made by one inventory mutation.
+type AdjustmentGroup struct {
+	Reason               string
+	ReferenceDocumentURI string
+	Changes              []AdjustmentChange
+}
+
+// InputIndex returns the position of the input list element a user error is about, for field
+// paths such as ["input", "changes", "2", "delta"]. It returns false for errors about the whole input.
+func (e UserError) InputIndex(list string) (int, bool) {
+	if len(e.Field) < 3 || e.Field[0] != "input" || e.Field[1] != list {
+		return 0, false
+	}
+	index, err := strconv.Atoi(e.Field[2])
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// adjustmentGroupFields is the GraphQL selection decoded into an adjustmentGroupNode.
+const adjustmentGroupFields = `
+	reason
+	referenceDocumentUri
+	changes {
+		name
+		delta
+		quantityAfterChange
+		item {
+			id
+		}
+		location {
+			id
+		}
+	}
+`
+
+type adjustmentGroupNode struct {
+	Reason               string  `json:"reason"`
+	ReferenceDocumentURI *string `json:"referenceDocumentUri"`
+	Changes              []struct {
+		Name                string `json:"name"`
+		Delta               int    `json:"delta"`
+		QuantityAfterChange *int   `json:"quantityAfterChange"`
+		Item                struct {
+			ID string `json:"id"`
+		} `json:"item"`
+		Location struct {
+			ID string `json:"id"`
+		} `json:"location"`
+	} `json:"changes"`
+}
+
+func (n *adjustmentGroupNode) group() *AdjustmentGroup {
+	if n == nil {
+		return &AdjustmentGroup{}
+	}
+	group := &AdjustmentGroup{Reason: n.Reason, Changes: make([]AdjustmentChange, len(n.Changes))}
+	if n.ReferenceDocumentURI != nil {
+		group.ReferenceDocumentURI = *n.ReferenceDocumentURI
+	}
+	for i, change := range n.Changes {
+		group.Changes[i] = AdjustmentChange{
+			Name:                change.Name,
+			Delta:               change.Delta,
+			QuantityAfterChange: change.QuantityAfterChange,
+			InventoryItemID:     strconv.FormatInt(legacyID(change.Item.ID), 10),
+			LocationID:          strconv.FormatInt(legacyID(change.Location.ID), 10),
+		}
+	}
+	return group
+}
+
+// AdjustQuantities applies all changes of the input in one inventoryAdjustQuantities mutation.
+// Shopify applies all of them or none; if any is rejected the returned *Error has kind
+// ErrorUserErrors, use UserError.InputIndex("changes") to find the rejected changes.
+func (c *ShopifyClient) AdjustQuantities(ctx context.Context, input AdjustQuantitiesInput) (*AdjustmentGroup, error) {
+	mutation := `
+		mutation inventoryAdjustQuantities($input: InventoryAdjustQuantitiesInput!) {
+			inventoryAdjustQuantities(input: $input) {
+				userErrors {
+					field
+					message
+					code
+				}
+				inventoryAdjustmentGroup {` + adjustmentGroupFields + `}
+			}
+		}
+	`
+
+	changes := make([]map[string]interface{}, len(input.Changes))
+	for i, change := range input.Changes {
+		changes[i] = map[string]interface{}{
+			"delta":           change.Delta,
+			"inventoryItemId": inventoryItemGID(change.InventoryItemID),
+			"locationId":      locationGID(change.LocationID),
+		}
+	}
+
+	var response struct {
+		InventoryAdjustQuantities struct {
+			UserErrors               []UserError          `json:"userErrors"`
+			InventoryAdjustmentGroup *adjustmentGroupNode `json:"inventoryAdjustmentGroup"`
+		} `json:"inventoryAdjustQuantities"`
+	}
+	err := c.Query(ctx, "inventoryAdjustQuantities", mutation, map[string]interface{}{
+		"input": map[string]interface{}{
+			"reason":               input.Reason,
+			"name":                 input.Name,
+			"referenceDocumentUri": input.ReferenceDocumentURI,
+			"changes":              changes,
+		},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.InventoryAdjustQuantities
+	if err := checkUserErrors("inventoryAdjustQuantities", result.UserErrors); err != nil {
+		return nil, err
+	}
+	return result.InventoryAdjustmentGroup.group(), nil
+}
+
+// SetQuantities sets all quantities of the input in one inventorySetQuantities mutation.
+// Rejected quantities are reported like in AdjustQuantities, under the "quantities" list.
+func (c *ShopifyClient) SetQuantities(ctx context.Context, input SetQuantitiesInput) (*AdjustmentGroup, error) {
+	mutation := `
+		mutation inventorySetQuantities($input: InventorySetQuantitiesInput!) {
+			inventorySetQuantities(input: $input) {
+				userErrors {
+					field
+					message
+					code
+				}
+				inventoryAdjustmentGroup {` + adjustmentGroupFields + `}
+			}
+		}
+	`
+
+	quantities := make([]map[string]interface{}, len(input.Quantities))
+	for i, quantity := range input.Quantities {
+		quantities[i] = map[string]interface{}{
+			"inventoryItemId": inventoryItemGID(quantity.InventoryItemID),
+			"locationId":      locationGID(quantity.LocationID),
+			"quantity":        quantity.Quantity,
+		}
+		if quantity.CompareQuantity != nil {
+			quantities[i]["compareQuantity"] = *quantity.CompareQuantity
+		}
+	}
+
+	var response struct {
+		InventorySetQuantities struct {
+			UserErrors               []UserError          `json:"userErrors"`
+			InventoryAdjustmentGroup *adjustmentGroupNode `json:"inventoryAdjustmentGroup"`
+		} `json:"inventorySetQuantities"`
+	}
+	err := c.Query(ctx, "inventorySetQuantities", mutation, map[string]interface{}{
+		"input": map[string]interface{}{
+			"reason":                input.Reason,
+			"name":                  input.Name,
+			"referenceDocumentUri":  input.ReferenceDocumentURI,
+			"ignoreCompareQuantity": input.IgnoreCompareQuantity,
+			"quantities":            quantities,
+		},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.InventorySetQuantities
+	if err := checkUserErrors("inventorySetQuantities", result.UserErrors); err != nil {
+		return nil, err
+	}
+	return result.InventoryAdjustmentGroup.group(), nil
+}
+
+// availableQuantities is the quantities selection of an inventory level.
+type availableQuantities struct {
+	Quantities []struct {
+		Name     string `json:"name"`
+		Quantity int    `json:"quantity"`
+	} `json:"quantities"`
+}
+
+func (q *availableQuantities) available() (int, bool) {
+	for _, quantity := range q.Quantities {
+		if quantity.Name == "available" {
+			return quantity.Quantity, true
+		}
+	}
+	return 0, false
+}
+
+// GetInventoryLevel retrieves the available quantity of an inventory item at a location.
+// Both IDs are the numeric Shopify IDs. It returns ErrNotStocked if the item is not stocked there.
+func (c *ShopifyClient) GetInventoryLevel(ctx context.Context, inventoryItemID, locationID string) (int, error) {
+	query := `
+		query inventoryLevel($inventoryItemId: ID!, $locationId: ID!) {
+			inventoryItem(id: $inventoryItemId) {
+				inventoryLevel(locationId: $locationId) {
+					quantities(names: ["available"]) {
+						name
+						quantity
+					}
+				}
+			}
+		}
+	`
+
+	var response struct {
+		InventoryItem *struct {
+			InventoryLevel *availableQuantities `json:"inventoryLevel"`
+		} `json:"inventoryItem"`
+	}
+	err := c.Query(ctx, "inventoryLevel", query, map[string]interface{}{
+		"inventoryItemId": inventoryItemGID(inventoryItemID),
+		"locationId":      locationGID(locationID),
+	}, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	item := response.InventoryItem
+	if item == nil || item.InventoryLevel == nil {
+		return 0, ErrNotStocked
+	}
+	available, ok := item.InventoryLevel.available()
+	if !ok {
+		return 0, errors.New("available quantity missing from response")
+	}
+	return available, nil
+}
+
+// inventoryNodesPageSize is how many inventory items are requested per nodes query.
+const inventoryNodesPageSize = 100
+
+// GetInventoryLevels retrieves the available quantities of many inventory items at a location.
+// Both kinds of IDs are the numeric Shopify IDs. Items that no longer exist or are not stocked at
+// the location are left out of the result.
+func (c *ShopifyClient) GetInventoryLevels(ctx context.Context, inventoryItemIDs []string, locationID string) (map[string]int, error) {
+	query := `
+		query inventoryLevels($ids: [ID!]!, $locationId: ID!) {
+			nodes(ids: $ids) {
+				... on InventoryItem {
+					id
+					inventoryLevel(locationId: $locationId) {
+						quantities(names: ["available"]) {
+							name
+							quantity
+						}
+					}
+				}
+			}
+		}
+	`
+
+	quantities := make(map[string]int, len(inventoryItemIDs))
+	for start := 0; start < len(inventoryItemIDs); start += inventoryNodesPageSize {
+		end := min(start+inventoryNodesPageSize, len(inventoryItemIDs))
+
+		ids := make([]string, 0, end-start)
+		for _, id := range inventoryItemIDs[start:end] {
+			ids = append(ids, inventoryItemGID(id))
+		}
+
+		var response struct {
+			Nodes []*struct {
+				ID             string               `json:"id"`
+				InventoryLevel *availableQuantities `json:"inventoryLevel"`
+			} `json:"nodes"`
+		}
+		err := c.Query(ctx, "inventoryLevels", query, map[string]interface{}{
+			"ids":        ids,
+			"locationId": locationGID(locationID),
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range response.Nodes {
+			if node == nil || node.InventoryLevel == nil {
+				continue
+			}
+			if available, ok := node.InventoryLevel.available(); ok {
+				quantities[strconv.FormatInt(legacyID(node.ID), 10)] = available
+			}
+		}
+	}
+	return quantities, nil
+}
+
+func inventoryItemGID(id string) string {
+	return fmt.Sprintf("gid://shopify/InventoryItem/%s", id)
+}
+
+func locationGID(id string) string {
+	return fmt.Sprintf("gid://shopify/Location/%s", id)
+}
@@ -0,0 +1,78 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+)
+
+// locationPageSize is how many locations are requested per page.
+const locationPageSize = 250
+
+// Location is a place where a store stocks inventory.
+type Location struct {
+	ID                   int64  `json:"id"`
+	Name                 string `json:"name"`
+	IsActive             bool   `json:"is_active"`
+	FulfillsOnlineOrders bool   `json:"fulfills_online_orders"`
+}
+
+// ListLocations retrieves every location of the store, including deactivated ones.
+func (c *ShopifyClient) ListLocations(ctx context.Context) ([]Location, error) {
+	query := `
+		query locations($first: Int!, $after: String) {
+			locations(first: $first, after: $after, includeInactive: true) {
+				nodes {
+					id
+					name
+					isActive
+					fulfillsOnlineOrders
+				}
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
+			}
+		}
+	`
+
+	var locations []Location
+	var after *string
+	for {
+		var response struct {
+			Locations struct {
+				Nodes []struct {
+					ID                   string `json:"id"`
+					Name                 string `json:"name"`
+					IsActive             bool   `json:"isActive"`
+					FulfillsOnlineOrders bool   `json:"fulfillsOnlineOrders"`
+				} `json:"nodes"`
+				PageInfo pageInfo `json:"pageInfo"`
+			} `json:"locations"`
+		}
+		err := c.Query(ctx, "locations", query, map[string]interface{}{
+			"first": locationPageSize,
+			"after": after,
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range response.Locations.Nodes {
+			locations = append(locations, Location{
+				ID:                   legacyID(node.ID),
+				Name:                 node.Name,
+				IsActive:             node.IsActive,
+				FulfillsOnlineOrders: node.FulfillsOnlineOrders,
+			})
+		}
+
+		page := response.Locations.PageInfo
+		if !page.HasNextPage {
+			return locations, nil
+		}
+		if page.EndCursor == "" {
+			return nil, errors.New("locations page has a next page but no end cursor")
+		}
+		after = &page.EndCursor
+	}
+}
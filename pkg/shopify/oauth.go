@@ -0,0 +1,145 @@
+package shopify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// shopDomainPattern matches the permanent myshopify.com domain of a shop.
+var shopDomainPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-]*\.myshopify\.com$`)
+
+// OAuthURL returns the base URL of a shop's OAuth endpoints. Tests replace it to send requests
+// to a fake server.
+var OAuthURL = func(shopDomain string) string {
+	return "https://" + shopDomain + "/admin/oauth"
+}
+
+// ValidShopDomain reports whether shopDomain is a myshopify.com domain such as example.myshopify.com.
+func ValidShopDomain(shopDomain string) bool {
+	return shopDomainPattern.MatchString(shopDomain)
+}
+
+// ShopStub returns the store stub of a myshopify.com domain, the part before the first dot.
+func ShopStub(shopDomain string) string {
+	stub, _, _ := strings.Cut(shopDomain, ".")
+	return stub
+}
+
+// OAuthConfig holds the credentials of the Shopify app used to install it on stores.
+type OAuthConfig struct {
+	APIKey      string
+	APISecret   string
+	Scopes      []string
+	RedirectURL string
+}
+
+// OAuthToken is an offline access token granted by a shop.
+type OAuthToken struct {
+	AccessToken string `json:"access_token"`
+	Scope       string `json:"scope"`
+}
+
+// AuthorizeURL returns the URL a merchant is sent to for approving the app on shopDomain.
+// state is returned unchanged to the redirect URL.
+func (c *OAuthConfig) AuthorizeURL(shopDomain, state string) string {
+	query := url.Values{
+		"client_id":    {c.APIKey},
+		"scope":        {strings.Join(c.Scopes, ",")},
+		"redirect_uri": {c.RedirectURL},
+		"state":        {state},
+	}
+	return OAuthURL(shopDomain) + "/authorize?" + query.Encode()
+}
+
+// ValidCallback reports whether the query of an OAuth callback was signed by Shopify with the
+// app's secret. Shopify signs every other parameter, sorted by name, with a hex HMAC-SHA256.
+func (c *OAuthConfig) ValidCallback(query url.Values) bool {
+	provided, err := hex.DecodeString(query.Get("hmac"))
+	if err != nil || len(provided) == 0 || c.APISecret == "" {
+		return false
+	}
+
+	signed := url.Values{}
+	for key, values := range query {
+		if key != "hmac" && key != "signature" {
+			signed[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.APISecret))
+	mac.Write([]byte(signed.Encode()))
+	return hmac.Equal(provided, mac.Sum(nil))
+}
+
+// ExchangeCode exchanges the authorization code of an OAuth callback for an offline access token.
+func (c *OAuthConfig) ExchangeCode(ctx context.Context, shopDomain, code string) (*OAuthToken, error) {
+	body, err := json.Marshal(map[string]string{
+		"client_id":     c.APIKey,
+		"client_secret": c.APISecret,
+		"code":          code,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, OAuthURL(shopDomain)+"/access_token", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, &Error{Kind: ErrorTransport, Operation: "accessToken", Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &Error{Kind: ErrorTransport, Operation: "accessToken", Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{Kind: ErrorHTTPStatus, Operation: "accessToken", StatusCode: resp.StatusCode, Messages: []string{string(respBody)}}
+	}
+
+	var token OAuthToken
+	if err := json.Unmarshal(respBody, &token); err != nil {
+		return nil, fmt.Errorf("invalid access token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("access token response for %s has no token", shopDomain)
+	}
+	return &token, nil
+}
+
+// MissingScopes returns the scopes of the config that were not granted by token. A granted
+// write scope implies the read scope of the same resource.
+func (c *OAuthConfig) MissingScopes(token *OAuthToken) []string {
+	granted := make(map[string]bool)
+	for _, scope := range strings.Split(token.Scope, ",") {
+		scope = strings.TrimSpace(scope)
+		granted[scope] = true
+		if resource, ok := strings.CutPrefix(scope, "write_"); ok {
+			granted["read_"+resource] = true
+		}
+	}
+
+	var missing []string
+	for _, scope := range c.Scopes {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
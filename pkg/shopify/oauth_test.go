@@ -0,0 +1,133 @@
+package shopify_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"gostockly/pkg/shopify"
+)
+
+// signCallback signs the query of an OAuth callback the way Shopify does.
+func signCallback(query url.Values, secret string) url.Values {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(query.Encode()))
+	signed := url.Values{"hmac": {hex.EncodeToString(mac.Sum(nil))}}
+	for key, values := range query {
+		signed[key] = values
+	}
+	return signed
+}
+
+func TestValidCallback(t *testing.T) {
+	config := &shopify.OAuthConfig{APIKey: "key", APISecret: "secret"}
+	callback := url.Values{
+		"code":      {"abc"},
+		"shop":      {"example.myshopify.com"},
+		"state":     {"state"},
+		"timestamp": {"1700000000"},
+	}
+
+	tests := []struct {
+		name  string
+		query func() url.Values
+		want  bool
+	}{
+		{
+			name:  "accepts a signed callback",
+			query: func() url.Values { return signCallback(callback, "secret") },
+			want:  true,
+		},
+		{
+			name: "rejects a tampered callback",
+			query: func() url.Values {
+				query := signCallback(callback, "secret")
+				query.Set("shop", "attacker.myshopify.com")
+				return query
+			},
+		},
+		{
+			name:  "rejects a callback signed with another secret",
+			query: func() url.Values { return signCallback(callback, "other") },
+		},
+		{
+			name: "rejects a callback without hmac",
+			query: func() url.Values {
+				query := signCallback(callback, "secret")
+				query.Del("hmac")
+				return query
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.ValidCallback(tt.query()); got != tt.want {
+				t.Fatalf("expected ValidCallback to return %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+// fakeOAuth sends OAuth requests to handler for the duration of the test.
+func fakeOAuth(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	previous := shopify.OAuthURL
+	shopify.OAuthURL = func(shopDomain string) string {
+		return server.URL + "/" + shopDomain + "/admin/oauth"
+	}
+	t.Cleanup(func() {
+		shopify.OAuthURL = previous
+		server.Close()
+	})
+}
+
+func TestExchangeCode(t *testing.T) {
+	config := &shopify.OAuthConfig{APIKey: "key", APISecret: "secret"}
+	fakeOAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || r.URL.Path != "/example.myshopify.com/admin/oauth/access_token" {
+			http.NotFound(w, r)
+			return
+		}
+		switch body["code"] {
+		case "good":
+			if body["client_id"] != "key" || body["client_secret"] != "secret" {
+				http.Error(w, "invalid client", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "scope": "read_products"})
+		case "empty":
+			json.NewEncoder(w).Encode(map[string]string{})
+		default:
+			http.Error(w, "invalid code", http.StatusBadRequest)
+		}
+	})
+
+	token, err := config.ExchangeCode(context.Background(), "example.myshopify.com", "good")
+	if err != nil {
+		t.Fatalf("ExchangeCode failed: %v", err)
+	}
+	if token.AccessToken != "token" || token.Scope != "read_products" {
+		t.Fatalf("unexpected token %+v", token)
+	}
+
+	_, err = config.ExchangeCode(context.Background(), "example.myshopify.com", "used")
+	var shopifyErr *shopify.Error
+	if !errors.As(err, &shopifyErr) || shopifyErr.Kind != shopify.ErrorHTTPStatus || shopifyErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an HTTP status error for a rejected code, got %v", err)
+	}
+
+	if _, err := config.ExchangeCode(context.Background(), "example.myshopify.com", "empty"); err == nil {
+		t.Fatal("expected a response without a token to fail")
+	}
+}
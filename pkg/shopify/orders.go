@@ -0,0 +1,183 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetLineItemSKUs looks up the SKUs of order line items by their numeric IDs.
+// Line items that no longer exist or have no SKU are left out of the result.
+func (c *ShopifyClient) GetLineItemSKUs(ctx context.Context, lineItemIDs []int64) (map[int64]string, error) {
+	query := `
+		query lineItemSKUs($ids: [ID!]!) {
+			nodes(ids: $ids) {
+				... on LineItem {
+					id
+					sku
+				}
+			}
+		}
+	`
+
+	ids := make([]string, len(lineItemIDs))
+	for i, id := range lineItemIDs {
+		ids[i] = fmt.Sprintf("gid://shopify/LineItem/%d", id)
+	}
+
+	var response struct {
+		Nodes []*struct {
+			ID  string `json:"id"`
+			SKU string `json:"sku"`
+		} `json:"nodes"`
+	}
+	if err := c.Query(ctx, "lineItemSKUs", query, map[string]interface{}{"ids": ids}, &response); err != nil {
+		return nil, err
+	}
+
+	skus := make(map[int64]string, len(response.Nodes))
+	for _, node := range response.Nodes {
+		if node == nil || node.SKU == "" {
+			continue
+		}
+		if id := legacyID(node.ID); id != 0 {
+			skus[id] = node.SKU
+		}
+	}
+	return skus, nil
+}
+
+// GetRestockedQuantities returns how many of each line item of an order were put back in stock
+// by the refunds created at or after since, keyed by the line item's numeric ID. Shopify records
+// the restock of quantities removed by an order edit as such a refund.
+func (c *ShopifyClient) GetRestockedQuantities(ctx context.Context, orderID int64, since time.Time) (map[int64]int, error) {
+	query := `
+		query orderRestocks($id: ID!) {
+			order(id: $id) {
+				refunds {
+					createdAt
+					refundLineItems(first: 100) {
+						nodes {
+							quantity
+							restocked
+							lineItem {
+								id
+							}
+						}
+					}
+				}
+			}
+		}
+	`
+
+	var response struct {
+		Order *struct {
+			Refunds []struct {
+				CreatedAt       time.Time `json:"createdAt"`
+				RefundLineItems struct {
+					Nodes []struct {
+						Quantity  int  `json:"quantity"`
+						Restocked bool `json:"restocked"`
+						LineItem  struct {
+							ID string `json:"id"`
+						} `json:"lineItem"`
+					} `json:"nodes"`
+				} `json:"refundLineItems"`
+			} `json:"refunds"`
+		} `json:"order"`
+	}
+	variables := map[string]interface{}{"id": fmt.Sprintf("gid://shopify/Order/%d", orderID)}
+	if err := c.Query(ctx, "orderRestocks", query, variables, &response); err != nil {
+		return nil, err
+	}
+	if response.Order == nil {
+		return nil, fmt.Errorf("order %d not found", orderID)
+	}
+
+	restocked := make(map[int64]int)
+	for _, refund := range response.Order.Refunds {
+		if refund.CreatedAt.Before(since) {
+			continue
+		}
+		for _, item := range refund.RefundLineItems.Nodes {
+			if item.Restocked {
+				restocked[legacyID(item.LineItem.ID)] += item.Quantity
+			}
+		}
+	}
+	return restocked, nil
+}
+
+// GetAssignedLocations returns the location each line item of an order is assigned to be
+// fulfilled from, keyed by the line item's numeric ID, with the numeric location IDs. Shopify
+// assigns the locations when the order is created, long before it is fulfilled. A line item
+// split across locations is assigned to none of them, and line items whose location is gone are
+// left out.
+func (c *ShopifyClient) GetAssignedLocations(ctx context.Context, orderID int64) (map[int64]string, error) {
+	query := `
+		query orderAssignedLocations($id: ID!) {
+			order(id: $id) {
+				fulfillmentOrders(first: 10) {
+					nodes {
+						assignedLocation {
+							location {
+								id
+							}
+						}
+						lineItems(first: 50) {
+							nodes {
+								lineItem {
+									id
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	`
+
+	var response struct {
+		Order *struct {
+			FulfillmentOrders struct {
+				Nodes []struct {
+					AssignedLocation struct {
+						Location *struct {
+							ID string `json:"id"`
+						} `json:"location"`
+					} `json:"assignedLocation"`
+					LineItems struct {
+						Nodes []struct {
+							LineItem struct {
+								ID string `json:"id"`
+							} `json:"lineItem"`
+						} `json:"nodes"`
+					} `json:"lineItems"`
+				} `json:"nodes"`
+			} `json:"fulfillmentOrders"`
+		} `json:"order"`
+	}
+	variables := map[string]interface{}{"id": fmt.Sprintf("gid://shopify/Order/%d", orderID)}
+	if err := c.Query(ctx, "orderAssignedLocations", query, variables, &response); err != nil {
+		return nil, err
+	}
+	if response.Order == nil {
+		return nil, fmt.Errorf("order %d not found", orderID)
+	}
+
+	assigned := make(map[int64]string)
+	for _, fulfillmentOrder := range response.Order.FulfillmentOrders.Nodes {
+		if fulfillmentOrder.AssignedLocation.Location == nil {
+			continue
+		}
+		locationID := fmt.Sprintf("%d", legacyID(fulfillmentOrder.AssignedLocation.Location.ID))
+		for _, item := range fulfillmentOrder.LineItems.Nodes {
+			id := legacyID(item.LineItem.ID)
+			if existing, ok := assigned[id]; ok && existing != locationID {
+				locationID = ""
+			}
+			assigned[id] = locationID
+		}
+	}
+	return assigned, nil
+}
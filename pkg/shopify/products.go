@@ -0,0 +1,237 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Page sizes for the paginated variant and inventory level queries. A connection costs two
+// points plus one per requested node, multiplied by the size of the connection it is nested
+// in, and a single query may cost at most 1000 points. A variant page costs
+// 2 + 50 + 50 * (2 + 10) = 652 points. Items stocked at more than 10 locations have their
+// remaining levels fetched per item, 100 at a time for 102 points.
+const (
+	variantPageSize         = 50
+	levelPageSize           = 10
+	remainingLevelsPageSize = 100
+)
+
+// Variant is a product variant with the inventory levels of its inventory item.
+type Variant struct {
+	ID              int64            `json:"id"`
+	ProductID       int64            `json:"product_id"`
+	ProductTitle    string           `json:"product_title"`
+	SKU             string           `json:"sku"`
+	InventoryItemID int64            `json:"inventory_item_id"`
+	InventoryLevels []InventoryLevel `json:"inventory_levels"`
+}
+
+// InventoryLevel is the available quantity of an inventory item at a location.
+type InventoryLevel struct {
+	LocationID int64 `json:"location_id"`
+	Available  int   `json:"available"`
+}
+
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type inventoryLevelConnection struct {
+	Nodes []struct {
+		Location struct {
+			ID string `json:"id"`
+		} `json:"location"`
+		Quantities []struct {
+			Name     string `json:"name"`
+			Quantity int    `json:"quantity"`
+		} `json:"quantities"`
+	} `json:"nodes"`
+	PageInfo pageInfo `json:"pageInfo"`
+}
+
+// ListVariants calls fn for every product variant in the store, following the cursor
+// pagination of the productVariants connection until the last page. Variants are streamed
+// page by page, so the whole catalog is never held in memory. Returning an error from fn
+// stops the iteration and returns that error.
+func (c *ShopifyClient) ListVariants(ctx context.Context, fn func(Variant) error) error {
+	query := `
+		query productVariants($first: Int!, $after: String, $levelsFirst: Int!) {
+			productVariants(first: $first, after: $after) {
+				nodes {
+					id
+					sku
+					product {
+						id
+						title
+					}
+					inventoryItem {
+						id
+						inventoryLevels(first: $levelsFirst) {
+							nodes {
+								location {
+									id
+								}
+								quantities(names: ["available"]) {
+									name
+									quantity
+								}
+							}
+							pageInfo {
+								hasNextPage
+								endCursor
+							}
+						}
+					}
+				}
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
+			}
+		}
+	`
+
+	var after *string
+	for {
+		var response struct {
+			ProductVariants struct {
+				Nodes []struct {
+					ID      string `json:"id"`
+					SKU     string `json:"sku"`
+					Product struct {
+						ID    string `json:"id"`
+						Title string `json:"title"`
+					} `json:"product"`
+					InventoryItem struct {
+						ID              string                   `json:"id"`
+						InventoryLevels inventoryLevelConnection `json:"inventoryLevels"`
+					} `json:"inventoryItem"`
+				} `json:"nodes"`
+				PageInfo pageInfo `json:"pageInfo"`
+			} `json:"productVariants"`
+		}
+		err := c.Query(ctx, "productVariants", query, map[string]interface{}{
+			"first":       variantPageSize,
+			"after":       after,
+			"levelsFirst": levelPageSize,
+		}, &response)
+		if err != nil {
+			return err
+		}
+
+		for _, node := range response.ProductVariants.Nodes {
+			variant := Variant{
+				ID:              legacyID(node.ID),
+				ProductID:       legacyID(node.Product.ID),
+				ProductTitle:    node.Product.Title,
+				SKU:             node.SKU,
+				InventoryItemID: legacyID(node.InventoryItem.ID),
+				InventoryLevels: inventoryLevels(node.InventoryItem.InventoryLevels),
+			}
+
+			// Items stocked at more locations than fit in the first page need their remaining levels fetched
+			levels := node.InventoryItem.InventoryLevels
+			if levels.PageInfo.HasNextPage {
+				more, err := c.fetchInventoryLevels(ctx, node.InventoryItem.ID, levels.PageInfo.EndCursor)
+				if err != nil {
+					return err
+				}
+				variant.InventoryLevels = append(variant.InventoryLevels, more...)
+			}
+
+			if err := fn(variant); err != nil {
+				return err
+			}
+		}
+
+		page := response.ProductVariants.PageInfo
+		if !page.HasNextPage {
+			return nil
+		}
+		if page.EndCursor == "" {
+			return errors.New("product variants page has a next page but no end cursor")
+		}
+		after = &page.EndCursor
+	}
+}
+
+// fetchInventoryLevels retrieves the inventory levels of an inventory item after the given cursor.
+func (c *ShopifyClient) fetchInventoryLevels(ctx context.Context, inventoryItemGID, after string) ([]InventoryLevel, error) {
+	query := `
+		query inventoryItemLevels($id: ID!, $first: Int!, $after: String) {
+			inventoryItem(id: $id) {
+				inventoryLevels(first: $first, after: $after) {
+					nodes {
+						location {
+							id
+						}
+						quantities(names: ["available"]) {
+							name
+							quantity
+						}
+					}
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+				}
+			}
+		}
+	`
+
+	var levels []InventoryLevel
+	for {
+		var response struct {
+			InventoryItem *struct {
+				InventoryLevels inventoryLevelConnection `json:"inventoryLevels"`
+			} `json:"inventoryItem"`
+		}
+		err := c.Query(ctx, "inventoryItemLevels", query, map[string]interface{}{
+			"id":    inventoryItemGID,
+			"first": remainingLevelsPageSize,
+			"after": after,
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+		if response.InventoryItem == nil {
+			return levels, nil
+		}
+
+		connection := response.InventoryItem.InventoryLevels
+		levels = append(levels, inventoryLevels(connection)...)
+		if !connection.PageInfo.HasNextPage || connection.PageInfo.EndCursor == "" {
+			return levels, nil
+		}
+		after = connection.PageInfo.EndCursor
+	}
+}
+
+// inventoryLevels converts a page of inventory levels, keeping only their available quantities.
+func inventoryLevels(connection inventoryLevelConnection) []InventoryLevel {
+	levels := make([]InventoryLevel, 0, len(connection.Nodes))
+	for _, node := range connection.Nodes {
+		for _, quantity := range node.Quantities {
+			if quantity.Name == "available" {
+				levels = append(levels, InventoryLevel{
+					LocationID: legacyID(node.Location.ID),
+					Available:  quantity.Quantity,
+				})
+			}
+		}
+	}
+	return levels
+}
+
+// legacyID returns the numeric ID at the end of a Shopify global ID such as
+// gid://shopify/ProductVariant/123, or 0 if it has none.
+func legacyID(gid string) int64 {
+	id, err := strconv.ParseInt(gid[strings.LastIndex(gid, "/")+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,197 @@
+package shopify
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Shopify's GraphQL Admin API limits each shop with a leaky bucket of query cost points. These
+// defaults match the smallest plan and are replaced by the throttle status of the first response.
+const (
+	defaultMaximumAvailable = 1000
+	defaultRestoreRate      = 50
+	// defaultQueryCost is assumed for a query until Shopify has reported what it costs.
+	defaultQueryCost = 50
+	// maxThrottleRetries is how many times a throttled request is retried before giving up.
+	maxThrottleRetries = 5
+	// defaultRetryAfter is how long to wait after a 429 without a Retry-After header.
+	defaultRetryAfter = time.Second
+)
+
+// RateLimitStats describes the query cost bucket of a shop and the time spent waiting for it.
+type RateLimitStats struct {
+	Shop               string        `json:"shop"`
+	MaximumAvailable   float64       `json:"maximum_available"`
+	CurrentlyAvailable float64       `json:"currently_available"`
+	RestoreRate        float64       `json:"restore_rate"`
+	Requests           int64         `json:"requests"`
+	Throttled          int64         `json:"throttled"`
+	Waits              int64         `json:"waits"`
+	WaitTime           time.Duration `json:"wait_time_ns"`
+}
+
+// costBucket tracks the query cost points available to a shop, refilling at restoreRate per second.
+type costBucket struct {
+	mu                 sync.Mutex
+	maximumAvailable   float64
+	currentlyAvailable float64
+	restoreRate        float64
+	updatedAt          time.Time
+
+	requests  int64
+	throttled int64
+	waits     int64
+	waitTime  time.Duration
+}
+
+var (
+	bucketsMu sync.Mutex
+	buckets   = make(map[string]*costBucket)
+
+	// queryCosts remembers the requested cost Shopify reported for each query text.
+	queryCosts sync.Map
+)
+
+// bucketFor returns the cost bucket of a shop, creating a full one on first use.
+func bucketFor(shop string) *costBucket {
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+
+	bucket, ok := buckets[shop]
+	if !ok {
+		bucket = &costBucket{
+			maximumAvailable:   defaultMaximumAvailable,
+			currentlyAvailable: defaultMaximumAvailable,
+			restoreRate:        defaultRestoreRate,
+			updatedAt:          time.Now(),
+		}
+		buckets[shop] = bucket
+	}
+	return bucket
+}
+
+// RateLimits returns the rate limit stats of the given shops. Shops that have not been
+// queried since startup are left out.
+func RateLimits(shops ...string) []RateLimitStats {
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+
+	stats := make([]RateLimitStats, 0, len(shops))
+	for _, shop := range shops {
+		bucket, ok := buckets[shop]
+		if !ok {
+			continue
+		}
+
+		bucket.mu.Lock()
+		stats = append(stats, RateLimitStats{
+			Shop:               shop,
+			MaximumAvailable:   bucket.maximumAvailable,
+			CurrentlyAvailable: bucket.available(time.Now()),
+			RestoreRate:        bucket.restoreRate,
+			Requests:           bucket.requests,
+			Throttled:          bucket.throttled,
+			Waits:              bucket.waits,
+			WaitTime:           bucket.waitTime,
+		})
+		bucket.mu.Unlock()
+	}
+	return stats
+}
+
+// estimatedCost returns the cost to reserve for a query before sending it.
+func estimatedCost(query string) float64 {
+	if cost, ok := queryCosts.Load(query); ok {
+		return cost.(float64)
+	}
+	return defaultQueryCost
+}
+
+// available returns the points available at now, including what has been restored since the
+// last update. The caller must hold b.mu.
+func (b *costBucket) available(now time.Time) float64 {
+	restored := b.restoreRate * now.Sub(b.updatedAt).Seconds()
+	return math.Min(b.maximumAvailable, b.currentlyAvailable+restored)
+}
+
+// reserve blocks until cost points are available and takes them out of the bucket, so
+// concurrent requests to the same shop queue up instead of all being throttled.
+// It returns ctx's error if ctx ends first.
+func (b *costBucket) reserve(ctx context.Context, cost float64) error {
+	var waited time.Duration
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		need := math.Min(cost, b.maximumAvailable)
+		available := b.available(now)
+		if available >= need {
+			b.currentlyAvailable = available - need
+			b.updatedAt = now
+			b.requests++
+			if waited > 0 {
+				b.waits++
+				b.waitTime += waited
+			}
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - available) / b.restoreRate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		waited += wait
+	}
+}
+
+// update replaces the bucket state with the throttle status reported by Shopify.
+func (b *costBucket) update(status throttleStatus) {
+	if status.MaximumAvailable <= 0 || status.RestoreRate <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maximumAvailable = status.MaximumAvailable
+	b.currentlyAvailable = status.CurrentlyAvailable
+	b.restoreRate = status.RestoreRate
+	b.updatedAt = time.Now()
+}
+
+// pause empties the bucket so that nothing is sent to the shop for d, after a 429.
+func (b *costBucket) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.throttled++
+	b.currentlyAvailable = -b.restoreRate * d.Seconds()
+	b.updatedAt = time.Now()
+}
+
+// recordThrottled counts a request rejected with a THROTTLED error.
+func (b *costBucket) recordThrottled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.throttled++
+}
+
+type throttleStatus struct {
+	MaximumAvailable   float64 `json:"maximumAvailable"`
+	CurrentlyAvailable float64 `json:"currentlyAvailable"`
+	RestoreRate        float64 `json:"restoreRate"`
+}
+
+// retryAfter returns how long a 429 response asks to wait before retrying.
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.ParseFloat(header.Get("Retry-After"), 64)
+	if err != nil || seconds <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
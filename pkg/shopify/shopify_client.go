@@ -2,33 +2,217 @@ package shopify
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"gostockly/pkg/logger"
 	"io"
+	"net"
 	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultAPIVersion is the Admin API version used when the deployment does not configure one.
+const DefaultAPIVersion = "2025-01"
+
+// defaultAPIVersion is the Admin API version of clients created without a store override.
+var defaultAPIVersion atomic.Value
+
+// apiVersionPattern matches stable versions such as 2025-01, and the unstable version.
+var apiVersionPattern = regexp.MustCompile(`^(\d{4}-(01|04|07|10)|unstable)$`)
+
+// ValidAPIVersion reports whether version names a Shopify Admin API version.
+func ValidAPIVersion(version string) bool {
+	return apiVersionPattern.MatchString(version)
+}
+
+// AdminURL returns the Admin API URL of a shop for an API version. Tests replace it to send
+// requests to a fake server.
+var AdminURL = func(shop, apiVersion string) string {
+	return fmt.Sprintf("https://%s.myshopify.com/admin/api/%s", shop, apiVersion)
+}
+
+// SetDefaultAPIVersion sets the Admin API version used by clients without a store override.
+func SetDefaultAPIVersion(version string) {
+	defaultAPIVersion.Store(version)
+}
+
+// DefaultVersion returns the Admin API version used by clients without a store override.
+func DefaultVersion() string {
+	if version, ok := defaultAPIVersion.Load().(string); ok && version != "" {
+		return version
+	}
+	return DefaultAPIVersion
+}
+
+// defaultRequestTimeout bounds a single GraphQL request when the caller's context has no deadline.
+const defaultRequestTimeout = 30 * time.Second
+
+// transport is shared by all Shopify clients so connections to a shop are reused.
+var transport = &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   10,
+}
+
+// httpClient sends GraphQL requests. Requests are further bounded by their context.
+var httpClient = &http.Client{Transport: transport, Timeout: 2 * defaultRequestTimeout}
+
+// downloadClient downloads bulk operation results, which may take longer than any fixed timeout,
+// so only connecting and waiting for the response headers are bounded.
+var downloadClient = &http.Client{Transport: transport}
+
 type ShopifyClient struct {
 	AccessToken string
 	StoreURL    string // Full Shopify API URL
+	Shop        string // Store stub, requests to the same shop share a rate limit
+	APIVersion  string
 }
 
+// NewShopifyClient creates a client for the store using the default Admin API version.
 func NewShopifyClient(accessToken, storeStub string) *ShopifyClient {
-	// Ensure the store URL is formatted correctly
-	storeURL := fmt.Sprintf("https://%s.myshopify.com/admin/api/2025-01", storeStub)
+	return NewShopifyClientWithVersion(accessToken, storeStub, "")
+}
+
+// NewShopifyClientWithVersion creates a client for the store using the given Admin API version,
+// or the default version if apiVersion is empty.
+func NewShopifyClientWithVersion(accessToken, storeStub, apiVersion string) *ShopifyClient {
+	if apiVersion == "" {
+		apiVersion = DefaultVersion()
+	}
+
+	storeURL := AdminURL(storeStub, apiVersion)
 	log := logger.GetLogger()
-	log.Info("Creating Shopify client for store: %s", storeStub)
+	log.Info("Creating Shopify client for store: %s (API version %s)", storeStub, apiVersion)
 
 	return &ShopifyClient{
 		AccessToken: accessToken,
 		StoreURL:    storeURL,
+		Shop:        storeStub,
+		APIVersion:  apiVersion,
+	}
+}
+
+// graphQLError is a top-level error of a GraphQL response.
+type graphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+// graphQLResponse is the envelope of every GraphQL response.
+type graphQLResponse struct {
+	Data       json.RawMessage `json:"data"`
+	Errors     []graphQLError  `json:"errors"`
+	Extensions struct {
+		Cost struct {
+			RequestedQueryCost float64        `json:"requestedQueryCost"`
+			ThrottleStatus     throttleStatus `json:"throttleStatus"`
+		} `json:"cost"`
+	} `json:"extensions"`
+}
+
+// throttled reports whether the response was rejected for exceeding the query cost limit.
+func (r *graphQLResponse) throttled() bool {
+	for _, err := range r.Errors {
+		if err.Extensions.Code == "THROTTLED" {
+			return true
+		}
 	}
+	return false
 }
 
-func (c *ShopifyClient) SendGraphQLRequest(query string, variables map[string]interface{}) ([]byte, error) {
+// Query sends a GraphQL query or mutation named operation and decodes its data into out.
+//
+// Requests wait for enough query cost points in the shop's bucket before they are sent, and
+// requests rejected as throttled, with a THROTTLED error or a 429, are retried after waiting.
+// Every failure is returned as an *Error.
+func (c *ShopifyClient) Query(ctx context.Context, operation, query string, variables map[string]interface{}, out interface{}) error {
 	log := logger.GetLogger()
 
+	shop := c.Shop
+	if shop == "" {
+		shop = c.StoreURL
+	}
+	bucket := bucketFor(shop)
+
+	for attempt := 0; ; attempt++ {
+		if err := bucket.reserve(ctx, estimatedCost(query)); err != nil {
+			return &Error{Kind: ErrorTransport, Operation: operation, Err: err}
+		}
+
+		body, header, status, err := c.send(ctx, query, variables)
+		if err != nil {
+			return &Error{Kind: ErrorTransport, Operation: operation, Err: err}
+		}
+
+		if status == http.StatusTooManyRequests {
+			wait := retryAfter(header)
+			bucket.pause(wait)
+			if attempt >= maxThrottleRetries {
+				return &Error{Kind: ErrorThrottled, Operation: operation, StatusCode: status}
+			}
+			log.Info("Shopify rate limited shop %s, retrying %s in %s", shop, operation, wait)
+			continue
+		}
+		if status != http.StatusOK {
+			return &Error{Kind: ErrorHTTPStatus, Operation: operation, StatusCode: status, Messages: []string{string(body)}}
+		}
+
+		var response graphQLResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return &Error{Kind: ErrorGraphQL, Operation: operation, Messages: []string{"invalid response: " + err.Error()}}
+		}
+
+		bucket.update(response.Extensions.Cost.ThrottleStatus)
+		if response.Extensions.Cost.RequestedQueryCost > 0 {
+			queryCosts.Store(query, response.Extensions.Cost.RequestedQueryCost)
+		}
+
+		if response.throttled() {
+			bucket.recordThrottled()
+			if attempt >= maxThrottleRetries {
+				return &Error{Kind: ErrorThrottled, Operation: operation}
+			}
+			// The bucket now holds the reported throttle status, so the next reserve waits long enough
+			log.Info("Shopify throttled %s for shop %s, retrying", operation, shop)
+			continue
+		}
+
+		if len(response.Errors) > 0 {
+			messages := make([]string, len(response.Errors))
+			for i, graphQLErr := range response.Errors {
+				messages[i] = graphQLErr.Message
+			}
+			log.Error("Shopify returned GraphQL errors for %s: %v", operation, messages)
+			return &Error{Kind: ErrorGraphQL, Operation: operation, Messages: messages}
+		}
+
+		if out != nil && len(response.Data) > 0 {
+			if err := json.Unmarshal(response.Data, out); err != nil {
+				return &Error{Kind: ErrorGraphQL, Operation: operation, Messages: []string{"invalid response data: " + err.Error()}}
+			}
+		}
+		return nil
+	}
+}
+
+// send sends a single GraphQL request and returns the response body and status.
+func (c *ShopifyClient) send(ctx context.Context, query string, variables map[string]interface{}) ([]byte, http.Header, int, error) {
+	log := logger.GetLogger()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRequestTimeout)
+		defer cancel()
+	}
+
 	// Log request details
 	log.Debug("Sending GraphQL request to Shopify: %s", c.StoreURL+"/graphql.json")
 	log.Debug("Query: %s", query)
@@ -44,14 +228,14 @@ func (c *ShopifyClient) SendGraphQLRequest(query string, variables map[string]in
 	})
 	if err != nil {
 		log.Error("Failed to marshal request body: %v", err)
-		return nil, err
+		return nil, nil, 0, err
 	}
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("POST", c.StoreURL+"/graphql.json", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.StoreURL+"/graphql.json", bytes.NewBuffer(requestBody))
 	if err != nil {
 		log.Error("Failed to create HTTP request: %v", err)
-		return nil, err
+		return nil, nil, 0, err
 	}
 
 	// Add headers
@@ -60,77 +244,32 @@ func (c *ShopifyClient) SendGraphQLRequest(query string, variables map[string]in
 	log.Debug("Headers added to request: Content-Type=application/json, X-Shopify-Access-Token=[REDACTED]")
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		log.Error("Failed to send request to Shopify: %v", err)
-		return nil, err
+		return nil, nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	// Log response status
 	log.Info("Received response from Shopify: status=%d", resp.StatusCode)
 
-	// Check for non-200 status codes
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Error("Shopify API request failed with status: %s, body: %s", resp.Status, body)
-		return nil, fmt.Errorf("Shopify API request failed with status: %s", resp.Status)
+	// Shopify flags requests using fields or versions it is going to remove
+	if reason := resp.Header.Get(deprecatedReasonHeader); reason != "" {
+		reportDeprecation(c.Shop, c.APIVersion, reason)
 	}
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Error("Failed to read response body: %v", err)
-		return nil, err
-	}
-
-	log.Debug("Response body: %s", body)
-	return body, nil
-}
-
-// Product represents a Shopify product.
-type Product struct {
-	ID       int64     `json:"id"`
-	Title    string    `json:"title"`
-	Variants []Variant `json:"variants"`
-}
-
-// Variant represents a Shopify product variant.
-type Variant struct {
-	ID                int64  `json:"id"`
-	SKU               string `json:"sku"`
-	InventoryItemID   int64  `json:"inventory_item_id"`
-	InventoryQuantity int    `json:"inventory_quantity"`
-}
-
-// FetchProducts retrieves all products from the Shopify store.
-func (c *ShopifyClient) FetchProducts() ([]Product, error) {
-	apiURL := c.StoreURL + "/products.json"
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("X-Shopify-Access-Token", c.AccessToken)
-
-	resp, err := (&http.Client{}).Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch products: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, nil, 0, err
 	}
 
-	var result struct {
-		Products []Product `json:"products"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusTooManyRequests {
+		log.Error("Shopify API request failed with status: %s, body: %s", resp.Status, body)
+	} else {
+		log.Debug("Response body: %s", body)
 	}
-
-	return result.Products, nil
+	return body, resp.Header, resp.StatusCode, nil
 }
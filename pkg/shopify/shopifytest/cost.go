@@ -0,0 +1,61 @@
+package shopifytest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxSingleQueryCost is the most a single query may cost before Shopify rejects it with
+// MAX_COST_EXCEEDED, whatever the state of the shop's bucket.
+const maxSingleQueryCost = 1000
+
+// firstArgumentPattern matches the first argument of a connection, as a literal or a variable.
+var firstArgumentPattern = regexp.MustCompile(`(?:^|[(,\s])first\s*:\s*(\$\w+|\d+)`)
+
+// requestedQueryCost estimates the cost of a query the way Shopify does for connections. The
+// query itself costs one point. Every connection costs two points plus one per node requested
+// with first, multiplied by the sizes of the connections it is nested in, so a page of 250
+// variants with 50 inventory levels each costs 2 + 250 + 250 * (2 + 50).
+func requestedQueryCost(query string, variables map[string]interface{}) int {
+	cost := 1
+	// multipliers holds, for every open selection set, how many times it is requested
+	multipliers := []int{1}
+	pending := 0
+	for i := 0; i < len(query); i++ {
+		switch query[i] {
+		case '(':
+			end := strings.IndexByte(query[i:], ')')
+			if end < 0 {
+				return cost
+			}
+			if match := firstArgumentPattern.FindStringSubmatch(query[i : i+end]); match != nil {
+				pending = argumentValue(match[1], variables)
+			}
+			i += end
+		case '{':
+			multiplier := multipliers[len(multipliers)-1]
+			if pending > 0 {
+				cost += multiplier * (2 + pending)
+				multiplier *= pending
+				pending = 0
+			}
+			multipliers = append(multipliers, multiplier)
+		case '}':
+			if len(multipliers) > 1 {
+				multipliers = multipliers[:len(multipliers)-1]
+			}
+		}
+	}
+	return cost
+}
+
+// argumentValue resolves an integer argument given as a literal or a variable.
+func argumentValue(argument string, variables map[string]interface{}) int {
+	if strings.HasPrefix(argument, "$") {
+		value, _ := variables[argument[1:]].(float64)
+		return int(value)
+	}
+	value, _ := strconv.Atoi(argument)
+	return value
+}
@@ -0,0 +1,484 @@
+package shopifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gostockly/pkg/shopify"
+)
+
+// resolve answers a request of a supported operation. Errors become top-level GraphQL errors.
+// The caller must hold s.mu.
+func (s *Server) resolve(shopName string, sh *shop, operation string, variables map[string]interface{}) (interface{}, error) {
+	switch operation {
+	case "inventoryAdjustQuantities":
+		return s.adjustQuantities(sh, variables)
+	case "inventorySetQuantities":
+		return s.setQuantities(sh, variables)
+	case "inventoryLevel":
+		return s.inventoryLevel(sh, variables)
+	case "inventoryLevels":
+		return s.inventoryLevels(sh, variables)
+	case "inventoryItemLevels":
+		return s.inventoryItemLevels(sh, variables)
+	case "productVariants":
+		return s.productVariants(sh, variables)
+	case "locations":
+		return s.locations(sh, variables)
+	case "lineItemSKUs":
+		return s.lineItemSKUs(sh, variables)
+	case "orderRestocks":
+		return s.orderRestocks(sh, variables)
+	case "orderAssignedLocations":
+		return s.orderAssignedLocations(sh, variables)
+	case "bulkOperationRunQuery":
+		return s.runBulkQuery(shopName, sh, variables)
+	case "bulkOperation":
+		return s.bulkOperation(shopName, variables)
+	}
+	return nil, fmt.Errorf("shopifytest does not support operation %q", operation)
+}
+
+func (s *Server) adjustQuantities(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	input := object(variables, "input")
+	changes := list(input, "changes")
+
+	var errs []shopify.UserError
+	for i, change := range changes {
+		key := levelKey{gidNumber(stringValue(change, "inventoryItemId")), gidNumber(stringValue(change, "locationId"))}
+		if _, ok := sh.levels[key]; !ok {
+			errs = append(errs, notStocked("changes", i))
+		}
+	}
+	if len(errs) > 0 {
+		return map[string]interface{}{
+			"inventoryAdjustQuantities": map[string]interface{}{"userErrors": userErrors(errs), "inventoryAdjustmentGroup": nil},
+		}, nil
+	}
+
+	// Shopify applies all changes of a mutation or none of them
+	groupChanges := make([]interface{}, len(changes))
+	for i, change := range changes {
+		key := levelKey{gidNumber(stringValue(change, "inventoryItemId")), gidNumber(stringValue(change, "locationId"))}
+		delta := intValue(change, "delta")
+		sh.levels[key] += delta
+		groupChanges[i] = adjustmentChange(key, delta, sh.levels[key])
+	}
+
+	return map[string]interface{}{
+		"inventoryAdjustQuantities": map[string]interface{}{
+			"userErrors":               []interface{}{},
+			"inventoryAdjustmentGroup": adjustmentGroup(input, groupChanges),
+		},
+	}, nil
+}
+
+func (s *Server) setQuantities(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	input := object(variables, "input")
+	quantities := list(input, "quantities")
+	ignoreCompare, _ := input["ignoreCompareQuantity"].(bool)
+
+	var errs []shopify.UserError
+	for i, quantity := range quantities {
+		key := levelKey{gidNumber(stringValue(quantity, "inventoryItemId")), gidNumber(stringValue(quantity, "locationId"))}
+		current, ok := sh.levels[key]
+		if !ok {
+			errs = append(errs, notStocked("quantities", i))
+			continue
+		}
+		if compare, ok := quantity["compareQuantity"]; ok && !ignoreCompare && int(compare.(float64)) != current {
+			errs = append(errs, shopify.UserError{
+				Field:   []string{"input", "quantities", strconv.Itoa(i), "compareQuantity"},
+				Message: "The compareQuantity argument no longer matches the persisted quantity.",
+				Code:    "COMPARE_QUANTITY_STALE",
+			})
+		}
+	}
+	if len(errs) > 0 {
+		return map[string]interface{}{
+			"inventorySetQuantities": map[string]interface{}{"userErrors": userErrors(errs), "inventoryAdjustmentGroup": nil},
+		}, nil
+	}
+
+	var groupChanges []interface{}
+	for _, quantity := range quantities {
+		key := levelKey{gidNumber(stringValue(quantity, "inventoryItemId")), gidNumber(stringValue(quantity, "locationId"))}
+		delta := intValue(quantity, "quantity") - sh.levels[key]
+		sh.levels[key] += delta
+		if delta != 0 {
+			groupChanges = append(groupChanges, adjustmentChange(key, delta, sh.levels[key]))
+		}
+	}
+
+	// Shopify returns no group when nothing changed
+	var group interface{}
+	if len(groupChanges) > 0 {
+		group = adjustmentGroup(input, groupChanges)
+	}
+	return map[string]interface{}{
+		"inventorySetQuantities": map[string]interface{}{"userErrors": []interface{}{}, "inventoryAdjustmentGroup": group},
+	}, nil
+}
+
+func (s *Server) inventoryLevel(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	itemID := gidNumber(stringValue(variables, "inventoryItemId"))
+	if !sh.hasItem(itemID) {
+		return map[string]interface{}{"inventoryItem": nil}, nil
+	}
+
+	var level interface{}
+	if quantity, ok := sh.levels[levelKey{itemID, gidNumber(stringValue(variables, "locationId"))}]; ok {
+		level = availableQuantities(quantity)
+	}
+	return map[string]interface{}{
+		"inventoryItem": map[string]interface{}{"inventoryLevel": level},
+	}, nil
+}
+
+func (s *Server) inventoryLevels(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	locationID := gidNumber(stringValue(variables, "locationId"))
+
+	var nodes []interface{}
+	for _, id := range variables["ids"].([]interface{}) {
+		itemID := gidNumber(id.(string))
+		if !sh.hasItem(itemID) {
+			nodes = append(nodes, nil)
+			continue
+		}
+
+		var level interface{}
+		if quantity, ok := sh.levels[levelKey{itemID, locationID}]; ok {
+			level = availableQuantities(quantity)
+		}
+		nodes = append(nodes, map[string]interface{}{"id": gid("InventoryItem", itemID), "inventoryLevel": level})
+	}
+	return map[string]interface{}{"nodes": nodes}, nil
+}
+
+func (s *Server) inventoryItemLevels(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	itemID := gidNumber(stringValue(variables, "id"))
+	if !sh.hasItem(itemID) {
+		return map[string]interface{}{"inventoryItem": nil}, nil
+	}
+	return map[string]interface{}{
+		"inventoryItem": map[string]interface{}{
+			"inventoryLevels": sh.levelConnection(itemID, intValue(variables, "first"), cursorValue(variables, "after")),
+		},
+	}, nil
+}
+
+func (s *Server) productVariants(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	start := cursorValue(variables, "after")
+	end := min(start+intValue(variables, "first"), len(sh.variants))
+	levelsFirst := intValue(variables, "levelsFirst")
+
+	var nodes []interface{}
+	for _, variant := range sh.variants[min(start, end):end] {
+		nodes = append(nodes, map[string]interface{}{
+			"id":  gid("ProductVariant", variant.ID),
+			"sku": variant.SKU,
+			"product": map[string]interface{}{
+				"id":    gid("Product", variant.ProductID),
+				"title": variant.ProductTitle,
+			},
+			"inventoryItem": map[string]interface{}{
+				"id":              gid("InventoryItem", variant.InventoryItemID),
+				"inventoryLevels": sh.levelConnection(variant.InventoryItemID, levelsFirst, 0),
+			},
+		})
+	}
+	return map[string]interface{}{
+		"productVariants": map[string]interface{}{"nodes": nodes, "pageInfo": pageInfo(end, len(sh.variants))},
+	}, nil
+}
+
+func (s *Server) locations(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	start := cursorValue(variables, "after")
+	end := min(start+intValue(variables, "first"), len(sh.locations))
+
+	var nodes []interface{}
+	for _, location := range sh.locations[min(start, end):end] {
+		nodes = append(nodes, map[string]interface{}{
+			"id":                   gid("Location", location.ID),
+			"name":                 location.Name,
+			"isActive":             location.IsActive,
+			"fulfillsOnlineOrders": location.FulfillsOnlineOrders,
+		})
+	}
+	return map[string]interface{}{
+		"locations": map[string]interface{}{"nodes": nodes, "pageInfo": pageInfo(end, len(sh.locations))},
+	}, nil
+}
+
+func (s *Server) lineItemSKUs(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	var nodes []interface{}
+	for _, id := range variables["ids"].([]interface{}) {
+		lineItemID := gidNumber(id.(string))
+		sku, ok := sh.lineItems[lineItemID]
+		if !ok {
+			nodes = append(nodes, nil)
+			continue
+		}
+		nodes = append(nodes, map[string]interface{}{"id": gid("LineItem", lineItemID), "sku": sku})
+	}
+	return map[string]interface{}{"nodes": nodes}, nil
+}
+
+// orderRestocks answers with the refunds of an order. Orders exist as long as they are referenced,
+// so an order without refunds is returned with none.
+func (s *Server) orderRestocks(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	orderID := gidNumber(variables["id"].(string))
+
+	refunds := []interface{}{}
+	for _, refund := range sh.refunds {
+		if refund.orderID != orderID {
+			continue
+		}
+		nodes := make([]interface{}, len(refund.lineItems))
+		for i, item := range refund.lineItems {
+			nodes[i] = map[string]interface{}{
+				"quantity":  item.Quantity,
+				"restocked": item.Restocked,
+				"lineItem":  map[string]interface{}{"id": gid("LineItem", item.LineItemID)},
+			}
+		}
+		refunds = append(refunds, map[string]interface{}{
+			"createdAt":       refund.createdAt.UTC().Format(time.RFC3339),
+			"refundLineItems": map[string]interface{}{"nodes": nodes},
+		})
+	}
+	return map[string]interface{}{"order": map[string]interface{}{"id": gid("Order", orderID), "refunds": refunds}}, nil
+}
+
+// orderAssignedLocations answers with the fulfillment orders of an order. Like orderRestocks, an
+// order without any is returned with none.
+func (s *Server) orderAssignedLocations(sh *shop, variables map[string]interface{}) (interface{}, error) {
+	orderID := gidNumber(variables["id"].(string))
+
+	nodes := []interface{}{}
+	for _, fulfillmentOrder := range sh.fulfillmentOrders[orderID] {
+		lineItems := make([]interface{}, len(fulfillmentOrder.lineItemIDs))
+		for i, id := range fulfillmentOrder.lineItemIDs {
+			lineItems[i] = map[string]interface{}{"lineItem": map[string]interface{}{"id": gid("LineItem", id)}}
+		}
+		nodes = append(nodes, map[string]interface{}{
+			"assignedLocation": map[string]interface{}{
+				"location": map[string]interface{}{"id": gid("Location", fulfillmentOrder.locationID)},
+			},
+			"lineItems": map[string]interface{}{"nodes": lineItems},
+		})
+	}
+	return map[string]interface{}{"order": map[string]interface{}{
+		"id":                gid("Order", orderID),
+		"fulfillmentOrders": map[string]interface{}{"nodes": nodes},
+	}}, nil
+}
+
+// runBulkQuery starts a bulk export of the shop's variants. The result is fixed when the
+// operation starts, and the operation completes the first time it is polled.
+func (s *Server) runBulkQuery(shopName string, sh *shop, variables map[string]interface{}) (interface{}, error) {
+	query := stringValue(variables, "query")
+	if !strings.Contains(query, "productVariants") {
+		return map[string]interface{}{
+			"bulkOperationRunQuery": map[string]interface{}{
+				"bulkOperation": nil,
+				"userErrors": userErrors([]shopify.UserError{
+					{Field: []string{"query"}, Message: "shopifytest only supports bulk queries of productVariants"},
+				}),
+			},
+		}, nil
+	}
+	for _, op := range s.bulk {
+		if op.shop == shopName && !op.polled {
+			return map[string]interface{}{
+				"bulkOperationRunQuery": map[string]interface{}{
+					"bulkOperation": nil,
+					"userErrors": userErrors([]shopify.UserError{
+						{Field: []string{"query"}, Message: "A bulk query operation for this app and shop is already in progress"},
+					}),
+				},
+			}, nil
+		}
+	}
+
+	op := &bulkOperation{id: gid("BulkOperation", s.newID()), shop: shopName}
+	for _, variant := range sh.variants {
+		line, _ := json.Marshal(map[string]interface{}{
+			"id":  gid("ProductVariant", variant.ID),
+			"sku": variant.SKU,
+			"product": map[string]interface{}{
+				"id":    gid("Product", variant.ProductID),
+				"title": variant.ProductTitle,
+			},
+			"inventoryItem": map[string]interface{}{"id": gid("InventoryItem", variant.InventoryItemID)},
+		})
+		op.lines = append(op.lines, string(line))
+	}
+	s.bulk[op.id] = op
+
+	return map[string]interface{}{
+		"bulkOperationRunQuery": map[string]interface{}{
+			"bulkOperation": s.bulkOperationNode(op, "CREATED"),
+			"userErrors":    []interface{}{},
+		},
+	}, nil
+}
+
+func (s *Server) bulkOperation(shopName string, variables map[string]interface{}) (interface{}, error) {
+	op, ok := s.bulk[stringValue(variables, "id")]
+	if !ok || op.shop != shopName {
+		return map[string]interface{}{"node": nil}, nil
+	}
+	op.polled = true
+	return map[string]interface{}{"node": s.bulkOperationNode(op, "COMPLETED")}, nil
+}
+
+func (s *Server) bulkOperationNode(op *bulkOperation, status string) map[string]interface{} {
+	node := map[string]interface{}{
+		"id":          op.id,
+		"status":      status,
+		"errorCode":   nil,
+		"objectCount": "0",
+		"url":         nil,
+	}
+	if status == "COMPLETED" {
+		node["objectCount"] = strconv.Itoa(len(op.lines))
+		if len(op.lines) > 0 {
+			node["url"] = fmt.Sprintf("%s/bulk/%d.jsonl", s.URL, gidNumber(op.id))
+		}
+	}
+	return node
+}
+
+func (s *Server) serveBulkResult(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/bulk/"), ".jsonl")
+
+	s.mu.Lock()
+	op, ok := s.bulk["gid://shopify/BulkOperation/"+id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	for _, line := range op.lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// hasItem reports whether an inventory item belongs to a variant of the shop.
+func (sh *shop) hasItem(inventoryItemID int64) bool {
+	for _, variant := range sh.variants {
+		if variant.InventoryItemID == inventoryItemID {
+			return true
+		}
+	}
+	return false
+}
+
+// levelConnection returns a page of an item's inventory levels, in the order of the shop's locations.
+func (sh *shop) levelConnection(inventoryItemID int64, first, start int) map[string]interface{} {
+	var stocked []int64
+	for _, location := range sh.locations {
+		if _, ok := sh.levels[levelKey{inventoryItemID, location.ID}]; ok {
+			stocked = append(stocked, location.ID)
+		}
+	}
+
+	end := min(start+first, len(stocked))
+	var nodes []interface{}
+	for _, locationID := range stocked[min(start, end):end] {
+		node := availableQuantities(sh.levels[levelKey{inventoryItemID, locationID}])
+		node["location"] = map[string]interface{}{"id": gid("Location", locationID)}
+		nodes = append(nodes, node)
+	}
+	return map[string]interface{}{"nodes": nodes, "pageInfo": pageInfo(end, len(stocked))}
+}
+
+func notStocked(list string, index int) shopify.UserError {
+	return shopify.UserError{
+		Field:   []string{"input", list, strconv.Itoa(index), "locationId"},
+		Message: "The specified inventory item is not stocked at the location.",
+		Code:    "ITEM_NOT_STOCKED_AT_LOCATION",
+	}
+}
+
+func userErrors(errs []shopify.UserError) []interface{} {
+	result := make([]interface{}, len(errs))
+	for i, userError := range errs {
+		result[i] = map[string]interface{}{"field": userError.Field, "message": userError.Message, "code": userError.Code}
+	}
+	return result
+}
+
+func adjustmentGroup(input map[string]interface{}, changes []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"reason":               input["reason"],
+		"referenceDocumentUri": input["referenceDocumentUri"],
+		"changes":              changes,
+	}
+}
+
+func adjustmentChange(key levelKey, delta, quantityAfter int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":                "available",
+		"delta":               delta,
+		"quantityAfterChange": quantityAfter,
+		"item":                map[string]interface{}{"id": gid("InventoryItem", key.inventoryItemID)},
+		"location":            map[string]interface{}{"id": gid("Location", key.locationID)},
+	}
+}
+
+func availableQuantities(quantity int) map[string]interface{} {
+	return map[string]interface{}{
+		"quantities": []interface{}{map[string]interface{}{"name": "available", "quantity": quantity}},
+	}
+}
+
+// pageInfo describes a page ending at end of total items. Cursors are offsets.
+func pageInfo(end, total int) map[string]interface{} {
+	return map[string]interface{}{"hasNextPage": end < total, "endCursor": strconv.Itoa(end)}
+}
+
+func gid(kind string, id int64) string {
+	return fmt.Sprintf("gid://shopify/%s/%d", kind, id)
+}
+
+func gidNumber(gid string) int64 {
+	id, _ := strconv.ParseInt(gid[strings.LastIndex(gid, "/")+1:], 10, 64)
+	return id
+}
+
+func object(values map[string]interface{}, key string) map[string]interface{} {
+	value, _ := values[key].(map[string]interface{})
+	return value
+}
+
+func list(values map[string]interface{}, key string) []map[string]interface{} {
+	items, _ := values[key].([]interface{})
+	result := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if value, ok := item.(map[string]interface{}); ok {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func stringValue(values map[string]interface{}, key string) string {
+	value, _ := values[key].(string)
+	return value
+}
+
+func intValue(values map[string]interface{}, key string) int {
+	value, _ := values[key].(float64)
+	return int(value)
+}
+
+func cursorValue(values map[string]interface{}, key string) int {
+	cursor, _ := strconv.Atoi(stringValue(values, key))
+	return cursor
+}
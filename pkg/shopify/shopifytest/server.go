@@ -0,0 +1,471 @@
+// Package shopifytest provides an in-process fake of the Shopify Admin GraphQL API for tests.
+//
+// The fake implements the queries and mutations sent by package shopify against stateful,
+// per-shop stock. Shops are created on first use. Every request is charged the cost Shopify
+// would compute from its connection sizes, and queries over the single query limit are
+// rejected with MAX_COST_EXCEEDED. Failures, throttling and deprecation notices can be
+// injected to exercise error handling.
+package shopifytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gostockly/pkg/shopify"
+)
+
+// operationPattern extracts the operation name from a query or mutation.
+var operationPattern = regexp.MustCompile(`^\s*(query|mutation)\s+(\w+)`)
+
+// Location is a location of a fake shop.
+type Location struct {
+	ID                   int64
+	Name                 string
+	IsActive             bool
+	FulfillsOnlineOrders bool
+}
+
+// Variant is a product variant of a fake shop with its inventory item.
+type Variant struct {
+	ID              int64
+	ProductID       int64
+	ProductTitle    string
+	SKU             string
+	InventoryItemID int64
+}
+
+// Request is a GraphQL request received by the fake.
+type Request struct {
+	Shop       string
+	APIVersion string
+	Operation  string
+	Variables  map[string]interface{}
+	// Cost is the requested query cost charged for the request
+	Cost int
+}
+
+// Failure describes how a request fails. Exactly one kind of failure should be set.
+type Failure struct {
+	// Status answers with this HTTP status and Body. A 429 gets a short Retry-After header.
+	Status int
+	Body   string
+	// GraphQLErrors answers with top-level GraphQL errors
+	GraphQLErrors []string
+	// Throttled answers with a THROTTLED error
+	Throttled bool
+	// UserErrors answers a mutation with these userErrors and applies nothing
+	UserErrors []shopify.UserError
+}
+
+type levelKey struct {
+	inventoryItemID int64
+	locationID      int64
+}
+
+type shop struct {
+	locations []Location
+	variants  []Variant
+	lineItems map[int64]string
+	refunds   []refund
+	// fulfillmentOrders holds the line items assigned to a location, by order ID
+	fulfillmentOrders map[int64][]fulfillmentOrder
+	levels            map[levelKey]int
+
+	// The simulated query cost bucket, disabled while maximumAvailable is zero
+	maximumAvailable   float64
+	currentlyAvailable float64
+	restoreRate        float64
+	updatedAt          time.Time
+
+	deprecationReason string
+}
+
+type refund struct {
+	orderID   int64
+	createdAt time.Time
+	lineItems []RefundLineItem
+}
+
+type fulfillmentOrder struct {
+	locationID  int64
+	lineItemIDs []int64
+}
+
+type injectedFailure struct {
+	shop      string
+	operation string
+	skip      int
+	times     int
+	failure   Failure
+}
+
+type bulkOperation struct {
+	id     string
+	shop   string
+	polled bool
+	lines  []string
+}
+
+// Server is a fake Shopify Admin API. Create it with NewServer and point clients at it
+// with Client, or with Install for code that creates its own clients.
+type Server struct {
+	URL string
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	nextID   int64
+	shops    map[string]*shop
+	failures []*injectedFailure
+	bulk     map[string]*bulkOperation
+	requests []Request
+}
+
+// NewServer starts a fake Shopify server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		nextID: 1000,
+		shops:  make(map[string]*shop),
+		bulk:   make(map[string]*bulkOperation),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Install points every client created by shopify.NewShopifyClient at the server until the
+// test ends, and closes the server then.
+func (s *Server) Install(t testing.TB) {
+	previous := shopify.AdminURL
+	shopify.AdminURL = s.adminURL
+	t.Cleanup(func() {
+		shopify.AdminURL = previous
+		s.Close()
+	})
+}
+
+// Client returns a client for a shop of the server.
+func (s *Server) Client(shopName string) *shopify.ShopifyClient {
+	return &shopify.ShopifyClient{
+		AccessToken: "test-token",
+		StoreURL:    s.adminURL(shopName, shopify.DefaultVersion()),
+		Shop:        shopName,
+		APIVersion:  shopify.DefaultVersion(),
+	}
+}
+
+func (s *Server) adminURL(shopName, apiVersion string) string {
+	return fmt.Sprintf("%s/%s/admin/api/%s", s.URL, shopName, apiVersion)
+}
+
+// AddLocation adds an active location that fulfills online orders to a shop.
+func (s *Server) AddLocation(shopName, name string) Location {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	location := Location{ID: s.newID(), Name: name, IsActive: true, FulfillsOnlineOrders: true}
+	sh := s.shop(shopName)
+	sh.locations = append(sh.locations, location)
+	return location
+}
+
+// AddVariant adds a product with a single variant to a shop. Its inventory item is not
+// stocked anywhere until SetAvailable is called.
+func (s *Server) AddVariant(shopName, productTitle, sku string) Variant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	variant := Variant{
+		ID:              s.newID(),
+		ProductID:       s.newID(),
+		ProductTitle:    productTitle,
+		SKU:             sku,
+		InventoryItemID: s.newID(),
+	}
+	sh := s.shop(shopName)
+	sh.variants = append(sh.variants, variant)
+	return variant
+}
+
+// AddLineItem adds an order line item for a SKU to a shop and returns its ID.
+func (s *Server) AddLineItem(shopName, sku string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID()
+	s.shop(shopName).lineItems[id] = sku
+	return id
+}
+
+// RefundLineItem is a quantity of an order line item refunded by AddRefund.
+type RefundLineItem struct {
+	LineItemID int64
+	Quantity   int
+	Restocked  bool
+}
+
+// AddRefund records a refund of an order, created at the given time.
+func (s *Server) AddRefund(shopName string, orderID int64, createdAt time.Time, lineItems ...RefundLineItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh := s.shop(shopName)
+	sh.refunds = append(sh.refunds, refund{orderID: orderID, createdAt: createdAt, lineItems: lineItems})
+}
+
+// AddFulfillmentOrder assigns line items of an order to be fulfilled from a location.
+func (s *Server) AddFulfillmentOrder(shopName string, orderID, locationID int64, lineItemIDs ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh := s.shop(shopName)
+	sh.fulfillmentOrders[orderID] = append(sh.fulfillmentOrders[orderID], fulfillmentOrder{locationID: locationID, lineItemIDs: lineItemIDs})
+}
+
+// SetAvailable stocks an inventory item at a location with the given available quantity.
+func (s *Server) SetAvailable(shopName string, inventoryItemID, locationID int64, quantity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shop(shopName).levels[levelKey{inventoryItemID, locationID}] = quantity
+}
+
+// Available returns the available quantity of an inventory item at a location, and false if
+// the item is not stocked there.
+func (s *Server) Available(shopName string, inventoryItemID, locationID int64) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	quantity, ok := s.shop(shopName).levels[levelKey{inventoryItemID, locationID}]
+	return quantity, ok
+}
+
+// SetCostLimit simulates Shopify's query cost bucket for a shop. Every request takes its
+// requested query cost out of the bucket, and requests costing more than the available points
+// are answered with a THROTTLED error.
+func (s *Server) SetCostLimit(shopName string, maximumAvailable, restoreRate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh := s.shop(shopName)
+	sh.maximumAvailable = maximumAvailable
+	sh.currentlyAvailable = maximumAvailable
+	sh.restoreRate = restoreRate
+	sh.updatedAt = time.Now()
+}
+
+// restore refills the simulated cost bucket for the time passed since its last update.
+func (sh *shop) restore() {
+	now := time.Now()
+	sh.currentlyAvailable = min(sh.maximumAvailable, sh.currentlyAvailable+sh.restoreRate*now.Sub(sh.updatedAt).Seconds())
+	sh.updatedAt = now
+}
+
+// Deprecate sets the X-Shopify-API-Deprecated-Reason header on every response to a shop.
+func (s *Server) Deprecate(shopName, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shop(shopName).deprecationReason = reason
+}
+
+// Fail makes the next times requests of an operation to a shop fail. An empty operation matches
+// every operation.
+func (s *Server) Fail(shopName, operation string, times int, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &injectedFailure{shop: shopName, operation: operation, times: times, failure: failure})
+}
+
+// FailAfter is Fail for the requests that follow the next skip matching ones.
+func (s *Server) FailAfter(shopName, operation string, skip, times int, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &injectedFailure{shop: shopName, operation: operation, skip: skip, times: times, failure: failure})
+}
+
+// Requests returns the requests received for a shop, oldest first.
+func (s *Server) Requests(shopName string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests []Request
+	for _, request := range s.requests {
+		if request.Shop == shopName {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// shop returns the state of a shop, creating it on first use. The caller must hold s.mu.
+func (s *Server) shop(name string) *shop {
+	sh, ok := s.shops[name]
+	if !ok {
+		sh = &shop{lineItems: make(map[int64]string), fulfillmentOrders: make(map[int64][]fulfillmentOrder), levels: make(map[levelKey]int)}
+		s.shops[name] = sh
+	}
+	return sh
+}
+
+// newID returns a new numeric ID, unique across all shops. The caller must hold s.mu.
+func (s *Server) newID() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// takeFailure returns the injected failure for a request, if any. The caller must hold s.mu.
+func (s *Server) takeFailure(shopName, operation string) *Failure {
+	for i, injected := range s.failures {
+		if injected.shop != shopName || (injected.operation != "" && injected.operation != operation) {
+			continue
+		}
+		if injected.skip > 0 {
+			injected.skip--
+			return nil
+		}
+		injected.times--
+		if injected.times <= 0 {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		}
+		return &injected.failure
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/bulk/") {
+		s.serveBulkResult(w, r)
+		return
+	}
+
+	// Paths look like /<shop>/admin/api/<version>/graphql.json
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 5 || parts[1] != "admin" || parts[2] != "api" || parts[4] != "graphql.json" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("X-Shopify-Access-Token") == "" {
+		http.Error(w, `{"errors":"[API] Invalid API key or access token"}`, http.StatusUnauthorized)
+		return
+	}
+	shopName, apiVersion := parts[0], parts[3]
+
+	var body struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	operation := ""
+	if match := operationPattern.FindStringSubmatch(body.Query); match != nil {
+		operation = match[2]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cost := requestedQueryCost(body.Query, body.Variables)
+	s.requests = append(s.requests, Request{Shop: shopName, APIVersion: apiVersion, Operation: operation, Variables: body.Variables, Cost: cost})
+	sh := s.shop(shopName)
+	if sh.deprecationReason != "" {
+		w.Header().Set("X-Shopify-API-Deprecated-Reason", sh.deprecationReason)
+	}
+
+	if failure := s.takeFailure(shopName, operation); failure != nil {
+		s.writeFailure(w, sh, operation, cost, failure)
+		return
+	}
+
+	if cost > maxSingleQueryCost {
+		s.writeJSON(w, sh, cost, map[string]interface{}{
+			"errors": []map[string]interface{}{{
+				"message":    fmt.Sprintf("Query cost is %d, which exceeds the single query max cost limit (%d).", cost, maxSingleQueryCost),
+				"extensions": map[string]interface{}{"code": "MAX_COST_EXCEEDED", "cost": cost, "maxCost": maxSingleQueryCost},
+			}},
+		})
+		return
+	}
+
+	if sh.maximumAvailable > 0 {
+		sh.restore()
+		if sh.currentlyAvailable < float64(cost) {
+			s.writeJSON(w, sh, cost, map[string]interface{}{
+				"errors": []map[string]interface{}{
+					{"message": "Throttled", "extensions": map[string]interface{}{"code": "THROTTLED"}},
+				},
+			})
+			return
+		}
+		sh.currentlyAvailable -= float64(cost)
+	}
+
+	data, err := s.resolve(shopName, sh, operation, body.Variables)
+	if err != nil {
+		s.writeJSON(w, sh, cost, map[string]interface{}{
+			"errors": []map[string]interface{}{{"message": err.Error()}},
+		})
+		return
+	}
+	s.writeJSON(w, sh, cost, map[string]interface{}{"data": data})
+}
+
+func (s *Server) writeFailure(w http.ResponseWriter, sh *shop, operation string, cost int, failure *Failure) {
+	switch {
+	case failure.Status != 0:
+		if failure.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0.01")
+		}
+		w.WriteHeader(failure.Status)
+		w.Write([]byte(failure.Body))
+	case failure.Throttled:
+		s.writeJSON(w, sh, cost, map[string]interface{}{
+			"errors": []map[string]interface{}{
+				{"message": "Throttled", "extensions": map[string]interface{}{"code": "THROTTLED"}},
+			},
+		})
+	case len(failure.GraphQLErrors) > 0:
+		errs := make([]map[string]interface{}, len(failure.GraphQLErrors))
+		for i, message := range failure.GraphQLErrors {
+			errs[i] = map[string]interface{}{"message": message}
+		}
+		s.writeJSON(w, sh, cost, map[string]interface{}{"errors": errs})
+	default:
+		s.writeJSON(w, sh, cost, map[string]interface{}{
+			"data": map[string]interface{}{
+				operation: map[string]interface{}{"userErrors": userErrors(failure.UserErrors)},
+			},
+		})
+	}
+}
+
+// writeJSON writes a GraphQL response with the cost extension Shopify adds to every response.
+func (s *Server) writeJSON(w http.ResponseWriter, sh *shop, cost int, response map[string]interface{}) {
+	maximumAvailable, currentlyAvailable, restoreRate := 1000.0, 1000.0, 50.0
+	if sh.maximumAvailable > 0 {
+		maximumAvailable, currentlyAvailable, restoreRate = sh.maximumAvailable, sh.currentlyAvailable, sh.restoreRate
+	}
+	response["extensions"] = map[string]interface{}{
+		"cost": map[string]interface{}{
+			"requestedQueryCost": cost,
+			"throttleStatus": map[string]interface{}{
+				"maximumAvailable":   maximumAvailable,
+				"currentlyAvailable": currentlyAvailable,
+				"restoreRate":        restoreRate,
+			},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,154 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+)
+
+// WebhookSubscription is a webhook topic delivered by Shopify to an HTTPS endpoint.
+type WebhookSubscription struct {
+	ID          string `json:"id"`
+	Topic       string `json:"topic"`
+	CallbackURL string `json:"callback_url"`
+}
+
+// webhookSubscriptionFields is the GraphQL selection decoded into a webhookSubscriptionNode.
+const webhookSubscriptionFields = `
+	id
+	topic
+	endpoint {
+		__typename
+		... on WebhookHttpEndpoint {
+			callbackUrl
+		}
+	}
+`
+
+type webhookSubscriptionNode struct {
+	ID       string `json:"id"`
+	Topic    string `json:"topic"`
+	Endpoint struct {
+		CallbackURL string `json:"callbackUrl"`
+	} `json:"endpoint"`
+}
+
+func (n *webhookSubscriptionNode) subscription() *WebhookSubscription {
+	return &WebhookSubscription{ID: n.ID, Topic: n.Topic, CallbackURL: n.Endpoint.CallbackURL}
+}
+
+// CreateWebhookSubscription subscribes callbackURL to a webhook topic such as ORDERS_CREATE.
+// Shopify rejects a second subscription of the same topic to the same URL with user errors.
+func (c *ShopifyClient) CreateWebhookSubscription(ctx context.Context, topic, callbackURL string) (*WebhookSubscription, error) {
+	mutation := `
+		mutation webhookSubscriptionCreate($topic: WebhookSubscriptionTopic!, $webhookSubscription: WebhookSubscriptionInput!) {
+			webhookSubscriptionCreate(topic: $topic, webhookSubscription: $webhookSubscription) {
+				webhookSubscription {` + webhookSubscriptionFields + `}
+				userErrors {
+					field
+					message
+				}
+			}
+		}
+	`
+
+	var response struct {
+		WebhookSubscriptionCreate struct {
+			WebhookSubscription *webhookSubscriptionNode `json:"webhookSubscription"`
+			UserErrors          []UserError              `json:"userErrors"`
+		} `json:"webhookSubscriptionCreate"`
+	}
+	err := c.Query(ctx, "webhookSubscriptionCreate", mutation, map[string]interface{}{
+		"topic": topic,
+		"webhookSubscription": map[string]interface{}{
+			"callbackUrl": callbackURL,
+			"format":      "JSON",
+		},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.WebhookSubscriptionCreate
+	if err := checkUserErrors("webhookSubscriptionCreate", result.UserErrors); err != nil {
+		return nil, err
+	}
+	if result.WebhookSubscription == nil {
+		return nil, errors.New("failed to create webhook subscription: no subscription returned")
+	}
+	return result.WebhookSubscription.subscription(), nil
+}
+
+// webhookSubscriptionPageSize is how many webhook subscriptions are requested per page.
+const webhookSubscriptionPageSize = 100
+
+// ListWebhookSubscriptions retrieves every webhook subscription the app has on the store.
+func (c *ShopifyClient) ListWebhookSubscriptions(ctx context.Context) ([]WebhookSubscription, error) {
+	query := `
+		query webhookSubscriptions($first: Int!, $after: String) {
+			webhookSubscriptions(first: $first, after: $after) {
+				nodes {` + webhookSubscriptionFields + `}
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
+			}
+		}
+	`
+
+	var subscriptions []WebhookSubscription
+	var after *string
+	for {
+		var response struct {
+			WebhookSubscriptions struct {
+				Nodes    []webhookSubscriptionNode `json:"nodes"`
+				PageInfo pageInfo                  `json:"pageInfo"`
+			} `json:"webhookSubscriptions"`
+		}
+		err := c.Query(ctx, "webhookSubscriptions", query, map[string]interface{}{
+			"first": webhookSubscriptionPageSize,
+			"after": after,
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range response.WebhookSubscriptions.Nodes {
+			subscriptions = append(subscriptions, *node.subscription())
+		}
+
+		page := response.WebhookSubscriptions.PageInfo
+		if !page.HasNextPage {
+			return subscriptions, nil
+		}
+		if page.EndCursor == "" {
+			return nil, errors.New("webhook subscriptions page has a next page but no end cursor")
+		}
+		after = &page.EndCursor
+	}
+}
+
+// DeleteWebhookSubscription removes a webhook subscription by its global ID.
+func (c *ShopifyClient) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	mutation := `
+		mutation webhookSubscriptionDelete($id: ID!) {
+			webhookSubscriptionDelete(id: $id) {
+				deletedWebhookSubscriptionId
+				userErrors {
+					field
+					message
+				}
+			}
+		}
+	`
+
+	var response struct {
+		WebhookSubscriptionDelete struct {
+			DeletedWebhookSubscriptionID *string     `json:"deletedWebhookSubscriptionId"`
+			UserErrors                   []UserError `json:"userErrors"`
+		} `json:"webhookSubscriptionDelete"`
+	}
+	if err := c.Query(ctx, "webhookSubscriptionDelete", mutation, map[string]interface{}{"id": id}, &response); err != nil {
+		return err
+	}
+	return checkUserErrors("webhookSubscriptionDelete", response.WebhookSubscriptionDelete.UserErrors)
+}
@@ -8,14 +8,21 @@ import (
 
 // ValidateHMAC validates the HMAC signature of a payload against a given secret.
 func ValidateHMAC(payload []byte, hmacHeader, secret string) bool {
+	if hmacHeader == "" || secret == "" {
+		return false
+	}
+
+	// Shopify sends the signature Base64 encoded
+	providedMAC, err := base64.StdEncoding.DecodeString(hmacHeader)
+	if err != nil {
+		return false
+	}
+
 	// Create a new HMAC using SHA256 and the provided secret
 	mac := hmac.New(sha256.New, []byte(secret))
 	mac.Write(payload)
 	expectedMAC := mac.Sum(nil)
 
-	// Encode the calculated HMAC in Base64 to match Shopify's header
-	expectedHMAC := base64.StdEncoding.EncodeToString(expectedMAC)
-
-	// Compare the calculated HMAC with the provided HMAC header
-	return hmacHeader == expectedHMAC
+	// Compare in constant time so the signature cannot be guessed byte by byte
+	return hmac.Equal(providedMAC, expectedMAC)
 }
@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestValidateHMAC(t *testing.T) {
+	payload := []byte(`{"id":1,"line_items":[{"sku":"ABC","quantity":1}]}`)
+	secret := "hush"
+	// openssl dgst -sha256 -hmac hush -binary | base64
+	signature := "ttkT0T75mhPgJ0NdRtzSl8QRXyt1zbVFkRsy3Ez2bBU="
+
+	if !ValidateHMAC(payload, signature, secret) {
+		t.Fatal("expected valid signature to pass")
+	}
+	if ValidateHMAC(payload, signature, "wrong-secret") {
+		t.Fatal("expected signature with wrong secret to fail")
+	}
+	if ValidateHMAC(append(payload, ' '), signature, secret) {
+		t.Fatal("expected modified payload to fail")
+	}
+	if ValidateHMAC(payload, "", secret) {
+		t.Fatal("expected empty signature to fail")
+	}
+	if ValidateHMAC(payload, signature, "") {
+		t.Fatal("expected empty secret to fail")
+	}
+}
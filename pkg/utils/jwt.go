@@ -7,37 +7,50 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func GenerateJWT(userID, secret string) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(), // Token expires in 24 hours
+// AccessClaims are the claims of an access token.
+type AccessClaims struct {
+	UserID string `json:"user_id"`
+	// TokenVersion is the user's token version when the token was issued. Tokens with an older
+	// version than the user's current one are no longer accepted.
+	TokenVersion int `json:"ver"`
+	jwt.RegisteredClaims
+}
+
+// GenerateJWT issues an HS256 access token for a user that expires after ttl.
+func GenerateJWT(userID string, tokenVersion int, secret string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := AccessClaims{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
 }
 
-func ValidateJWT(tokenString, secret string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// ValidateJWT checks the signature and expiry of an access token and returns its claims.
+func ValidateJWT(tokenString, secret string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
 			return nil, errors.New("unexpected signing method")
 		}
 		return []byte(secret), nil
 	})
 
 	if err != nil || !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", errors.New("invalid claims")
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiry")
 	}
-
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return "", errors.New("user ID not found in token")
+	if claims.UserID == "" {
+		return nil, errors.New("user ID not found in token")
 	}
 
-	return userID, nil
+	return claims, nil
 }
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestValidateJWT(t *testing.T) {
+	secret := "hush"
+
+	token, err := GenerateJWT("user-1", 3, secret, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	claims, err := ValidateJWT(token, secret)
+	if err != nil {
+		t.Fatalf("expected valid token to pass: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.TokenVersion != 3 {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	if _, err := ValidateJWT(token, "wrong-secret"); err == nil {
+		t.Fatal("expected token with wrong secret to fail")
+	}
+
+	expired, _ := GenerateJWT("user-1", 3, secret, -time.Minute)
+	if _, err := ValidateJWT(expired, secret); err == nil {
+		t.Fatal("expected expired token to fail")
+	}
+
+	otherAlg, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"user_id": "user-1",
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte(secret))
+	if _, err := ValidateJWT(otherAlg, secret); err == nil {
+		t.Fatal("expected token signed with another algorithm to fail")
+	}
+
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "user-1"}).SignedString([]byte(secret))
+	if _, err := ValidateJWT(noExpiry, secret); err == nil {
+		t.Fatal("expected token without expiry to fail")
+	}
+}